@@ -20,12 +20,25 @@ func main() {
 
 	go application.GRPCServer.MustRun()
 
+	if cfg.HTTP.Port != 0 {
+		go application.HTTPServer.MustRun()
+	}
+
+	if cfg.Metrics.Port != 0 {
+		go application.MetricsServer.MustRun()
+	}
+
+	application.Workers.Run()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	<-stop
 
 	application.GRPCServer.Stop()
+	application.HTTPServer.Stop()
+	application.MetricsServer.Stop()
+	application.Workers.Stop()
 	log.Info("applciation stop")
 }
 
@@ -1,17 +1,51 @@
 package app
 
 import (
+	"crypto/ed25519"
 	"log/slog"
 	"time"
 
 	grpcapp "github.com/goggle-source/grpc-servic/sso/internal/app/grpc"
+	httpapp "github.com/goggle-source/grpc-servic/sso/internal/app/http"
+	metricsapp "github.com/goggle-source/grpc-servic/sso/internal/app/metrics"
+	workersapp "github.com/goggle-source/grpc-servic/sso/internal/app/workers"
 	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/authz"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/breach"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/geoip"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/password"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/publisher"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/ratelimit"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/seal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenancy"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/apikey"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/appadmin"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/audit"
 	"github.com/goggle-source/grpc-servic/sso/internal/services/auth"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/events"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/federation"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/org"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/outbox"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/privacy"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/profile"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/risk"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/session"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/useradmin"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/webhook"
 	"github.com/goggle-source/grpc-servic/sso/internal/storage/postgresql"
 )
 
 type App struct {
-	GRPCServer *grpcapp.App
+	GRPCServer    *grpcapp.App
+	HTTPServer    *httpapp.App
+	MetricsServer *metricsapp.App
+	Workers       *workersapp.App
 }
 
 func NewApp(log *slog.Logger, grpcPort int, cfg config.Config, tokenTTL time.Duration) *App {
@@ -21,12 +55,169 @@ func NewApp(log *slog.Logger, grpcPort int, cfg config.Config, tokenTTL time.Dur
 		panic(err)
 	}
 
-	auth := auth.New(log, db, db, db, tokenTTL)
+	passwords, err := password.NewEngine(cfg.Password)
+	if err != nil {
+		panic(err)
+	}
+
+	breaches, err := breach.New(cfg.Breach)
+	if err != nil {
+		panic(err)
+	}
+
+	auditor, err := NewAudit(log, db, cfg.Audit)
+	if err != nil {
+		panic(err)
+	}
+
+	guard := lockout.New(log, db, auditor, cfg.Lockout)
+
+	sender, err := notifier.New(log, cfg.Notifier)
+	if err != nil {
+		panic(err)
+	}
+	notify := notifier.NewQueue(log, sender)
+
+	var geo risk.GeoIP
+	if cfg.Risk.GeoIPPath != "" {
+		geoDB, err := geoip.Open(cfg.Risk.GeoIPPath)
+		if err != nil {
+			panic(err)
+		}
+		geo = geoDB
+	}
+
+	risks, err := risk.New(log, db, geo, notify, auditor, cfg.Risk)
+	if err != nil {
+		panic(err)
+	}
+
+	ldap := auth.Directory{Config: cfg.LDAP}
+	switch cfg.LDAP.Mode {
+	case domain.DirectoryFirst, domain.DirectoryOnly, "":
+	default:
+		panic("unknown ldap mode: " + cfg.LDAP.Mode)
+	}
+	if cfg.LDAP.URL != "" {
+		ldap.Authenticator = directory.New(cfg.LDAP)
+	}
+
+	var otpKey *seal.Key
+	if cfg.Factors.KeyPath != "" {
+		if otpKey, err = seal.LoadKey(cfg.Factors.KeyPath); err != nil {
+			panic(err)
+		}
+	} else if cfg.Hardened {
+		panic("factors.key_path is required in hardened mode")
+	}
 
-	grpcApp := grpcapp.NewApp(log, grpcPort, auth)
+	auth := auth.New(log, auth.Options{
+		UserSaver:    db,
+		UserProvider: db,
+		AppProvider:  db,
+		Passwords:    passwords,
+		Breaches:     breaches,
+		Rotation:     cfg.Rotation,
+		Guard:        guard,
+		Risk:         risks,
+		Notifier:     notify,
+		Auditor:      auditor,
+		EmailChanges: db,
+		Sessions:     db,
+		Factors:      db,
+		OTPKey:       otpKey,
+		Directory:    ldap,
+		Hardened:     cfg.Hardened,
+		TokenTTL:     tokenTTL,
+		RefreshTTL:   cfg.Sessions.RefreshTTL,
+	})
+
+	var limits ratelimit.Store
+	switch cfg.Limits.Store {
+	case "postgres":
+		limits = db.RateLimits()
+	case "memory", "":
+		limits = ratelimit.NewMemoryStore()
+	default:
+		panic("unknown rate limit store: " + cfg.Limits.Store)
+	}
+
+	limiter := ratelimit.New(log, limits, cfg.Limits)
+
+	clients, err := clientinfo.New(cfg.Clients)
+	if err != nil {
+		panic(err)
+	}
+
+	webhooks := webhook.New(log, db, cfg.Webhooks)
+
+	erasures := privacy.New(log, db, guard, auditor, cfg.Erasure)
+
+	sessions := session.New(log, db, auditor, cfg.Sessions)
+
+	keys := apikey.New(log, db, auditor, cfg.APIKeys)
+
+	federated, err := federation.New(log, db, auth, auditor, cfg.Upstream)
+	if err != nil {
+		panic(err)
+	}
+
+	grpcApp := grpcapp.NewApp(log, grpcPort, grpcapp.Services{
+		Auth:       auth,
+		Audit:      auditor,
+		Webhooks:   webhooks,
+		Events:     events.New(log, db, cfg.Watch),
+		UserAdmin:  useradmin.New(log, db, auditor),
+		AppAdmin:   appadmin.New(log, db, auditor),
+		Profiles:   profile.New(log, db, auditor),
+		Privacy:    erasures,
+		Sessions:   sessions,
+		Orgs:       org.New(log, db, notify, auditor),
+		APIKeys:    keys,
+		Federation: federated,
+	}, clients, limiter, authz.New(log, db, db, db, keys, grpcapp.AdminMethods, grpcapp.PublicMethods, grpcapp.StepUpMethods, grpcapp.APIKeyScopes), tenancy.New(log, db))
+
+	broker, err := publisher.New(log, cfg.Outbox.Publisher)
+	if err != nil {
+		panic(err)
+	}
+
+	relay := outbox.New(log, db, publisher.Fanout{broker, webhooks}, cfg.Outbox)
+
+	workers := workersapp.NewApp(log)
+	workers.Add("outbox_relay", relay.Run)
+	workers.Add("webhook_delivery", webhooks.Run)
+	workers.Add("audit_retention", auditor.RunRetention)
+	workers.Add("audit_checkpoints", auditor.RunCheckpoints)
+	workers.Add("user_erasure", erasures.Run)
+	workers.Add("session_cleanup", sessions.Run)
+	workers.Add("login_history_retention", risks.RunRetention)
+	workers.Add("api_key_usage", keys.Run)
+	workers.Add("api_key_cleanup", keys.RunCleanup)
+	workers.Add("saml_assertion_cleanup", federated.RunCleanup)
+	workers.Add("rate_limit_prune", limiter.RunPrune)
+	workers.Add("login_failure_cleanup", guard.RunCleanup)
+	workers.Add("notifications", notify.Run)
 
 	return &App{
-		GRPCServer: grpcApp,
+		GRPCServer:    grpcApp,
+		HTTPServer:    httpapp.NewApp(log, cfg.HTTP.Port, federated, clients, limiter),
+		MetricsServer: metricsapp.NewApp(log, cfg.Metrics.Port),
+		Workers:       workers,
+	}
+
+}
+
+// NewAudit builds the audit service with the checkpoint key from cfg, it is
+// shared with the audit command.
+func NewAudit(log *slog.Logger, storage audit.Storage, cfg config.Audit) (*audit.Audit, error) {
+	var key ed25519.PrivateKey
+	if cfg.SigningKeyPath != "" {
+		var err error
+		if key, err = auditchain.LoadKey(cfg.SigningKeyPath); err != nil {
+			return nil, err
+		}
 	}
 
+	return audit.New(log, storage, cfg, key), nil
 }
@@ -2,22 +2,154 @@ package grpcapp
 
 import (
 	"fmt"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
 	"log/slog"
 	"net"
 
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	apikeyRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/apikey"
+	appadminRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/appadmin"
+	auditRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/audit"
 	authRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/auth"
+	eventsRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/events"
+	federationRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/federation"
+	orgRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/org"
+	privacyRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/privacy"
+	profileRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/profile"
+	sessionRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/session"
+	useradminRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/useradmin"
+	webhookRPC "github.com/goggle-source/grpc-servic/sso/internal/grpc/webhook"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/authz"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/ratelimit"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenancy"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/warning"
 	"google.golang.org/grpc"
 )
 
+// Services are the implementations behind the gRPC services.
+type Services struct {
+	Auth       authRPC.ServicAuth
+	Audit      auditRPC.ServicAudit
+	Webhooks   webhookRPC.ServicWebhooks
+	Events     eventsRPC.ServicEvents
+	UserAdmin  useradminRPC.ServicUserAdmin
+	AppAdmin   appadminRPC.ServicAppAdmin
+	Profiles   profileRPC.ServicProfile
+	Privacy    privacyRPC.ServicPrivacy
+	Sessions   sessionRPC.ServicSessions
+	Orgs       orgRPC.ServicOrganizations
+	APIKeys    apikeyRPC.ServicAPIKeys
+	Federation federationRPC.ServicFederation
+}
+
+// AdminMethods may only be called with the access token of an admin.
+var AdminMethods = []string{
+	ssov1.Auth_UnlockUser_FullMethodName,
+	ssov1.Audit_ListAuditEvents_FullMethodName,
+	ssov1.Webhooks_CreateWebhook_FullMethodName,
+	ssov1.Webhooks_DeleteWebhook_FullMethodName,
+	ssov1.Webhooks_ListWebhookDeliveries_FullMethodName,
+	ssov1.Events_WatchUserEvents_FullMethodName,
+	ssov1.UserAdmin_GetUser_FullMethodName,
+	ssov1.UserAdmin_ListUsers_FullMethodName,
+	ssov1.UserAdmin_UpdateUser_FullMethodName,
+	ssov1.UserAdmin_DisableUser_FullMethodName,
+	ssov1.UserAdmin_EnableUser_FullMethodName,
+	ssov1.UserAdmin_DeleteUser_FullMethodName,
+	ssov1.UserAdmin_ResetOTP_FullMethodName,
+	ssov1.Privacy_CancelErasure_FullMethodName,
+	ssov1.AppAdmin_UpdateApp_FullMethodName,
+}
+
+// PublicMethods are called anonymously when their token or API key is not
+// accepted, other methods reject such calls.
+var PublicMethods = []string{
+	ssov1.Auth_Register_FullMethodName,
+	ssov1.Auth_Login_FullMethodName,
+	ssov1.Auth_IsAdmin_FullMethodName,
+	ssov1.Auth_ChangePassword_FullMethodName,
+	ssov1.Auth_ConfirmEmailChange_FullMethodName,
+	ssov1.Auth_Refresh_FullMethodName,
+	ssov1.Federation_StartFederatedLogin_FullMethodName,
+	ssov1.Federation_FinishFederatedLogin_FullMethodName,
+	ssov1.Federation_RedeemLoginCode_FullMethodName,
+}
+
+// StepUpMethods may be called with the token of a session that has to be
+// stepped up, see authz.Authorizer.
+var StepUpMethods = []string{
+	ssov1.Auth_EnrollOTP_FullMethodName,
+	ssov1.Auth_ConfirmOTP_FullMethodName,
+	ssov1.Auth_StepUp_FullMethodName,
+}
+
+// APIKeyScopes maps the services API keys may call to the scope a key needs
+// for them, keys can not call other services. Admin methods still require
+// the key of an admin.
+var APIKeyScopes = map[string]string{
+	service(ssov1.Profiles_ServiceDesc):      domain.ScopeProfile,
+	service(ssov1.Sessions_ServiceDesc):      domain.ScopeSessions,
+	service(ssov1.Organizations_ServiceDesc): domain.ScopeOrgs,
+	service(ssov1.Privacy_ServiceDesc):       domain.ScopePrivacy,
+	service(ssov1.UserAdmin_ServiceDesc):     domain.ScopeAdmin,
+	service(ssov1.AppAdmin_ServiceDesc):      domain.ScopeAdmin,
+	service(ssov1.Audit_ServiceDesc):         domain.ScopeAdmin,
+	service(ssov1.Webhooks_ServiceDesc):      domain.ScopeAdmin,
+	service(ssov1.Events_ServiceDesc):        domain.ScopeAdmin,
+}
+
+// service returns the "/package.service/" prefix of the methods of desc.
+func service(desc grpc.ServiceDesc) string {
+	return "/" + desc.ServiceName + "/"
+}
+
 type App struct {
 	log        *slog.Logger
 	gRPCServer *grpc.Server
 	port       int
 }
 
-func NewApp(log *slog.Logger, port int, servic authRPC.ServicAuth) *App {
-	gRPCServer := grpc.NewServer()
-	authRPC.Register(gRPCServer, servic)
+func NewApp(
+	log *slog.Logger,
+	port int,
+	services Services,
+	clients *clientinfo.Resolver,
+	limiter *ratelimit.Limiter,
+	authorizer *authz.Authorizer,
+	tenants *tenancy.Resolver,
+) *App {
+	// calls are limited before anything touches the database, then the
+	// tenant is resolved and the authorizer looks up the app of the token
+	// within it
+	gRPCServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			clients.UnaryServerInterceptor(),
+			limiter.UnaryServerInterceptor(),
+			warning.UnaryServerInterceptor(),
+			tenants.UnaryServerInterceptor(),
+			authorizer.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			clients.StreamServerInterceptor(),
+			limiter.StreamServerInterceptor(),
+			tenants.StreamServerInterceptor(),
+			authorizer.StreamServerInterceptor(),
+		),
+	)
+	authRPC.Register(gRPCServer, services.Auth)
+	auditRPC.Register(gRPCServer, services.Audit)
+	webhookRPC.Register(gRPCServer, services.Webhooks)
+	eventsRPC.Register(gRPCServer, services.Events)
+	useradminRPC.Register(gRPCServer, services.UserAdmin)
+	appadminRPC.Register(gRPCServer, services.AppAdmin)
+	profileRPC.Register(gRPCServer, services.Profiles)
+	privacyRPC.Register(gRPCServer, services.Privacy)
+	sessionRPC.Register(gRPCServer, services.Sessions)
+	orgRPC.Register(gRPCServer, services.Orgs)
+	apikeyRPC.Register(gRPCServer, services.APIKeys)
+	federationRPC.Register(gRPCServer, services.Federation)
 	return &App{
 		log:        log,
 		gRPCServer: gRPCServer,
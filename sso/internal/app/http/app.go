@@ -0,0 +1,77 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	Httpsaml "github.com/goggle-source/grpc-servic/sso/internal/http/saml"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/ratelimit"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// App serves the browser facing endpoints, the SAML ones for now. Requests
+// are limited like the calls of the gRPC server, by the address of the
+// client behind the trusted proxies.
+type App struct {
+	log    *slog.Logger
+	server *http.Server
+	port   int
+}
+
+func NewApp(
+	log *slog.Logger,
+	port int,
+	saml Httpsaml.ServicSAML,
+	clients *clientinfo.Resolver,
+	limiter *ratelimit.Limiter,
+) *App {
+	mux := http.NewServeMux()
+	Httpsaml.Register(mux, saml)
+
+	return &App{
+		log: log,
+		server: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           clients.Middleware(limiter.Middleware(mux)),
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		port: port,
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	a.log.With(slog.String("op", op)).
+		Info("starting http server", slog.Int("port", a.port))
+
+	if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "httpapp.Stop"
+
+	a.log.With(slog.String("op", op)).
+		Info("stopping http server", slog.Int("port", a.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	_ = a.server.Shutdown(ctx)
+}
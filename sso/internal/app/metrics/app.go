@@ -0,0 +1,66 @@
+package metricsapp
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// App serves the expvar counters on /debug/vars.
+type App struct {
+	log    *slog.Logger
+	server *http.Server
+	port   int
+}
+
+func NewApp(log *slog.Logger, port int) *App {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return &App{
+		log: log,
+		server: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		port: port,
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "metricsapp.Run"
+
+	a.log.With(slog.String("op", op)).
+		Info("starting metrics server", slog.Int("port", a.port))
+
+	if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "metricsapp.Stop"
+
+	a.log.With(slog.String("op", op)).
+		Info("stopping metrics server", slog.Int("port", a.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	_ = a.server.Shutdown(ctx)
+}
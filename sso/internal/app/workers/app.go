@@ -0,0 +1,62 @@
+package workersapp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Worker runs until ctx is done.
+type Worker func(ctx context.Context)
+
+// App runs the background jobs of the service.
+type App struct {
+	log     *slog.Logger
+	workers map[string]Worker
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewApp(log *slog.Logger) *App {
+	return &App{
+		log:     log,
+		workers: make(map[string]Worker),
+	}
+}
+
+// Add registers a worker, it has to be called before Run.
+func (a *App) Add(name string, worker Worker) {
+	a.workers[name] = worker
+}
+
+// Run starts every worker and returns immediately.
+func (a *App) Run() {
+	const op = "workersapp.Run"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+
+	for name, worker := range a.workers {
+		a.log.Info("starting worker", slog.String("op", op), slog.String("worker", name))
+
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			worker(ctx)
+		}()
+	}
+}
+
+// Stop cancels the workers and waits for them to return.
+func (a *App) Stop() {
+	const op = "workersapp.Stop"
+
+	if a.cancel == nil {
+		return
+	}
+
+	a.log.Info("stopping workers", slog.String("op", op))
+
+	a.cancel()
+	a.wg.Wait()
+}
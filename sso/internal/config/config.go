@@ -8,10 +8,31 @@ import (
 )
 
 type Config struct {
-	Env      string        `mapstructure:"env"`
-	TokenTTL time.Duration `mapstructure:"token_ttl" env-required:"true"`
-	GRPC     GrpcServer    `mapstructure:"grpc-server"`
-	Db       Database      `mapstructure:"database"`
+	Env      string           `mapstructure:"env"`
+	TokenTTL time.Duration    `mapstructure:"token_ttl" env-required:"true"`
+	GRPC     GrpcServer       `mapstructure:"grpc-server"`
+	Db       Database         `mapstructure:"database"`
+	Password PasswordPolicy   `mapstructure:"password_policy"`
+	Breach   Breach           `mapstructure:"breach"`
+	Rotation PasswordRotation `mapstructure:"password_rotation"`
+	Lockout  Lockout          `mapstructure:"lockout"`
+	Metrics  Metrics          `mapstructure:"metrics"`
+	HTTP     HTTPServer       `mapstructure:"http"`
+	Clients  Clients          `mapstructure:"clients"`
+	Limits   RateLimit        `mapstructure:"rate_limit"`
+	Notifier Notifier         `mapstructure:"notifier"`
+	Hardened bool             `mapstructure:"hardened"`
+	Audit    Audit            `mapstructure:"audit"`
+	Outbox   Outbox           `mapstructure:"outbox"`
+	Webhooks Webhooks         `mapstructure:"webhooks"`
+	Watch    Watch            `mapstructure:"watch"`
+	Erasure  Erasure          `mapstructure:"erasure"`
+	Sessions Sessions         `mapstructure:"sessions"`
+	Factors  Factors          `mapstructure:"factors"`
+	Risk     Risk             `mapstructure:"risk"`
+	APIKeys  APIKeys          `mapstructure:"api_keys"`
+	Upstream Federation       `mapstructure:"federation"`
+	LDAP     Directory        `mapstructure:"ldap"`
 }
 
 type GrpcServer struct {
@@ -27,6 +48,330 @@ type Database struct {
 	Host     string `mapstructure:"host"`
 }
 
+type PasswordRules struct {
+	MinLength         int      `mapstructure:"min_length"`
+	MaxLength         int      `mapstructure:"max_length"`
+	RequireUpper      bool     `mapstructure:"require_upper"`
+	RequireLower      bool     `mapstructure:"require_lower"`
+	RequireDigit      bool     `mapstructure:"require_digit"`
+	RequireSpecial    bool     `mapstructure:"require_special"`
+	BanEmailLocalPart bool     `mapstructure:"ban_email_local_part"`
+	BannedSubstrings  []string `mapstructure:"banned_substrings"`
+	BlocklistPath     string   `mapstructure:"blocklist_path"`
+}
+
+// PasswordPolicy is the deployment wide policy. An entry in Apps overlays it
+// for the given app.
+type PasswordPolicy struct {
+	PasswordRules `mapstructure:",squash"`
+	Apps          []AppPasswordPolicy `mapstructure:"apps"`
+}
+
+// AppPasswordPolicy holds the rules an app changes, unset fields keep the
+// deployment value. BannedSubstrings and BlocklistPath add to the deployment
+// ones, an app can not lift them.
+type AppPasswordPolicy struct {
+	AppID             int64    `mapstructure:"app_id"`
+	MinLength         *int     `mapstructure:"min_length"`
+	MaxLength         *int     `mapstructure:"max_length"`
+	RequireUpper      *bool    `mapstructure:"require_upper"`
+	RequireLower      *bool    `mapstructure:"require_lower"`
+	RequireDigit      *bool    `mapstructure:"require_digit"`
+	RequireSpecial    *bool    `mapstructure:"require_special"`
+	BanEmailLocalPart *bool    `mapstructure:"ban_email_local_part"`
+	BannedSubstrings  []string `mapstructure:"banned_substrings"`
+	BlocklistPath     string   `mapstructure:"blocklist_path"`
+}
+
+// Breach configures the offline breached password check. Mode is "warn" or
+// "block", passwords seen fewer than MinCount times are accepted.
+type Breach struct {
+	CorpusPath string `mapstructure:"corpus_path"`
+	Mode       string `mapstructure:"mode"`
+	MinCount   int    `mapstructure:"min_count"`
+}
+
+// PasswordRotation controls reuse and expiry of passwords. Zero values turn
+// the corresponding check off.
+type PasswordRotation struct {
+	HistoryDepth   int           `mapstructure:"history_depth"`
+	MaxAgeDays     int           `mapstructure:"max_age_days"`
+	ChangeTokenTTL time.Duration `mapstructure:"change_token_ttl"`
+}
+
+// Lockout locks an account or a client address after the given number of
+// failed logins within Window. A zero threshold turns that counter off.
+type Lockout struct {
+	AccountThreshold int           `mapstructure:"account_threshold"`
+	IPThreshold      int           `mapstructure:"ip_threshold"`
+	Window           time.Duration `mapstructure:"window"`
+	BaseDuration     time.Duration `mapstructure:"base_duration"`
+	MaxDuration      time.Duration `mapstructure:"max_duration"`
+}
+
+// RateLimit configures the token buckets of the gRPC and the HTTP server.
+// Store is "memory" or "postgres". Methods override Default by full method
+// name, e.g. "/auth.auth/Login", or by the pattern of an HTTP route, e.g.
+// "POST /saml/{connector}/acs". A rule without requests is unlimited.
+type RateLimit struct {
+	Store    string            `mapstructure:"store"`
+	KeyByApp bool              `mapstructure:"key_by_app"`
+	Default  RateLimitRule     `mapstructure:"default"`
+	Methods  []MethodRateLimit `mapstructure:"methods"`
+}
+
+type RateLimitRule struct {
+	Requests int           `mapstructure:"requests"`
+	Per      time.Duration `mapstructure:"per"`
+	Burst    int           `mapstructure:"burst"`
+}
+
+type MethodRateLimit struct {
+	Method        string `mapstructure:"method"`
+	RateLimitRule `mapstructure:",squash"`
+}
+
+// Notifier selects how users are notified, Kind is "log" or "smtp".
+type Notifier struct {
+	Kind string `mapstructure:"kind"`
+	SMTP SMTP   `mapstructure:"smtp"`
+}
+
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+}
+
+// Audit.Retention is how long audit events are kept, zero keeps them
+// forever. SigningKeyPath holds the hex encoded Ed25519 seed that signs a
+// checkpoint of the chain every CheckpointInterval.
+type Audit struct {
+	Retention          time.Duration `mapstructure:"retention"`
+	CheckpointInterval time.Duration `mapstructure:"checkpoint_interval"`
+	SigningKeyPath     string        `mapstructure:"signing_key_path"`
+}
+
+// Outbox configures the relay of domain events. Published events are kept
+// for Retention, zero keeps them forever.
+type Outbox struct {
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	Lease        time.Duration `mapstructure:"lease"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff"`
+	Retention    time.Duration `mapstructure:"retention"`
+	Publisher    Publisher     `mapstructure:"publisher"`
+}
+
+// Publisher selects where events go, Kind is "log", "file" or "webhook".
+type Publisher struct {
+	Kind    string        `mapstructure:"kind"`
+	Path    string        `mapstructure:"path"`
+	URL     string        `mapstructure:"url"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Webhooks configures the delivery of webhooks, a delivery is dead-lettered
+// after MaxAttempts failed attempts. Webhooks may only point to public
+// addresses unless AllowPrivateNetworks is set, for local development.
+type Webhooks struct {
+	PollInterval         time.Duration `mapstructure:"poll_interval"`
+	BatchSize            int           `mapstructure:"batch_size"`
+	Lease                time.Duration `mapstructure:"lease"`
+	Timeout              time.Duration `mapstructure:"timeout"`
+	MaxAttempts          int           `mapstructure:"max_attempts"`
+	MaxBackoff           time.Duration `mapstructure:"max_backoff"`
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+}
+
+// Watch configures WatchUserEvents, the outbox is polled every
+// PollInterval.
+type Watch struct {
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// Erasure configures the right to erasure. A user is disabled as soon as the
+// erasure is requested and erased once GracePeriod has passed, until then the
+// request can be cancelled.
+type Erasure struct {
+	GracePeriod  time.Duration `mapstructure:"grace_period"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	Lease        time.Duration `mapstructure:"lease"`
+}
+
+// Sessions configures login sessions. A session expires when its refresh
+// token is not used for RefreshTTL, expired sessions are deleted every
+// CleanupInterval.
+type Sessions struct {
+	RefreshTTL      time.Duration `mapstructure:"refresh_ttl"`
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+}
+
+// Factors configures the second factors. KeyPath holds the hex encoded 32
+// byte key that seals the TOTP secrets, without it they are stored in the
+// clear, which hardened mode does not allow.
+type Factors struct {
+	KeyPath string `mapstructure:"key_path"`
+}
+
+// Federation lists the upstream OpenID Connect and SAML providers users may
+// log in with. The state of a login at a provider expires after StateTTL.
+type Federation struct {
+	StateTTL   time.Duration   `mapstructure:"state_ttl"`
+	Timeout    time.Duration   `mapstructure:"timeout"`
+	Connectors []OIDCConnector `mapstructure:"connectors"`
+	SAML       []SAMLConnector `mapstructure:"saml"`
+}
+
+// OIDCConnector is an upstream provider of a tenant, zero TenantID means the
+// default tenant. A non-zero AppID limits the connector to that app.
+//
+// JIT creates users on their first login. Link decides what happens when the
+// email of a new identity belongs to a user already: "verified_email" links
+// the identity if the provider verified the email, "none" refuses the login.
+type OIDCConnector struct {
+	Name         string   `mapstructure:"name"`
+	TenantID     int64    `mapstructure:"tenant_id"`
+	AppID        int64    `mapstructure:"app_id"`
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+	JIT          bool     `mapstructure:"jit"`
+	Link         string   `mapstructure:"link"`
+}
+
+// SAMLConnector is a SAML 2.0 identity provider of a tenant. Name is part of
+// the URLs of the HTTP server, /saml/{name}/metadata, /saml/{name}/login and
+// /saml/{name}/acs, so it has to be unique across tenants. EntityID and
+// ACSURL are what this service is called at the provider, ACSURL is the
+// public URL of the acs endpoint.
+//
+// IdPCertificate is the PEM encoded certificate the provider signs with. The
+// email of a user is the EmailAttribute of the assertion, without it the
+// NameID when that is of the emailAddress format. JIT and Link are the ones
+// of OIDCConnector. SAML has no claim for a verified email, TrustEmail takes
+// the emails the provider asserts as verified, without it they neither
+// provision users nor link to existing ones.
+//
+// ProfileAttributes names the attributes the profile of a provisioned user
+// is filled from, empty names are not mapped.
+type SAMLConnector struct {
+	Name           string `mapstructure:"name"`
+	TenantID       int64  `mapstructure:"tenant_id"`
+	AppID          int64  `mapstructure:"app_id"`
+	EntityID       string `mapstructure:"entity_id"`
+	ACSURL         string `mapstructure:"acs_url"`
+	IdPEntityID    string `mapstructure:"idp_entity_id"`
+	IdPSSOURL      string `mapstructure:"idp_sso_url"`
+	IdPCertificate string `mapstructure:"idp_certificate"`
+	EmailAttribute string `mapstructure:"email_attribute"`
+	JIT            bool   `mapstructure:"jit"`
+	Link           string `mapstructure:"link"`
+	TrustEmail     bool   `mapstructure:"trust_email"`
+
+	ProfileAttributes SAMLProfileAttributes `mapstructure:"profile_attributes"`
+}
+
+// SAMLProfileAttributes maps the attributes of an assertion to the fields of
+// a profile.
+type SAMLProfileAttributes struct {
+	DisplayName string `mapstructure:"display_name"`
+	Locale      string `mapstructure:"locale"`
+	Timezone    string `mapstructure:"timezone"`
+	AvatarURL   string `mapstructure:"avatar_url"`
+}
+
+// Directory is an LDAP directory the users of a tenant log in against, zero
+// TenantID means the default tenant and an empty URL turns it off.
+//
+// Mode "first" asks the directory before the local passwords and falls back
+// to them for users the directory does not know, and for users that never
+// logged in through it while it is unreachable. "only" never falls back.
+// Users are found below BaseDN with UserFilter, "%s" is replaced with the
+// escaped email. Up to CacheSize entries found are cached for CacheTTL, the
+// password is checked against the directory on every login.
+type Directory struct {
+	TenantID       int64         `mapstructure:"tenant_id"`
+	Mode           string        `mapstructure:"mode"`
+	URL            string        `mapstructure:"url"`
+	StartTLS       bool          `mapstructure:"start_tls"`
+	BindDN         string        `mapstructure:"bind_dn"`
+	BindPassword   string        `mapstructure:"bind_password"`
+	BaseDN         string        `mapstructure:"base_dn"`
+	UserFilter     string        `mapstructure:"user_filter"`
+	EmailAttribute string        `mapstructure:"email_attribute"`
+	GroupAttribute string        `mapstructure:"group_attribute"`
+	GroupRoles     []GroupRole   `mapstructure:"group_roles"`
+	CacheTTL       time.Duration `mapstructure:"cache_ttl"`
+	CacheSize      int           `mapstructure:"cache_size"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+}
+
+// GroupRole grants Role to the members of the directory group with the DN
+// Group.
+type GroupRole struct {
+	Group string `mapstructure:"group"`
+	Role  string `mapstructure:"role"`
+}
+
+// APIKeys configures the API keys of users. A key lives at most MaxTTL, the
+// last use of keys is written every FlushInterval. Expired keys are deleted
+// ExpiredRetention after they expired.
+type APIKeys struct {
+	MaxTTL           time.Duration `mapstructure:"max_ttl"`
+	FlushInterval    time.Duration `mapstructure:"flush_interval"`
+	ExpiredRetention time.Duration `mapstructure:"expired_retention"`
+}
+
+// Risk scores every login by adding the weights of the signals it raises. A
+// score at or above a threshold takes that action, a zero threshold or a zero
+// weight turns it off. BlockScore is the exception, zero blocks at 100 and
+// only a negative score never blocks. Logins are kept for HistoryRetention to
+// compare the next ones against.
+type Risk struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	GeoIPPath        string        `mapstructure:"geoip_path"`
+	NotifyScore      int           `mapstructure:"notify_score"`
+	StepUpScore      int           `mapstructure:"step_up_score"`
+	BlockScore       int           `mapstructure:"block_score"`
+	Weights          RiskWeights   `mapstructure:"weights"`
+	MaxSpeedKmh      float64       `mapstructure:"max_speed_kmh"`
+	MinTravelKm      float64       `mapstructure:"min_travel_km"`
+	AccountsPerIP    int           `mapstructure:"accounts_per_ip"`
+	AccountsWindow   time.Duration `mapstructure:"accounts_window"`
+	HistoryRetention time.Duration `mapstructure:"history_retention"`
+}
+
+type RiskWeights struct {
+	NewDevice        int `mapstructure:"new_device"`
+	NewIPRange       int `mapstructure:"new_ip_range"`
+	ImpossibleTravel int `mapstructure:"impossible_travel"`
+	SharedIP         int `mapstructure:"many_accounts_per_ip"`
+}
+
+// Clients tells how the address of a client is found. Behind proxies the
+// peer of a call is the nearest proxy, the address is then read from IPHeader
+// when the peer is one of TrustedProxies, addresses or CIDR ranges.
+type Clients struct {
+	IPHeader       string   `mapstructure:"ip_header"`
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+type Metrics struct {
+	Port int `mapstructure:"port"`
+}
+
+// HTTPServer serves the browser facing endpoints such as the SAML ones, a
+// zero Port turns it off.
+type HTTPServer struct {
+	Port int `mapstructure:"port"`
+}
+
 func MustLoad() *Config {
 
 	path := ".\\config"
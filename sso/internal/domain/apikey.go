@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// API key scopes, a key may only call the methods of its scopes. Methods
+// without a scope can not be called with a key at all.
+const (
+	ScopeProfile  = "profile"
+	ScopeSessions = "sessions"
+	ScopeOrgs     = "orgs"
+	ScopePrivacy  = "privacy"
+	ScopeAdmin    = "admin"
+)
+
+// Scopes are the valid API key scopes.
+var Scopes = []string{ScopeProfile, ScopeSessions, ScopeOrgs, ScopePrivacy, ScopeAdmin}
+
+// APIKey is a long-lived credential of a user for scripts and CI jobs. Only
+// the prefix and a hash of the key are kept, the key itself is shown once.
+type APIKey struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	Hash       []byte
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit event types.
+const (
+	AuditRegister       = "register"
+	AuditLogin          = "login"
+	AuditAdminCheck     = "admin_check"
+	AuditPasswordChange = "password_change"
+	AuditTokenRevoke    = "token_revoke"
+	AuditLoginLockout   = "login_lockout"
+	AuditUserUnlock     = "user_unlock"
+	AuditUserUpdate     = "user_update"
+	AuditUserDisable    = "user_disable"
+	AuditUserEnable     = "user_enable"
+	AuditUserDelete     = "user_delete"
+	AuditProfileUpdate  = "profile_update"
+	AuditEmailChangeReq = "email_change_request"
+	AuditEmailChange    = "email_change"
+	AuditDataExport     = "data_export"
+	AuditErasureRequest = "erasure_request"
+	AuditErasureCancel  = "erasure_cancel"
+	AuditUserErase      = "user_erase"
+	AuditTokenRefresh   = "token_refresh"
+	AuditSessionRevoke  = "session_revoke"
+	AuditRiskDecision   = "risk_decision"
+	AuditOTPEnroll      = "otp_enroll"
+	AuditOTPConfirm     = "otp_confirm"
+	AuditOTPRemove      = "otp_remove"
+	AuditOTPReset       = "otp_reset"
+	AuditStepUp         = "step_up"
+	AuditOrgCreate      = "org_create"
+	AuditOrgInvite      = "org_invite"
+	AuditOrgJoin        = "org_join"
+	AuditOrgUpdate      = "org_member_update"
+	AuditOrgRemove      = "org_member_remove"
+	AuditAPIKeyCreate   = "api_key_create"
+	AuditAPIKeyRevoke   = "api_key_revoke"
+	AuditFederatedLogin = "federated_login"
+	AuditIdentityLink   = "identity_link"
+	AuditAppUpdate      = "app_update"
+)
+
+// UserSubject is the audit subject of an action on a user.
+func UserSubject(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+// ParseUserSubject returns the user of a subject made by UserSubject.
+func ParseUserSubject(subject string) (userID int64, ok bool) {
+	id, ok := strings.CutPrefix(subject, "user:")
+	if !ok {
+		return 0, false
+	}
+
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// OrgSubject is the audit subject of an action on an organization.
+func OrgSubject(orgID int64) string {
+	return "org:" + strconv.FormatInt(orgID, 10)
+}
+
+// AccountSubject is the audit subject of the failed logins and lockouts of
+// email, which need not be the address of a user.
+func AccountSubject(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// AppSubject is the audit subject of an action on an app.
+func AppSubject(appID int64) string {
+	return "app:" + strconv.FormatInt(appID, 10)
+}
+
+// ErasedSubject replaces subjects removed by the erasure with tombstoneID.
+func ErasedSubject(tombstoneID int64) string {
+	return "erasure:" + strconv.FormatInt(tombstoneID, 10)
+}
+
+// Audit event results.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+type AuditEvent struct {
+	ID        int64
+	Type      string
+	ActorID   int64
+	Subject   string
+	IP        string
+	UserAgent string
+	AppID     int64
+	Result    string
+	Reason    string
+	CreatedAt time.Time
+
+	// PrevHash and Hash chain the event to the one stored before it, both
+	// are hex encoded SHA-256 sums. With TenantChain set that is the event
+	// stored before it in the same tenant, older events are chained to the
+	// event stored before them in any tenant.
+	PrevHash    string
+	Hash        string
+	TenantChain bool
+
+	// PIIDigest is set once the personal data of the event was erased, it
+	// stands in for the removed data in the hash. TombstoneID is the erasure
+	// that removed it.
+	PIIDigest   string
+	TombstoneID int64
+
+	// TenantID is covered by the hash when TenantHashed is set, events
+	// stored before it was added are not.
+	TenantID     int64
+	TenantHashed bool
+}
+
+// AuditCheckpoint is a signature over the chain head at EventID.
+type AuditCheckpoint struct {
+	ID        int64
+	EventID   int64
+	Hash      string
+	Signature []byte
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit events, zero fields match everything. Events
+// come newest first, or oldest first when Ascending is set, AfterID continues
+// a previous page.
+type AuditFilter struct {
+	ActorID int64
+	Type    string
+	AppID   int64
+	Result  string
+	Since   time.Time
+	Until   time.Time
+	AfterID int64
+	Limit   int
+
+	Ascending bool
+}
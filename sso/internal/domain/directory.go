@@ -0,0 +1,19 @@
+package domain
+
+// Directory modes, see config.Directory.
+const (
+	DirectoryFirst = "first"
+	DirectoryOnly  = "only"
+)
+
+// RoleAdmin is the role a directory group grants to make its members
+// admins.
+const RoleAdmin = "admin"
+
+// DirectoryUser is an entry of an external directory whose password was
+// checked. Roles are granted by the groups of the entry.
+type DirectoryUser struct {
+	DN    string
+	Email string
+	Roles []string
+}
@@ -0,0 +1,55 @@
+package domain
+
+import "time"
+
+// Domain event types published through the outbox.
+const (
+	EventUserRegistered  = "UserRegistered"
+	EventPasswordChanged = "PasswordChanged"
+	EventUserDeleted     = "UserDeleted"
+	EventEmailChanged    = "EmailChanged"
+)
+
+// Event is a change other services may react to. ID is unique per event,
+// consumers use it to drop the duplicates at-least-once delivery produces.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	TenantID   int64     `json:"tenant_id,omitempty"`
+	UserID     int64     `json:"user_id"`
+	AppID      int64     `json:"app_id,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// OutboxEvent is an event waiting in the outbox, Seq orders the outbox.
+// Position places it in the stream of events.
+type OutboxEvent struct {
+	Seq      int64
+	Position EventPosition
+	Event    Event
+	Attempts int
+}
+
+// EventPosition orders the stream of outbox events by the transaction that
+// wrote them and then by Seq. Unlike Seq alone the order is safe to page
+// by, an event is streamed only once every transaction that could still
+// write an earlier one has ended.
+type EventPosition struct {
+	XID uint64
+	Seq int64
+}
+
+// Before reports whether p comes before o in the stream.
+func (p EventPosition) Before(o EventPosition) bool {
+	return p.XID < o.XID || (p.XID == o.XID && p.Seq < o.Seq)
+}
+
+// EventFilter selects outbox events after the After position in stream
+// order. Empty Types and zero AppID match everything.
+type EventFilter struct {
+	After EventPosition
+	Types []string
+	AppID int64
+	Limit int
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// Link policies of an upstream connector for identities whose email belongs
+// to a user already.
+const (
+	LinkNone          = "none"
+	LinkVerifiedEmail = "verified_email"
+)
+
+// FederatedIdentity links the subject of an upstream identity provider to a
+// user. Connector names the provider, Email is the one it reported when the
+// identity was linked.
+type FederatedIdentity struct {
+	ID        int64
+	UserID    int64
+	Connector string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// LoginCode is a one-time code of a finished login the app redeems for the
+// tokens of the user, only its hash is stored. IP, UserAgent and Device are
+// the client that logged in, the session is started for it.
+type LoginCode struct {
+	Hash      []byte
+	UserID    int64
+	AppID     int64
+	IP        string
+	UserAgent string
+	Device    string
+	ExpiresAt time.Time
+}
@@ -1,13 +1,83 @@
 package domain
 
+import "time"
+
+// User statuses, disabled users and users pending erasure can not log in.
+const (
+	UserStatusActive         = "active"
+	UserStatusDisabled       = "disabled"
+	UserStatusPendingErasure = "pending_erasure"
+)
+
 type User struct {
-	ID           int64
-	Email        string
-	PasswordHash []byte
+	ID                int64
+	TenantID          int64
+	Email             string
+	PasswordHash      []byte
+	PasswordChangedAt time.Time
+	Status            string
+	CreatedAt         time.Time
+	// TokensInvalidBefore revokes the access tokens issued before it.
+	TokensInvalidBefore time.Time
+	// Directory is set once the user logged in through the directory, it
+	// checks the password from then on.
+	Directory bool
+}
+
+// Disabled reports whether the user is kept from logging in.
+func (u User) Disabled() bool {
+	return u.Status == UserStatusDisabled || u.Status == UserStatusPendingErasure
+}
+
+// UserFilter selects users in id order. Email matches a part of the email,
+// AfterID continues a previous page.
+type UserFilter struct {
+	Email   string
+	Status  string
+	AfterID int64
+	Limit   int
+}
+
+// UserUpdate holds the fields to change, nil fields are kept.
+type UserUpdate struct {
+	Email     *string
+	IsAdmin   *bool
+	Directory *bool
 }
 
 type App struct {
-	ID     int64
-	Name   string
-	Secret string
+	ID       int64
+	TenantID int64
+	Name     string
+	Secret   string
+	// AttributeSchema validates the custom attributes the app writes, apps
+	// without one can not write any.
+	AttributeSchema AttributeSchema
+	// ClaimAttributes are the profile fields and custom attributes put into
+	// the access tokens of the app.
+	ClaimAttributes []string
+	// MinACR is the authentication level sessions of the app need, a session
+	// below it is told to step up. Zero accepts a password login.
+	MinACR int
+	// OrgScoped apps get the organizations of the user in the orgs claim.
+	OrgScoped bool
+	// CallbackURLs are where the HTTP server may send users back to after a
+	// login, with a one-time code.
+	CallbackURLs []string
+}
+
+// AppUpdate holds the fields of an app to change, nil fields are kept.
+type AppUpdate struct {
+	MinACR       *int
+	CallbackURLs *[]string
+}
+
+// EmailChange is a pending change of the email address, it is applied once
+// the new address is confirmed with the token whose hash is kept here.
+type EmailChange struct {
+	UserID    int64
+	NewEmail  string
+	AppID     int64
+	TokenHash []byte
+	ExpiresAt time.Time
 }
@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+// Member roles within an organization. Owners and admins manage the members,
+// only owners may make other owners.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrgRoles are the valid member roles.
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+// Organization groups users of a tenant, such as the customers of a B2B app.
+type Organization struct {
+	ID        int64
+	TenantID  int64
+	Name      string
+	CreatedBy int64
+	CreatedAt time.Time
+}
+
+// Member is the membership of a user in an organization, Teams are the
+// groups of the organization the user belongs to.
+type Member struct {
+	OrgID    int64
+	UserID   int64
+	Email    string
+	Role     string
+	Teams    []string
+	JoinedAt time.Time
+}
+
+// CanManage reports whether the member may invite and remove members.
+func (m Member) CanManage() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
+
+// MemberUpdate changes the fields of a membership that are not nil, an
+// empty Teams removes every team.
+type MemberUpdate struct {
+	Role  *string
+	Teams *[]string
+}
+
+// OrgInvite is a pending invitation, it turns into a membership once the
+// invited user accepts it with the token whose hash is kept here.
+type OrgInvite struct {
+	ID        int64
+	OrgID     int64
+	Email     string
+	Role      string
+	Teams     []string
+	InvitedBy int64
+	TokenHash []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
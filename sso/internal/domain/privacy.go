@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// Erasure is a request to erase a user. It stays as the tombstone erased
+// audit events point at once the user is gone.
+type Erasure struct {
+	ID          int64
+	UserID      int64
+	TenantID    int64
+	RequestedBy int64
+	RequestedAt time.Time
+	EraseAfter  time.Time
+	ErasedAt    time.Time
+}
+
+// Consent is the access a user granted an app by starting the first session
+// in it.
+type Consent struct {
+	AppID     int64
+	AppName   string
+	GrantedAt time.Time
+}
@@ -0,0 +1,50 @@
+package domain
+
+import "time"
+
+// Profile fields that can be mapped into token claims next to the custom
+// attributes.
+const (
+	ProfileDisplayName = "display_name"
+	ProfileLocale      = "locale"
+	ProfileTimezone    = "timezone"
+	ProfileAvatarURL   = "avatar_url"
+)
+
+// Attribute types of an AttributeSchema.
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+)
+
+type Profile struct {
+	UserID      int64
+	DisplayName string
+	Locale      string
+	Timezone    string
+	AvatarURL   string
+	Attributes  map[string]any
+	UpdatedAt   time.Time
+}
+
+// ProfileUpdate holds the fields to change, nil fields are kept. Attributes
+// are merged into the stored ones, a nil value removes the attribute.
+type ProfileUpdate struct {
+	DisplayName *string
+	Locale      *string
+	Timezone    *string
+	AvatarURL   *string
+	Attributes  map[string]any
+}
+
+// AttributeSchema maps the name of a custom attribute to its rule. It is
+// stored as JSON on the app.
+type AttributeSchema map[string]AttributeRule
+
+type AttributeRule struct {
+	Type      string   `json:"type"`
+	Required  bool     `json:"required,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+}
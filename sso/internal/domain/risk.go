@@ -0,0 +1,52 @@
+package domain
+
+import "time"
+
+// Risk signals raised by a login.
+const (
+	RiskNewDevice        = "new_device"
+	RiskNewIPRange       = "new_ip_range"
+	RiskImpossibleTravel = "impossible_travel"
+	RiskSharedIP         = "many_accounts_per_ip"
+)
+
+// Risk actions, from the mildest.
+const (
+	RiskAllow  = "allow"
+	RiskNotify = "notify"
+	RiskStepUp = "step_up"
+	RiskBlock  = "block"
+)
+
+// RiskDecision is the outcome of assessing a login.
+type RiskDecision struct {
+	Score   int
+	Signals []string
+	Action  string
+}
+
+// LoginRecord is a successful login kept to assess the next ones. HasLocation
+// is false when the address was not found in the GeoIP database.
+type LoginRecord struct {
+	UserID      int64
+	IP          string
+	IPRange     string
+	Device      string
+	Country     string
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+	CreatedAt   time.Time
+}
+
+// LoginHistory is what the earlier logins tell about a new one.
+type LoginHistory struct {
+	Logins       int
+	KnownDevice  bool
+	KnownIPRange bool
+	// Last is the latest login, zero when there is none.
+	Last LoginRecord
+	// AccountsFromIP counts the other users that logged in from the address
+	// recently.
+	AccountsFromIP int
+}
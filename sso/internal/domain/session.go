@@ -0,0 +1,81 @@
+package domain
+
+import "time"
+
+// Authentication methods, the amr claim lists those a session was
+// authenticated with.
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	// AMRFederated marks sessions of users an upstream identity provider
+	// authenticated, the value is not registered in RFC 8176.
+	AMRFederated = "fed"
+)
+
+// Authentication levels, the acr claim. A session starts at ACRPassword and
+// is raised by StepUp, apps declare the level they require with App.MinACR.
+const (
+	ACRPassword    = 1
+	ACRMultiFactor = 2
+)
+
+// MethodACR is the level a session reaches once it is confirmed with method.
+var MethodACR = map[string]int{
+	AMRPassword:  ACRPassword,
+	AMROTP:       ACRMultiFactor,
+	AMRFederated: ACRPassword,
+}
+
+// Session is one login of a user on a device. A session lives as long as its
+// refresh tokens, it is gone once revoked or expired.
+type Session struct {
+	ID         int64
+	UserID     int64
+	AppID      int64
+	Device     string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// ACR is the authentication level reached so far, AMR the methods used
+	// to reach it.
+	ACR int
+	AMR []string
+	// StepUpRequired is set when the login looked risky, the user has to
+	// confirm it with a stronger factor.
+	StepUpRequired bool
+}
+
+// RefreshToken is the stored part of a refresh token and the client it was
+// issued to.
+type RefreshToken struct {
+	Hash      []byte
+	IP        string
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+// Tokens are issued by Login and Refresh.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    int64
+	ACR          int
+	// StepUpRequired tells the client to confirm the session with a
+	// stronger factor.
+	StepUpRequired bool
+}
+
+// OTPFactor is the TOTP secret of a user. It is pending until the user
+// proves with a code that the authenticator app was set up, LastStep is the
+// last time step whose code was accepted.
+type OTPFactor struct {
+	UserID      int64
+	Secret      string
+	ConfirmedAt time.Time
+	LastStep    int64
+}
+
+// Confirmed reports whether the factor can be used to step up.
+func (f OTPFactor) Confirmed() bool { return !f.ConfirmedAt.IsZero() }
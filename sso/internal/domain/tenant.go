@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// Tenant is a customer of the deployment. Users and apps belong to one
+// tenant, the same email can be registered once per tenant.
+type Tenant struct {
+	ID        int64
+	Slug      string
+	Name      string
+	CreatedAt time.Time
+}
@@ -0,0 +1,62 @@
+package domain
+
+import "time"
+
+// Webhook delivery statuses. Dead deliveries ran out of attempts and are
+// kept for inspection only.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook receives the events of its app. Empty EventTypes selects every
+// event.
+type Webhook struct {
+	ID         int64
+	AppID      int64
+	URL        string
+	Secret     string
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+// Wants reports whether eventType is selected by the webhook.
+func (w Webhook) Wants(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookDelivery struct {
+	ID             int64
+	WebhookID      int64
+	TenantID       int64
+	EventID        string
+	EventType      string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    time.Time
+}
+
+// DeliveryFilter selects deliveries of a webhook, newest first. AfterID
+// continues a previous page.
+type DeliveryFilter struct {
+	WebhookID int64
+	Status    string
+	AfterID   int64
+	Limit     int
+}
@@ -0,0 +1,127 @@
+package Grpcapikey
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxNameLength = 200
+	maxScopes     = 10
+)
+
+type ServicAPIKeys interface {
+	CreateAPIKey(
+		ctx context.Context,
+		name string,
+		scopes []string,
+		expiresAt time.Time,
+	) (key string, apiKey domain.APIKey, err error)
+
+	ListAPIKeys(
+		ctx context.Context,
+	) (keys []domain.APIKey, err error)
+
+	RevokeAPIKey(
+		ctx context.Context,
+		keyID int64,
+	) error
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedApikeysServer
+	keys ServicAPIKeys
+}
+
+func Register(gRPC *grpc.Server, keys ServicAPIKeys) {
+	ssov1.RegisterApikeysServer(gRPC, &ServerAPI{keys: keys})
+}
+
+func (s *ServerAPI) CreateAPIKey(ctx context.Context, req *ssov1.CreateAPIKeyRequest) (*ssov1.CreateAPIKeyResponse, error) {
+	if err := ValidateCreateAPIKey(req); err != nil {
+		return nil, err
+	}
+
+	var expiresAt time.Time
+	if req.GetExpiresAt() != nil {
+		expiresAt = req.GetExpiresAt().AsTime()
+	}
+
+	key, apiKey, err := s.keys.CreateAPIKey(ctx, strings.TrimSpace(req.GetName()), req.GetScopes(), expiresAt)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.CreateAPIKeyResponse{
+		Key:    key,
+		ApiKey: toAPIKey(apiKey),
+	}, nil
+}
+
+func (s *ServerAPI) ListAPIKeys(ctx context.Context, req *ssov1.ListAPIKeysRequest) (*ssov1.ListAPIKeysResponse, error) {
+	keys, err := s.keys.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	out := make([]*ssov1.APIKey, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, toAPIKey(k))
+	}
+
+	return &ssov1.ListAPIKeysResponse{
+		ApiKeys: out,
+	}, nil
+}
+
+func (s *ServerAPI) RevokeAPIKey(ctx context.Context, req *ssov1.RevokeAPIKeyRequest) (*ssov1.RevokeAPIKeyResponse, error) {
+	if req.GetId() <= 0 {
+		return nil, grpcerr.InvalidArgument("id", "id is requred")
+	}
+
+	if err := s.keys.RevokeAPIKey(ctx, req.GetId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RevokeAPIKeyResponse{}, nil
+}
+
+func ValidateCreateAPIKey(req *ssov1.CreateAPIKeyRequest) error {
+	name := strings.TrimSpace(req.GetName())
+	if name == "" || len(name) > maxNameLength {
+		return grpcerr.InvalidArgument("name", "name is required and at most 200 bytes long")
+	}
+
+	if len(req.GetScopes()) == 0 || len(req.GetScopes()) > maxScopes {
+		return grpcerr.InvalidArgument("scopes", "between 1 and 10 scopes are required")
+	}
+
+	if req.GetExpiresAt() != nil && !req.GetExpiresAt().IsValid() {
+		return grpcerr.InvalidArgument("expires_at", "expires_at is not a valid time")
+	}
+
+	return nil
+}
+
+func toAPIKey(k domain.APIKey) *ssov1.APIKey {
+	out := &ssov1.APIKey{
+		Id:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		ExpiresAt: timestamppb.New(k.ExpiresAt),
+		CreatedAt: timestamppb.New(k.CreatedAt),
+	}
+	if !k.LastUsedAt.IsZero() {
+		out.LastUsedAt = timestamppb.New(k.LastUsedAt)
+	}
+
+	return out
+}
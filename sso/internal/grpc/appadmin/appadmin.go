@@ -0,0 +1,75 @@
+package Grpcappadmin
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+)
+
+const emptyID = 0
+
+type ServicAppAdmin interface {
+	UpdateApp(
+		ctx context.Context,
+		appID int64,
+		update domain.AppUpdate,
+	) (app domain.App, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedAppAdminServer
+	apps ServicAppAdmin
+}
+
+func Register(gRPC *grpc.Server, apps ServicAppAdmin) {
+	ssov1.RegisterAppAdminServer(gRPC, &ServerAPI{apps: apps})
+}
+
+func (s *ServerAPI) UpdateApp(ctx context.Context, req *ssov1.UpdateAppRequest) (*ssov1.UpdateAppResponse, error) {
+	if err := ValidateUpdateApp(req); err != nil {
+		return nil, err
+	}
+
+	var update domain.AppUpdate
+	if req.MinAcr != nil {
+		minACR := int(req.GetMinAcr())
+		update.MinACR = &minACR
+	}
+	if req.GetSetCallbackUrls() {
+		callbackURLs := req.GetCallbackUrls()
+		update.CallbackURLs = &callbackURLs
+	}
+
+	app, err := s.apps.UpdateApp(ctx, req.GetAppId(), update)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.UpdateAppResponse{
+		App: &ssov1.App{
+			Id:           app.ID,
+			Name:         app.Name,
+			MinAcr:       int32(app.MinACR),
+			CallbackUrls: app.CallbackURLs,
+		},
+	}, nil
+}
+
+func ValidateUpdateApp(req *ssov1.UpdateAppRequest) error {
+	if req.GetAppId() == emptyID {
+		return grpcerr.InvalidArgument("app_id", "app_id is requred")
+	}
+
+	if req.MinAcr == nil && !req.GetSetCallbackUrls() {
+		return grpcerr.InvalidArgument("min_acr", "nothing to update")
+	}
+
+	if req.MinAcr != nil && req.GetMinAcr() < 0 || req.GetMinAcr() > domain.ACRMultiFactor {
+		return grpcerr.InvalidArgument("min_acr", "min_acr must be 0, 1 or 2")
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package Grpcaudit
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServicAudit interface {
+	List(
+		ctx context.Context,
+		filter domain.AuditFilter,
+		pageSize int,
+		pageToken string,
+	) (events []domain.AuditEvent, nextPageToken string, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedAuditServer
+	audit ServicAudit
+}
+
+func Register(gRPC *grpc.Server, audit ServicAudit) {
+	ssov1.RegisterAuditServer(gRPC, &ServerAPI{audit: audit})
+}
+
+func (s *ServerAPI) ListAuditEvents(ctx context.Context, req *ssov1.ListAuditEventsRequest) (*ssov1.ListAuditEventsResponse, error) {
+	if err := ValidateListAuditEvents(req); err != nil {
+		return nil, err
+	}
+
+	filter := domain.AuditFilter{
+		ActorID: req.GetActorId(),
+		Type:    req.GetEventType(),
+		AppID:   int64(req.GetAppId()),
+		Result:  req.GetResult(),
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	events, next, err := s.audit.List(ctx, filter, int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp := &ssov1.ListAuditEventsResponse{
+		Events:        make([]*ssov1.AuditEvent, 0, len(events)),
+		NextPageToken: next,
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &ssov1.AuditEvent{
+			Id:        event.ID,
+			EventType: event.Type,
+			ActorId:   event.ActorID,
+			Subject:   event.Subject,
+			Ip:        event.IP,
+			UserAgent: event.UserAgent,
+			AppId:     int32(event.AppID),
+			Result:    event.Result,
+			Reason:    event.Reason,
+			CreatedAt: timestamppb.New(event.CreatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func ValidateListAuditEvents(req *ssov1.ListAuditEventsRequest) error {
+	if req.GetPageSize() < 0 {
+		return grpcerr.InvalidArgument("page_size", "page_size must not be negative")
+	}
+
+	switch req.GetResult() {
+	case "", domain.AuditSuccess, domain.AuditFailure:
+	default:
+		return grpcerr.InvalidArgument("result", "result must be success or failure")
+	}
+
+	if err := validTimestamp("since", req.GetSince()); err != nil {
+		return err
+	}
+
+	return validTimestamp("until", req.GetUntil())
+}
+
+func validTimestamp(field string, ts *timestamppb.Timestamp) error {
+	if ts == nil {
+		return nil
+	}
+
+	if err := ts.CheckValid(); err != nil {
+		return grpcerr.InvalidArgument(field, field+" is not a valid timestamp")
+	}
+
+	return nil
+}
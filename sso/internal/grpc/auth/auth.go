@@ -2,18 +2,21 @@ package Grpcauth
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"strings"
 
 	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
-	"github.com/goggle-source/grpc-servic/sso/internal/services/auth"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 const (
 	emptyID = 0
+
+	// minLoginPasswordLen only rejects obviously malformed input, the real
+	// rules live in the password policy and apply when a password is set.
+	minLoginPasswordLen = 7
 )
 
 type ServicAuth interface {
@@ -22,18 +25,71 @@ type ServicAuth interface {
 		email string,
 		password string,
 		appID int64,
-	) (token string, err error)
+	) (tokens domain.Tokens, err error)
 
 	Register(
 		ctx context.Context,
 		email string,
 		password string,
+		appID int64,
 	) (userID int64, err error)
 
 	IsAdmin(
 		ctx context.Context,
 		userID int64,
 	) (isAdmin bool, err error)
+
+	ChangePassword(
+		ctx context.Context,
+		email string,
+		oldPassword string,
+		newPassword string,
+		changeToken string,
+		appID int64,
+	) error
+
+	UnlockUser(
+		ctx context.Context,
+		userID int64,
+	) error
+
+	RequestEmailChange(
+		ctx context.Context,
+		password string,
+		newEmail string,
+	) error
+
+	ConfirmEmailChange(
+		ctx context.Context,
+		token string,
+	) error
+
+	Refresh(
+		ctx context.Context,
+		refreshToken string,
+	) (tokens domain.Tokens, err error)
+
+	EnrollOTP(
+		ctx context.Context,
+		password string,
+	) (secret string, uri string, err error)
+
+	ConfirmOTP(
+		ctx context.Context,
+		code string,
+	) error
+
+	RemoveOTP(
+		ctx context.Context,
+		password string,
+		code string,
+	) error
+
+	StepUp(
+		ctx context.Context,
+		method string,
+		code string,
+	) (tokens domain.Tokens, err error)
 }
 
 type ServerAPI struct {
@@ -51,20 +107,15 @@ func (s *ServerAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		return nil, err
 	}
 
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int64(req.GetAppId()))
-
+	tokens, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int64(req.GetAppId()))
 	if err != nil {
-		if errors.Is(err, auth.ErrInvalidCredentials) {
-			return nil, status.Error(codes.Internal, "error credentails")
-		}
-		if errors.Is(err, auth.ErrAppNotFound) {
-			return nil, status.Error(codes.NotFound, "app is not found")
-		}
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, grpcerr.Status(err)
 	}
 
 	return &ssov1.LoginResponse{
-		Token: token,
+		Token:          tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		StepUpRequired: tokens.StepUpRequired,
 	}, nil
 }
 
@@ -73,12 +124,16 @@ func (s *ServerAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 		return nil, err
 	}
 
-	userID, err := s.auth.Register(ctx, req.GetEmail(), req.GetPassword())
+	userID, err := s.auth.Register(ctx, req.GetEmail(), req.GetPassword(), int64(req.GetAppId()))
 	if err != nil {
-		if errors.Is(err, auth.ErrUserExists) {
-			return nil, status.Error(codes.AlreadyExists, "user alredy exists")
-		}
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, grpcerr.Status(err)
+	}
+
+	if userID == emptyID {
+		// hardened mode, the outcome was sent to the email address
+		return &ssov1.RegisterResponse{
+			Message: "check your email to continue",
+		}, nil
 	}
 
 	return &ssov1.RegisterResponse{
@@ -93,10 +148,7 @@ func (s *ServerAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ss
 
 	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
 	if err != nil {
-		if errors.Is(err, auth.ErrAppNotFound) {
-			return nil, status.Error(codes.NotFound, "app is not found")
-		}
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, grpcerr.Status(err)
 	}
 
 	return &ssov1.IsAdminResponse{
@@ -104,21 +156,147 @@ func (s *ServerAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ss
 	}, nil
 }
 
+func (s *ServerAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*ssov1.ChangePasswordResponse, error) {
+	if err := ValidateChangePassword(req); err != nil {
+		return nil, err
+	}
+
+	err := s.auth.ChangePassword(ctx, req.GetEmail(), req.GetOldPassword(), req.GetNewPassword(), req.GetChangeToken(), int64(req.GetAppId()))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.ChangePasswordResponse{}, nil
+}
+
+func (s *ServerAPI) UnlockUser(ctx context.Context, req *ssov1.UnlockUserRequest) (*ssov1.UnlockUserResponse, error) {
+	if req.GetUserId() == emptyID {
+		return nil, grpcerr.InvalidArgument("user_id", "user_id is requred")
+	}
+
+	if err := s.auth.UnlockUser(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.UnlockUserResponse{}, nil
+}
+
+func (s *ServerAPI) RequestEmailChange(ctx context.Context, req *ssov1.RequestEmailChangeRequest) (*ssov1.RequestEmailChangeResponse, error) {
+	if err := ValidateRequestEmailChange(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.RequestEmailChange(ctx, req.GetPassword(), req.GetNewEmail()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RequestEmailChangeResponse{}, nil
+}
+
+func (s *ServerAPI) ConfirmEmailChange(ctx context.Context, req *ssov1.ConfirmEmailChangeRequest) (*ssov1.ConfirmEmailChangeResponse, error) {
+	if req.GetToken() == "" {
+		return nil, grpcerr.InvalidArgument("token", "token is required")
+	}
+
+	if err := s.auth.ConfirmEmailChange(ctx, req.GetToken()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.ConfirmEmailChangeResponse{}, nil
+}
+
+func (s *ServerAPI) Refresh(ctx context.Context, req *ssov1.RefreshRequest) (*ssov1.RefreshResponse, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, grpcerr.InvalidArgument("refresh_token", "refresh_token is required")
+	}
+
+	tokens, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RefreshResponse{
+		Token:          tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		StepUpRequired: tokens.StepUpRequired,
+	}, nil
+}
+
+func (s *ServerAPI) EnrollOTP(ctx context.Context, req *ssov1.EnrollOTPRequest) (*ssov1.EnrollOTPResponse, error) {
+	if req.GetPassword() == "" {
+		return nil, grpcerr.InvalidArgument("password", "password is required")
+	}
+
+	secret, uri, err := s.auth.EnrollOTP(ctx, req.GetPassword())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.EnrollOTPResponse{
+		Secret:     secret,
+		OtpauthUri: uri,
+	}, nil
+}
+
+func (s *ServerAPI) ConfirmOTP(ctx context.Context, req *ssov1.ConfirmOTPRequest) (*ssov1.ConfirmOTPResponse, error) {
+	if req.GetCode() == "" {
+		return nil, grpcerr.InvalidArgument("code", "code is required")
+	}
+
+	if err := s.auth.ConfirmOTP(ctx, req.GetCode()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.ConfirmOTPResponse{}, nil
+}
+
+func (s *ServerAPI) RemoveOTP(ctx context.Context, req *ssov1.RemoveOTPRequest) (*ssov1.RemoveOTPResponse, error) {
+	if req.GetPassword() == "" {
+		return nil, grpcerr.InvalidArgument("password", "password is required")
+	}
+
+	if req.GetCode() == "" {
+		return nil, grpcerr.InvalidArgument("code", "code is required")
+	}
+
+	if err := s.auth.RemoveOTP(ctx, req.GetPassword(), req.GetCode()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RemoveOTPResponse{}, nil
+}
+
+func (s *ServerAPI) StepUp(ctx context.Context, req *ssov1.StepUpRequest) (*ssov1.StepUpResponse, error) {
+	if err := ValidateStepUp(req); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.auth.StepUp(ctx, req.GetMethod(), req.GetCode())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.StepUpResponse{
+		Token: tokens.AccessToken,
+		Acr:   int32(tokens.ACR),
+	}, nil
+}
+
 func ValidateLogin(req *ssov1.LoginRequest) error {
 	if req.GetEmail() == "" || !strings.Contains(req.GetEmail(), "@") {
-		return status.Error(codes.InvalidArgument, "email is required")
+		return grpcerr.InvalidArgument("email", "email is required")
 	}
 
 	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+		return grpcerr.InvalidArgument("password", "password is required")
 	}
 
-	if len(req.GetPassword()) < 7 {
-		return status.Error(codes.InvalidArgument, "password must be at least 10 characters")
+	if len(req.GetPassword()) < minLoginPasswordLen {
+		return grpcerr.InvalidArgument("password", fmt.Sprintf("password must be at least %d characters", minLoginPasswordLen))
 	}
 
 	if req.GetAppId() == emptyID {
-		return status.Error(codes.InvalidArgument, "app_id is required")
+		return grpcerr.InvalidArgument("app_id", "app_id is required")
 	}
 
 	return nil
@@ -126,15 +304,33 @@ func ValidateLogin(req *ssov1.LoginRequest) error {
 
 func ValidateRegister(req *ssov1.RegisterRequest) error {
 	if req.GetEmail() == "" || !strings.Contains(req.GetEmail(), "@") {
-		return status.Error(codes.InvalidArgument, "email is required")
+		return grpcerr.InvalidArgument("email", "email is required")
 	}
 
 	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+		return grpcerr.InvalidArgument("password", "password is required")
+	}
+
+	return nil
+}
+
+func ValidateChangePassword(req *ssov1.ChangePasswordRequest) error {
+	if req.GetChangeToken() == "" {
+		if req.GetEmail() == "" || !strings.Contains(req.GetEmail(), "@") {
+			return grpcerr.InvalidArgument("email", "email is required")
+		}
+
+		if req.GetOldPassword() == "" {
+			return grpcerr.InvalidArgument("old_password", "old_password is required")
+		}
+	}
+
+	if req.GetNewPassword() == "" {
+		return grpcerr.InvalidArgument("new_password", "new_password is required")
 	}
 
-	if len(req.GetPassword()) < 7 {
-		return status.Error(codes.InvalidArgument, "password must be at least 10 characters")
+	if req.GetAppId() == emptyID {
+		return grpcerr.InvalidArgument("app_id", "app_id is required")
 	}
 
 	return nil
@@ -142,7 +338,31 @@ func ValidateRegister(req *ssov1.RegisterRequest) error {
 
 func ValidateIsAdmin(req *ssov1.IsAdminRequest) error {
 	if req.GetUserId() == emptyID {
-		return status.Error(codes.InvalidArgument, "user_id is requred")
+		return grpcerr.InvalidArgument("user_id", "user_id is requred")
+	}
+
+	return nil
+}
+
+func ValidateRequestEmailChange(req *ssov1.RequestEmailChangeRequest) error {
+	if req.GetPassword() == "" {
+		return grpcerr.InvalidArgument("password", "password is required")
+	}
+
+	if req.GetNewEmail() == "" || !strings.Contains(req.GetNewEmail(), "@") {
+		return grpcerr.InvalidArgument("new_email", "new_email is required")
+	}
+
+	return nil
+}
+
+func ValidateStepUp(req *ssov1.StepUpRequest) error {
+	if req.GetMethod() != domain.AMROTP {
+		return grpcerr.InvalidArgument("method", "method must be otp")
+	}
+
+	if req.GetCode() == "" {
+		return grpcerr.InvalidArgument("code", "code is required")
 	}
 
 	return nil
@@ -0,0 +1,51 @@
+package Grpcevents
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServicEvents interface {
+	Watch(
+		ctx context.Context,
+		types []string,
+		appID int64,
+		cursor string,
+		send func(event domain.Event, cursor string) error,
+	) error
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedEventsServer
+	events ServicEvents
+}
+
+func Register(gRPC *grpc.Server, events ServicEvents) {
+	ssov1.RegisterEventsServer(gRPC, &ServerAPI{events: events})
+}
+
+func (s *ServerAPI) WatchUserEvents(req *ssov1.WatchUserEventsRequest, stream ssov1.Events_WatchUserEventsServer) error {
+	if req.GetAppId() < 0 {
+		return grpcerr.InvalidArgument("app_id", "app_id must not be negative")
+	}
+
+	err := s.events.Watch(stream.Context(), req.GetEventTypes(), int64(req.GetAppId()), req.GetCursor(),
+		func(event domain.Event, cursor string) error {
+			return stream.Send(&ssov1.UserEvent{
+				Cursor:     cursor,
+				EventId:    event.ID,
+				EventType:  event.Type,
+				UserId:     event.UserID,
+				AppId:      int32(event.AppID),
+				Email:      event.Email,
+				OccurredAt: timestamppb.New(event.OccurredAt),
+			})
+		})
+
+	return grpcerr.Status(err)
+}
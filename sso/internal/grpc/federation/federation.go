@@ -0,0 +1,162 @@
+package Grpcfederation
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const emptyID = 0
+
+type ServicFederation interface {
+	StartLogin(
+		ctx context.Context,
+		connector string,
+		appID int64,
+	) (authURL string, err error)
+
+	StartLink(
+		ctx context.Context,
+		connector string,
+	) (authURL string, err error)
+
+	FinishLogin(
+		ctx context.Context,
+		appID int64,
+		state string,
+		code string,
+	) (tokens domain.Tokens, err error)
+
+	Identities(
+		ctx context.Context,
+	) (identities []domain.FederatedIdentity, err error)
+
+	RedeemLoginCode(
+		ctx context.Context,
+		appID int64,
+		code string,
+	) (tokens domain.Tokens, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedFederationServer
+	federation ServicFederation
+}
+
+func Register(gRPC *grpc.Server, federation ServicFederation) {
+	ssov1.RegisterFederationServer(gRPC, &ServerAPI{federation: federation})
+}
+
+func (s *ServerAPI) StartFederatedLogin(ctx context.Context, req *ssov1.StartFederatedLoginRequest) (*ssov1.StartFederatedLoginResponse, error) {
+	if req.GetConnector() == "" {
+		return nil, grpcerr.InvalidArgument("connector", "connector is requred")
+	}
+
+	var (
+		authURL string
+		err     error
+	)
+	if req.GetLink() {
+		authURL, err = s.federation.StartLink(ctx, req.GetConnector())
+	} else {
+		if req.GetAppId() == emptyID {
+			return nil, grpcerr.InvalidArgument("app_id", "app_id is requred")
+		}
+		authURL, err = s.federation.StartLogin(ctx, req.GetConnector(), int64(req.GetAppId()))
+	}
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.StartFederatedLoginResponse{
+		AuthUrl: authURL,
+	}, nil
+}
+
+func (s *ServerAPI) FinishFederatedLogin(ctx context.Context, req *ssov1.FinishFederatedLoginRequest) (*ssov1.FinishFederatedLoginResponse, error) {
+	if err := ValidateFinish(req); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.federation.FinishLogin(ctx, int64(req.GetAppId()), req.GetState(), req.GetCode())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.FinishFederatedLoginResponse{
+		Token:          tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		StepUpRequired: tokens.StepUpRequired,
+	}, nil
+}
+
+func (s *ServerAPI) RedeemLoginCode(ctx context.Context, req *ssov1.RedeemLoginCodeRequest) (*ssov1.RedeemLoginCodeResponse, error) {
+	if err := ValidateRedeemLoginCode(req); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.federation.RedeemLoginCode(ctx, int64(req.GetAppId()), req.GetCode())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RedeemLoginCodeResponse{
+		Token:          tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		StepUpRequired: tokens.StepUpRequired,
+	}, nil
+}
+
+func (s *ServerAPI) ListIdentities(ctx context.Context, req *ssov1.ListIdentitiesRequest) (*ssov1.ListIdentitiesResponse, error) {
+	identities, err := s.federation.Identities(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	out := make([]*ssov1.FederatedIdentity, 0, len(identities))
+	for _, i := range identities {
+		out = append(out, &ssov1.FederatedIdentity{
+			Id:        i.ID,
+			Connector: i.Connector,
+			Subject:   i.Subject,
+			Email:     i.Email,
+			CreatedAt: timestamppb.New(i.CreatedAt),
+		})
+	}
+
+	return &ssov1.ListIdentitiesResponse{
+		Identities: out,
+	}, nil
+}
+
+func ValidateFinish(req *ssov1.FinishFederatedLoginRequest) error {
+	if req.GetAppId() == emptyID {
+		return grpcerr.InvalidArgument("app_id", "app_id is requred")
+	}
+
+	if req.GetState() == "" {
+		return grpcerr.InvalidArgument("state", "state is requred")
+	}
+
+	if req.GetCode() == "" {
+		return grpcerr.InvalidArgument("code", "code is requred")
+	}
+
+	return nil
+}
+
+func ValidateRedeemLoginCode(req *ssov1.RedeemLoginCodeRequest) error {
+	if req.GetAppId() == emptyID {
+		return grpcerr.InvalidArgument("app_id", "app_id is requred")
+	}
+
+	if req.GetCode() == "" {
+		return grpcerr.InvalidArgument("code", "code is requred")
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+// Package grpcerr translates errors of the services into gRPC statuses.
+//
+// Every status carries an errdetails.ErrorInfo in the "sso" domain whose
+// Reason is stable and meant for clients to branch on. Password problems add
+// a BadRequest with one violation per broken rule, throttling adds a
+// RetryInfo.
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/attrschema"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/password"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/apikey"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/appadmin"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/audit"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/auth"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/events"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/federation"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/org"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/privacy"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/profile"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/session"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/useradmin"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/webhook"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const Domain = "sso"
+
+const (
+	ReasonInvalidArgument    = "INVALID_ARGUMENT"
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonInvalidToken       = "INVALID_TOKEN"
+	ReasonUnauthenticated    = "UNAUTHENTICATED"
+	ReasonPermissionDenied   = "PERMISSION_DENIED"
+	ReasonInvalidPageToken   = "INVALID_PAGE_TOKEN"
+	ReasonInvalidCursor      = "INVALID_CURSOR"
+	ReasonCursorExpired      = "CURSOR_EXPIRED"
+	ReasonUserExists         = "USER_EXISTS"
+	ReasonUserNotFound       = "USER_NOT_FOUND"
+	ReasonUserDisabled       = "USER_DISABLED"
+	ReasonSelfAction         = "SELF_ACTION"
+	ReasonAppNotFound        = "APP_NOT_FOUND"
+	ReasonWebhookNotFound    = "WEBHOOK_NOT_FOUND"
+	ReasonErasurePending     = "ERASURE_PENDING"
+	ReasonNoErasure          = "NO_ERASURE"
+	ReasonSessionNotFound    = "SESSION_NOT_FOUND"
+	ReasonFactorEnrolled     = "FACTOR_ENROLLED"
+	ReasonFactorNotEnrolled  = "FACTOR_NOT_ENROLLED"
+	ReasonStepUpRequired     = "STEP_UP_REQUIRED"
+	ReasonOrgNotFound        = "ORG_NOT_FOUND"
+	ReasonMemberExists       = "MEMBER_EXISTS"
+	ReasonMemberNotFound     = "MEMBER_NOT_FOUND"
+	ReasonLastOwner          = "LAST_OWNER"
+	ReasonAPIKeyNotFound     = "API_KEY_NOT_FOUND"
+	ReasonConnectorNotFound  = "CONNECTOR_NOT_FOUND"
+	ReasonUpstreamLogin      = "UPSTREAM_LOGIN_FAILED"
+	ReasonAccountExists      = "ACCOUNT_EXISTS"
+	ReasonNotProvisioned     = "NOT_PROVISIONED"
+	ReasonIdentityLinked     = "IDENTITY_LINKED"
+	ReasonDirectoryDown      = "DIRECTORY_UNAVAILABLE"
+	ReasonPasswordPolicy     = "PASSWORD_POLICY"
+	ReasonPasswordBreached   = "PASSWORD_BREACHED"
+	ReasonPasswordReused     = "PASSWORD_REUSED"
+	ReasonPasswordExpired    = "PASSWORD_EXPIRED"
+	ReasonLoginLocked        = "LOGIN_LOCKED"
+	ReasonLoginBlocked       = "LOGIN_BLOCKED"
+	ReasonRateLimited        = "RATE_LIMITED"
+	ReasonCanceled           = "CANCELED"
+	ReasonDeadlineExceeded   = "DEADLINE_EXCEEDED"
+	ReasonInternal           = "INTERNAL"
+)
+
+type mapping struct {
+	err     error
+	code    codes.Code
+	reason  string
+	message string
+}
+
+// mappings is checked in order with errors.Is.
+var mappings = []mapping{
+	{auth.ErrInvalidCredentials, codes.Unauthenticated, ReasonInvalidCredentials, "error credentails"},
+	{auth.ErrInvalidChangeToken, codes.Unauthenticated, ReasonInvalidToken, "invalid change token"},
+	{auth.ErrUserExists, codes.AlreadyExists, ReasonUserExists, "user alredy exists"},
+	{auth.ErrUserNotFound, codes.NotFound, ReasonUserNotFound, "user is not found"},
+	{auth.ErrAppNotFound, codes.NotFound, ReasonAppNotFound, "app is not found"},
+	{auth.ErrInvalidAppID, codes.InvalidArgument, ReasonInvalidArgument, "invalid app_id"},
+	{auth.ErrUserDisabled, codes.PermissionDenied, ReasonUserDisabled, "user is disabled"},
+	{auth.ErrLoginBlocked, codes.PermissionDenied, ReasonLoginBlocked, "login blocked as suspicious"},
+	{auth.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{auth.ErrInvalidEmailChangeToken, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired email change token"},
+	{auth.ErrInvalidRefreshToken, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired refresh token"},
+	{auth.ErrRefreshTokenReused, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired refresh token"},
+	{auth.ErrOTPEnrolled, codes.AlreadyExists, ReasonFactorEnrolled, "otp is already enrolled"},
+	{auth.ErrFactorNotEnrolled, codes.FailedPrecondition, ReasonFactorNotEnrolled, "the factor is not enrolled"},
+	{auth.ErrUnsupportedFactor, codes.InvalidArgument, ReasonInvalidArgument, "the method can not be used to step up"},
+	{auth.ErrStepUpRequired, codes.PermissionDenied, ReasonStepUpRequired, "the session has to be stepped up first"},
+	{auth.ErrSessionRequired, codes.Unauthenticated, ReasonUnauthenticated, "the access token has no session, log in again"},
+	{auth.ErrSameEmail, codes.InvalidArgument, ReasonInvalidArgument, "new_email equals the current email"},
+	{directory.ErrUnavailable, codes.Unavailable, ReasonDirectoryDown, "the directory is unavailable, try again later"},
+	{useradmin.ErrUserNotFound, codes.NotFound, ReasonUserNotFound, "user is not found"},
+	{useradmin.ErrUserExists, codes.AlreadyExists, ReasonUserExists, "email is taken"},
+	{useradmin.ErrSelfAction, codes.FailedPrecondition, ReasonSelfAction, "admins can not disable or delete themselves"},
+	{useradmin.ErrInvalidPageToken, codes.InvalidArgument, ReasonInvalidPageToken, "invalid page_token"},
+	{useradmin.ErrOTPNotEnrolled, codes.FailedPrecondition, ReasonFactorNotEnrolled, "the user has no otp factor"},
+	{appadmin.ErrAppNotFound, codes.NotFound, ReasonAppNotFound, "app is not found"},
+	{appadmin.ErrInvalidMinACR, codes.InvalidArgument, ReasonInvalidArgument, "min_acr must be 0, 1 or 2"},
+	{appadmin.ErrInvalidCallbackURL, codes.InvalidArgument, ReasonInvalidArgument, "callback_urls must be absolute http or https urls without a fragment"},
+	{access.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{access.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied, "only admins can access data of other users"},
+	{profile.ErrUserNotFound, codes.NotFound, ReasonUserNotFound, "user is not found"},
+	{profile.ErrAppNotFound, codes.NotFound, ReasonAppNotFound, "app is not found"},
+	{privacy.ErrUserNotFound, codes.NotFound, ReasonUserNotFound, "user is not found"},
+	{privacy.ErrErasurePending, codes.AlreadyExists, ReasonErasurePending, "erasure is already requested"},
+	{privacy.ErrNoErasure, codes.FailedPrecondition, ReasonNoErasure, "no pending erasure"},
+	{session.ErrUserNotFound, codes.NotFound, ReasonUserNotFound, "user is not found"},
+	{session.ErrSessionNotFound, codes.NotFound, ReasonSessionNotFound, "session is not found"},
+	{org.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{org.ErrOrgNotFound, codes.NotFound, ReasonOrgNotFound, "organization is not found"},
+	{org.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied, "only owners and admins can manage members"},
+	{org.ErrInvalidRole, codes.InvalidArgument, ReasonInvalidArgument, "role must be owner, admin or member"},
+	{org.ErrInvalidInvite, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired invite token"},
+	{org.ErrAlreadyMember, codes.AlreadyExists, ReasonMemberExists, "user is already a member"},
+	{org.ErrMemberNotFound, codes.NotFound, ReasonMemberNotFound, "member is not found"},
+	{org.ErrLastOwner, codes.FailedPrecondition, ReasonLastOwner, "the last owner can not be removed or demoted"},
+	{apikey.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{apikey.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied, "api keys can not manage api keys"},
+	{apikey.ErrInvalidScope, codes.InvalidArgument, ReasonInvalidArgument, "scopes must be profile, sessions, orgs, privacy or admin"},
+	{apikey.ErrInvalidExpiry, codes.InvalidArgument, ReasonInvalidArgument, "expires_at must be in the future and within the allowed lifetime"},
+	{apikey.ErrAPIKeyNotFound, codes.NotFound, ReasonAPIKeyNotFound, "api key is not found"},
+	{keyformat.ErrInvalidAPIKey, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{federation.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated, "a valid access token is required"},
+	{federation.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied, "api keys can not link identities"},
+	{federation.ErrConnectorNotFound, codes.NotFound, ReasonConnectorNotFound, "identity provider is not found"},
+	{federation.ErrAppNotFound, codes.NotFound, ReasonAppNotFound, "app is not found"},
+	{federation.ErrInvalidState, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired state"},
+	{federation.ErrUpstreamLogin, codes.Unauthenticated, ReasonUpstreamLogin, "the identity provider did not confirm the login"},
+	{federation.ErrAccountExists, codes.FailedPrecondition, ReasonAccountExists, "an account with the email exists, log in and link the identity"},
+	{federation.ErrNotProvisioned, codes.PermissionDenied, ReasonNotProvisioned, "no account for the identity"},
+	{federation.ErrIdentityLinked, codes.AlreadyExists, ReasonIdentityLinked, "the identity is linked to another user"},
+	{federation.ErrEmailNotVerified, codes.PermissionDenied, ReasonNotProvisioned, "the identity provider did not verify the email"},
+	{federation.ErrInvalidRedirect, codes.InvalidArgument, ReasonInvalidArgument, "redirect_uri must be a callback url of the app"},
+	{federation.ErrInvalidLoginCode, codes.Unauthenticated, ReasonInvalidToken, "invalid or expired login code"},
+	{audit.ErrInvalidPageToken, codes.InvalidArgument, ReasonInvalidPageToken, "invalid page_token"},
+	{audit.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied, "the method is restricted to admins"},
+	{webhook.ErrWebhookNotFound, codes.NotFound, ReasonWebhookNotFound, "webhook is not found"},
+	{webhook.ErrAppNotFound, codes.NotFound, ReasonAppNotFound, "app is not found"},
+	{webhook.ErrInvalidURL, codes.InvalidArgument, ReasonInvalidArgument, "url must be an absolute http or https url"},
+	{webhook.ErrPrivateURL, codes.InvalidArgument, ReasonInvalidArgument, "url must point to a public address"},
+	{webhook.ErrUnknownEventType, codes.InvalidArgument, ReasonInvalidArgument, "unknown event type"},
+	{webhook.ErrInvalidPageToken, codes.InvalidArgument, ReasonInvalidPageToken, "invalid page_token"},
+	{events.ErrInvalidCursor, codes.InvalidArgument, ReasonInvalidCursor, "invalid cursor"},
+	{events.ErrCursorExpired, codes.FailedPrecondition, ReasonCursorExpired, "the cursor is older than the retained events, resync and watch without a cursor"},
+	{events.ErrUnknownEventType, codes.InvalidArgument, ReasonInvalidArgument, "unknown event type"},
+	{context.Canceled, codes.Canceled, ReasonCanceled, "request canceled"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, ReasonDeadlineExceeded, "deadline exceeded"},
+}
+
+// Status converts err into a gRPC status error. Errors that already are
+// statuses are returned unchanged, unknown errors become Internal without
+// leaking their text.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var verr *password.ViolationError
+	if errors.As(err, &verr) {
+		return passwordStatus(ReasonPasswordPolicy, verr.Violations...)
+	}
+
+	var aerr *attrschema.ViolationError
+	if errors.As(err, &aerr) {
+		br := &errdetails.BadRequest{}
+		for _, v := range aerr.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+				Reason:      ReasonInvalidArgument,
+			})
+		}
+		return newStatus(codes.InvalidArgument, "invalid profile: "+aerr.Error(), errorInfo(ReasonInvalidArgument), br)
+	}
+
+	if errors.Is(err, auth.ErrPasswordBreached) {
+		return passwordStatus(ReasonPasswordBreached, password.Violation{
+			Rule:        ReasonPasswordBreached,
+			Description: "password has appeared in a data breach",
+		})
+	}
+
+	if errors.Is(err, auth.ErrPasswordReused) {
+		return passwordStatus(ReasonPasswordReused, password.Violation{
+			Rule:        ReasonPasswordReused,
+			Description: "password was used recently",
+		})
+	}
+
+	var expired *auth.PasswordExpiredError
+	if errors.As(err, &expired) {
+		return newStatus(codes.FailedPrecondition, "password expired", &errdetails.ErrorInfo{
+			Reason:   ReasonPasswordExpired,
+			Domain:   Domain,
+			Metadata: map[string]string{"change_token": expired.ChangeToken},
+		})
+	}
+
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		return Exhausted(ReasonLoginLocked, "too many failed logins, try again later", locked.RetryAfter)
+	}
+
+	for _, m := range mappings {
+		if errors.Is(err, m.err) {
+			return newStatus(m.code, m.message, errorInfo(m.reason))
+		}
+	}
+
+	return newStatus(codes.Internal, "internal error", errorInfo(ReasonInternal))
+}
+
+// InvalidArgument reports a request field that failed validation.
+func InvalidArgument(field string, description string) error {
+	return newStatus(codes.InvalidArgument, description,
+		errorInfo(ReasonInvalidArgument),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       field,
+			Description: description,
+			Reason:      ReasonInvalidArgument,
+		}}},
+	)
+}
+
+// Unauthenticated asks the client for credentials.
+func Unauthenticated(message string) error {
+	return newStatus(codes.Unauthenticated, message, errorInfo(ReasonUnauthenticated))
+}
+
+// PermissionDenied rejects an authenticated client.
+func PermissionDenied(message string) error {
+	return newStatus(codes.PermissionDenied, message, errorInfo(ReasonPermissionDenied))
+}
+
+// StepUpRequired rejects the token of a session that has to be stepped up.
+func StepUpRequired(message string) error {
+	return newStatus(codes.PermissionDenied, message, errorInfo(ReasonStepUpRequired))
+}
+
+// Exhausted tells the client to come back after retryAfter.
+func Exhausted(reason string, message string, retryAfter time.Duration) error {
+	return newStatus(codes.ResourceExhausted, message,
+		errorInfo(reason),
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	)
+}
+
+func passwordStatus(reason string, violations ...password.Violation) error {
+	br := &errdetails.BadRequest{}
+	for _, v := range violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "password",
+			Description: v.Description,
+			Reason:      v.Rule,
+		})
+	}
+
+	verr := &password.ViolationError{Violations: violations}
+
+	return newStatus(codes.InvalidArgument, "invalid password: "+verr.Error(), errorInfo(reason), br)
+}
+
+func errorInfo(reason string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{Reason: reason, Domain: Domain}
+}
+
+func newStatus(code codes.Code, message string, details ...protoadapt.MessageV1) error {
+	st := status.New(code, message)
+
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
@@ -0,0 +1,169 @@
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/attrschema"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/password"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/apikey"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/audit"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/auth"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/federation"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/org"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/privacy"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/profile"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/session"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/useradmin"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/webhook"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	type test struct {
+		name       string
+		err        error
+		code       codes.Code
+		reason     string
+		violations []string
+		retry      bool
+		metadata   map[string]string
+	}
+
+	wrap := func(err error) error { return fmt.Errorf("auth.Login: %w", err) }
+
+	tests := []test{
+		{name: "invalid credentials", err: wrap(auth.ErrInvalidCredentials), code: codes.Unauthenticated, reason: ReasonInvalidCredentials},
+		{name: "invalid change token", err: wrap(auth.ErrInvalidChangeToken), code: codes.Unauthenticated, reason: ReasonInvalidToken},
+		{name: "user exists", err: wrap(auth.ErrUserExists), code: codes.AlreadyExists, reason: ReasonUserExists},
+		{name: "user not found", err: wrap(auth.ErrUserNotFound), code: codes.NotFound, reason: ReasonUserNotFound},
+		{name: "app not found", err: wrap(auth.ErrAppNotFound), code: codes.NotFound, reason: ReasonAppNotFound},
+		{name: "invalid app id", err: wrap(auth.ErrInvalidAppID), code: codes.InvalidArgument, reason: ReasonInvalidArgument},
+		{name: "invalid email change token", err: wrap(auth.ErrInvalidEmailChangeToken), code: codes.Unauthenticated, reason: ReasonInvalidToken},
+		{name: "user disabled", err: wrap(auth.ErrUserDisabled), code: codes.PermissionDenied, reason: ReasonUserDisabled},
+		{name: "admin user not found", err: wrap(useradmin.ErrUserNotFound), code: codes.NotFound, reason: ReasonUserNotFound},
+		{name: "admin email taken", err: wrap(useradmin.ErrUserExists), code: codes.AlreadyExists, reason: ReasonUserExists},
+		{name: "login blocked", err: wrap(auth.ErrLoginBlocked), code: codes.PermissionDenied, reason: ReasonLoginBlocked},
+		{name: "refresh token reused", err: wrap(auth.ErrRefreshTokenReused), code: codes.Unauthenticated, reason: ReasonInvalidToken},
+		{name: "factor not enrolled", err: wrap(auth.ErrFactorNotEnrolled), code: codes.FailedPrecondition, reason: ReasonFactorNotEnrolled},
+		{name: "token without session", err: wrap(auth.ErrSessionRequired), code: codes.Unauthenticated, reason: ReasonUnauthenticated},
+		{name: "session not found", err: wrap(session.ErrSessionNotFound), code: codes.NotFound, reason: ReasonSessionNotFound},
+		{name: "org not found", err: wrap(org.ErrOrgNotFound), code: codes.NotFound, reason: ReasonOrgNotFound},
+		{name: "invalid invite", err: wrap(org.ErrInvalidInvite), code: codes.Unauthenticated, reason: ReasonInvalidToken},
+		{name: "last owner", err: wrap(org.ErrLastOwner), code: codes.FailedPrecondition, reason: ReasonLastOwner},
+		{name: "api key not found", err: wrap(apikey.ErrAPIKeyNotFound), code: codes.NotFound, reason: ReasonAPIKeyNotFound},
+		{name: "api key manages keys", err: wrap(apikey.ErrPermissionDenied), code: codes.PermissionDenied, reason: ReasonPermissionDenied},
+		{name: "federated account exists", err: wrap(federation.ErrAccountExists), code: codes.FailedPrecondition, reason: ReasonAccountExists},
+		{name: "identity linked", err: wrap(federation.ErrIdentityLinked), code: codes.AlreadyExists, reason: ReasonIdentityLinked},
+		{name: "upstream login failed", err: wrap(federation.ErrUpstreamLogin), code: codes.Unauthenticated, reason: ReasonUpstreamLogin},
+		{name: "invalid login code", err: wrap(federation.ErrInvalidLoginCode), code: codes.Unauthenticated, reason: ReasonInvalidToken},
+		{name: "erasure pending", err: wrap(privacy.ErrErasurePending), code: codes.AlreadyExists, reason: ReasonErasurePending},
+		{name: "no erasure", err: wrap(privacy.ErrNoErasure), code: codes.FailedPrecondition, reason: ReasonNoErasure},
+		{name: "admin self action", err: wrap(useradmin.ErrSelfAction), code: codes.FailedPrecondition, reason: ReasonSelfAction},
+		{
+			name: "password policy",
+			err: wrap(&password.ViolationError{Violations: []password.Violation{
+				{Rule: password.RuleMinLength, Description: "password must be at least 10 characters"},
+				{Rule: password.RuleDigit, Description: "password must contain a digit"},
+			}}),
+			code:       codes.InvalidArgument,
+			reason:     ReasonPasswordPolicy,
+			violations: []string{password.RuleMinLength, password.RuleDigit},
+		},
+		{name: "password breached", err: wrap(auth.ErrPasswordBreached), code: codes.InvalidArgument, reason: ReasonPasswordBreached, violations: []string{ReasonPasswordBreached}},
+		{name: "password reused", err: wrap(auth.ErrPasswordReused), code: codes.InvalidArgument, reason: ReasonPasswordReused, violations: []string{ReasonPasswordReused}},
+		{
+			name:     "password expired",
+			err:      wrap(&auth.PasswordExpiredError{ChangeToken: "token"}),
+			code:     codes.FailedPrecondition,
+			reason:   ReasonPasswordExpired,
+			metadata: map[string]string{"change_token": "token"},
+		},
+		{name: "login locked", err: wrap(&lockout.LockedError{RetryAfter: time.Minute}), code: codes.ResourceExhausted, reason: ReasonLoginLocked, retry: true},
+		{name: "directory down", err: wrap(fmt.Errorf("directory.Authenticate: %w: dial tcp", directory.ErrUnavailable)), code: codes.Unavailable, reason: ReasonDirectoryDown},
+		{name: "invalid page token", err: fmt.Errorf("audit.List: %w", audit.ErrInvalidPageToken), code: codes.InvalidArgument, reason: ReasonInvalidPageToken},
+		{name: "audit not admin", err: fmt.Errorf("audit.List: %w", audit.ErrPermissionDenied), code: codes.PermissionDenied, reason: ReasonPermissionDenied},
+		{name: "webhook not found", err: fmt.Errorf("webhook.Delete: %w", webhook.ErrWebhookNotFound), code: codes.NotFound, reason: ReasonWebhookNotFound},
+		{
+			name:       "invalid profile",
+			err:        fmt.Errorf("profile.UpdateProfile: %w", &attrschema.ViolationError{Violations: []attrschema.Violation{{Field: "locale", Description: "bad"}, {Field: "attributes.plan", Description: "bad"}}}),
+			code:       codes.InvalidArgument,
+			reason:     ReasonInvalidArgument,
+			violations: []string{ReasonInvalidArgument, ReasonInvalidArgument},
+		},
+		{name: "profile of another user", err: fmt.Errorf("profile.GetProfile: %w", profile.ErrPermissionDenied), code: codes.PermissionDenied, reason: ReasonPermissionDenied},
+		{name: "canceled", err: wrap(context.Canceled), code: codes.Canceled, reason: ReasonCanceled},
+		{name: "deadline", err: wrap(context.DeadlineExceeded), code: codes.DeadlineExceeded, reason: ReasonDeadlineExceeded},
+		{name: "unknown", err: errors.New("pq: connection refused"), code: codes.Internal, reason: ReasonInternal},
+		{name: "invalid argument", err: InvalidArgument("email", "email is required"), code: codes.InvalidArgument, reason: ReasonInvalidArgument, violations: []string{ReasonInvalidArgument}},
+		{name: "exhausted", err: Exhausted(ReasonRateLimited, "slow down", time.Second), code: codes.ResourceExhausted, reason: ReasonRateLimited, retry: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st, ok := status.FromError(Status(test.err))
+			if !ok {
+				t.Fatal("not a status error")
+			}
+
+			if st.Code() != test.code {
+				t.Errorf("expected code %s, got %s", test.code, st.Code())
+			}
+
+			var info *errdetails.ErrorInfo
+			var badRequest *errdetails.BadRequest
+			var retry *errdetails.RetryInfo
+			for _, d := range st.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					info = d
+				case *errdetails.BadRequest:
+					badRequest = d
+				case *errdetails.RetryInfo:
+					retry = d
+				}
+			}
+
+			if info == nil || info.GetReason() != test.reason || info.GetDomain() != Domain {
+				t.Errorf("expected reason %s, got %v", test.reason, info)
+			}
+
+			for k, v := range test.metadata {
+				if info.GetMetadata()[k] != v {
+					t.Errorf("expected metadata %s=%s, got %v", k, v, info.GetMetadata())
+				}
+			}
+
+			if len(badRequest.GetFieldViolations()) != len(test.violations) {
+				t.Fatalf("expected violations %v, got %v", test.violations, badRequest)
+			}
+			for i, v := range badRequest.GetFieldViolations() {
+				if v.GetReason() != test.violations[i] {
+					t.Errorf("violation %d: expected %s, got %s", i, test.violations[i], v.GetReason())
+				}
+			}
+
+			if test.retry != (retry != nil) {
+				t.Errorf("expected retry info %v, got %v", test.retry, retry)
+			}
+		})
+	}
+}
+
+func TestStatusKeepsStatus(t *testing.T) {
+	err := status.Error(codes.PermissionDenied, "denied")
+	if Status(err) != err {
+		t.Error("status error was translated again")
+	}
+
+	if Status(nil) != nil {
+		t.Error("nil error became a status")
+	}
+}
@@ -0,0 +1,237 @@
+package Grpcorg
+
+import (
+	"context"
+	"strings"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxNameLength = 200
+	maxTeams      = 50
+)
+
+type ServicOrganizations interface {
+	CreateOrganization(
+		ctx context.Context,
+		name string,
+	) (org domain.Organization, err error)
+
+	InviteMember(
+		ctx context.Context,
+		orgID int64,
+		email string,
+		role string,
+		teams []string,
+	) (invite domain.OrgInvite, err error)
+
+	AcceptInvite(
+		ctx context.Context,
+		token string,
+	) (member domain.Member, err error)
+
+	ListMembers(
+		ctx context.Context,
+		orgID int64,
+	) (members []domain.Member, err error)
+
+	UpdateMember(
+		ctx context.Context,
+		orgID int64,
+		userID int64,
+		update domain.MemberUpdate,
+	) (member domain.Member, err error)
+
+	RemoveMember(
+		ctx context.Context,
+		orgID int64,
+		userID int64,
+	) error
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedOrganizationsServer
+	orgs ServicOrganizations
+}
+
+func Register(gRPC *grpc.Server, orgs ServicOrganizations) {
+	ssov1.RegisterOrganizationsServer(gRPC, &ServerAPI{orgs: orgs})
+}
+
+func (s *ServerAPI) CreateOrganization(ctx context.Context, req *ssov1.CreateOrganizationRequest) (*ssov1.CreateOrganizationResponse, error) {
+	name := strings.TrimSpace(req.GetName())
+	if name == "" || len(name) > maxNameLength {
+		return nil, grpcerr.InvalidArgument("name", "name is required and at most 200 bytes long")
+	}
+
+	org, err := s.orgs.CreateOrganization(ctx, name)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.CreateOrganizationResponse{
+		Organization: &ssov1.Organization{
+			Id:        org.ID,
+			Name:      org.Name,
+			CreatedAt: timestamppb.New(org.CreatedAt),
+		},
+	}, nil
+}
+
+func (s *ServerAPI) InviteMember(ctx context.Context, req *ssov1.InviteMemberRequest) (*ssov1.InviteMemberResponse, error) {
+	if err := ValidateInviteMember(req); err != nil {
+		return nil, err
+	}
+
+	invite, err := s.orgs.InviteMember(ctx, req.GetOrgId(), req.GetEmail(), req.GetRole(), req.GetTeams())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.InviteMemberResponse{
+		InviteId:  invite.ID,
+		ExpiresAt: timestamppb.New(invite.ExpiresAt),
+	}, nil
+}
+
+func (s *ServerAPI) AcceptInvite(ctx context.Context, req *ssov1.AcceptInviteRequest) (*ssov1.AcceptInviteResponse, error) {
+	if req.GetToken() == "" {
+		return nil, grpcerr.InvalidArgument("token", "token is required")
+	}
+
+	member, err := s.orgs.AcceptInvite(ctx, req.GetToken())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.AcceptInviteResponse{
+		Member: toMember(member),
+	}, nil
+}
+
+func (s *ServerAPI) ListMembers(ctx context.Context, req *ssov1.ListMembersRequest) (*ssov1.ListMembersResponse, error) {
+	if req.GetOrgId() <= 0 {
+		return nil, grpcerr.InvalidArgument("org_id", "org_id is requred")
+	}
+
+	members, err := s.orgs.ListMembers(ctx, req.GetOrgId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	out := make([]*ssov1.Member, 0, len(members))
+	for _, m := range members {
+		out = append(out, toMember(m))
+	}
+
+	return &ssov1.ListMembersResponse{
+		Members: out,
+	}, nil
+}
+
+func (s *ServerAPI) UpdateMember(ctx context.Context, req *ssov1.UpdateMemberRequest) (*ssov1.UpdateMemberResponse, error) {
+	if err := ValidateUpdateMember(req); err != nil {
+		return nil, err
+	}
+
+	update := domain.MemberUpdate{Role: req.Role}
+	if req.GetSetTeams() {
+		teams := req.GetTeams()
+		update.Teams = &teams
+	}
+
+	member, err := s.orgs.UpdateMember(ctx, req.GetOrgId(), req.GetUserId(), update)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.UpdateMemberResponse{
+		Member: toMember(member),
+	}, nil
+}
+
+func (s *ServerAPI) RemoveMember(ctx context.Context, req *ssov1.RemoveMemberRequest) (*ssov1.RemoveMemberResponse, error) {
+	if req.GetOrgId() <= 0 {
+		return nil, grpcerr.InvalidArgument("org_id", "org_id is requred")
+	}
+
+	if req.GetUserId() <= 0 {
+		return nil, grpcerr.InvalidArgument("user_id", "user_id is requred")
+	}
+
+	if err := s.orgs.RemoveMember(ctx, req.GetOrgId(), req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RemoveMemberResponse{}, nil
+}
+
+func ValidateInviteMember(req *ssov1.InviteMemberRequest) error {
+	if req.GetOrgId() <= 0 {
+		return grpcerr.InvalidArgument("org_id", "org_id is requred")
+	}
+
+	if req.GetEmail() == "" || !strings.Contains(req.GetEmail(), "@") {
+		return grpcerr.InvalidArgument("email", "email is required")
+	}
+
+	if req.GetRole() == "" {
+		return grpcerr.InvalidArgument("role", "role is required")
+	}
+
+	return validateTeams(req.GetTeams())
+}
+
+func ValidateUpdateMember(req *ssov1.UpdateMemberRequest) error {
+	if req.GetOrgId() <= 0 {
+		return grpcerr.InvalidArgument("org_id", "org_id is requred")
+	}
+
+	if req.GetUserId() <= 0 {
+		return grpcerr.InvalidArgument("user_id", "user_id is requred")
+	}
+
+	if req.Role == nil && !req.GetSetTeams() {
+		return grpcerr.InvalidArgument("role", "role or set_teams is required")
+	}
+
+	if req.Role != nil && req.GetRole() == "" {
+		return grpcerr.InvalidArgument("role", "role must not be empty")
+	}
+
+	if !req.GetSetTeams() && len(req.GetTeams()) > 0 {
+		return grpcerr.InvalidArgument("set_teams", "set_teams is required to change teams")
+	}
+
+	return validateTeams(req.GetTeams())
+}
+
+func validateTeams(teams []string) error {
+	if len(teams) > maxTeams {
+		return grpcerr.InvalidArgument("teams", "at most 50 teams")
+	}
+
+	for _, team := range teams {
+		if strings.TrimSpace(team) == "" || len(team) > maxNameLength {
+			return grpcerr.InvalidArgument("teams", "team names must not be empty and at most 200 bytes long")
+		}
+	}
+
+	return nil
+}
+
+func toMember(m domain.Member) *ssov1.Member {
+	return &ssov1.Member{
+		OrgId:    m.OrgID,
+		UserId:   m.UserID,
+		Email:    m.Email,
+		Role:     m.Role,
+		Teams:    m.Teams,
+		JoinedAt: timestamppb.New(m.JoinedAt),
+	}
+}
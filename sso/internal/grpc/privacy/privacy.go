@@ -0,0 +1,90 @@
+package Grpcprivacy
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const archiveContentType = "application/json"
+
+type ServicPrivacy interface {
+	ExportUserData(
+		ctx context.Context,
+		userID int64,
+	) (archive []byte, err error)
+
+	RequestErasure(
+		ctx context.Context,
+		userID int64,
+	) (erasure domain.Erasure, err error)
+
+	CancelErasure(
+		ctx context.Context,
+		userID int64,
+	) error
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedPrivacyServer
+	privacy ServicPrivacy
+}
+
+func Register(gRPC *grpc.Server, privacy ServicPrivacy) {
+	ssov1.RegisterPrivacyServer(gRPC, &ServerAPI{privacy: privacy})
+}
+
+func (s *ServerAPI) ExportUserData(ctx context.Context, req *ssov1.ExportUserDataRequest) (*ssov1.ExportUserDataResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	archive, err := s.privacy.ExportUserData(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.ExportUserDataResponse{
+		Archive:     archive,
+		ContentType: archiveContentType,
+	}, nil
+}
+
+func (s *ServerAPI) EraseUser(ctx context.Context, req *ssov1.EraseUserRequest) (*ssov1.EraseUserResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	erasure, err := s.privacy.RequestErasure(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.EraseUserResponse{
+		EraseAfter: timestamppb.New(erasure.EraseAfter),
+	}, nil
+}
+
+func (s *ServerAPI) CancelErasure(ctx context.Context, req *ssov1.CancelErasureRequest) (*ssov1.CancelErasureResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.privacy.CancelErasure(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.CancelErasureResponse{}, nil
+}
+
+func validateUserID(userID int64) error {
+	if userID < 0 {
+		return grpcerr.InvalidArgument("user_id", "user_id must not be negative")
+	}
+
+	return nil
+}
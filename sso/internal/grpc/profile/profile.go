@@ -0,0 +1,113 @@
+package Grpcprofile
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServicProfile interface {
+	GetProfile(
+		ctx context.Context,
+		userID int64,
+	) (profile domain.Profile, err error)
+
+	UpdateProfile(
+		ctx context.Context,
+		userID int64,
+		update domain.ProfileUpdate,
+	) (profile domain.Profile, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedProfilesServer
+	profiles ServicProfile
+}
+
+func Register(gRPC *grpc.Server, profiles ServicProfile) {
+	ssov1.RegisterProfilesServer(gRPC, &ServerAPI{profiles: profiles})
+}
+
+func (s *ServerAPI) GetProfile(ctx context.Context, req *ssov1.GetProfileRequest) (*ssov1.GetProfileResponse, error) {
+	if req.GetUserId() < 0 {
+		return nil, grpcerr.InvalidArgument("user_id", "user_id must not be negative")
+	}
+
+	profile, err := s.profiles.GetProfile(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	out, err := toProfile(profile)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.GetProfileResponse{
+		Profile: out,
+	}, nil
+}
+
+func (s *ServerAPI) UpdateProfile(ctx context.Context, req *ssov1.UpdateProfileRequest) (*ssov1.UpdateProfileResponse, error) {
+	if err := ValidateUpdateProfile(req); err != nil {
+		return nil, err
+	}
+
+	update := domain.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		AvatarURL:   req.AvatarUrl,
+	}
+	if req.GetAttributes() != nil {
+		update.Attributes = req.GetAttributes().AsMap()
+	}
+
+	profile, err := s.profiles.UpdateProfile(ctx, req.GetUserId(), update)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	out, err := toProfile(profile)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.UpdateProfileResponse{
+		Profile: out,
+	}, nil
+}
+
+func ValidateUpdateProfile(req *ssov1.UpdateProfileRequest) error {
+	if req.GetUserId() < 0 {
+		return grpcerr.InvalidArgument("user_id", "user_id must not be negative")
+	}
+
+	if req.DisplayName == nil && req.Locale == nil && req.Timezone == nil && req.AvatarUrl == nil && len(req.GetAttributes().GetFields()) == 0 {
+		return grpcerr.InvalidArgument("display_name", "nothing to update")
+	}
+
+	return nil
+}
+
+func toProfile(profile domain.Profile) (*ssov1.Profile, error) {
+	attributes, err := structpb.NewStruct(profile.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssov1.Profile{
+		UserId:      profile.UserID,
+		DisplayName: profile.DisplayName,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+		AvatarUrl:   profile.AvatarURL,
+		Attributes:  attributes,
+		UpdatedAt:   timestamppb.New(profile.UpdatedAt),
+	}, nil
+}
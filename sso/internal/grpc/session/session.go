@@ -0,0 +1,102 @@
+package Grpcsession
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServicSessions interface {
+	ListSessions(
+		ctx context.Context,
+		userID int64,
+	) (sessions []domain.Session, err error)
+
+	RevokeSession(
+		ctx context.Context,
+		sessionID int64,
+	) error
+
+	RevokeAllSessions(
+		ctx context.Context,
+		userID int64,
+	) (revoked int64, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedSessionsServer
+	sessions ServicSessions
+}
+
+func Register(gRPC *grpc.Server, sessions ServicSessions) {
+	ssov1.RegisterSessionsServer(gRPC, &ServerAPI{sessions: sessions})
+}
+
+func (s *ServerAPI) ListSessions(ctx context.Context, req *ssov1.ListSessionsRequest) (*ssov1.ListSessionsResponse, error) {
+	if req.GetUserId() < 0 {
+		return nil, grpcerr.InvalidArgument("user_id", "user_id must not be negative")
+	}
+
+	sessions, err := s.sessions.ListSessions(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	caller, _ := principal.FromContext(ctx)
+
+	out := make([]*ssov1.Session, 0, len(sessions))
+	for _, session := range sessions {
+		out = append(out, toSession(session, caller.SessionID))
+	}
+
+	return &ssov1.ListSessionsResponse{
+		Sessions: out,
+	}, nil
+}
+
+func (s *ServerAPI) RevokeSession(ctx context.Context, req *ssov1.RevokeSessionRequest) (*ssov1.RevokeSessionResponse, error) {
+	if req.GetSessionId() <= 0 {
+		return nil, grpcerr.InvalidArgument("session_id", "session_id is requred")
+	}
+
+	if err := s.sessions.RevokeSession(ctx, req.GetSessionId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RevokeSessionResponse{}, nil
+}
+
+func (s *ServerAPI) RevokeAllSessions(ctx context.Context, req *ssov1.RevokeAllSessionsRequest) (*ssov1.RevokeAllSessionsResponse, error) {
+	if req.GetUserId() < 0 {
+		return nil, grpcerr.InvalidArgument("user_id", "user_id must not be negative")
+	}
+
+	revoked, err := s.sessions.RevokeAllSessions(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.RevokeAllSessionsResponse{
+		Revoked: revoked,
+	}, nil
+}
+
+func toSession(session domain.Session, current int64) *ssov1.Session {
+	return &ssov1.Session{
+		Id:         session.ID,
+		UserId:     session.UserID,
+		AppId:      session.AppID,
+		Device:     session.Device,
+		UserAgent:  session.UserAgent,
+		Ip:         session.IP,
+		CreatedAt:  timestamppb.New(session.CreatedAt),
+		LastSeenAt: timestamppb.New(session.LastSeenAt),
+		ExpiresAt:  timestamppb.New(session.ExpiresAt),
+		Current:    session.ID == current,
+	}
+}
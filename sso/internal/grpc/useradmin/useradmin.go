@@ -0,0 +1,226 @@
+package Grpcuseradmin
+
+import (
+	"context"
+	"strings"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/useradmin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const emptyID = 0
+
+type ServicUserAdmin interface {
+	GetUser(
+		ctx context.Context,
+		userID int64,
+	) (user useradmin.UserWithRole, err error)
+
+	ListUsers(
+		ctx context.Context,
+		email string,
+		status string,
+		pageSize int,
+		pageToken string,
+	) (users []domain.User, nextPageToken string, err error)
+
+	UpdateUser(
+		ctx context.Context,
+		userID int64,
+		update domain.UserUpdate,
+	) error
+
+	DisableUser(
+		ctx context.Context,
+		userID int64,
+	) error
+
+	EnableUser(
+		ctx context.Context,
+		userID int64,
+	) error
+
+	DeleteUser(
+		ctx context.Context,
+		userID int64,
+	) error
+
+	ResetOTP(
+		ctx context.Context,
+		userID int64,
+	) error
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedUserAdminServer
+	users ServicUserAdmin
+}
+
+func Register(gRPC *grpc.Server, users ServicUserAdmin) {
+	ssov1.RegisterUserAdminServer(gRPC, &ServerAPI{users: users})
+}
+
+func (s *ServerAPI) GetUser(ctx context.Context, req *ssov1.GetUserRequest) (*ssov1.GetUserResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetUser(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.GetUserResponse{
+		User: toUser(user.User, user.IsAdmin),
+	}, nil
+}
+
+func (s *ServerAPI) ListUsers(ctx context.Context, req *ssov1.ListUsersRequest) (*ssov1.ListUsersResponse, error) {
+	if err := ValidateListUsers(req); err != nil {
+		return nil, err
+	}
+
+	users, next, err := s.users.ListUsers(ctx, req.GetEmail(), req.GetStatus(), int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp := &ssov1.ListUsersResponse{
+		Users:         make([]*ssov1.User, 0, len(users)),
+		NextPageToken: next,
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toUser(user, false))
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) UpdateUser(ctx context.Context, req *ssov1.UpdateUserRequest) (*ssov1.UpdateUserResponse, error) {
+	if err := ValidateUpdateUser(req); err != nil {
+		return nil, err
+	}
+
+	update := domain.UserUpdate{
+		Email:   req.Email,
+		IsAdmin: req.IsAdmin,
+	}
+
+	if err := s.users.UpdateUser(ctx, req.GetUserId(), update); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	user, err := s.users.GetUser(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.UpdateUserResponse{
+		User: toUser(user.User, user.IsAdmin),
+	}, nil
+}
+
+func (s *ServerAPI) DisableUser(ctx context.Context, req *ssov1.DisableUserRequest) (*ssov1.DisableUserResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.DisableUser(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.DisableUserResponse{}, nil
+}
+
+func (s *ServerAPI) EnableUser(ctx context.Context, req *ssov1.EnableUserRequest) (*ssov1.EnableUserResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.EnableUser(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.EnableUserResponse{}, nil
+}
+
+func (s *ServerAPI) DeleteUser(ctx context.Context, req *ssov1.DeleteUserRequest) (*ssov1.DeleteUserResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.DeleteUser(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.DeleteUserResponse{}, nil
+}
+
+func (s *ServerAPI) ResetOTP(ctx context.Context, req *ssov1.ResetOTPRequest) (*ssov1.ResetOTPResponse, error) {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.ResetOTP(ctx, req.GetUserId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.ResetOTPResponse{}, nil
+}
+
+func ValidateListUsers(req *ssov1.ListUsersRequest) error {
+	if req.GetPageSize() < 0 {
+		return grpcerr.InvalidArgument("page_size", "page_size must not be negative")
+	}
+
+	switch req.GetStatus() {
+	case "", domain.UserStatusActive, domain.UserStatusDisabled, domain.UserStatusPendingErasure:
+	default:
+		return grpcerr.InvalidArgument("status", "status must be active, disabled or pending_erasure")
+	}
+
+	return nil
+}
+
+func ValidateUpdateUser(req *ssov1.UpdateUserRequest) error {
+	if err := validateUserID(req.GetUserId()); err != nil {
+		return err
+	}
+
+	if req.Email != nil && !strings.Contains(req.GetEmail(), "@") {
+		return grpcerr.InvalidArgument("email", "email is not valid")
+	}
+
+	if req.Email == nil && req.IsAdmin == nil {
+		return grpcerr.InvalidArgument("email", "nothing to update")
+	}
+
+	return nil
+}
+
+func validateUserID(userID int64) error {
+	if userID == emptyID {
+		return grpcerr.InvalidArgument("user_id", "user_id is requred")
+	}
+
+	return nil
+}
+
+func toUser(user domain.User, isAdmin bool) *ssov1.User {
+	out := &ssov1.User{
+		Id:        user.ID,
+		Email:     user.Email,
+		Status:    user.Status,
+		IsAdmin:   isAdmin,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+	}
+	if !user.PasswordChangedAt.IsZero() {
+		out.PasswordChangedAt = timestamppb.New(user.PasswordChangedAt)
+	}
+
+	return out
+}
@@ -0,0 +1,131 @@
+package Grpcwebhook
+
+import (
+	"context"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const emptyID = 0
+
+type ServicWebhooks interface {
+	Create(
+		ctx context.Context,
+		appID int64,
+		url string,
+		eventTypes []string,
+	) (webhook domain.Webhook, err error)
+
+	Delete(
+		ctx context.Context,
+		webhookID int64,
+	) error
+
+	ListDeliveries(
+		ctx context.Context,
+		webhookID int64,
+		status string,
+		pageSize int,
+		pageToken string,
+	) (deliveries []domain.WebhookDelivery, nextPageToken string, err error)
+}
+
+type ServerAPI struct {
+	ssov1.UnimplementedWebhooksServer
+	webhooks ServicWebhooks
+}
+
+func Register(gRPC *grpc.Server, webhooks ServicWebhooks) {
+	ssov1.RegisterWebhooksServer(gRPC, &ServerAPI{webhooks: webhooks})
+}
+
+func (s *ServerAPI) CreateWebhook(ctx context.Context, req *ssov1.CreateWebhookRequest) (*ssov1.CreateWebhookResponse, error) {
+	if req.GetAppId() == emptyID {
+		return nil, grpcerr.InvalidArgument("app_id", "app_id is required")
+	}
+
+	if req.GetUrl() == "" {
+		return nil, grpcerr.InvalidArgument("url", "url is required")
+	}
+
+	webhook, err := s.webhooks.Create(ctx, int64(req.GetAppId()), req.GetUrl(), req.GetEventTypes())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.CreateWebhookResponse{
+		WebhookId: webhook.ID,
+		Secret:    webhook.Secret,
+	}, nil
+}
+
+func (s *ServerAPI) DeleteWebhook(ctx context.Context, req *ssov1.DeleteWebhookRequest) (*ssov1.DeleteWebhookResponse, error) {
+	if req.GetWebhookId() == emptyID {
+		return nil, grpcerr.InvalidArgument("webhook_id", "webhook_id is required")
+	}
+
+	if err := s.webhooks.Delete(ctx, req.GetWebhookId()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &ssov1.DeleteWebhookResponse{}, nil
+}
+
+func (s *ServerAPI) ListWebhookDeliveries(ctx context.Context, req *ssov1.ListWebhookDeliveriesRequest) (*ssov1.ListWebhookDeliveriesResponse, error) {
+	if err := ValidateListWebhookDeliveries(req); err != nil {
+		return nil, err
+	}
+
+	deliveries, next, err := s.webhooks.ListDeliveries(ctx, req.GetWebhookId(), req.GetStatus(), int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp := &ssov1.ListWebhookDeliveriesResponse{
+		Deliveries:    make([]*ssov1.WebhookDelivery, 0, len(deliveries)),
+		NextPageToken: next,
+	}
+	for _, d := range deliveries {
+		delivery := &ssov1.WebhookDelivery{
+			Id:             d.ID,
+			EventId:        d.EventID,
+			EventType:      d.EventType,
+			Status:         d.Status,
+			Attempts:       int32(d.Attempts),
+			LastStatusCode: int32(d.LastStatusCode),
+			LastError:      d.LastError,
+			CreatedAt:      timestamppb.New(d.CreatedAt),
+		}
+		if d.Status == domain.DeliveryPending {
+			delivery.NextAttemptAt = timestamppb.New(d.NextAttemptAt)
+		}
+		if !d.DeliveredAt.IsZero() {
+			delivery.DeliveredAt = timestamppb.New(d.DeliveredAt)
+		}
+		resp.Deliveries = append(resp.Deliveries, delivery)
+	}
+
+	return resp, nil
+}
+
+func ValidateListWebhookDeliveries(req *ssov1.ListWebhookDeliveriesRequest) error {
+	if req.GetWebhookId() == emptyID {
+		return grpcerr.InvalidArgument("webhook_id", "webhook_id is required")
+	}
+
+	if req.GetPageSize() < 0 {
+		return grpcerr.InvalidArgument("page_size", "page_size must not be negative")
+	}
+
+	switch req.GetStatus() {
+	case "", domain.DeliveryPending, domain.DeliveryDelivered, domain.DeliveryDead:
+	default:
+		return grpcerr.InvalidArgument("status", "status must be pending, delivered or dead")
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package httperr writes the statuses grpcerr builds to the HTTP endpoints,
+// so that their clients branch on the same reasons as gRPC clients.
+package httperr
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Write writes the status err as JSON, a RetryInfo is sent as Retry-After.
+func Write(w http.ResponseWriter, err error) {
+	st, _ := status.FromError(err)
+
+	resp := errorResponse{Code: st.Code().String(), Message: st.Message()}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			resp.Reason = d.GetReason()
+		case *errdetails.RetryInfo:
+			seconds := math.Ceil(d.GetRetryDelay().AsDuration().Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+		}
+	}
+
+	WriteJSON(w, Code(st.Code()), resp)
+}
+
+func WriteJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Code returns the HTTP status of a gRPC code.
+func Code(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Canceled:
+		return 499
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
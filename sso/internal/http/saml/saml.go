@@ -0,0 +1,158 @@
+// Package Httpsaml serves the browser facing endpoints of the SAML
+// connectors: the metadata a provider is set up with, the start of a login
+// and the assertion consumer service the provider posts its response to.
+//
+// The login sets a cookie with the state it sends along as RelayState, the
+// ACS only accepts a response posted by the browser that holds it. The user
+// is then sent back to the callback of the app with a one-time code the app
+// redeems for tokens over gRPC, tokens never reach the browser.
+package Httpsaml
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/http/httperr"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/federation"
+)
+
+const emptyID = 0
+
+// maxFormSize bounds the posted form, responses with a signature and a few
+// attributes are well under it.
+const maxFormSize = 1 << 20
+
+// stateCookie holds the state of the login the browser started, the
+// provider posts to the ACS cross site so it has to be SameSite=None.
+const stateCookie = "sso_saml_state"
+
+type ServicSAML interface {
+	SAMLMetadata(
+		ctx context.Context,
+		connector string,
+	) (metadata []byte, err error)
+
+	StartSAMLLogin(
+		ctx context.Context,
+		connector string,
+		appID int64,
+		redirectURI string,
+	) (authURL string, state string, err error)
+
+	FinishSAMLLogin(
+		ctx context.Context,
+		connector string,
+		samlResponse string,
+		relayState string,
+	) (redirectURL string, err error)
+}
+
+type handler struct {
+	saml ServicSAML
+}
+
+func Register(mux *http.ServeMux, saml ServicSAML) {
+	h := &handler{saml: saml}
+
+	mux.HandleFunc("GET /saml/{connector}/metadata", h.metadata)
+	mux.HandleFunc("GET /saml/{connector}/login", h.login)
+	mux.HandleFunc("POST /saml/{connector}/acs", h.acs)
+}
+
+func (h *handler) metadata(w http.ResponseWriter, r *http.Request) {
+	metadata, err := h.saml.SAMLMetadata(r.Context(), r.PathValue("connector"))
+	if err != nil {
+		httperr.Write(w, grpcerr.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(metadata)
+}
+
+func (h *handler) login(w http.ResponseWriter, r *http.Request) {
+	appID, err := strconv.ParseInt(r.URL.Query().Get("app_id"), 10, 64)
+	if err != nil || appID == emptyID {
+		httperr.Write(w, grpcerr.InvalidArgument("app_id", "app_id is requred"))
+		return
+	}
+
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	if redirectURI == "" {
+		httperr.Write(w, grpcerr.InvalidArgument("redirect_uri", "redirect_uri is requred"))
+		return
+	}
+
+	connector := r.PathValue("connector")
+	authURL, state, err := h.saml.StartSAMLLogin(r.Context(), connector, appID, redirectURI)
+	if err != nil {
+		httperr.Write(w, grpcerr.Status(err))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     cookiePath(connector),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h *handler) acs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseForm(); err != nil {
+		httperr.Write(w, grpcerr.InvalidArgument("SAMLResponse", "invalid form"))
+		return
+	}
+
+	samlResponse := r.PostForm.Get("SAMLResponse")
+	if samlResponse == "" {
+		httperr.Write(w, grpcerr.InvalidArgument("SAMLResponse", "SAMLResponse is requred"))
+		return
+	}
+
+	relayState := r.PostForm.Get("RelayState")
+	if relayState == "" {
+		httperr.Write(w, grpcerr.InvalidArgument("RelayState", "RelayState is requred"))
+		return
+	}
+
+	connector := r.PathValue("connector")
+
+	// a response posted by another browser, e.g. one an attacker got for
+	// their own account, does not carry the cookie of the login
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(relayState)) != 1 {
+		httperr.Write(w, grpcerr.Status(federation.ErrInvalidState))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Path:     cookiePath(connector),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	redirectURL, err := h.saml.FinishSAMLLogin(r.Context(), connector, samlResponse, relayState)
+	if err != nil {
+		httperr.Write(w, grpcerr.Status(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+func cookiePath(connector string) string {
+	return "/saml/" + connector
+}
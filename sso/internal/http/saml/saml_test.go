@@ -0,0 +1,87 @@
+package Httpsaml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const callback = "https://app.example.com/callback"
+
+type fakeSAML struct {
+	finished int
+}
+
+func (f *fakeSAML) SAMLMetadata(_ context.Context, _ string) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeSAML) StartSAMLLogin(_ context.Context, connector string, _ int64, redirectURI string) (string, string, error) {
+	return "https://idp.example.com/sso", "state-of-" + connector, nil
+}
+
+func (f *fakeSAML) FinishSAMLLogin(_ context.Context, _ string, _ string, _ string) (string, error) {
+	f.finished++
+	return callback + "?code=abc", nil
+}
+
+func TestLoginBindsRelayState(t *testing.T) {
+	saml := &fakeSAML{}
+	mux := http.NewServeMux()
+	Register(mux, saml)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/saml/corp/login?app_id=1&redirect_uri="+url.QueryEscape(callback), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie || cookies[0].Value != "state-of-corp" || cookies[0].Path != "/saml/corp" {
+		t.Fatalf("unexpected cookies %+v", cookies)
+	}
+
+	acs := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{"SAMLResponse": {"response"}, "RelayState": {"state-of-corp"}}
+		req := httptest.NewRequest(http.MethodPost, "/saml/corp/acs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a response posted by a browser that did not start the login
+	if rec := acs(nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("acs without cookie status = %d", rec.Code)
+	}
+	if rec := acs(&http.Cookie{Name: stateCookie, Value: "state-of-other"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("acs with another state status = %d", rec.Code)
+	}
+	if saml.finished != 0 {
+		t.Fatal("login finished without the cookie")
+	}
+
+	rec = acs(cookies[0])
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != callback+"?code=abc" {
+		t.Fatalf("acs status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if cleared := rec.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Fatalf("state cookie not cleared: %+v", cleared)
+	}
+}
+
+func TestLoginRequiresRedirectURI(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux, &fakeSAML{})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/saml/corp/login?app_id=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
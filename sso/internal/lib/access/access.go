@@ -0,0 +1,107 @@
+// Package access holds what the services acting on the data of a user
+// share: resolving whose data a call is about and auditing its outcome.
+package access
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("an access token is required")
+	ErrPermissionDenied = errors.New("only admins can access data of other users")
+)
+
+// Reason codes of failures every service shares.
+const (
+	ReasonUnauthenticated  = "unauthenticated"
+	ReasonPermissionDenied = "permission_denied"
+	ReasonInternal         = "internal_error"
+)
+
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+// Reasons maps the errors a service expects to fail with to the codes their
+// failures are audited with.
+type Reasons map[error]string
+
+// Owner resolves the user a call about userID is about, zero means the
+// caller, and checks that the caller may access it. Only admins may access
+// other users.
+func Owner(ctx context.Context, admins AdminChecker, userID int64) (int64, error) {
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+
+	if userID == 0 || userID == caller.UserID {
+		return caller.UserID, nil
+	}
+
+	isAdmin, err := admins.IsAdmin(ctx, caller.UserID)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		return 0, err
+	}
+	if !isAdmin {
+		return 0, ErrPermissionDenied
+	}
+
+	return userID, nil
+}
+
+// UserSubject is the audit subject of a call about userID, zero means the
+// caller.
+func UserSubject(ctx context.Context, userID int64) string {
+	if userID == 0 {
+		caller, _ := principal.FromContext(ctx)
+		userID = caller.UserID
+	}
+
+	return domain.UserSubject(userID)
+}
+
+// Record audits event of the caller with the outcome err. A failure is
+// recorded with the code reasons has for it; the text of other errors may
+// hold internal details, they are recorded as ReasonInternal.
+func Record(ctx context.Context, auditor Auditor, event domain.AuditEvent, err error, reasons Reasons) {
+	if caller, ok := principal.FromContext(ctx); ok {
+		event.ActorID = caller.UserID
+		event.AppID = caller.AppID
+	}
+
+	event.Result = domain.AuditSuccess
+	if err != nil {
+		event.Result = domain.AuditFailure
+		event.Reason = Reason(err, reasons)
+	}
+
+	auditor.Record(ctx, event)
+}
+
+// Reason returns the code err is audited with.
+func Reason(err error, reasons Reasons) string {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return ReasonUnauthenticated
+	case errors.Is(err, ErrPermissionDenied):
+		return ReasonPermissionDenied
+	}
+
+	for target, reason := range reasons {
+		if errors.Is(err, target) {
+			return reason
+		}
+	}
+
+	return ReasonInternal
+}
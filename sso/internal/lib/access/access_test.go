@@ -0,0 +1,86 @@
+package access_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+)
+
+type admins map[int64]bool
+
+func (a admins) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return a[userID], nil
+}
+
+type auditor []domain.AuditEvent
+
+func (a *auditor) Record(_ context.Context, event domain.AuditEvent) {
+	*a = append(*a, event)
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 3})
+}
+
+func TestOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		userID int64
+		owner  int64
+		err    error
+	}{
+		{name: "anonymous", ctx: context.Background(), userID: 7, err: access.ErrUnauthenticated},
+		{name: "caller", ctx: as(7), userID: 0, owner: 7},
+		{name: "self", ctx: as(7), userID: 7, owner: 7},
+		{name: "other user", ctx: as(7), userID: 8, err: access.ErrPermissionDenied},
+		{name: "admin", ctx: as(1), userID: 8, owner: 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owner, err := access.Owner(test.ctx, admins{1: true}, test.userID)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if owner != test.owner {
+				t.Fatalf("owner = %d, want %d", owner, test.owner)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	errKnown := errors.New("known")
+	reasons := access.Reasons{errKnown: "known_reason"}
+
+	var a auditor
+	event := domain.AuditEvent{Type: domain.AuditUserUpdate, Subject: access.UserSubject(as(7), 0)}
+
+	access.Record(as(7), &a, event, nil, reasons)
+	access.Record(as(7), &a, event, fmt.Errorf("op: %w", errKnown), reasons)
+	access.Record(as(7), &a, event, fmt.Errorf("op: %w", access.ErrPermissionDenied), reasons)
+	access.Record(as(7), &a, event, fmt.Errorf("op: %w", errors.New("dial tcp 10.0.0.5:5432: connection refused")), reasons)
+
+	want := []struct{ result, reason string }{
+		{domain.AuditSuccess, ""},
+		{domain.AuditFailure, "known_reason"},
+		{domain.AuditFailure, access.ReasonPermissionDenied},
+		// the text of unexpected errors stays out of the audit log
+		{domain.AuditFailure, access.ReasonInternal},
+	}
+	for i, w := range want {
+		e := a[i]
+		if e.Result != w.result || e.Reason != w.reason {
+			t.Errorf("event %d: result %q reason %q, want %q %q", i, e.Result, e.Reason, w.result, w.reason)
+		}
+		if e.ActorID != 7 || e.AppID != 3 || e.Subject != domain.UserSubject(7) {
+			t.Errorf("event %d: unexpected caller %+v", i, e)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package attrschema validates the custom attributes of a profile against the
+// schema of an app.
+package attrschema
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+const (
+	// MaxAttributes bounds the custom attributes of a profile across all
+	// apps.
+	MaxAttributes = 64
+	// MaxStringLength bounds string attributes whose rule sets no or a
+	// larger max_length.
+	MaxStringLength = 1024
+)
+
+// Violation names the offending field, custom attributes are reported as
+// "attributes.<name>".
+type Violation struct {
+	Field       string
+	Description string
+}
+
+// ViolationError is returned when a profile update breaks one or more rules.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+
+	return strings.Join(descriptions, "; ")
+}
+
+// Validate checks the attributes set by an update and the required attributes
+// of the merged result. Attributes of other apps that the update does not
+// touch are left alone. A nil schema accepts no custom attributes, else an
+// app without one could write the attributes another app puts into its
+// claims.
+func Validate(schema domain.AttributeSchema, update map[string]any, merged map[string]any) error {
+	var violations []Violation
+
+	for _, name := range sortedKeys(update) {
+		value := update[name]
+
+		rule, ok := schema[name]
+		if !ok {
+			violations = append(violations, violation(name, "is not a known attribute"))
+			continue
+		}
+
+		if value == nil {
+			continue
+		}
+
+		if desc := check(rule, value); desc != "" {
+			violations = append(violations, violation(name, desc))
+		}
+	}
+
+	for _, name := range sortedKeys(schema) {
+		if schema[name].Required && merged[name] == nil {
+			violations = append(violations, violation(name, "is required"))
+		}
+	}
+
+	if len(update) > 0 && len(merged) > MaxAttributes {
+		violations = append(violations, Violation{
+			Field:       "attributes",
+			Description: fmt.Sprintf("a profile has at most %d attributes", MaxAttributes),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+
+	return nil
+}
+
+func check(rule domain.AttributeRule, value any) string {
+	switch rule.Type {
+	case domain.AttributeString:
+		s, ok := value.(string)
+		if !ok {
+			return "must be a string"
+		}
+		maxLength := MaxStringLength
+		if rule.MaxLength > 0 && rule.MaxLength < maxLength {
+			maxLength = rule.MaxLength
+		}
+		if utf8.RuneCountInString(s) > maxLength {
+			return fmt.Sprintf("must be at most %d characters", maxLength)
+		}
+		if len(rule.Enum) > 0 && !slices.Contains(rule.Enum, s) {
+			return "must be one of " + strings.Join(rule.Enum, ", ")
+		}
+	case domain.AttributeNumber:
+		if _, ok := value.(float64); !ok {
+			return "must be a number"
+		}
+	case domain.AttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return "must be a boolean"
+		}
+	default:
+		return "has an unknown type in the schema"
+	}
+
+	return ""
+}
+
+func violation(name string, desc string) Violation {
+	return Violation{Field: "attributes." + name, Description: name + " " + desc}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
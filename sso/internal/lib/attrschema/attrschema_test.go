@@ -0,0 +1,105 @@
+package attrschema
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+func TestValidate(t *testing.T) {
+	schema := domain.AttributeSchema{
+		"plan":     {Type: domain.AttributeString, Required: true, Enum: []string{"free", "pro"}},
+		"nickname": {Type: domain.AttributeString, MaxLength: 5},
+		"age":      {Type: domain.AttributeNumber},
+		"beta":     {Type: domain.AttributeBoolean},
+	}
+
+	tests := []struct {
+		name   string
+		update map[string]any
+		stored map[string]any
+		fields []string
+	}{
+		{name: "valid", update: map[string]any{"plan": "pro", "age": 30.0, "beta": true}},
+		{name: "required kept from stored", update: map[string]any{"nickname": "jo"}, stored: map[string]any{"plan": "free"}},
+		{name: "other apps attributes are ignored", update: map[string]any{"plan": "free"}, stored: map[string]any{"crm_id": "x"}},
+		{name: "missing required", update: map[string]any{"beta": false}, fields: []string{"attributes.plan"}},
+		{name: "removing required", update: map[string]any{"plan": nil}, stored: map[string]any{"plan": "pro"}, fields: []string{"attributes.plan"}},
+		{name: "unknown", update: map[string]any{"plan": "pro", "crm_id": "x"}, fields: []string{"attributes.crm_id"}},
+		{name: "wrong types", update: map[string]any{"plan": "pro", "age": "30", "beta": "yes"}, fields: []string{"attributes.age", "attributes.beta"}},
+		{name: "enum and length", update: map[string]any{"plan": "gold", "nickname": "jonathan"}, fields: []string{"attributes.nickname", "attributes.plan"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := map[string]any{}
+			for k, v := range tt.stored {
+				merged[k] = v
+			}
+			for k, v := range tt.update {
+				if v == nil {
+					delete(merged, k)
+				} else {
+					merged[k] = v
+				}
+			}
+
+			err := Validate(schema, tt.update, merged)
+
+			var got []string
+			var verr *ViolationError
+			if errors.As(err, &verr) {
+				for _, v := range verr.Violations {
+					got = append(got, v.Field)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.fields) {
+				t.Fatalf("violations = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestValidateWithoutSchema(t *testing.T) {
+	// an app without a schema can neither write nor remove the attributes
+	// of other apps
+	for _, update := range []map[string]any{{"plan": "pro"}, {"plan": nil}} {
+		var verr *ViolationError
+		if err := Validate(nil, update, map[string]any{"plan": "pro"}); !errors.As(err, &verr) || verr.Violations[0].Field != "attributes.plan" {
+			t.Fatalf("%v: expected a violation, got %v", update, err)
+		}
+	}
+
+	if err := Validate(nil, nil, map[string]any{"plan": "pro"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	schema := domain.AttributeSchema{
+		"bio": {Type: domain.AttributeString},
+		"tag": {Type: domain.AttributeString, MaxLength: 10 * MaxStringLength},
+	}
+
+	long := strings.Repeat("a", MaxStringLength+1)
+	update := map[string]any{"bio": long, "tag": long}
+	var verr *ViolationError
+	if err := Validate(schema, update, update); !errors.As(err, &verr) || len(verr.Violations) != 2 {
+		t.Fatalf("expected 2 violations, got %v", err)
+	}
+
+	merged := map[string]any{"bio": "short"}
+	for i := 0; i < MaxAttributes; i++ {
+		merged[fmt.Sprintf("other_%d", i)] = "x"
+	}
+	if err := Validate(schema, map[string]any{"bio": "short"}, merged); !errors.As(err, &verr) || verr.Violations[0].Field != "attributes" {
+		t.Fatalf("expected a violation of the count, got %v", err)
+	}
+}
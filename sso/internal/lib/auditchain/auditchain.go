@@ -0,0 +1,293 @@
+// Package auditchain makes the audit log tamper-evident.
+//
+// Every event stores the hash of the event before it in its tenant, so
+// editing or deleting a row breaks every link after it. Checkpoints sign the
+// head of every chain with an Ed25519 key, which catches a rewrite of a whole
+// chain and a cut off tail. Neither catches events deleted from the start of
+// the chain together with their checkpoints, anchors kept outside the
+// database do.
+//
+// The subject, address and user agent are hashed through a digest of their
+// own so that personal data can later be removed without breaking the chain.
+package auditchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+// Hash returns the chain hash of e, it covers e.PrevHash and, with
+// e.TenantHashed, the tenant. The stored
+// e.PIIDigest is only trusted for events with a TombstoneID, the personal
+// data of other events is hashed as it is.
+func Hash(e domain.AuditEvent) string {
+	h := sha256.New()
+
+	writeField(h, e.PrevHash)
+	writeField(h, strconv.FormatInt(e.ID, 10))
+	writeField(h, e.Type)
+	writeField(h, strconv.FormatInt(e.ActorID, 10))
+	digest := PIIDigest(e)
+	if e.TombstoneID != 0 {
+		digest = e.PIIDigest
+	}
+	writeField(h, digest)
+	writeField(h, strconv.FormatInt(e.AppID, 10))
+	writeField(h, e.Result)
+	writeField(h, e.Reason)
+	writeField(h, strconv.FormatInt(e.CreatedAt.UnixMicro(), 10))
+	if e.TenantHashed {
+		writeField(h, strconv.FormatInt(e.TenantID, 10))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PIIDigest hashes the personal data of e.
+func PIIDigest(e domain.AuditEvent) string {
+	h := sha256.New()
+
+	writeField(h, e.Subject)
+	writeField(h, e.IP)
+	writeField(h, e.UserAgent)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField length prefixes s so that no two events encode the same.
+func writeField(h hash.Hash, s string) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	h.Write(n[:])
+	h.Write([]byte(s))
+}
+
+// LoadKey reads a hex encoded Ed25519 seed from path.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	const op = "auditchain.LoadKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: key must be a hex encoded %d byte seed", op, ed25519.SeedSize)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Sign signs the chain head at eventID.
+func Sign(key ed25519.PrivateKey, eventID int64, hash string) []byte {
+	return ed25519.Sign(key, checkpointMessage(eventID, hash))
+}
+
+func checkpointMessage(eventID int64, hash string) []byte {
+	return []byte("sso-audit-checkpoint:" + strconv.FormatInt(eventID, 10) + ":" + hash)
+}
+
+var ErrBrokenChain = errors.New("audit chain is broken")
+
+// BrokenLinkError points at the first event that does not verify.
+type BrokenLinkError struct {
+	EventID int64
+	Reason  string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("audit chain broken at event %d: %s", e.EventID, e.Reason)
+}
+
+func (e *BrokenLinkError) Unwrap() error { return ErrBrokenChain }
+
+// Anchor pins an event of the chain from outside the database. Operators
+// store the head of the chain, see Audit.Head, somewhere the database
+// credentials cannot reach and hand it back to the verification.
+type Anchor struct {
+	EventID   int64
+	Hash      string
+	CreatedAt time.Time
+}
+
+// String encodes a as "<event id>:<hash>:<unix micros>", see ParseAnchor.
+func (a Anchor) String() string {
+	return strconv.FormatInt(a.EventID, 10) + ":" + a.Hash + ":" + strconv.FormatInt(a.CreatedAt.UnixMicro(), 10)
+}
+
+var ErrInvalidAnchor = errors.New("invalid anchor")
+
+// ParseAnchor decodes an anchor written by Anchor.String.
+func ParseAnchor(s string) (Anchor, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[1] == "" {
+		return Anchor{}, ErrInvalidAnchor
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		return Anchor{}, ErrInvalidAnchor
+	}
+
+	micros, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return Anchor{}, ErrInvalidAnchor
+	}
+
+	return Anchor{EventID: id, Hash: parts[1], CreatedAt: time.UnixMicro(micros).UTC()}, nil
+}
+
+// link is the last event seen of a chain.
+type link struct {
+	id   int64
+	hash string
+}
+
+// Verifier checks the chains fed to it in id order.
+//
+// The first chained event of a tenant is trusted to link to whatever came
+// before it, older events may have been removed by retention. Events without
+// a hash before the first chained one predate the chain and are only
+// counted.
+type Verifier struct {
+	checkpoints map[int64]domain.AuditCheckpoint
+	anchors     map[int64]Anchor
+	horizon     time.Time
+	matched     map[int64]bool
+
+	// prev is the last event of any tenant, events stored before
+	// TenantChain link to it
+	prev    link
+	tenants map[int64]link
+	firstID int64
+	lastID  int64
+
+	Events      int
+	Legacy      int
+	Checkpoints int
+	Anchors     int
+}
+
+// NewVerifier checks the signatures of checkpoints with pub. With a nil pub
+// signatures are not checked, the checkpoints still have to match the chain.
+func NewVerifier(pub ed25519.PublicKey, checkpoints []domain.AuditCheckpoint) (*Verifier, error) {
+	v := &Verifier{
+		checkpoints: make(map[int64]domain.AuditCheckpoint, len(checkpoints)),
+		anchors:     make(map[int64]Anchor),
+		matched:     make(map[int64]bool),
+		tenants:     make(map[int64]link),
+	}
+
+	for _, cp := range checkpoints {
+		if pub != nil && !ed25519.Verify(pub, checkpointMessage(cp.EventID, cp.Hash), cp.Signature) {
+			return nil, &BrokenLinkError{EventID: cp.EventID, Reason: fmt.Sprintf("checkpoint %d has an invalid signature", cp.ID)}
+		}
+		v.checkpoints[cp.EventID] = cp
+	}
+
+	return v, nil
+}
+
+// Anchor makes the verification require the events of anchors. Anchored
+// events created before horizon may have been removed by retention, a zero
+// horizon requires all of them.
+func (v *Verifier) Anchor(horizon time.Time, anchors ...Anchor) {
+	v.horizon = horizon
+	for _, a := range anchors {
+		v.anchors[a.EventID] = a
+	}
+}
+
+// Add verifies the next event of the chain.
+func (v *Verifier) Add(e domain.AuditEvent) error {
+	if e.Hash == "" {
+		if v.firstID == 0 {
+			v.Legacy++
+			return nil
+		}
+		return &BrokenLinkError{EventID: e.ID, Reason: "event is not chained"}
+	}
+
+	prev, seen := v.prev, v.firstID != 0
+	if e.TenantChain {
+		prev, seen = v.tenants[e.TenantID]
+	}
+	if seen && e.PrevHash != prev.hash {
+		return &BrokenLinkError{EventID: e.ID, Reason: fmt.Sprintf("previous hash does not match event %d", prev.id)}
+	}
+
+	if Hash(e) != e.Hash {
+		return &BrokenLinkError{EventID: e.ID, Reason: "event was modified"}
+	}
+
+	if cp, ok := v.checkpoints[e.ID]; ok {
+		if cp.Hash != e.Hash {
+			return &BrokenLinkError{EventID: e.ID, Reason: fmt.Sprintf("hash does not match checkpoint %d", cp.ID)}
+		}
+		v.matched[e.ID] = true
+		v.Checkpoints++
+	}
+
+	if a, ok := v.anchors[e.ID]; ok {
+		if a.Hash != e.Hash {
+			return &BrokenLinkError{EventID: e.ID, Reason: "hash does not match the anchor"}
+		}
+		v.matched[e.ID] = true
+		v.Anchors++
+	}
+
+	if v.firstID == 0 {
+		v.firstID = e.ID
+	}
+	v.prev = link{id: e.ID, hash: e.Hash}
+	v.tenants[e.TenantID] = v.prev
+	v.lastID = e.ID
+	v.Events++
+
+	return nil
+}
+
+// Finish reports checkpoints and anchors of events that should still be
+// there. The tail of a chain was cut off when there are checkpoints, events
+// were deleted anywhere when there are anchors.
+func (v *Verifier) Finish() error {
+	var missing *BrokenLinkError
+	report := func(id int64, reason string) {
+		if missing == nil || id < missing.EventID {
+			missing = &BrokenLinkError{EventID: id, Reason: reason}
+		}
+	}
+
+	for id, cp := range v.checkpoints {
+		// checkpoints older than the chain outlived their events
+		if v.matched[id] || (v.firstID != 0 && id < v.firstID) {
+			continue
+		}
+		report(id, fmt.Sprintf("event signed by checkpoint %d is missing", cp.ID))
+	}
+
+	for id, a := range v.anchors {
+		if v.matched[id] || (!v.horizon.IsZero() && a.CreatedAt.Before(v.horizon)) {
+			continue
+		}
+		report(id, "anchored event is missing")
+	}
+
+	if missing != nil {
+		return missing
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package auditchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+func chain(n int) []domain.AuditEvent {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	events := make([]domain.AuditEvent, 0, n)
+	prev := ""
+	for i := 1; i <= n; i++ {
+		e := domain.AuditEvent{
+			ID:        int64(i),
+			Type:      domain.AuditLogin,
+			ActorID:   7,
+			Subject:   "jonn@gmail.com",
+			IP:        "10.0.0.1",
+			AppID:     1,
+			Result:    domain.AuditSuccess,
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+			PrevHash:  prev,
+		}
+		e.Hash = Hash(e)
+		prev = e.Hash
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func verify(pub ed25519.PublicKey, checkpoints []domain.AuditCheckpoint, events []domain.AuditEvent) error {
+	v, err := NewVerifier(pub, checkpoints)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		if err := v.Add(e); err != nil {
+			return err
+		}
+	}
+
+	return v.Finish()
+}
+
+func TestVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := chain(5)
+	checkpoint := domain.AuditCheckpoint{ID: 1, EventID: 5, Hash: events[4].Hash, Signature: Sign(key, 5, events[4].Hash)}
+
+	type test struct {
+		name        string
+		events      func([]domain.AuditEvent) []domain.AuditEvent
+		checkpoints []domain.AuditCheckpoint
+		brokenAt    int64
+	}
+
+	tests := []test{
+		{
+			name:   "intact",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent { return e },
+		},
+		{
+			name:   "expired head",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent { return e[2:] },
+		},
+		{
+			name: "legacy rows",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent {
+				return append([]domain.AuditEvent{{ID: 0, Type: domain.AuditLogin}}, e...)
+			},
+		},
+		{
+			name: "edited row",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent {
+				e[2].Result = domain.AuditFailure
+				return e
+			},
+			brokenAt: 3,
+		},
+		{
+			name: "deleted row",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent {
+				return append(e[:1], e[2:]...)
+			},
+			brokenAt: 3,
+		},
+		{
+			name:        "truncated tail",
+			events:      func(e []domain.AuditEvent) []domain.AuditEvent { return e[:3] },
+			checkpoints: []domain.AuditCheckpoint{checkpoint},
+			brokenAt:    5,
+		},
+		{
+			name:        "signed head",
+			events:      func(e []domain.AuditEvent) []domain.AuditEvent { return e },
+			checkpoints: []domain.AuditCheckpoint{checkpoint},
+		},
+		{
+			name:   "forged checkpoint",
+			events: func(e []domain.AuditEvent) []domain.AuditEvent { return e },
+			checkpoints: []domain.AuditCheckpoint{
+				{ID: 2, EventID: 5, Hash: events[4].Hash, Signature: make([]byte, ed25519.SignatureSize)},
+			},
+			brokenAt: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verify(pub, test.checkpoints, test.events(chain(5)))
+			if test.brokenAt == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var broken *BrokenLinkError
+			if !errors.As(err, &broken) {
+				t.Fatalf("expected BrokenLinkError, got %v", err)
+			}
+			if broken.EventID != test.brokenAt {
+				t.Errorf("expected broken link at %d, got %v", test.brokenAt, broken)
+			}
+		})
+	}
+}
+
+func TestVerifyErased(t *testing.T) {
+	events := chain(3)
+
+	// erasure keeps the digest and drops the personal data
+	erased := events[1]
+	erased.PIIDigest = PIIDigest(erased)
+	erased.Subject = domain.ErasedSubject(1)
+	erased.IP = ""
+	erased.TombstoneID = 1
+	events[1] = erased
+
+	if err := verify(nil, nil, events); err != nil {
+		t.Fatalf("erased chain does not verify: %v", err)
+	}
+
+	// a forged digest is still caught
+	events[1].PIIDigest = PIIDigest(domain.AuditEvent{Subject: "someone@else.com"})
+
+	var broken *BrokenLinkError
+	if err := verify(nil, nil, events); !errors.As(err, &broken) || broken.EventID != 2 {
+		t.Fatalf("expected broken link at 2, got %v", err)
+	}
+
+	// a digest without a tombstone does not cover changed data
+	events = chain(3)
+	events[1].PIIDigest = PIIDigest(events[1])
+	events[1].Subject = "someone@else.com"
+
+	if err := verify(nil, nil, events); !errors.As(err, &broken) || broken.EventID != 2 {
+		t.Fatalf("expected broken link at 2, got %v", err)
+	}
+}
+
+// tenantChains interleaves the events of tenant 1 and 2, each tenant chained
+// on its own. The first legacy events are chained across tenants.
+func tenantChains(n int, legacy int) []domain.AuditEvent {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	events := make([]domain.AuditEvent, 0, n)
+	prev := ""
+	tenants := map[int64]string{}
+	for i := 1; i <= n; i++ {
+		e := domain.AuditEvent{
+			ID:          int64(i),
+			Type:        domain.AuditLogin,
+			Result:      domain.AuditSuccess,
+			CreatedAt:   start.Add(time.Duration(i) * time.Second),
+			TenantID:    int64(i%2 + 1),
+			TenantChain: i > legacy,
+		}
+		e.PrevHash = prev
+		if e.TenantChain {
+			e.PrevHash = tenants[e.TenantID]
+		}
+		e.Hash = Hash(e)
+		prev = e.Hash
+		tenants[e.TenantID] = e.Hash
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestHashTenant(t *testing.T) {
+	e := chain(1)[0]
+	e.TenantID = 1
+
+	// events stored before the tenant was hashed keep their hash
+	legacy := e
+	legacy.TenantID = 2
+	if Hash(legacy) != Hash(e) {
+		t.Fatal("hash of a legacy event depends on its tenant")
+	}
+
+	e.TenantHashed = true
+	moved := e
+	moved.TenantID = 2
+	if Hash(moved) == Hash(e) {
+		t.Fatal("hash does not cover the tenant")
+	}
+}
+
+func TestVerifyTenantChains(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(e domain.AuditEvent) domain.AuditCheckpoint {
+		return domain.AuditCheckpoint{ID: e.ID, EventID: e.ID, Hash: e.Hash, Signature: Sign(key, e.ID, e.Hash)}
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		events := tenantChains(8, 3)
+		if err := verify(pub, []domain.AuditCheckpoint{sign(events[6]), sign(events[7])}, events); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("linked across tenants", func(t *testing.T) {
+		events := tenantChains(8, 3)
+		events[5].PrevHash = events[4].Hash
+		events[5].Hash = Hash(events[5])
+
+		var broken *BrokenLinkError
+		if err := verify(nil, nil, events); !errors.As(err, &broken) || broken.EventID != 6 {
+			t.Fatalf("expected broken link at 6, got %v", err)
+		}
+	})
+
+	t.Run("tail of one tenant cut off", func(t *testing.T) {
+		events := tenantChains(8, 3)
+		// event 7 is the head of tenant 2 while tenant 1 goes on
+		checkpoints := []domain.AuditCheckpoint{sign(events[6]), sign(events[7])}
+
+		var broken *BrokenLinkError
+		if err := verify(pub, checkpoints, append(events[:6], events[7])); !errors.As(err, &broken) || broken.EventID != 7 {
+			t.Fatalf("expected broken link at 7, got %v", err)
+		}
+	})
+}
+
+func TestVerifyAnchors(t *testing.T) {
+	anchor := func(e domain.AuditEvent) Anchor {
+		return Anchor{EventID: e.ID, Hash: e.Hash, CreatedAt: e.CreatedAt}
+	}
+
+	run := func(events []domain.AuditEvent, horizon time.Time, anchors ...Anchor) error {
+		v, err := NewVerifier(nil, nil)
+		if err != nil {
+			return err
+		}
+		v.Anchor(horizon, anchors...)
+
+		for _, e := range events {
+			if err := v.Add(e); err != nil {
+				return err
+			}
+		}
+
+		return v.Finish()
+	}
+
+	events := chain(5)
+
+	tests := []struct {
+		name     string
+		events   []domain.AuditEvent
+		horizon  time.Time
+		anchor   Anchor
+		brokenAt int64
+	}{
+		{name: "intact", events: events, anchor: anchor(events[1])},
+		{name: "start deleted", events: events[2:], anchor: anchor(events[1]), brokenAt: 2},
+		{name: "start expired", events: events[2:], horizon: events[2].CreatedAt, anchor: anchor(events[1])},
+		{name: "tail deleted", events: events[:3], anchor: anchor(events[4]), brokenAt: 5},
+		{name: "rewritten", events: events, anchor: Anchor{EventID: 2, Hash: events[2].Hash}, brokenAt: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(tt.events, tt.horizon, tt.anchor)
+			if tt.brokenAt == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var broken *BrokenLinkError
+			if !errors.As(err, &broken) || broken.EventID != tt.brokenAt {
+				t.Fatalf("expected broken link at %d, got %v", tt.brokenAt, err)
+			}
+		})
+	}
+}
+
+func TestParseAnchor(t *testing.T) {
+	want := Anchor{EventID: 42, Hash: "abc", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 1000, time.UTC)}
+
+	got, err := ParseAnchor(want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, s := range []string{"", "42", "42:abc", "x:abc:1", "42::1", "0:abc:1"} {
+		if _, err := ParseAnchor(s); !errors.Is(err, ErrInvalidAnchor) {
+			t.Errorf("ParseAnchor(%q) = %v, want ErrInvalidAnchor", s, err)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package authz authenticates callers by the access token or API key they
+// send and restricts admin methods to admins.
+package authz
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type AppProvider interface {
+	App(ctx context.Context, appID int64) (domain.App, error)
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+// SessionProvider returns the active sessions, revoked and expired sessions
+// are not found.
+type SessionProvider interface {
+	Session(ctx context.Context, sessionID int64) (domain.Session, error)
+	TouchSession(ctx context.Context, sessionID int64) error
+}
+
+// APIKeyProvider authenticates API keys, see package apikey.
+type APIKeyProvider interface {
+	Authenticate(ctx context.Context, key string) (domain.APIKey, error)
+	Touch(keyID int64)
+}
+
+// touchInterval limits how often the last-seen time of a session is written.
+const touchInterval = time.Minute
+
+var (
+	errUnauthenticated = grpcerr.Unauthenticated("a valid access token is required")
+	errNotAdmin        = grpcerr.PermissionDenied("the method is restricted to admins")
+	errNoScope         = grpcerr.PermissionDenied("the api key has no scope for the method")
+	errStepUp          = grpcerr.StepUpRequired("the session has to be stepped up first")
+
+	errRevoked = errors.New("token is revoked")
+)
+
+// Authorizer reads the "authorization: Bearer <token>" metadata of every
+// call. Calls of admin methods without a token of an admin are rejected,
+// other methods may be called anonymously. Tokens of disabled users, tokens
+// issued before User.TokensInvalidBefore, tokens of revoked sessions and
+// tokens of apps outside the tenant of the call are not accepted. Public
+// methods such as Login and Refresh are then called anonymously, so that a
+// stale token does not lock a client out of them, every other method
+// rejects them.
+//
+// API keys are read from the "x-api-key" metadata or the bearer token. They
+// are treated like tokens issued when the key was created and may only call
+// the services that keyScopes maps to one of their scopes, keyed by the
+// "/package.service/" part of the method.
+//
+// Tokens of risky logins and tokens below the App.MinACR of their app are
+// restricted, they may only call public methods and stepUpMethods until the
+// session is stepped up.
+type Authorizer struct {
+	log           *slog.Logger
+	apps          AppProvider
+	users         UserProvider
+	sessions      SessionProvider
+	keys          APIKeyProvider
+	adminMethods  map[string]bool
+	publicMethods map[string]bool
+	stepUpMethods map[string]bool
+	keyScopes     map[string]string
+}
+
+func New(
+	log *slog.Logger,
+	apps AppProvider,
+	users UserProvider,
+	sessions SessionProvider,
+	keys APIKeyProvider,
+	adminMethods []string,
+	publicMethods []string,
+	stepUpMethods []string,
+	keyScopes map[string]string,
+) *Authorizer {
+	return &Authorizer{
+		log:           log,
+		apps:          apps,
+		users:         users,
+		sessions:      sessions,
+		keys:          keys,
+		adminMethods:  set(adminMethods),
+		publicMethods: set(publicMethods),
+		stepUpMethods: set(stepUpMethods),
+		keyScopes:     keyScopes,
+	}
+}
+
+func set(methods []string) map[string]bool {
+	m := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		m[method] = true
+	}
+
+	return m
+}
+
+// UnaryServerInterceptor stores the caller in the context, see package
+// principal.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor stores the caller in the context of the stream.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Authorizer) authorize(ctx context.Context, method string) (context.Context, error) {
+	const op = "authz.authorize"
+
+	key, token := credentials(ctx)
+	if key == "" && token == "" {
+		if a.adminMethods[method] {
+			return nil, errUnauthenticated
+		}
+		return ctx, nil
+	}
+
+	var (
+		p          principal.Principal
+		restricted bool
+		err        error
+	)
+	if key != "" {
+		p, err = a.authenticateKey(ctx, key)
+	} else {
+		p, restricted, err = a.authenticate(ctx, token)
+	}
+	if err != nil {
+		if errors.Is(err, jwtToken.ErrInvalidToken) || errors.Is(err, storage.ErrAppNotFound) ||
+			errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrSessionNotFound) ||
+			errors.Is(err, keyformat.ErrInvalidAPIKey) || errors.Is(err, errRevoked) {
+			if !a.publicMethods[method] {
+				return nil, errUnauthenticated
+			}
+			a.log.Debug("invalid credentials ignored", slog.String("op", op),
+				slog.String("method", method), slog.Any("err", err))
+			return ctx, nil
+		}
+		a.log.Error("field to authenticate", slog.String("op", op), slog.Any("err", err))
+		return nil, grpcerr.Status(err)
+	}
+
+	if p.APIKeyID != 0 {
+		key := domain.APIKey{ID: p.APIKeyID, Scopes: p.Scopes}
+		if scope, ok := a.keyScopes[service(method)]; !ok || !key.HasScope(scope) {
+			a.log.Warn("api key out of scope", slog.String("op", op),
+				slog.String("method", method), slog.Int64("key_id", p.APIKeyID))
+			return nil, errNoScope
+		}
+	}
+
+	if restricted && !a.stepUpMethods[method] && !a.publicMethods[method] {
+		a.log.Warn("restricted token denied", slog.String("op", op),
+			slog.String("method", method), slog.Int64("uid", p.UserID))
+		return nil, errStepUp
+	}
+
+	if a.adminMethods[method] {
+		isAdmin, err := a.users.IsAdmin(ctx, p.UserID)
+		if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+			a.log.Error("field to check admin", slog.String("op", op), slog.Any("err", err))
+			return nil, grpcerr.Status(err)
+		}
+		if !isAdmin {
+			a.log.Warn("admin method denied", slog.String("op", op),
+				slog.String("method", method), slog.Int64("uid", p.UserID))
+			return nil, errNotAdmin
+		}
+		p.Admin = true
+	}
+
+	return principal.With(ctx, p), nil
+}
+
+// authenticate returns the caller of token, restricted is set when the
+// session of the token has to be stepped up first.
+func (a *Authorizer) authenticate(ctx context.Context, token string) (p principal.Principal, restricted bool, err error) {
+	appID, err := jwtToken.AppID(token)
+	if err != nil {
+		return principal.Principal{}, false, err
+	}
+
+	app, err := a.apps.App(ctx, appID)
+	if err != nil {
+		return principal.Principal{}, false, err
+	}
+
+	// the app of the token must belong to the tenant of the call
+	if app.TenantID != 0 && app.TenantID != tenant.ID(ctx) {
+		return principal.Principal{}, false, storage.ErrAppNotFound
+	}
+
+	claims, err := jwtToken.ParseAccessToken(token, app)
+	if err != nil {
+		return principal.Principal{}, false, err
+	}
+
+	user, err := a.users.UserByID(ctx, claims.UserID)
+	if err != nil {
+		return principal.Principal{}, false, err
+	}
+
+	if user.Disabled() || issuedAt(claims).Before(user.TokensInvalidBefore) {
+		return principal.Principal{}, false, errRevoked
+	}
+
+	if claims.SessionID != 0 {
+		if err := a.checkSession(ctx, claims); err != nil {
+			return principal.Principal{}, false, err
+		}
+	}
+
+	p = principal.Principal{
+		UserID:         claims.UserID,
+		Email:          claims.Email,
+		AppID:          claims.AppID,
+		SessionID:      claims.SessionID,
+		StepUpRequired: claims.StepUpRequired,
+	}
+
+	return p, claims.StepUpRequired || claims.ACR < app.MinACR, nil
+}
+
+func (a *Authorizer) authenticateKey(ctx context.Context, key string) (principal.Principal, error) {
+	apiKey, err := a.keys.Authenticate(ctx, key)
+	if err != nil {
+		return principal.Principal{}, err
+	}
+
+	user, err := a.users.UserByID(ctx, apiKey.UserID)
+	if err != nil {
+		return principal.Principal{}, err
+	}
+
+	if user.Disabled() || apiKey.CreatedAt.Before(user.TokensInvalidBefore) {
+		return principal.Principal{}, errRevoked
+	}
+
+	// keys of disabled users and revoked keys do not count as used
+	a.keys.Touch(apiKey.ID)
+
+	return principal.Principal{UserID: user.ID, Email: user.Email, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}, nil
+}
+
+// issuedAt returns when the token of claims was issued. Tokens issued before
+// the iat claim was added count as issued at the Unix epoch, the
+// TokensInvalidBefore of users that never revoked their tokens, so only
+// users that did are logged out.
+func issuedAt(claims jwtToken.Claims) time.Time {
+	if claims.IssuedAt.IsZero() {
+		return time.Unix(0, 0)
+	}
+
+	return claims.IssuedAt
+}
+
+// service returns the "/package.service/" prefix of method.
+func service(method string) string {
+	return method[:strings.LastIndex(method, "/")+1]
+}
+
+// checkSession rejects tokens whose session was revoked and records that the
+// session is in use.
+func (a *Authorizer) checkSession(ctx context.Context, claims jwtToken.Claims) error {
+	const op = "authz.checkSession"
+
+	session, err := a.sessions.Session(ctx, claims.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != claims.UserID {
+		return errRevoked
+	}
+
+	if time.Since(session.LastSeenAt) > touchInterval {
+		if err := a.sessions.TouchSession(ctx, session.ID); err != nil {
+			a.log.Error("field to touch session", slog.String("op", op), slog.Any("err", err))
+		}
+	}
+
+	return nil
+}
+
+// credentials returns the API key or else the access token of the call.
+func credentials(ctx context.Context) (key string, token string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if v := md.Get("x-api-key"); len(v) > 0 && v[0] != "" {
+		return strings.TrimSpace(v[0]), ""
+	}
+
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			token = strings.TrimSpace(token)
+			if strings.HasPrefix(token, keyformat.Prefix) {
+				return token, ""
+			}
+			return "", token
+		}
+	}
+
+	return "", ""
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,370 @@
+package authz
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const adminMethod = "/auth.UserAdmin/GetUser"
+
+var publicMethods = []string{"/auth.auth/Login"}
+
+var stepUpMethods = []string{"/auth.auth/StepUp"}
+
+type fakeStorage struct {
+	app      domain.App
+	admins   map[int64]bool
+	users    map[int64]domain.User
+	sessions map[int64]domain.Session
+	keys     map[string]domain.APIKey
+	touched  map[int64]bool
+}
+
+func (f fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	u, ok := f.users[userID]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f fakeStorage) App(_ context.Context, appID int64) (domain.App, error) {
+	if appID != f.app.ID {
+		return domain.App{}, storage.ErrAppNotFound
+	}
+
+	return f.app, nil
+}
+
+func (f fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func (f fakeStorage) Session(_ context.Context, sessionID int64) (domain.Session, error) {
+	s, ok := f.sessions[sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrSessionNotFound
+	}
+
+	return s, nil
+}
+
+func (f fakeStorage) TouchSession(context.Context, int64) error { return nil }
+
+func (f fakeStorage) Authenticate(_ context.Context, key string) (domain.APIKey, error) {
+	k, ok := f.keys[key]
+	if !ok {
+		return domain.APIKey{}, keyformat.ErrInvalidAPIKey
+	}
+
+	return k, nil
+}
+
+func (f fakeStorage) Touch(keyID int64) {
+	if f.touched != nil {
+		f.touched[keyID] = true
+	}
+}
+
+var keyScopes = map[string]string{
+	"/auth.profiles/":  domain.ScopeProfile,
+	"/auth.UserAdmin/": domain.ScopeAdmin,
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	app := domain.App{ID: 1, Name: "test", Secret: "secret"}
+	st := fakeStorage{app: app, admins: map[int64]bool{1: true}, users: map[int64]domain.User{
+		1: {ID: 1},
+		2: {ID: 2},
+		3: {ID: 3, Status: domain.UserStatusDisabled},
+		4: {ID: 4, TokensInvalidBefore: time.Now().Add(time.Minute)},
+	}, sessions: map[int64]domain.Session{
+		10: {ID: 10, UserID: 2},
+	}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st, st, st, []string{adminMethod}, publicMethods, stepUpMethods, keyScopes)
+
+	token := func(uid int64, app domain.App) string {
+		t.Helper()
+		tok, err := jwtToken.GetToken(domain.User{ID: uid, Email: "u@example.com"}, app, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	sessionToken := func(uid int64, sid int64) string {
+		t.Helper()
+		tok, err := jwtToken.GetTokenWithClaims(domain.User{ID: uid}, app, time.Hour, map[string]any{"sid": sid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	change, err := jwtToken.GetChangeToken(domain.User{ID: 1}, app, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		code   codes.Code
+		uid    int64
+	}{
+		{name: "anonymous public method", method: "/auth.auth/Login", code: codes.OK},
+		{name: "public method with token", method: "/auth.auth/Login", token: token(2, app), code: codes.OK, uid: 2},
+		{name: "anonymous admin method", method: adminMethod, code: codes.Unauthenticated},
+		{name: "admin", method: adminMethod, token: token(1, app), code: codes.OK, uid: 1},
+		{name: "not an admin", method: adminMethod, token: token(2, app), code: codes.PermissionDenied},
+		{name: "forged token", method: adminMethod, token: token(1, domain.App{ID: 1, Secret: "other"}), code: codes.Unauthenticated},
+		{name: "unknown app", method: adminMethod, token: token(1, domain.App{ID: 2, Secret: "secret"}), code: codes.Unauthenticated},
+		{name: "change token", method: adminMethod, token: change, code: codes.Unauthenticated},
+		{name: "active session", method: "/auth.auth/Login", token: sessionToken(2, 10), code: codes.OK, uid: 2},
+		// public methods are called anonymously with tokens that are not
+		// accepted, admin methods reject them
+		{name: "disabled user", method: "/auth.auth/Login", token: token(3, app), code: codes.OK},
+		{name: "revoked token", method: "/auth.auth/Login", token: token(4, app), code: codes.OK},
+		{name: "deleted user", method: "/auth.auth/Login", token: token(5, app), code: codes.OK},
+		{name: "revoked session", method: "/auth.auth/Login", token: sessionToken(2, 11), code: codes.OK},
+		{name: "session of another user", method: "/auth.auth/Login", token: sessionToken(1, 10), code: codes.OK},
+		{name: "garbage", method: "/auth.auth/Login", token: "garbage", code: codes.OK},
+		{name: "disabled user on admin method", method: adminMethod, token: token(3, app), code: codes.Unauthenticated},
+		{name: "revoked token on admin method", method: adminMethod, token: token(4, app), code: codes.Unauthenticated},
+		{name: "revoked session on admin method", method: adminMethod, token: sessionToken(1, 11), code: codes.Unauthenticated},
+		{name: "garbage on admin method", method: adminMethod, token: "garbage", code: codes.Unauthenticated},
+		{name: "revoked token on owner method", method: "/auth.profiles/GetProfile", token: token(4, app), code: codes.Unauthenticated},
+		{name: "anonymous owner method", method: "/auth.profiles/GetProfile", code: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+
+			var got principal.Principal
+			handler := func(ctx context.Context, _ any) (any, error) {
+				got, _ = principal.FromContext(ctx)
+				return nil, nil
+			}
+
+			_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+
+			if got.UserID != tt.uid {
+				t.Fatalf("principal uid = %d, want %d", got.UserID, tt.uid)
+			}
+			if want := tt.method == adminMethod && tt.code == codes.OK; got.Admin != want {
+				t.Fatalf("principal admin = %v, want %v", got.Admin, want)
+			}
+		})
+	}
+}
+
+func TestTenantMismatch(t *testing.T) {
+	app := domain.App{ID: 1, Name: "test", Secret: "secret", TenantID: 2}
+	st := fakeStorage{app: app, users: map[int64]domain.User{2: {ID: 2}}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st, st, st, []string{adminMethod}, publicMethods, stepUpMethods, keyScopes)
+
+	tok, err := jwtToken.GetToken(domain.User{ID: 2}, app, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := metadata.Pairs("authorization", "Bearer "+tok)
+	handler := func(context.Context, any) (any, error) { return nil, nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.profiles/GetProfile"}
+
+	ctx := tenant.With(metadata.NewIncomingContext(context.Background(), md), 2)
+	if _, err := a.UnaryServerInterceptor()(ctx, nil, info, handler); err != nil {
+		t.Fatalf("token rejected in its tenant: %v", err)
+	}
+
+	ctx = tenant.With(metadata.NewIncomingContext(context.Background(), md), 1)
+	_, err = a.UnaryServerInterceptor()(ctx, nil, info, handler)
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Fatalf("code = %v, want %v", code, codes.Unauthenticated)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	st := fakeStorage{admins: map[int64]bool{1: true}, users: map[int64]domain.User{
+		1: {ID: 1, Email: "admin@example.com"},
+		2: {ID: 2, Email: "u@example.com"},
+		3: {ID: 3, Status: domain.UserStatusDisabled},
+		4: {ID: 4, TokensInvalidBefore: time.Now()},
+	}, keys: map[string]domain.APIKey{
+		"sso_a_admin":    {ID: 1, UserID: 1, Scopes: []string{domain.ScopeAdmin}, CreatedAt: created},
+		"sso_b_profile":  {ID: 2, UserID: 2, Scopes: []string{domain.ScopeProfile}, CreatedAt: created},
+		"sso_c_notadmin": {ID: 3, UserID: 2, Scopes: []string{domain.ScopeAdmin}, CreatedAt: created},
+		"sso_d_disabled": {ID: 4, UserID: 3, Scopes: []string{domain.ScopeProfile}, CreatedAt: created},
+		"sso_e_revoked":  {ID: 5, UserID: 4, Scopes: []string{domain.ScopeProfile}, CreatedAt: created},
+	}, touched: map[int64]bool{}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st, st, st, []string{adminMethod}, publicMethods, stepUpMethods, keyScopes)
+
+	tests := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		code   codes.Code
+		key    int64
+	}{
+		{name: "x-api-key", method: "/auth.profiles/GetProfile", md: metadata.Pairs("x-api-key", "sso_b_profile"), key: 2},
+		{name: "bearer", method: "/auth.profiles/GetProfile", md: metadata.Pairs("authorization", "Bearer sso_b_profile"), key: 2},
+		{name: "unknown key", method: "/auth.profiles/GetProfile", md: metadata.Pairs("x-api-key", "sso_x_nope"), code: codes.Unauthenticated},
+		{name: "out of scope", method: adminMethod, md: metadata.Pairs("x-api-key", "sso_b_profile"), code: codes.PermissionDenied},
+		{name: "unmapped service", method: "/auth.auth/Login", md: metadata.Pairs("x-api-key", "sso_b_profile"), code: codes.PermissionDenied},
+		{name: "unknown key on public method", method: "/auth.auth/Login", md: metadata.Pairs("x-api-key", "sso_x_nope")},
+		{name: "admin scope of an admin", method: adminMethod, md: metadata.Pairs("x-api-key", "sso_a_admin"), key: 1},
+		{name: "admin scope of a user", method: adminMethod, md: metadata.Pairs("x-api-key", "sso_c_notadmin"), code: codes.PermissionDenied},
+		{name: "disabled user", method: "/auth.profiles/GetProfile", md: metadata.Pairs("x-api-key", "sso_d_disabled"), code: codes.Unauthenticated},
+		{name: "created before revocation", method: "/auth.profiles/GetProfile", md: metadata.Pairs("x-api-key", "sso_e_revoked"), code: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got principal.Principal
+			handler := func(ctx context.Context, _ any) (any, error) {
+				got, _ = principal.FromContext(ctx)
+				return nil, nil
+			}
+
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+
+			if got.APIKeyID != tt.key {
+				t.Fatalf("principal key = %d, want %d", got.APIKeyID, tt.key)
+			}
+		})
+	}
+
+	// keys of disabled users and revoked keys are not recorded as used
+	if !st.touched[1] || !st.touched[2] || st.touched[4] || st.touched[5] {
+		t.Fatalf("unexpected keys touched %v", st.touched)
+	}
+}
+
+func TestTokenWithoutIssuedAt(t *testing.T) {
+	app := domain.App{ID: 1, Name: "test", Secret: "secret"}
+	epoch := time.Unix(0, 0)
+	st := fakeStorage{app: app, users: map[int64]domain.User{
+		1: {ID: 1, TokensInvalidBefore: epoch},
+		2: {ID: 2, TokensInvalidBefore: time.Now().Add(-time.Hour)},
+	}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st, st, st, []string{adminMethod}, publicMethods, stepUpMethods, keyScopes)
+
+	// tokens issued before the iat claim was added
+	legacy := func(uid int64) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"uid":    uid,
+			"email":  "u@example.com",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"app_id": app.ID,
+		}).SignedString([]byte(app.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	tests := []struct {
+		name string
+		uid  int64
+		code codes.Code
+	}{
+		{name: "tokens never revoked", uid: 1, code: codes.OK},
+		{name: "tokens revoked since", uid: 2, code: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+legacy(tt.uid)))
+			handler := func(context.Context, any) (any, error) { return nil, nil }
+
+			_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.profiles/GetProfile"}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestStepUpRequired(t *testing.T) {
+	app := domain.App{ID: 1, Name: "test", Secret: "secret", MinACR: domain.ACRMultiFactor}
+	st := fakeStorage{app: app, users: map[int64]domain.User{2: {ID: 2}}, sessions: map[int64]domain.Session{
+		10: {ID: 10, UserID: 2},
+	}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st, st, st, []string{adminMethod}, publicMethods, stepUpMethods, keyScopes)
+
+	token := func(session domain.Session) string {
+		t.Helper()
+		tok, err := jwtToken.GetTokenWithClaims(domain.User{ID: 2}, app, time.Hour, jwtToken.SessionClaims(session))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	risky := token(domain.Session{ID: 10, ACR: domain.ACRMultiFactor, StepUpRequired: true})
+	password := token(domain.Session{ID: 10, ACR: domain.ACRPassword})
+	steppedUp := token(domain.Session{ID: 10, ACR: domain.ACRMultiFactor, AMR: []string{domain.AMRPassword, domain.AMROTP}})
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		code   codes.Code
+		stepUp bool
+	}{
+		{name: "risky login", method: "/auth.profiles/GetProfile", token: risky, code: codes.PermissionDenied},
+		{name: "risky login steps up", method: "/auth.auth/StepUp", token: risky, stepUp: true},
+		{name: "risky login on public method", method: "/auth.auth/Login", token: risky, stepUp: true},
+		{name: "below the level of the app", method: "/auth.profiles/GetProfile", token: password, code: codes.PermissionDenied},
+		{name: "below the level steps up", method: "/auth.auth/StepUp", token: password},
+		{name: "stepped up", method: "/auth.profiles/GetProfile", token: steppedUp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got principal.Principal
+			handler := func(ctx context.Context, _ any) (any, error) {
+				got, _ = principal.FromContext(ctx)
+				return nil, nil
+			}
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tt.token))
+			_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+
+			if got.StepUpRequired != tt.stepUp {
+				t.Fatalf("principal step up = %v, want %v", got.StepUpRequired, tt.stepUp)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+const (
+	ModeWarn  = "warn"
+	ModeBlock = "block"
+
+	prefixLen = 5
+)
+
+type Verdict int
+
+const (
+	VerdictNone Verdict = iota
+	VerdictWarn
+	VerdictBlock
+)
+
+// Checker looks passwords up in a local copy of the Pwned Passwords corpus.
+//
+// The corpus is either a directory of HIBP range files, one file per 5 char
+// SHA-1 prefix holding "SUFFIX:COUNT" lines, or a single file of
+// "HASH:COUNT" lines. Both have to be sorted by hash. Files are mmap'd and
+// binary searched, nothing is loaded into the heap.
+type Checker struct {
+	mode     string
+	minCount int
+
+	dir  string
+	data []byte
+}
+
+// New opens the corpus from config. With an empty corpus path the checker
+// accepts every password.
+func New(cfg config.Breach) (*Checker, error) {
+	const op = "breach.New"
+
+	c := &Checker{mode: cfg.Mode, minCount: cfg.MinCount}
+
+	if c.mode == "" {
+		c.mode = ModeBlock
+	}
+	if c.mode != ModeWarn && c.mode != ModeBlock {
+		return nil, fmt.Errorf("%s: unknown mode %q", op, cfg.Mode)
+	}
+
+	if c.minCount <= 0 {
+		c.minCount = 1
+	}
+
+	if cfg.CorpusPath == "" {
+		return c, nil
+	}
+
+	info, err := os.Stat(cfg.CorpusPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if info.IsDir() {
+		c.dir = cfg.CorpusPath
+		return c, nil
+	}
+
+	// The single file corpus stays mapped for the lifetime of the process.
+	data, _, err := mapFile(cfg.CorpusPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	c.data = data
+
+	return c, nil
+}
+
+// Check reports what should happen to password according to the configured
+// mode.
+func (c *Checker) Check(password string) (Verdict, error) {
+	count, err := c.Count(password)
+	if err != nil {
+		return VerdictNone, err
+	}
+
+	if count < c.minCount {
+		return VerdictNone, nil
+	}
+
+	if c.mode == ModeWarn {
+		return VerdictWarn, nil
+	}
+
+	return VerdictBlock, nil
+}
+
+// Count returns how many times password was seen in breaches.
+func (c *Checker) Count(password string) (int, error) {
+	const op = "breach.Count"
+
+	sum := sha1.Sum([]byte(password))
+	hash := bytes.ToUpper([]byte(hex.EncodeToString(sum[:])))
+
+	if c.data != nil {
+		return search(c.data, hash)
+	}
+
+	if c.dir == "" {
+		return 0, nil
+	}
+
+	prefix := string(hash[:prefixLen])
+
+	data, unmap, err := mapFile(filepath.Join(c.dir, prefix))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unmap()
+
+	return search(data, hash[prefixLen:])
+}
+
+// search binary searches the sorted "KEY:COUNT" lines of data for key.
+func search(data []byte, key []byte) (int, error) {
+	lo, hi := 0, len(data)
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start := lo
+		if i := bytes.LastIndexByte(data[lo:mid], '\n'); i >= 0 {
+			start = lo + i + 1
+		}
+
+		end := len(data)
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+			end = start + i
+		}
+
+		line := bytes.TrimRight(data[start:end], "\r")
+		lineKey, count, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			return 0, fmt.Errorf("malformed corpus line %q", line)
+		}
+
+		switch cmp := bytes.Compare(bytes.ToUpper(lineKey), key); {
+		case cmp == 0:
+			n, err := strconv.Atoi(string(count))
+			if err != nil {
+				return 0, fmt.Errorf("malformed corpus line %q", line)
+			}
+			return n, nil
+		case cmp < 0:
+			lo = end + 1
+		default:
+			hi = start
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,100 @@
+package breach
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+func TestChecker(t *testing.T) {
+	breached := map[string]int{
+		"password1":   2413945,
+		"qwerty":      10,
+		"hunter2":     1,
+		"Sup3rSecret": 3,
+	}
+
+	var lines []string
+	for p, count := range breached {
+		lines = append(lines, sha1Hex(p)+":"+strconv.Itoa(count))
+	}
+	sort.Strings(lines)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pwned.txt")
+	writeFile(t, file, strings.Join(lines, "\r\n")+"\r\n")
+
+	rangeDir := filepath.Join(dir, "range")
+	if err := os.Mkdir(rangeDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		f, err := os.OpenFile(filepath.Join(rangeDir, line[:prefixLen]), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line[prefixLen:] + "\n")
+		f.Close()
+	}
+
+	type test struct {
+		name    string
+		cfg     config.Breach
+		pass    string
+		verdict Verdict
+	}
+
+	tests := []test{
+		{name: "file block", cfg: config.Breach{CorpusPath: file}, pass: "password1", verdict: VerdictBlock},
+		{name: "file last line", cfg: config.Breach{CorpusPath: file}, pass: "hunter2", verdict: VerdictBlock},
+		{name: "file clean", cfg: config.Breach{CorpusPath: file}, pass: "correct horse battery staple", verdict: VerdictNone},
+		{name: "range block", cfg: config.Breach{CorpusPath: rangeDir, Mode: ModeBlock}, pass: "qwerty", verdict: VerdictBlock},
+		{name: "range missing prefix", cfg: config.Breach{CorpusPath: rangeDir}, pass: "correct horse battery staple", verdict: VerdictNone},
+		{name: "warn", cfg: config.Breach{CorpusPath: rangeDir, Mode: ModeWarn}, pass: "Sup3rSecret", verdict: VerdictWarn},
+		{name: "below min count", cfg: config.Breach{CorpusPath: file, MinCount: 5}, pass: "Sup3rSecret", verdict: VerdictNone},
+		{name: "disabled", cfg: config.Breach{}, pass: "password1", verdict: VerdictNone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := New(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verdict, err := c.Check(test.pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if verdict != test.verdict {
+				t.Errorf("expected verdict %d, got %d", test.verdict, verdict)
+			}
+		})
+	}
+}
+
+func TestCheckerUnknownMode(t *testing.T) {
+	if _, err := New(config.Breach{Mode: "ignore"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package breach
+
+import "os"
+
+// mapFile falls back to reading the file into memory where mmap is not
+// available.
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,175 @@
+package clientinfo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Info describes the client that sent the current request.
+type Info struct {
+	IP        string
+	UserAgent string
+	// Device is the "x-device-id" metadata, an id the client keeps for the
+	// device it runs on.
+	Device string
+}
+
+type ctxKey struct{}
+
+func With(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// FromContext returns the client info stored by the interceptor, the zero
+// Info when there is none.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(ctxKey{}).(Info)
+	return info
+}
+
+// Resolver finds the client of a call. The address is the one of the peer
+// unless the peer is a trusted proxy, it is then read from the header the
+// proxies set, see config.Clients.
+type Resolver struct {
+	header  string
+	proxies []netip.Prefix
+}
+
+// New returns a Resolver for cfg, the header is only read when proxies are
+// trusted.
+func New(cfg config.Clients) (*Resolver, error) {
+	const op = "clientinfo.New"
+
+	r := &Resolver{header: strings.ToLower(cfg.IPHeader)}
+
+	for _, proxy := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%s: invalid trusted proxy %q: %w", op, proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		r.proxies = append(r.proxies, prefix.Masked())
+	}
+
+	return r, nil
+}
+
+// UnaryServerInterceptor stores the address, the user agent and the device
+// of every call in the context.
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(With(ctx, r.fromIncoming(ctx)), req)
+	}
+}
+
+// StreamServerInterceptor stores the same info in the context of a stream.
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: With(ss.Context(), r.fromIncoming(ss.Context()))})
+	}
+}
+
+// Middleware stores the same info in the context of every HTTP request.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info := Info{
+			IP:        r.ClientIP(req.RemoteAddr, req.Header.Values(r.header)),
+			UserAgent: req.UserAgent(),
+			Device:    req.Header.Get("X-Device-Id"),
+		}
+
+		next.ServeHTTP(w, req.WithContext(With(req.Context(), info)))
+	})
+}
+
+func (r *Resolver) fromIncoming(ctx context.Context) Info {
+	var info Info
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.IP = r.ClientIP(p.Addr.String(), md.Get(r.header))
+	}
+
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		info.UserAgent = ua[0]
+	}
+	if device := md.Get("x-device-id"); len(device) > 0 {
+		info.Device = device[0]
+	}
+
+	return info
+}
+
+// ClientIP returns the client address of a call from remote, headers are the
+// values of the configured header. They are only read when remote is a
+// trusted proxy: the list is walked from the end, every trusted proxy is
+// skipped and the first other address is the client. Addresses before it
+// were written by the client and can not be trusted.
+func (r *Resolver) ClientIP(remote string, headers []string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+
+	if r.header == "" || !r.trusted(host) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range headers {
+		for _, hop := range strings.Split(h, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break
+		}
+		client = hops[i]
+		if !r.trusted(hops[i]) {
+			break
+		}
+	}
+
+	return client
+}
+
+func (r *Resolver) trusted(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, proxy := range r.proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
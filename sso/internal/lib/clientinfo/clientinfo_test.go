@@ -0,0 +1,107 @@
+package clientinfo_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientIP(t *testing.T) {
+	r, err := clientinfo.New(config.Clients{IPHeader: "X-Forwarded-For", TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers []string
+		want    string
+	}{
+		{name: "direct client", remote: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "direct client sets the header", remote: "203.0.113.7:5000", headers: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "behind a proxy", remote: "10.0.0.5:5000", headers: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "behind two proxies", remote: "10.0.0.5:5000", headers: []string{"198.51.100.1, 192.168.1.1"}, want: "198.51.100.1"},
+		{name: "spoofed hop", remote: "10.0.0.5:5000", headers: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "repeated header", remote: "10.0.0.5:5000", headers: []string{"1.2.3.4", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy without header", remote: "10.0.0.5:5000", want: "10.0.0.5"},
+		{name: "garbage", remote: "10.0.0.5:5000", headers: []string{"unknown"}, want: "10.0.0.5"},
+		{name: "mapped address of a proxy", remote: "[::ffff:10.0.0.5]:5000", headers: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.ClientIP(tt.remote, tt.headers); got != tt.want {
+				t.Fatalf("client ip = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidProxy(t *testing.T) {
+	if _, err := clientinfo.New(config.Clients{IPHeader: "x-forwarded-for", TrustedProxies: []string{"proxy.local"}}); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	r, err := clientinfo.New(config.Clients{IPHeader: "x-forwarded-for", TrustedProxies: []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "198.51.100.1", "user-agent", "test", "x-device-id", "laptop"))
+
+	var got clientinfo.Info
+	handler := func(ctx context.Context, _ any) (any, error) {
+		got = clientinfo.FromContext(ctx)
+		return nil, nil
+	}
+
+	if _, err := r.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (clientinfo.Info{IP: "198.51.100.1", UserAgent: "test", Device: "laptop"}); got != want {
+		t.Fatalf("info = %+v, want %+v", got, want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r, err := clientinfo.New(config.Clients{IPHeader: "X-Forwarded-For", TrustedProxies: []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got clientinfo.Info
+	handler := r.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = clientinfo.FromContext(req.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/saml/corp/acs", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.1")
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("X-Device-Id", "laptop")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := (clientinfo.Info{IP: "198.51.100.1", UserAgent: "test", Device: "laptop"}); got != want {
+		t.Fatalf("info = %+v, want %+v", got, want)
+	}
+
+	// the header of a client that is not a trusted proxy is ignored
+	req.RemoteAddr = "198.51.100.7:5000"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got.IP != "198.51.100.7" {
+		t.Fatalf("ip = %q, want the remote address", got.IP)
+	}
+}
@@ -0,0 +1,293 @@
+// Package directory authenticates users against an LDAP directory such as
+// Active Directory. A service account finds the entry of the user, a bind
+// as the entry checks the password and its groups grant roles.
+package directory
+
+import (
+	"container/list"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found in the directory")
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+	ErrUnavailable        = errors.New("directory is unavailable")
+)
+
+const (
+	defaultUserFilter     = "(mail=%s)"
+	defaultEmailAttribute = "mail"
+	defaultGroupAttribute = "memberOf"
+	defaultCacheTTL       = 5 * time.Minute
+	defaultCacheSize      = 10000
+	defaultTimeout        = 5 * time.Second
+)
+
+type cached struct {
+	key     string
+	user    domain.DirectoryUser
+	expires time.Time
+}
+
+// LDAP is the directory of config.Directory. Entries found by email are
+// cached, a changed group membership shows after the entry expired. A full
+// cache drops the entry used least recently.
+type LDAP struct {
+	cfg config.Directory
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	// order holds the cached entries, the most recently used in front
+	order *list.List
+}
+
+func New(cfg config.Directory) *LDAP {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = defaultUserFilter
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = defaultEmailAttribute
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = defaultGroupAttribute
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultCacheTTL
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	return &LDAP{
+		cfg:   cfg,
+		cache: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Authenticate binds as the entry of email with password. Unknown emails
+// are ErrUserNotFound, a refused bind is ErrInvalidCredentials and a
+// directory that can not be reached is ErrUnavailable.
+func (d *LDAP) Authenticate(ctx context.Context, email string, password string) (domain.DirectoryUser, error) {
+	const op = "directory.Authenticate"
+
+	// an empty password is an unauthenticated bind, which servers accept
+	if password == "" {
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	user, err := d.entry(conn, email)
+	if err != nil {
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, unavailable(err))
+	}
+
+	return user, nil
+}
+
+// Lookup returns the entry of email without checking a password, the
+// directory is only asked when the entry is not cached.
+func (d *LDAP) Lookup(ctx context.Context, email string) (domain.DirectoryUser, error) {
+	const op = "directory.Lookup"
+
+	if user, ok := d.cached(strings.ToLower(email)); ok {
+		return user, nil
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	user, err := d.entry(conn, email)
+	if err != nil {
+		return domain.DirectoryUser{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// entry returns the cached entry of email and finds and caches it when it
+// is not.
+func (d *LDAP) entry(conn *ldap.Conn, email string) (domain.DirectoryUser, error) {
+	key := strings.ToLower(email)
+
+	if user, ok := d.cached(key); ok {
+		return user, nil
+	}
+
+	user, err := d.find(conn, email)
+	if err != nil {
+		return domain.DirectoryUser{}, unavailable(err)
+	}
+
+	d.store(key, user)
+
+	return user, nil
+}
+
+func (d *LDAP) cached(key string) (domain.DirectoryUser, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	el, ok := d.cache[key]
+	if !ok {
+		return domain.DirectoryUser{}, false
+	}
+
+	c := el.Value.(*cached)
+	if time.Now().After(c.expires) {
+		d.order.Remove(el)
+		delete(d.cache, key)
+		return domain.DirectoryUser{}, false
+	}
+
+	d.order.MoveToFront(el)
+
+	return c.user, true
+}
+
+func (d *LDAP) store(key string, user domain.DirectoryUser) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.cache[key]; ok {
+		d.order.Remove(el)
+	}
+	d.cache[key] = d.order.PushFront(&cached{key: key, user: user, expires: time.Now().Add(d.cfg.CacheTTL)})
+
+	for d.order.Len() > d.cfg.CacheSize {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.cache, oldest.Value.(*cached).key)
+	}
+}
+
+func (d *LDAP) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(d.cfg.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	conn.SetTimeout(d.cfg.Timeout)
+
+	if d.cfg.StartTLS {
+		u, err := url.Parse(d.cfg.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, unavailable(err)
+		}
+	}
+
+	return conn, nil
+}
+
+// find looks the entry of email up as the service account.
+func (d *LDAP) find(conn *ldap.Conn, email string) (domain.DirectoryUser, error) {
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return domain.DirectoryUser{}, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // more than one entry is an error
+		int(d.cfg.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(d.cfg.UserFilter, ldap.EscapeFilter(email)),
+		[]string{d.cfg.EmailAttribute, d.cfg.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return domain.DirectoryUser{}, ErrUserNotFound
+		}
+		return domain.DirectoryUser{}, err
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return domain.DirectoryUser{}, ErrUserNotFound
+	case 1:
+	default:
+		return domain.DirectoryUser{}, fmt.Errorf("%d entries match %q", len(res.Entries), email)
+	}
+
+	entry := res.Entries[0]
+
+	user := domain.DirectoryUser{
+		DN:    entry.DN,
+		Email: entry.GetAttributeValue(d.cfg.EmailAttribute),
+		Roles: d.roles(entry.GetAttributeValues(d.cfg.GroupAttribute)),
+	}
+	if user.Email == "" {
+		user.Email = email
+	}
+
+	return user, nil
+}
+
+// unavailable marks the errors of a lost connection or of a server that
+// does not serve requests with ErrUnavailable.
+func unavailable(err error) error {
+	if ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// roles maps the groups of an entry to roles, group DNs compare without
+// regard to case.
+func (d *LDAP) roles(groups []string) []string {
+	var roles []string
+	for _, g := range d.cfg.GroupRoles {
+		for _, group := range groups {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(g.Group)) {
+				roles = append(roles, g.Role)
+				break
+			}
+		}
+	}
+
+	return roles
+}
@@ -0,0 +1,202 @@
+package directory_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory/ldaptest"
+)
+
+const (
+	adminsGroup = "cn=sso-admins,ou=groups,dc=example,dc=com"
+	staffGroup  = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+// newTestDirectory returns a directory of jonn and anna, configure changes
+// its config.
+func newTestDirectory(t *testing.T, configure ...func(*config.Directory)) (*directory.LDAP, *ldaptest.Server) {
+	t.Helper()
+
+	stub := ldaptest.New(t,
+		ldaptest.Entry{
+			DN:       "cn=sso,ou=services,dc=example,dc=com",
+			Password: "service-secret",
+		},
+		ldaptest.Entry{
+			DN:       "uid=jonn,ou=people,dc=example,dc=com",
+			Password: "jonn-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"mail":        {"jonn@gmail.com"},
+				"memberOf":    {"CN=SSO-Admins,OU=Groups,DC=example,DC=com", staffGroup},
+			},
+		},
+		ldaptest.Entry{
+			DN:       "uid=anna,ou=people,dc=example,dc=com",
+			Password: "anna-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"mail":        {"anna@gmail.com"},
+				"memberOf":    {staffGroup},
+			},
+		},
+	)
+
+	cfg := config.Directory{
+		URL:          stub.URL,
+		BindDN:       "cn=sso,ou=services,dc=example,dc=com",
+		BindPassword: "service-secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(mail=%s))",
+		GroupRoles: []config.GroupRole{
+			{Group: adminsGroup, Role: domain.RoleAdmin},
+			{Group: staffGroup, Role: "staff"},
+		},
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+
+	return directory.New(cfg), stub
+}
+
+func TestAuthenticate(t *testing.T) {
+	d, _ := newTestDirectory(t)
+	ctx := context.Background()
+
+	user, err := d.Authenticate(ctx, "jonn@gmail.com", "jonn-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != "uid=jonn,ou=people,dc=example,dc=com" || user.Email != "jonn@gmail.com" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if !slices.Equal(user.Roles, []string{domain.RoleAdmin, "staff"}) {
+		t.Fatalf("roles = %v", user.Roles)
+	}
+
+	user, err = d.Authenticate(ctx, "anna@gmail.com", "anna-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(user.Roles, []string{"staff"}) {
+		t.Fatalf("roles = %v", user.Roles)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	d, _ := newTestDirectory(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     error
+	}{
+		{"wrong password", "jonn@gmail.com", "anna-secret", directory.ErrInvalidCredentials},
+		{"empty password", "jonn@gmail.com", "", directory.ErrInvalidCredentials},
+		{"unknown user", "bob@gmail.com", "bob-secret", directory.ErrUserNotFound},
+		{"filter injection", "*", "jonn-secret", directory.ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Authenticate(ctx, tt.email, tt.password); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCachesEntries(t *testing.T) {
+	d, stub := newTestDirectory(t)
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := d.Authenticate(ctx, "jonn@gmail.com", "jonn-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stub.Searches(); n != 1 {
+		t.Fatalf("searches = %d, want 1", n)
+	}
+
+	// the password is still checked against the directory
+	if _, err := d.Authenticate(ctx, "JONN@gmail.com", "wrong"); !errors.Is(err, directory.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if n := stub.Searches(); n != 1 {
+		t.Fatalf("searches = %d, want 1", n)
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	d, stub := newTestDirectory(t, func(cfg *config.Directory) { cfg.CacheSize = 1 })
+	ctx := context.Background()
+
+	for _, login := range []struct{ email, password string }{
+		{"jonn@gmail.com", "jonn-secret"},
+		{"anna@gmail.com", "anna-secret"},
+		{"anna@gmail.com", "anna-secret"},
+		{"jonn@gmail.com", "jonn-secret"},
+	} {
+		if _, err := d.Authenticate(ctx, login.email, login.password); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// anna pushed jonn out of the cache
+	if n := stub.Searches(); n != 3 {
+		t.Fatalf("searches = %d, want 3", n)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d, stub := newTestDirectory(t)
+	ctx := context.Background()
+
+	if _, err := d.Authenticate(ctx, "jonn@gmail.com", "jonn-secret"); err != nil {
+		t.Fatal(err)
+	}
+	binds := stub.Binds()
+
+	user, err := d.Lookup(ctx, "jonn@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != "uid=jonn,ou=people,dc=example,dc=com" || !slices.Equal(user.Roles, []string{domain.RoleAdmin, "staff"}) {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if stub.Searches() != 1 || stub.Binds() != binds {
+		t.Fatal("cached entry looked up in the directory")
+	}
+
+	user, err = d.Lookup(ctx, "anna@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(user.Roles, []string{"staff"}) || stub.Searches() != 2 {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	if _, err := d.Lookup(ctx, "bob@gmail.com"); !errors.Is(err, directory.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestAuthenticateUnreachable(t *testing.T) {
+	d := directory.New(config.Directory{URL: "ldap://127.0.0.1:1"})
+
+	if _, err := d.Authenticate(context.Background(), "jonn@gmail.com", "jonn-secret"); !errors.Is(err, directory.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if _, err := d.Lookup(context.Background(), "jonn@gmail.com"); !errors.Is(err, directory.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
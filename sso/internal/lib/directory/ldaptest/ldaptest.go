@@ -0,0 +1,276 @@
+// Package ldaptest runs an in-process LDAP server for tests. It answers
+// simple binds and searches with equality, presence, and, or and not filters
+// over a fixed set of entries, other operations are refused.
+package ldaptest
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is an entry of the directory, Password is what it binds with.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is the stub server, URL is its "ldap://" address.
+type Server struct {
+	URL string
+
+	listener net.Listener
+	entries  []Entry
+
+	mu       sync.Mutex
+	searches int
+	binds    int
+}
+
+// New starts a server with the entries, it is stopped at the end of the
+// test. Searches need a bind first.
+func New(t testing.TB, entries ...Entry) *Server {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		URL:      "ldap://" + l.Addr().String(),
+		listener: l,
+		entries:  entries,
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go s.serve()
+
+	return s
+}
+
+// Searches returns the number of searches served so far.
+func (s *Server) Searches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.searches
+}
+
+// Binds returns the number of successful binds so far.
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.binds
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := s.bind(op)
+			bound = code == ldap.LDAPResultSuccess
+			err = write(conn, id, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				err = write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				break
+			}
+			err = s.search(conn, id, op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			err = write(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// bind checks a simple bind, the password is the context specific child 0.
+func (s *Server) bind(op *ber.Packet) uint16 {
+	if len(op.Children) < 3 {
+		return ldap.LDAPResultProtocolError
+	}
+
+	dn, _ := op.Children[1].Value.(string)
+	auth := op.Children[2]
+	if auth.ClassType != ber.ClassContext || auth.Tag != 0 {
+		return ldap.LDAPResultAuthMethodNotSupported
+	}
+	password := auth.Data.String()
+
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) && password != "" && e.Password == password {
+			s.mu.Lock()
+			s.binds++
+			s.mu.Unlock()
+			return ldap.LDAPResultSuccess
+		}
+	}
+
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (s *Server) search(w io.Writer, id int64, op *ber.Packet) error {
+	s.mu.Lock()
+	s.searches++
+	s.mu.Unlock()
+
+	if len(op.Children) < 8 {
+		return write(w, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError))
+	}
+
+	base, _ := op.Children[0].Value.(string)
+	filter := op.Children[6]
+
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		if name, ok := a.Value.(string); ok {
+			attrs = append(attrs, name)
+		}
+	}
+
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), strings.ToLower(base)) || !matches(e, filter) {
+			continue
+		}
+		if err := write(w, id, entry(e, attrs)); err != nil {
+			return err
+		}
+	}
+
+	return write(w, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func matches(e Entry, f *ber.Packet) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matches(e, c) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matches(e, c) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(f.Children) == 1 && !matches(e, f.Children[0])
+	case ldap.FilterEqualityMatch:
+		if len(f.Children) != 2 {
+			return false
+		}
+		name, _ := f.Children[0].Value.(string)
+		want, _ := f.Children[1].Value.(string)
+		for _, v := range attribute(e, name) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(attribute(e, f.Data.String())) > 0
+	}
+
+	return false
+}
+
+func attribute(e Entry, name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func entry(e Entry, attrs []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		if len(attrs) > 0 && !contains(attrs, name) {
+			continue
+		}
+
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		list.AppendChild(attr)
+	}
+	op.AppendChild(list)
+
+	return op
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return op
+}
+
+func write(w io.Writer, id int64, op *ber.Packet) error {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	envelope.AppendChild(op)
+
+	_, err := w.Write(envelope.Bytes())
+	return err
+}
+
+func contains(list []string, name string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+// Package geoip locates IP addresses with a local database file.
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is where an address is registered.
+type Location struct {
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+type ipRange struct {
+	first netip.Addr
+	last  netip.Addr
+	loc   Location
+}
+
+// DB holds the ranges of a database file. The file is CSV with one range per
+// line:
+//
+//	first_ip,last_ip,country,latitude,longitude
+//
+// Empty lines and lines starting with '#' are skipped. IPv4 and IPv6 ranges
+// may be mixed, ranges must not overlap.
+type DB struct {
+	ranges []ipRange
+}
+
+// Open reads the database file at path.
+func Open(path string) (*DB, error) {
+	const op = "geoip.Open"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	db, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return db, nil
+}
+
+// Parse reads a database in the format described on DB.
+func Parse(r io.Reader) (*DB, error) {
+	db := &DB{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rng, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		db.ranges = append(db.ranges, rng)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].first.Less(db.ranges[j].first)
+	})
+
+	return db, nil
+}
+
+func parseLine(line string) (ipRange, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 5 {
+		return ipRange{}, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	first, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return ipRange{}, err
+	}
+	last, err := netip.ParseAddr(fields[1])
+	if err != nil {
+		return ipRange{}, err
+	}
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return ipRange{}, fmt.Errorf("invalid range %s-%s", first, last)
+	}
+
+	lat, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return ipRange{}, err
+	}
+	lon, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	return ipRange{
+		first: first.Unmap(),
+		last:  last.Unmap(),
+		loc:   Location{Country: fields[2], Latitude: lat, Longitude: lon},
+	}, nil
+}
+
+// Lookup returns the location of ip, ok is false when no range holds it.
+func (db *DB) Lookup(ip netip.Addr) (loc Location, ok bool) {
+	ip = ip.Unmap()
+
+	// the last range that starts at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return ip.Less(db.ranges[i].first)
+	}) - 1
+	if i < 0 {
+		return Location{}, false
+	}
+
+	rng := db.ranges[i]
+	if rng.first.Is4() != ip.Is4() || rng.last.Less(ip) {
+		return Location{}, false
+	}
+
+	return rng.loc, true
+}
+
+const earthRadiusKm = 6371
+
+// Distance returns the great-circle distance between a and b in kilometres.
+func Distance(a, b Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
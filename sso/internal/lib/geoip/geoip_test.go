@@ -0,0 +1,69 @@
+package geoip
+
+import (
+	"math"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testDB = `
+# first_ip,last_ip,country,latitude,longitude
+10.0.0.0,10.0.255.255,DE,52.52,13.40
+192.168.1.0,192.168.1.255,US,40.71,-74.00
+2001:db8::,2001:db8::ffff,JP,35.68,139.69
+`
+
+func TestLookup(t *testing.T) {
+	db, err := Parse(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		ok      bool
+	}{
+		{ip: "10.0.3.4", country: "DE", ok: true},
+		{ip: "10.0.255.255", country: "DE", ok: true},
+		{ip: "::ffff:10.0.0.1", country: "DE", ok: true},
+		{ip: "10.1.0.0"},
+		{ip: "192.168.1.7", country: "US", ok: true},
+		{ip: "1.1.1.1"},
+		{ip: "2001:db8::42", country: "JP", ok: true},
+		{ip: "2001:db9::1"},
+	}
+
+	for _, tt := range tests {
+		loc, ok := db.Lookup(netip.MustParseAddr(tt.ip))
+		if ok != tt.ok || loc.Country != tt.country {
+			t.Errorf("Lookup(%s) = %v, %v, want %s, %v", tt.ip, loc, ok, tt.country, tt.ok)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, line := range []string{
+		"10.0.0.0,10.0.0.255,DE,52.52",
+		"10.0.0.255,10.0.0.0,DE,52.52,13.40",
+		"10.0.0.0,2001:db8::,DE,52.52,13.40",
+		"10.0.0.0,10.0.0.255,DE,north,13.40",
+	} {
+		if _, err := Parse(strings.NewReader(line)); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	berlin := Location{Latitude: 52.52, Longitude: 13.40}
+	newYork := Location{Latitude: 40.71, Longitude: -74.00}
+
+	if d := Distance(berlin, newYork); math.Abs(d-6385) > 20 {
+		t.Fatalf("Berlin - New York = %.0f km", d)
+	}
+	if d := Distance(berlin, berlin); d != 0 {
+		t.Fatalf("distance to itself = %f", d)
+	}
+}
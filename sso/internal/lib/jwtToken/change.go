@@ -0,0 +1,93 @@
+package jwtToken
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const purposePasswordChange = "password_change"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// ChangeClaims are the claims of a change token issued by GetChangeToken.
+type ChangeClaims struct {
+	UserID int64
+	// PasswordVersion identifies the password hash the token was issued
+	// for, see IssuedFor.
+	PasswordVersion string
+}
+
+// IssuedFor reports whether the token was issued for the current password
+// of user. A token stops working once the password changes, so it can be
+// used for one change only.
+func (c ChangeClaims) IssuedFor(user domain.User) bool {
+	return c.UserID == user.ID &&
+		subtle.ConstantTimeCompare([]byte(c.PasswordVersion), []byte(passwordVersion(user.PasswordHash))) == 1
+}
+
+// GetChangeToken issues a short lived token that only allows the user to
+// call ChangePassword, it is handed out instead of an access token when the
+// password has expired.
+func GetChangeToken(user domain.User, app domain.App, exp time.Duration) (string, error) {
+	if app.Secret == "" {
+		return "", errors.New("error secretKey")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid":     user.ID,
+		"app_id":  app.ID,
+		"purpose": purposePasswordChange,
+		"pwv":     passwordVersion(user.PasswordHash),
+		"exp":     time.Now().Add(exp).Unix(),
+	})
+
+	return token.SignedString([]byte(app.Secret))
+}
+
+// ParseChangeToken verifies a token issued by GetChangeToken for app. The
+// caller still has to check the claims with IssuedFor against the user.
+func ParseChangeToken(tokenString string, app domain.App) (ChangeClaims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return []byte(app.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return ChangeClaims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if purpose, _ := claims["purpose"].(string); purpose != purposePasswordChange {
+		return ChangeClaims{}, ErrInvalidToken
+	}
+
+	if appID, _ := claims["app_id"].(float64); int64(appID) != app.ID {
+		return ChangeClaims{}, ErrInvalidToken
+	}
+
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return ChangeClaims{}, ErrInvalidToken
+	}
+
+	version, ok := claims["pwv"].(string)
+	if !ok {
+		return ChangeClaims{}, ErrInvalidToken
+	}
+
+	return ChangeClaims{UserID: int64(uid), PasswordVersion: version}, nil
+}
+
+// passwordVersion is a digest of the password hash, it does not help to
+// guess the password any more than the hash itself.
+func passwordVersion(hash []byte) string {
+	sum := sha256.Sum256(hash)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
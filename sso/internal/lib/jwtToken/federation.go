@@ -0,0 +1,78 @@
+package jwtToken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const purposeFederation = "federation"
+
+// FederationState is the state of a login at an upstream provider. It makes
+// the round trip through the browser of the user as the state parameter.
+type FederationState struct {
+	Connector string
+	AppID     int64
+	Nonce     string
+	// UserID is the user that started the login to link the identity to,
+	// zero for plain logins.
+	UserID int64
+	// RedirectURI is the callback of the app a SAML login sends the user
+	// back to with a one-time code.
+	RedirectURI string
+}
+
+// GetFederationState signs state with the secret of app.
+func GetFederationState(state FederationState, app domain.App, exp time.Duration) (string, error) {
+	if app.Secret == "" {
+		return "", errors.New("error secretKey")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"connector":    state.Connector,
+		"app_id":       app.ID,
+		"nonce":        state.Nonce,
+		"uid":          state.UserID,
+		"redirect_uri": state.RedirectURI,
+		"purpose":      purposeFederation,
+		"exp":          time.Now().Add(exp).Unix(),
+	})
+
+	return token.SignedString([]byte(app.Secret))
+}
+
+// ParseFederationState verifies a state issued by GetFederationState for app.
+func ParseFederationState(tokenString string, app domain.App) (FederationState, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return []byte(app.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return FederationState{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if purpose, _ := claims["purpose"].(string); purpose != purposeFederation {
+		return FederationState{}, ErrInvalidToken
+	}
+
+	if appID, _ := claims["app_id"].(float64); int64(appID) != app.ID {
+		return FederationState{}, ErrInvalidToken
+	}
+
+	state := FederationState{AppID: app.ID}
+	state.Connector, _ = claims["connector"].(string)
+	state.Nonce, _ = claims["nonce"].(string)
+	state.RedirectURI, _ = claims["redirect_uri"].(string)
+	uid, _ := claims["uid"].(float64)
+	state.UserID = int64(uid)
+
+	if state.Connector == "" || state.Nonce == "" {
+		return FederationState{}, ErrInvalidToken
+	}
+
+	return state, nil
+}
@@ -9,14 +9,28 @@ import (
 )
 
 func GetToken(user domain.User, app domain.App, exp time.Duration) (string, error) {
+	return GetTokenWithClaims(user, app, exp, nil)
+}
+
+// GetTokenWithClaims adds extra claims to the token, they can not override
+// the claims set by GetToken.
+func GetTokenWithClaims(user domain.User, app domain.App, exp time.Duration, extra map[string]any) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
 
+	for k, v := range extra {
+		claims[k] = v
+	}
+
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
+	claims["iat"] = time.Now().Unix()
 	claims["exp"] = time.Now().Add(exp).Unix()
 	claims["app_id"] = app.ID
+	if app.TenantID != 0 {
+		claims["tid"] = app.TenantID
+	}
 
 	if app.Secret == "" {
 		return "", errors.New("error secretKey")
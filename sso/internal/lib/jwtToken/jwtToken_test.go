@@ -79,3 +79,172 @@ func ParseToken(tokenString string, secretKey string) (*jwt.Token, error) {
 		return []byte(secretKey), nil
 	})
 }
+
+func TestChangeToken(t *testing.T) {
+	user := domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: []byte("hash-1")}
+	app := domain.App{ID: 2, Secret: "tokenSecret"}
+
+	token, err := GetChangeToken(user, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseChangeToken(token, app)
+	if err != nil {
+		t.Fatalf("field parse change token: %v", err)
+	}
+	if claims.UserID != user.ID || !claims.IssuedFor(user) {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// the token is spent once the password changes
+	changed := user
+	changed.PasswordHash = []byte("hash-2")
+	if claims.IssuedFor(changed) {
+		t.Error("token accepted after the password changed")
+	}
+	if claims.IssuedFor(domain.User{ID: 8, PasswordHash: user.PasswordHash}) {
+		t.Error("token accepted for another user")
+	}
+
+	if _, err := ParseChangeToken(token, domain.App{ID: 3, Secret: "tokenSecret"}); err == nil {
+		t.Error("token accepted for another app")
+	}
+
+	accessToken, err := GetToken(user, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseChangeToken(accessToken, app); err == nil {
+		t.Error("access token accepted as change token")
+	}
+}
+
+func TestFederationState(t *testing.T) {
+	app := domain.App{ID: 2, Secret: "tokenSecret"}
+
+	token, err := GetFederationState(FederationState{Connector: "okta", Nonce: "n", UserID: 7}, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := ParseFederationState(token, app)
+	if err != nil {
+		t.Fatalf("field parse federation state: %v", err)
+	}
+	if state.Connector != "okta" || state.Nonce != "n" || state.UserID != 7 || state.AppID != app.ID {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	if _, err := ParseFederationState(token, domain.App{ID: 3, Secret: "tokenSecret"}); err == nil {
+		t.Error("state accepted for another app")
+	}
+	if _, err := ParseAccessToken(token, app); err == nil {
+		t.Error("state accepted as access token")
+	}
+
+	change, err := GetChangeToken(domain.User{ID: 7}, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFederationState(change, app); err == nil {
+		t.Error("change token accepted as state")
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	user := domain.User{ID: 7, Email: "jonn@gmail.com"}
+	app := domain.App{ID: 2, Secret: "tokenSecret"}
+
+	token, err := GetToken(user, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appID, err := AppID(token)
+	if err != nil || appID != app.ID {
+		t.Fatalf("expected app %d, got %d, %v", app.ID, appID, err)
+	}
+
+	claims, err := ParseAccessToken(token, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID || claims.Email != user.Email || claims.AppID != app.ID {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if _, err := ParseAccessToken(token, domain.App{ID: 2, Secret: "otherSecret"}); err == nil {
+		t.Error("token accepted with another secret")
+	}
+
+	changeToken, err := GetChangeToken(user, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseAccessToken(changeToken, app); err == nil {
+		t.Error("change token accepted as access token")
+	}
+}
+
+func TestTenantClaim(t *testing.T) {
+	user := domain.User{ID: 7, Email: "jonn@gmail.com"}
+	app := domain.App{ID: 2, Secret: "tokenSecret", TenantID: 3}
+
+	token, err := GetToken(user, app, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseAccessToken(token, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TenantID != 3 {
+		t.Errorf("tid = %d, want 3", claims.TenantID)
+	}
+
+	app.TenantID = 4
+	if _, err := ParseAccessToken(token, app); err == nil {
+		t.Error("token accepted in another tenant")
+	}
+}
+
+func TestProfileClaims(t *testing.T) {
+	user := domain.User{ID: 7, Email: "jonn@gmail.com"}
+	app := domain.App{ID: 2, Secret: "tokenSecret"}
+	profile := domain.Profile{
+		DisplayName: "Jonn",
+		Locale:      "en-GB",
+		Attributes:  map[string]any{"plan": "pro", "crm_id": "42"},
+	}
+
+	extra := ProfileClaims(profile, []string{domain.ProfileDisplayName, domain.ProfileTimezone, "plan", "missing", "uid"})
+
+	token, err := GetTokenWithClaims(user, app, time.Minute, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseToken(token, app.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+
+	if claims["name"] != "Jonn" {
+		t.Errorf("name = %v", claims["name"])
+	}
+	for _, c := range []string{"locale", "zoneinfo"} {
+		if _, ok := claims[c]; ok {
+			t.Errorf("unexpected claim %s", c)
+		}
+	}
+	attributes, _ := claims["attributes"].(map[string]any)
+	if len(attributes) != 1 || attributes["plan"] != "pro" {
+		t.Errorf("attributes = %v", claims["attributes"])
+	}
+	if claims["uid"] != float64(user.ID) {
+		t.Errorf("uid overridden: %v", claims["uid"])
+	}
+}
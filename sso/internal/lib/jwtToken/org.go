@@ -0,0 +1,25 @@
+package jwtToken
+
+import "github.com/goggle-source/grpc-servic/sso/internal/domain"
+
+// OrgClaims put the memberships of the user into the orgs claim of tokens for
+// org-scoped apps, one entry with the id, role and teams per organization.
+// The claim is an empty list for users without organizations. It is a
+// snapshot taken when the token is issued, a changed or removed membership
+// only shows once the token is refreshed.
+func OrgClaims(members []domain.Member) map[string]any {
+	orgs := make([]map[string]any, 0, len(members))
+	for _, m := range members {
+		teams := m.Teams
+		if teams == nil {
+			teams = []string{}
+		}
+		orgs = append(orgs, map[string]any{
+			"id":    m.OrgID,
+			"role":  m.Role,
+			"teams": teams,
+		})
+	}
+
+	return map[string]any{"orgs": orgs}
+}
@@ -0,0 +1,105 @@
+package jwtToken
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims of an access token issued by GetToken.
+type Claims struct {
+	UserID int64
+	Email  string
+	AppID  int64
+	// IssuedAt is zero for tokens issued before the iat claim was added.
+	IssuedAt time.Time
+	// SessionID is the sid claim, zero when the token has none.
+	SessionID int64
+	// ACR and AMR tell how the session was authenticated, see
+	// SessionClaims. ACR is zero for tokens without a session.
+	ACR int
+	AMR []string
+	// StepUpRequired is the step_up claim of tokens of risky logins.
+	StepUpRequired bool
+	// TenantID is the tid claim, zero for tokens issued before tenants.
+	TenantID int64
+}
+
+// AppID reads the app of a token without verifying it, the secret needed to
+// verify the token belongs to that app.
+func AppID(tokenString string) (int64, error) {
+	claims := jwt.MapClaims{}
+
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	appID, ok := claims["app_id"].(float64)
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+
+	return int64(appID), nil
+}
+
+// ParseAccessToken verifies an access token issued by GetToken for app. Tokens
+// issued for another purpose, such as change tokens, are rejected.
+func ParseAccessToken(tokenString string, app domain.App) (Claims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return []byte(app.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if _, ok := claims["purpose"]; ok {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if appID, _ := claims["app_id"].(float64); int64(appID) != app.ID {
+		return Claims{}, ErrInvalidToken
+	}
+
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+
+	email, _ := claims["email"].(string)
+
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = time.Unix(int64(iat), 0)
+	}
+
+	sid, _ := claims["sid"].(float64)
+
+	// a token is only valid in the tenant it was issued in
+	tid, hasTID := claims["tid"].(float64)
+	if hasTID && app.TenantID != 0 && int64(tid) != app.TenantID {
+		return Claims{}, ErrInvalidToken
+	}
+
+	result := Claims{UserID: int64(uid), Email: email, AppID: app.ID, IssuedAt: issuedAt, SessionID: int64(sid), TenantID: int64(tid)}
+
+	if acr, ok := claims["acr"].(string); ok {
+		result.ACR, _ = strconv.Atoi(acr)
+	}
+
+	result.StepUpRequired, _ = claims["step_up"].(bool)
+
+	if amr, ok := claims["amr"].([]any); ok {
+		for _, m := range amr {
+			if s, ok := m.(string); ok {
+				result.AMR = append(result.AMR, s)
+			}
+		}
+	}
+
+	return result, nil
+}
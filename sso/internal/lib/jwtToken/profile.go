@@ -0,0 +1,45 @@
+package jwtToken
+
+import "github.com/goggle-source/grpc-servic/sso/internal/domain"
+
+// profileClaims are the OIDC names of the profile fields.
+var profileClaims = map[string]string{
+	domain.ProfileDisplayName: "name",
+	domain.ProfileLocale:      "locale",
+	domain.ProfileTimezone:    "zoneinfo",
+	domain.ProfileAvatarURL:   "picture",
+}
+
+// ProfileClaims picks the claims an app asked for. Profile fields use their
+// OIDC claim names, custom attributes are nested under "attributes". Empty
+// fields and missing attributes are left out.
+func ProfileClaims(profile domain.Profile, names []string) map[string]any {
+	claims := map[string]any{}
+	attributes := map[string]any{}
+
+	fields := map[string]string{
+		domain.ProfileDisplayName: profile.DisplayName,
+		domain.ProfileLocale:      profile.Locale,
+		domain.ProfileTimezone:    profile.Timezone,
+		domain.ProfileAvatarURL:   profile.AvatarURL,
+	}
+
+	for _, name := range names {
+		if claim, ok := profileClaims[name]; ok {
+			if fields[name] != "" {
+				claims[claim] = fields[name]
+			}
+			continue
+		}
+
+		if v, ok := profile.Attributes[name]; ok && v != nil {
+			attributes[name] = v
+		}
+	}
+
+	if len(attributes) > 0 {
+		claims["attributes"] = attributes
+	}
+
+	return claims
+}
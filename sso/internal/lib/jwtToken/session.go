@@ -0,0 +1,34 @@
+package jwtToken
+
+import (
+	"strconv"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+// SessionClaims are the claims of an access token issued for session: sid,
+// the acr level as a string as OIDC has it and the amr methods. Tokens of a
+// session that has to be stepped up carry "step_up", authz only lets them
+// call the methods that step up.
+func SessionClaims(session domain.Session) map[string]any {
+	amr := session.AMR
+	if len(amr) == 0 {
+		amr = []string{domain.AMRPassword}
+	}
+
+	acr := session.ACR
+	if acr == 0 {
+		acr = domain.ACRPassword
+	}
+
+	claims := map[string]any{
+		"sid": session.ID,
+		"acr": strconv.Itoa(acr),
+		"amr": amr,
+	}
+	if session.StepUpRequired {
+		claims["step_up"] = true
+	}
+
+	return claims
+}
@@ -0,0 +1,54 @@
+// Package keyformat is the format of API keys: "sso_<prefix>_<secret>". The
+// prefix finds the stored key, only a hash of the whole key is stored.
+package keyformat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Prefix starts every key, so keys are easy to tell from access tokens and
+// to find by secret scanners.
+const Prefix = "sso_"
+
+// ErrInvalidAPIKey is returned for keys that are unknown, forged or expired.
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// New returns a random key, the prefix to find it by and the hash to check
+// it against.
+func New() (key string, prefix string, hash []byte, err error) {
+	b := make([]byte, 38)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+
+	prefix = hex.EncodeToString(b[:6])
+	key = Prefix + prefix + "_" + hex.EncodeToString(b[6:])
+
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hash stored for key.
+func Hash(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// KeyPrefix returns the prefix stored for key, ok is false if key does not
+// look like an API key.
+func KeyPrefix(key string) (prefix string, ok bool) {
+	rest, ok := strings.CutPrefix(key, Prefix)
+	if !ok {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+
+	return prefix, true
+}
@@ -0,0 +1,37 @@
+package keyformat_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+)
+
+func TestNew(t *testing.T) {
+	key, prefix, hash, err := keyformat.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, keyformat.Prefix) {
+		t.Fatalf("key %q does not start with %q", key, keyformat.Prefix)
+	}
+
+	got, ok := keyformat.KeyPrefix(key)
+	if !ok || got != prefix {
+		t.Fatalf("key %q does not carry prefix %q", key, prefix)
+	}
+
+	if !bytes.Equal(keyformat.Hash(key), hash) {
+		t.Fatal("hash does not match the key")
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	for _, key := range []string{"", "garbage", "sso_", "sso_abc", "sso__secret", "sso_abc_", "eyJhbGciOi.x.y"} {
+		if _, ok := keyformat.KeyPrefix(key); ok {
+			t.Errorf("%q accepted as a key", key)
+		}
+	}
+}
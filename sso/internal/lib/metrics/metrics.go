@@ -0,0 +1,11 @@
+package metrics
+
+import "expvar"
+
+// Counters are published through expvar and served on /debug/vars by the
+// metrics server.
+var (
+	LoginFailures = expvar.NewInt("sso_login_failures_total")
+	LoginLockouts = expvar.NewInt("sso_login_lockouts_total")
+	LoginBlocked  = expvar.NewInt("sso_login_blocked_total")
+)
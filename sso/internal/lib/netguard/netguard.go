@@ -0,0 +1,102 @@
+// Package netguard keeps requests to URLs that users register, such as
+// webhooks, off loopback, link-local and private networks. The host is
+// checked when the URL is saved and every connection is checked again when
+// it is dialed, so a name that later resolves elsewhere is still caught.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("address is not public")
+
+// reserved are ranges the netip predicates do not cover.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// Public reports whether ip is a public unicast address.
+func Public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, p := range reserved {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckHost resolves host and fails with ErrForbiddenAddress when any of its
+// addresses is not public.
+func CheckHost(ctx context.Context, host string) error {
+	const op = "netguard.CheckHost"
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !Public(ip) {
+			return fmt.Errorf("%s: %w", op, ErrForbiddenAddress)
+		}
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, ip := range ips {
+		if !Public(ip) {
+			return fmt.Errorf("%s: %w", op, ErrForbiddenAddress)
+		}
+	}
+
+	return nil
+}
+
+// control refuses connections to addresses that are not public, it runs
+// after the name was resolved.
+func control(_ string, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !Public(ap.Addr()) {
+		return fmt.Errorf("netguard: dial %s: %w", address, ErrForbiddenAddress)
+	}
+
+	return nil
+}
+
+// Client returns an http client that only connects to public addresses. It
+// ignores proxy settings, a proxy would be dialed in place of the target.
+func Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
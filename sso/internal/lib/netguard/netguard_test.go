@@ -0,0 +1,64 @@
+package netguard_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/netguard"
+)
+
+func TestPublic(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":              true,
+		"2001:4860:4860::8888": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"224.0.0.1":            false,
+	}
+
+	for addr, want := range tests {
+		if got := netguard.Public(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	ctx := context.Background()
+
+	for _, host := range []string{"127.0.0.1", "169.254.169.254", "localhost"} {
+		if err := netguard.CheckHost(ctx, host); !errors.Is(err, netguard.ErrForbiddenAddress) {
+			t.Errorf("CheckHost(%s) = %v, want ErrForbiddenAddress", host, err)
+		}
+	}
+
+	if err := netguard.CheckHost(ctx, "8.8.8.8"); err != nil {
+		t.Errorf("CheckHost(8.8.8.8) = %v", err)
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request reached a loopback server")
+	}))
+	defer srv.Close()
+
+	_, err := netguard.Client(time.Second).Get(srv.URL)
+	if !errors.Is(err, netguard.ErrForbiddenAddress) {
+		t.Fatalf("err = %v, want ErrForbiddenAddress", err)
+	}
+}
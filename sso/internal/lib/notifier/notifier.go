@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns the notifier selected in config, "log" when nothing is set.
+func New(log *slog.Logger, cfg config.Notifier) (Notifier, error) {
+	switch cfg.Kind {
+	case "", "log":
+		return &LogNotifier{log: log}, nil
+	case "smtp":
+		return &SMTPNotifier{cfg: cfg.SMTP}, nil
+	default:
+		return nil, fmt.Errorf("notifier.New: unknown kind %q", cfg.Kind)
+	}
+}
+
+// LogNotifier only writes messages to the log, it is meant for development.
+type LogNotifier struct {
+	log *slog.Logger
+}
+
+func (n *LogNotifier) Notify(_ context.Context, msg Message) error {
+	n.log.Info("notification",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
+
+type SMTPNotifier struct {
+	cfg config.SMTP
+}
+
+func (n *SMTPNotifier) Notify(_ context.Context, msg Message) error {
+	const op = "notifier.SMTP"
+
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(n.cfg.Port))
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	if err := smtp.SendMail(addr, auth, n.cfg.From, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
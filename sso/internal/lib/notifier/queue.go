@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var ErrQueueFull = errors.New("notification queue is full")
+
+const (
+	queueSize   = 1024
+	sendTimeout = 30 * time.Second
+)
+
+// Queue sends messages in the background so that the time sending takes does
+// not show in responses. Run has to be registered as a worker, messages still
+// queued when it stops are sent before it returns.
+type Queue struct {
+	log      *slog.Logger
+	next     Notifier
+	messages chan Message
+}
+
+// NewQueue returns a Queue that sends messages with next.
+func NewQueue(log *slog.Logger, next Notifier) *Queue {
+	return &Queue{
+		log:      log,
+		next:     next,
+		messages: make(chan Message, queueSize),
+	}
+}
+
+// Notify queues msg, a full queue drops it rather than holding the request
+// up.
+func (q *Queue) Notify(_ context.Context, msg Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run sends the queued messages until ctx is done. A message that is being
+// sent is not cut off by ctx, sendTimeout bounds it.
+func (q *Queue) Run(ctx context.Context) {
+	sendCtx := context.WithoutCancel(ctx)
+
+	for {
+		select {
+		case msg := <-q.messages:
+			q.send(sendCtx, msg)
+		case <-ctx.Done():
+			q.drain(sendCtx)
+			return
+		}
+	}
+}
+
+func (q *Queue) drain(ctx context.Context) {
+	for {
+		select {
+		case msg := <-q.messages:
+			q.send(ctx, msg)
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) send(ctx context.Context, msg Message) {
+	const op = "notifier.Queue"
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	if err := q.next.Notify(ctx, msg); err != nil {
+		q.log.Error("field to send notification", slog.String("op", op), slog.Any("err", err))
+	}
+}
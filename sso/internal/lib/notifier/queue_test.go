@@ -0,0 +1,54 @@
+package notifier_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+)
+
+type recorder struct {
+	sent []notifier.Message
+}
+
+func (r *recorder) Notify(ctx context.Context, msg notifier.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func TestQueueDrainsOnStop(t *testing.T) {
+	rec := &recorder{}
+	q := notifier.NewQueue(slog.New(slog.NewTextHandler(io.Discard, nil)), rec)
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := q.Notify(context.Background(), notifier.Message{To: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Run(ctx)
+
+	if len(rec.sent) != 2 || rec.sent[0].To != "a@example.com" || rec.sent[1].To != "b@example.com" {
+		t.Fatalf("unexpected messages sent %+v", rec.sent)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := notifier.NewQueue(slog.New(slog.NewTextHandler(io.Discard, nil)), &recorder{})
+
+	var err error
+	for i := 0; i < 2048 && err == nil; i++ {
+		err = q.Notify(context.Background(), notifier.Message{To: "a@example.com"})
+	}
+	if !errors.Is(err, notifier.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+// Package oidc is the relying party side of the OpenID Connect authorization
+// code flow: discovery, the code exchange and the verification of ID tokens
+// against the keys of the provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// Provider is an upstream provider as described by its discovery document.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+const (
+	// cacheTTL is how long discovery documents and signing keys are
+	// used before they are fetched again.
+	cacheTTL = time.Hour
+	// missInterval limits how often tokens signed with unknown keys make
+	// the keys be fetched again.
+	missInterval = time.Minute
+)
+
+type cachedProvider struct {
+	provider  Provider
+	fetchedAt time.Time
+}
+
+type keySet struct {
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// missedAt is when a refetch last did not find the key asked for
+	missedAt time.Time
+}
+
+// Client talks to upstream providers, discovery documents and signing keys
+// are cached per issuer for cacheTTL. A token signed with a key that is not
+// cached fetches the keys again, providers rotate them.
+type Client struct {
+	http *http.Client
+	now  func() time.Time
+
+	mu        sync.Mutex
+	providers map[string]cachedProvider
+	keys      map[string]keySet
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		http:      httpClient,
+		now:       time.Now,
+		providers: make(map[string]cachedProvider),
+		keys:      make(map[string]keySet),
+	}
+}
+
+// Provider returns the discovery document of issuer, it must name issuer as
+// its issuer.
+func (c *Client) Provider(ctx context.Context, issuer string) (Provider, error) {
+	const op = "oidc.Provider"
+
+	c.mu.Lock()
+	cached, ok := c.providers[issuer]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetchedAt) < cacheTTL {
+		return cached.provider, nil
+	}
+
+	var p Provider
+	if err := c.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &p); err != nil {
+		return Provider{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if p.Issuer != issuer {
+		return Provider{}, fmt.Errorf("%s: issuer %q does not match %q", op, p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return Provider{}, fmt.Errorf("%s: incomplete discovery document", op)
+	}
+
+	c.mu.Lock()
+	c.providers[issuer] = cachedProvider{provider: p, fetchedAt: c.now()}
+	c.mu.Unlock()
+
+	return p, nil
+}
+
+// AuthURL is where the user logs in at the provider, the provider redirects
+// back to redirectURL with a code and state. The code is bound to verifier
+// with PKCE, see Challenge, and can only be redeemed with it.
+func (p Provider) AuthURL(clientID string, redirectURL string, scopes []string, state string, nonce string, verifier string) string {
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Challenge returns the S256 PKCE challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Exchange redeems code with the verifier it was requested with at the
+// token endpoint and returns the ID token.
+func (c *Client) Exchange(
+	ctx context.Context,
+	p Provider,
+	clientID string,
+	clientSecret string,
+	redirectURL string,
+	code string,
+	verifier string,
+) (string, error) {
+	const op = "oidc.Exchange"
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: token endpoint answered %d %s", op, resp.StatusCode, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%s: no id_token in the response", op)
+	}
+
+	return body.IDToken, nil
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token. Unknown key ids fetch the keys of the provider again, at most once
+// per missInterval.
+func (c *Client) Verify(ctx context.Context, p Provider, rawIDToken string, clientID string, nonce string) (Claims, error) {
+	const op = "oidc.Verify"
+
+	var keyErr error
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := c.key(ctx, p, kid)
+		if err != nil {
+			keyErr = err
+		}
+		return key, err
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if keyErr != nil && !errors.Is(keyErr, ErrInvalidIDToken) {
+			return Claims{}, fmt.Errorf("%s: %w", op, keyErr)
+		}
+		return Claims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidIDToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return Claims{}, fmt.Errorf("%s: %w: nonce mismatch", op, ErrInvalidIDToken)
+	}
+
+	out := Claims{}
+	out.Subject, _ = claims["sub"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.Name, _ = claims["name"].(string)
+
+	// some providers send the flag as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		out.EmailVerified = v == "true"
+	}
+
+	if out.Subject == "" {
+		return Claims{}, fmt.Errorf("%s: %w: no subject", op, ErrInvalidIDToken)
+	}
+
+	return out, nil
+}
+
+// key returns the signing key kid of the provider.
+func (c *Client) key(ctx context.Context, p Provider, kid string) (*rsa.PublicKey, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	set, ok := c.keys[p.JWKSURI]
+	c.mu.Unlock()
+
+	fresh := ok && now.Sub(set.fetchedAt) < cacheTTL
+	if fresh {
+		if key, ok := lookup(set.keys, kid); ok {
+			return key, nil
+		}
+		// tokens with made up key ids must not make every call fetch
+		if now.Sub(set.missedAt) < missInterval {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+		}
+	}
+
+	keys, err := c.fetchKeys(ctx, p.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	set = keySet{keys: keys, fetchedAt: now}
+	key, ok := lookup(keys, kid)
+	if !ok {
+		set.missedAt = now
+	}
+
+	c.mu.Lock()
+	c.keys[p.JWKSURI] = set
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	return key, nil
+}
+
+// lookup returns the key kid of keys. A set with a single key may leave kid
+// out of the tokens.
+func lookup(keys map[string]*rsa.PublicKey, kid string) (*rsa.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *Client) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
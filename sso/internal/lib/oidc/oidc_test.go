@@ -0,0 +1,185 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/oidc"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	redirectURL = "https://app.example.com/callback"
+	verifier    = "verifier-0123456789-0123456789-0123456789-0123"
+)
+
+func TestCodeFlow(t *testing.T) {
+	stub := oidctest.New(t, "client", "secret")
+	c := oidc.NewClient(nil)
+	ctx := context.Background()
+
+	p, err := c.Provider(ctx, stub.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state, err := stub.Login(p.AuthURL("client", redirectURL, nil, "state-1", "nonce-1", verifier),
+		oidctest.Identity{Subject: "u-1", Email: "jonn@gmail.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q", state)
+	}
+
+	if _, err := c.Exchange(ctx, p, "client", "wrong", redirectURL, code, verifier); err == nil {
+		t.Fatal("exchange with a wrong secret succeeded")
+	}
+
+	idToken, err := c.Exchange(ctx, p, "client", "secret", redirectURL, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Verify(ctx, p, idToken, "client", "other nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+
+	claims, err := c.Verify(ctx, p, idToken, "client", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "u-1" || claims.Email != "jonn@gmail.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := c.Exchange(ctx, p, "client", "secret", redirectURL, code, verifier); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+}
+
+func TestExchangeChecksVerifier(t *testing.T) {
+	stub := oidctest.New(t, "client", "secret")
+	c := oidc.NewClient(nil)
+	ctx := context.Background()
+
+	p, err := c.Provider(ctx, stub.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, err := stub.Login(p.AuthURL("client", redirectURL, nil, "state-1", "nonce-1", verifier),
+		oidctest.Identity{Subject: "u-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Exchange(ctx, p, "client", "secret", redirectURL, code, verifier+"x"); err == nil {
+		t.Fatal("exchange with a wrong verifier succeeded")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	stub := oidctest.New(t, "client", "secret")
+	c := oidc.NewClient(nil)
+	ctx := context.Background()
+
+	p, err := c.Provider(ctx, stub.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   stub.Issuer,
+			"aud":   "client",
+			"sub":   "u-1",
+			"nonce": "n",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{name: "other issuer", change: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "other audience", change: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{name: "expired", change: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no subject", change: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "no nonce", change: func(c jwt.MapClaims) { delete(c, "nonce") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+
+			token, err := stub.IDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Verify(ctx, p, token, "client", "n"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+
+	// a token signed by someone else
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+	token, err := forged.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Verify(ctx, p, token, "client", "n"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	stub := oidctest.New(t, "client", "secret")
+	c := oidc.NewClient(nil)
+	ctx := context.Background()
+
+	p, err := c.Provider(ctx, stub.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := func() string {
+		t.Helper()
+		token, err := stub.IDToken(jwt.MapClaims{
+			"iss":   stub.Issuer,
+			"aud":   "client",
+			"sub":   "u-1",
+			"nonce": "n",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Verify(ctx, p, token(), "client", "n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stub.KeyFetches(); n != 1 {
+		t.Fatalf("keys fetched %d times, want 1", n)
+	}
+
+	// a token with a key id that is not cached refetches the keys
+	stub.Rotate(t)
+	if _, err := c.Verify(ctx, p, token(), "client", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.KeyFetches(); n != 2 {
+		t.Fatalf("keys fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,235 @@
+// Package oidctest runs a stub OpenID Connect provider for tests. It serves
+// discovery, signing keys and a token endpoint. Login does what a user would
+// do at the login page of the provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity is the user that logs in at the provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type grant struct {
+	identity    Identity
+	nonce       string
+	redirectURL string
+	challenge   string
+}
+
+// Provider is the stub provider, Issuer is the URL of its server.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	server *httptest.Server
+
+	mu      sync.Mutex
+	key     *rsa.PrivateKey
+	keyID   string
+	grants  map[string]grant
+	fetches int
+}
+
+// New starts a provider for the client, it is stopped at the end of the
+// test.
+func New(t testing.TB, clientID string, clientSecret string) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		keyID:        "key-1",
+		grants:       make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /keys", p.keys)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	p.Issuer = p.server.URL
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// Rotate replaces the signing key by a new one with another key id.
+func (p *Provider) Rotate(t testing.TB) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.keyID = "key-" + hex.EncodeToString(key.N.Bytes()[:4])
+}
+
+// KeyFetches returns how often the signing keys were requested.
+func (p *Provider) KeyFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fetches
+}
+
+// Login logs id in with the authorization URL the client redirected to and
+// returns the code and state the provider redirects back with.
+func (p *Provider) Login(authURL string, id Identity) (code string, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" {
+		return "", "", errors.New("oidctest: unexpected authorization request")
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		return "", "", errors.New("oidctest: no S256 code challenge")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code = hex.EncodeToString(b)
+
+	p.mu.Lock()
+	p.grants[code] = grant{identity: id, nonce: q.Get("nonce"), redirectURL: q.Get("redirect_uri"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return code, q.Get("state"), nil
+}
+
+// IDToken signs an ID token for the client, tests use it to forge tokens
+// with claims of their choice.
+func (p *Provider) IDToken(claims jwt.MapClaims) (string, error) {
+	p.mu.Lock()
+	key, keyID := p.key, p.keyID
+	p.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	return token.SignedString(key)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer,
+		"authorization_endpoint": p.Issuer + "/authorize",
+		"token_endpoint":         p.Issuer + "/token",
+		"jwks_uri":               p.Issuer + "/keys",
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	pub, keyID := p.key.PublicKey, p.keyID
+	p.fetches++
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.FormValue("code")
+
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if r.FormValue("grant_type") != "authorization_code" || !ok || g.redirectURL != r.FormValue("redirect_uri") ||
+		!validVerifier(r.FormValue("code_verifier"), g.challenge) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := p.IDToken(jwt.MapClaims{
+		"iss":            p.Issuer,
+		"aud":            p.ClientID,
+		"sub":            g.identity.Subject,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"nonce":          g.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "stub",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// validVerifier checks verifier against the S256 challenge.
+func validVerifier(verifier string, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier != "" && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
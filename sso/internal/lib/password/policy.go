@@ -0,0 +1,287 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+// bcrypt ignores everything after the first 72 bytes, so a longer password
+// is never accepted whatever the configured maximum is.
+const maxBcryptBytes = 72
+
+const (
+	RuleMinLength     = "PASSWORD_TOO_SHORT"
+	RuleMaxLength     = "PASSWORD_TOO_LONG"
+	RuleUpper         = "PASSWORD_NO_UPPER"
+	RuleLower         = "PASSWORD_NO_LOWER"
+	RuleDigit         = "PASSWORD_NO_DIGIT"
+	RuleSpecial       = "PASSWORD_NO_SPECIAL"
+	RuleEmail         = "PASSWORD_CONTAINS_EMAIL"
+	RuleBanned        = "PASSWORD_BANNED_SUBSTRING"
+	RuleCommon        = "PASSWORD_COMMON"
+	defaultMinLength  = 10
+	minEmailLocalPart = 3
+)
+
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// ViolationError is returned by Engine.Validate when the password breaks
+// one or more rules of the policy.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+
+	return strings.Join(descriptions, "; ")
+}
+
+type Policy struct {
+	minLength         int
+	maxLength         int
+	requireUpper      bool
+	requireLower      bool
+	requireDigit      bool
+	requireSpecial    bool
+	banEmailLocalPart bool
+	bannedSubstrings  []string
+	blocklist         map[string]struct{}
+}
+
+// Engine holds the deployment policy and the policies of apps built on it.
+type Engine struct {
+	def  Policy
+	apps map[int64]Policy
+}
+
+// NewEngine builds the policies from config and loads the blocklists from disk
+func NewEngine(cfg config.PasswordPolicy) (*Engine, error) {
+	const op = "password.NewEngine"
+
+	def, err := newPolicy(cfg.PasswordRules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	apps := make(map[int64]Policy, len(cfg.Apps))
+	for _, app := range cfg.Apps {
+		p, err := def.overlay(app)
+		if err != nil {
+			return nil, fmt.Errorf("%s: app %d: %w", op, app.AppID, err)
+		}
+		apps[app.AppID] = p
+	}
+
+	return &Engine{def: def, apps: apps}, nil
+}
+
+// Validate checks password against the policy of appID, falling back to the
+// deployment policy when the app has none. appID may be zero.
+func (e *Engine) Validate(appID int64, email string, password string) error {
+	p, ok := e.apps[appID]
+	if !ok {
+		p = e.def
+	}
+
+	if violations := p.check(email, password); len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+
+	return nil
+}
+
+func newPolicy(rules config.PasswordRules) (Policy, error) {
+	p := Policy{
+		minLength:         rules.MinLength,
+		maxLength:         rules.MaxLength,
+		requireUpper:      rules.RequireUpper,
+		requireLower:      rules.RequireLower,
+		requireDigit:      rules.RequireDigit,
+		requireSpecial:    rules.RequireSpecial,
+		banEmailLocalPart: rules.BanEmailLocalPart,
+	}
+
+	if p.minLength <= 0 {
+		p.minLength = defaultMinLength
+	}
+
+	if p.maxLength <= 0 || p.maxLength > maxBcryptBytes {
+		p.maxLength = maxBcryptBytes
+	}
+
+	p.bannedSubstrings = bannedSubstrings(rules.BannedSubstrings)
+
+	if rules.BlocklistPath != "" {
+		blocklist, err := loadBlocklist(rules.BlocklistPath)
+		if err != nil {
+			return Policy{}, err
+		}
+		p.blocklist = blocklist
+	}
+
+	return p, nil
+}
+
+// overlay returns p changed by the rules app sets. The banned substrings and
+// the blocklist of app are added to those of p.
+func (p Policy) overlay(app config.AppPasswordPolicy) (Policy, error) {
+	if app.MinLength != nil && *app.MinLength > 0 {
+		p.minLength = *app.MinLength
+	}
+
+	if app.MaxLength != nil && *app.MaxLength > 0 {
+		p.maxLength = min(*app.MaxLength, maxBcryptBytes)
+	}
+
+	for _, rule := range []struct {
+		set   *bool
+		field *bool
+	}{
+		{app.RequireUpper, &p.requireUpper},
+		{app.RequireLower, &p.requireLower},
+		{app.RequireDigit, &p.requireDigit},
+		{app.RequireSpecial, &p.requireSpecial},
+		{app.BanEmailLocalPart, &p.banEmailLocalPart},
+	} {
+		if rule.set != nil {
+			*rule.field = *rule.set
+		}
+	}
+
+	// clipped so that apps never append into the deployment's array
+	p.bannedSubstrings = append(slices.Clip(p.bannedSubstrings), bannedSubstrings(app.BannedSubstrings)...)
+
+	if app.BlocklistPath != "" {
+		blocklist, err := loadBlocklist(app.BlocklistPath)
+		if err != nil {
+			return Policy{}, err
+		}
+		maps.Copy(blocklist, p.blocklist)
+		p.blocklist = blocklist
+	}
+
+	return p, nil
+}
+
+func bannedSubstrings(substrings []string) []string {
+	var banned []string
+	for _, s := range substrings {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			banned = append(banned, s)
+		}
+	}
+
+	return banned
+}
+
+func (p Policy) check(email string, password string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMinLength,
+			Description: fmt.Sprintf("password must be at least %d characters", p.minLength),
+		})
+	}
+
+	if length > p.maxLength || len(password) > maxBcryptBytes {
+		violations = append(violations, Violation{
+			Rule:        RuleMaxLength,
+			Description: fmt.Sprintf("password must be at most %d characters", p.maxLength),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSpecial = true
+		}
+	}
+
+	if p.requireUpper && !hasUpper {
+		violations = append(violations, Violation{Rule: RuleUpper, Description: "password must contain an uppercase letter"})
+	}
+	if p.requireLower && !hasLower {
+		violations = append(violations, Violation{Rule: RuleLower, Description: "password must contain a lowercase letter"})
+	}
+	if p.requireDigit && !hasDigit {
+		violations = append(violations, Violation{Rule: RuleDigit, Description: "password must contain a digit"})
+	}
+	if p.requireSpecial && !hasSpecial {
+		violations = append(violations, Violation{Rule: RuleSpecial, Description: "password must contain a special character"})
+	}
+
+	lower := strings.ToLower(password)
+
+	if p.banEmailLocalPart {
+		local, _, _ := strings.Cut(strings.ToLower(email), "@")
+		if len(local) >= minEmailLocalPart && strings.Contains(lower, local) {
+			violations = append(violations, Violation{Rule: RuleEmail, Description: "password must not contain the email address"})
+		}
+	}
+
+	for _, s := range p.bannedSubstrings {
+		if strings.Contains(lower, s) {
+			violations = append(violations, Violation{
+				Rule:        RuleBanned,
+				Description: fmt.Sprintf("password must not contain %q", s),
+			})
+		}
+	}
+
+	if _, ok := p.blocklist[lower]; ok {
+		violations = append(violations, Violation{Rule: RuleCommon, Description: "password is too common"})
+	}
+
+	return violations
+}
+
+// loadBlocklist reads one password per line, blank lines and lines starting
+// with # are skipped.
+func loadBlocklist(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	blocklist := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read blocklist: %w", err)
+	}
+
+	return blocklist, nil
+}
@@ -0,0 +1,174 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+func TestEngineValidate(t *testing.T) {
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("# common\nQwerty12345!\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := config.PasswordRules{
+		MinLength:         10,
+		MaxLength:         20,
+		RequireUpper:      true,
+		RequireLower:      true,
+		RequireDigit:      true,
+		RequireSpecial:    true,
+		BanEmailLocalPart: true,
+		BannedSubstrings:  []string{"acme"},
+		BlocklistPath:     blocklist,
+	}
+
+	appBlocklist := filepath.Join(dir, "app_blocklist.txt")
+	if err := os.WriteFile(appBlocklist, []byte("Letmein-2024\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	minLength, off := 4, false
+	engine, err := NewEngine(config.PasswordPolicy{
+		PasswordRules: rules,
+		Apps: []config.AppPasswordPolicy{
+			{AppID: 2, MinLength: &minLength, RequireUpper: &off, RequireDigit: &off, RequireSpecial: &off},
+			{AppID: 3, BannedSubstrings: []string{"widget"}, BlocklistPath: appBlocklist},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name     string
+		appID    int64
+		email    string
+		password string
+		rules    []string
+	}
+
+	tests := []test{
+		{
+			name:     "success",
+			email:    "jonn@gmail.com",
+			password: "Str0ng-Passw0rd",
+		},
+		{
+			name:     "too short",
+			email:    "jonn@gmail.com",
+			password: "Sh0rt!",
+			rules:    []string{RuleMinLength},
+		},
+		{
+			name:     "too long",
+			email:    "jonn@gmail.com",
+			password: "Very-L0ng-Password-Indeed",
+			rules:    []string{RuleMaxLength},
+		},
+		{
+			name:     "missing classes",
+			email:    "jonn@gmail.com",
+			password: "onlylowercase",
+			rules:    []string{RuleUpper, RuleDigit, RuleSpecial},
+		},
+		{
+			name:     "email local part",
+			email:    "jonnsmith@gmail.com",
+			password: "JonnSmith-2024",
+			rules:    []string{RuleEmail},
+		},
+		{
+			name:     "banned substring",
+			email:    "jonn@gmail.com",
+			password: "Welcome-ACME-1",
+			rules:    []string{RuleBanned},
+		},
+		{
+			name:     "blocklist",
+			email:    "jonn@gmail.com",
+			password: "qwerty12345!",
+			rules:    []string{RuleUpper, RuleCommon},
+		},
+		{
+			name:     "app policy",
+			appID:    2,
+			email:    "jonn@gmail.com",
+			password: "abcd",
+		},
+		{
+			name:     "app policy keeps unset rules",
+			appID:    2,
+			email:    "jonn@gmail.com",
+			password: "ABCD",
+			rules:    []string{RuleLower},
+		},
+		{
+			name:     "app policy keeps deployment bans",
+			appID:    2,
+			email:    "jonn@gmail.com",
+			password: "qwerty12345!",
+			rules:    []string{RuleCommon},
+		},
+		{
+			name:     "app policy keeps deployment banned substrings",
+			appID:    2,
+			email:    "jonn@gmail.com",
+			password: "acme",
+			rules:    []string{RuleBanned},
+		},
+		{
+			name:     "app bans add to deployment ones",
+			appID:    3,
+			email:    "jonn@gmail.com",
+			password: "Acme-Widget-1",
+			rules:    []string{RuleBanned, RuleBanned},
+		},
+		{
+			name:     "app blocklist adds to deployment one",
+			appID:    3,
+			email:    "jonn@gmail.com",
+			password: "Letmein-2024",
+			rules:    []string{RuleCommon},
+		},
+		{
+			name:     "app blocklist keeps deployment one",
+			appID:    3,
+			email:    "jonn@gmail.com",
+			password: "Qwerty12345!",
+			rules:    []string{RuleCommon},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := engine.Validate(test.appID, test.email, test.password)
+			if len(test.rules) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var verr *ViolationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected ViolationError, got %v", err)
+			}
+
+			if len(verr.Violations) != len(test.rules) {
+				t.Fatalf("expected %v, got %+v", test.rules, verr.Violations)
+			}
+
+			for i, rule := range test.rules {
+				if verr.Violations[i].Rule != rule {
+					t.Errorf("violation %d: expected %s, got %s", i, rule, verr.Violations[i].Rule)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package principal
+
+import "context"
+
+// Principal is the authenticated caller of the current request.
+type Principal struct {
+	UserID int64
+	Email  string
+	AppID  int64
+	// SessionID is zero for tokens issued before sessions were recorded.
+	SessionID int64
+	// APIKeyID is set for calls authenticated by an API key, the call may
+	// only use methods of Scopes.
+	APIKeyID int64
+	Scopes   []string
+	// Admin is set when the call passed the admin check of an admin method.
+	// Services behind such methods check it too, so that a method missing
+	// from the list of admin methods fails closed.
+	Admin bool
+	// StepUpRequired is set for tokens of risky logins, such calls may only
+	// step the session up.
+	StepUpRequired bool
+}
+
+type ctxKey struct{}
+
+func With(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the caller stored by the authz interceptor, ok is
+// false for anonymous calls.
+func FromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,161 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+// Message is one event on the wire. Subject is the event type, brokers like
+// NATS or Kafka use it as the subject or topic. ID stays the same on every
+// redelivery so that consumers can drop duplicates.
+type Message struct {
+	ID      string          `json:"id"`
+	Subject string          `json:"subject"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Publisher delivers messages to a broker. Publish returns only once the
+// broker has accepted the message.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// New returns the publisher selected in config, "log" when nothing is set.
+func New(log *slog.Logger, cfg config.Publisher) (Publisher, error) {
+	switch cfg.Kind {
+	case "", "log":
+		return &LogPublisher{log: log}, nil
+	case "file":
+		return NewFileSink(cfg.Path)
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("publisher.New: webhook url is required")
+		}
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		return &Webhook{url: cfg.URL, client: &http.Client{Timeout: timeout}}, nil
+	default:
+		return nil, fmt.Errorf("publisher.New: unknown kind %q", cfg.Kind)
+	}
+}
+
+// LogPublisher only writes messages to the log, it is meant for development.
+type LogPublisher struct {
+	log *slog.Logger
+}
+
+func (p *LogPublisher) Publish(_ context.Context, msg Message) error {
+	p.log.Info("event published",
+		slog.String("id", msg.ID),
+		slog.String("subject", msg.Subject),
+		slog.String("data", string(msg.Data)),
+	)
+
+	return nil
+}
+
+// FileSink appends messages to a file as JSON lines, it is meant for tests
+// and local development.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	const op = "publisher.NewFileSink"
+
+	if path == "" {
+		return nil, fmt.Errorf("%s: path is required", op)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &FileSink{file: f}, nil
+}
+
+func (s *FileSink) Publish(_ context.Context, msg Message) error {
+	const op = "publisher.FileSink"
+
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// Webhook POSTs the data of every message to a URL. Any 2xx answer counts
+// as delivered.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *Webhook) Publish(ctx context.Context, msg Message) error {
+	const op = "publisher.Webhook"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(msg.Data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", msg.ID)
+	req.Header.Set("X-Event-Type", msg.Subject)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+	}
+
+	return nil
+}
+
+// Fanout publishes every message to all of its publishers. A failure of one
+// fails the message, which the outbox then delivers again to all of them.
+type Fanout []Publisher
+
+func (f Fanout) Publish(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, p := range f {
+		if err := p.Publish(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
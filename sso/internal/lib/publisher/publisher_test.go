@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+)
+
+func TestWebhook(t *testing.T) {
+	status := http.StatusOK
+
+	var gotID, gotType, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotID, gotType, gotBody = r.Header.Get("X-Event-Id"), r.Header.Get("X-Event-Type"), string(body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), config.Publisher{Kind: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := Message{ID: "1", Subject: "UserRegistered", Data: []byte(`{"user_id":7}`)}
+
+	if err := p.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if gotID != "1" || gotType != "UserRegistered" || gotBody != `{"user_id":7}` {
+		t.Errorf("unexpected request: id %q, type %q, body %q", gotID, gotType, gotBody)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := p.Publish(context.Background(), msg); err == nil {
+		t.Error("expected an error for a 503 answer")
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit is a token bucket that refills Rate tokens per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Bucket is the persisted state of one key.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take refills b up to now and takes one token from it. When there is no
+// token left it reports how long the caller has to wait for the next one.
+func (l Limit) Take(b Bucket, now time.Time) (Bucket, bool, time.Duration) {
+	if b.Updated.IsZero() {
+		b = Bucket{Tokens: float64(l.Burst), Updated: now}
+	}
+
+	elapsed := now.Sub(b.Updated).Seconds()
+	if elapsed > 0 {
+		b.Tokens = math.Min(float64(l.Burst), b.Tokens+elapsed*l.Rate)
+		b.Updated = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return b, true, 0
+	}
+
+	if l.Rate <= 0 {
+		return b, false, time.Duration(math.MaxInt64)
+	}
+
+	wait := time.Duration((1 - b.Tokens) / l.Rate * float64(time.Second))
+
+	return b, false, wait
+}
+
+// refill is how long an empty bucket takes to fill up.
+func (l Limit) refill() time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = 10 * time.Minute
+
+// MemoryStore keeps the buckets of a single instance in memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// memoryBucket remembers how long the limit of the bucket takes to refill,
+// the bucket is full and can be dropped once it was idle for that long.
+type memoryBucket struct {
+	Bucket
+	refill time.Duration
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]memoryBucket),
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryStore) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok, wait := limit.Take(m.buckets[key].Bucket, now)
+	m.buckets[key] = memoryBucket{Bucket: b, refill: limit.refill()}
+
+	return ok, wait, nil
+}
+
+// sweep drops the buckets that were idle for as long as their limit takes to
+// refill, a fresh bucket is full anyway so nothing is lost.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if now.Sub(b.Updated) > b.refill {
+			delete(m.buckets, key)
+		}
+	}
+}
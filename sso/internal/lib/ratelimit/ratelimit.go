@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/http/httperr"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"google.golang.org/grpc"
+)
+
+// Store keeps the buckets. The in-memory store is enough for one instance,
+// several instances have to share a store such as PostgreSQL or Redis.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// Pruner is implemented by stores that keep buckets until they are told
+// to drop them.
+type Pruner interface {
+	// Prune drops the buckets not touched for idle.
+	Prune(ctx context.Context, idle time.Duration) (int64, error)
+}
+
+type appIDGetter interface {
+	GetAppId() int32
+}
+
+// Limiter picks the limit of a method and builds the bucket key of a call.
+type Limiter struct {
+	log      *slog.Logger
+	store    Store
+	def      Limit
+	methods  map[string]Limit
+	keyByApp bool
+}
+
+func New(log *slog.Logger, store Store, cfg config.RateLimit) *Limiter {
+	methods := make(map[string]Limit, len(cfg.Methods))
+	for _, m := range cfg.Methods {
+		methods[m.Method] = limit(m.RateLimitRule)
+	}
+
+	return &Limiter{
+		log:      log,
+		store:    store,
+		def:      limit(cfg.Default),
+		methods:  methods,
+		keyByApp: cfg.KeyByApp,
+	}
+}
+
+func limit(rule config.RateLimitRule) Limit {
+	if rule.Requests <= 0 || rule.Per <= 0 {
+		return Limit{}
+	}
+
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = rule.Requests
+	}
+
+	return Limit{Rate: float64(rule.Requests) / rule.Per.Seconds(), Burst: burst}
+}
+
+// UnaryServerInterceptor rejects calls over the limit with ResourceExhausted
+// and a RetryInfo detail. It has to run after the clientinfo interceptor.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := l.allow(ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor takes a token when a stream is opened, the
+// messages of an open stream are not limited. The request is not read yet,
+// so streams are not keyed by app.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.allow(ss.Context(), info.FullMethod, nil); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// Middleware limits the requests to the routes of mux the way the
+// interceptors limit calls. The pattern of a route, e.g.
+// "POST /saml/{connector}/acs", is its method name in the config. It has to
+// run after the clientinfo middleware.
+func (l *Limiter) Middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// requests no route matches are answered by the mux without work
+		if _, pattern := mux.Handler(r); pattern != "" {
+			if err := l.allow(r.Context(), pattern, nil); err != nil {
+				httperr.Write(w, err)
+				return
+			}
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) allow(ctx context.Context, method string, req any) error {
+	const op = "ratelimit.Interceptor"
+
+	lim := l.limit(method)
+	if lim.Burst == 0 {
+		return nil
+	}
+
+	allowed, retryAfter, err := l.store.Take(ctx, l.key(ctx, method, req), lim)
+	if err != nil {
+		// fail open, an unavailable store must not take the service down
+		l.log.Error("field to take token", slog.String("op", op), slog.Any("err", err))
+		return nil
+	}
+
+	if !allowed {
+		return grpcerr.Exhausted(grpcerr.ReasonRateLimited,
+			fmt.Sprintf("rate limit exceeded, retry in %s", retryAfter.Round(time.Second)), retryAfter)
+	}
+
+	return nil
+}
+
+func (l *Limiter) limit(method string) Limit {
+	if lim, ok := l.methods[method]; ok {
+		return lim
+	}
+
+	return l.def
+}
+
+func (l *Limiter) key(ctx context.Context, method string, req any) string {
+	parts := []string{method, clientinfo.FromContext(ctx).IP}
+
+	if l.keyByApp {
+		if r, ok := req.(appIDGetter); ok {
+			parts = append(parts, "app:"+strconv.Itoa(int(r.GetAppId())))
+		}
+	}
+
+	return strings.Join(parts, "|")
+}
+
+const pruneInterval = 10 * time.Minute
+
+// RunPrune drops idle buckets from a store that is a Pruner until ctx is
+// done. A bucket that was not touched for as long as the slowest limit
+// takes to refill is full and the same as no bucket at all.
+func (l *Limiter) RunPrune(ctx context.Context) {
+	const op = "ratelimit.RunPrune"
+
+	pruner, ok := l.store.(Pruner)
+	if !ok {
+		return
+	}
+
+	log := l.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := pruner.Prune(ctx, l.refill())
+		if err != nil {
+			log.Error("field to prune rate limit buckets", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("rate limit buckets pruned", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refill is the longest time a limit takes to fill an empty bucket.
+func (l *Limiter) refill() time.Duration {
+	longest := l.def.refill()
+	for _, lim := range l.methods {
+		longest = max(longest, lim.refill())
+	}
+
+	return longest
+}
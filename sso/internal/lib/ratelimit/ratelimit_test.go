@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimitTake(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	b, ok, _ := limit.Take(Bucket{}, now)
+	if !ok {
+		t.Fatal("first call rejected")
+	}
+	b, ok, _ = limit.Take(b, now)
+	if !ok {
+		t.Fatal("burst call rejected")
+	}
+
+	b, ok, wait := limit.Take(b, now)
+	if ok {
+		t.Fatal("call over the burst accepted")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s, got %s", wait)
+	}
+
+	if _, ok, _ = limit.Take(b, now.Add(time.Second)); !ok {
+		t.Error("call rejected after refill")
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	limiter := New(slog.New(slog.NewTextHandler(io.Discard, nil)), NewMemoryStore(), config.RateLimit{
+		KeyByApp: true,
+		Methods: []config.MethodRateLimit{
+			{Method: "/auth.auth/Login", RateLimitRule: config.RateLimitRule{Requests: 1, Per: time.Minute}},
+		},
+	})
+	interceptor := limiter.UnaryServerInterceptor()
+
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	call := func(method string, ip string, appID int32) error {
+		ctx := clientinfo.With(context.Background(), clientinfo.Info{IP: ip})
+		_, err := interceptor(ctx, &ssov1.LoginRequest{AppId: appID}, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call("/auth.auth/Login", "10.0.0.1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	err := call("/auth.auth/Login", "10.0.0.1", 1)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Errorf("expected retry info, got %v", st.Details())
+	}
+
+	if err := call("/auth.auth/Login", "10.0.0.2", 1); err != nil {
+		t.Errorf("other address limited: %v", err)
+	}
+	if err := call("/auth.auth/Login", "10.0.0.1", 2); err != nil {
+		t.Errorf("other app limited: %v", err)
+	}
+	if err := call("/auth.auth/IsAdmin", "10.0.0.1", 1); err != nil {
+		t.Errorf("unlimited method limited: %v", err)
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s fakeStream) Context() context.Context { return s.ctx }
+
+func TestStreamInterceptor(t *testing.T) {
+	limiter := New(slog.New(slog.NewTextHandler(io.Discard, nil)), NewMemoryStore(), config.RateLimit{
+		Methods: []config.MethodRateLimit{
+			{Method: "/events.Events/WatchUserEvents", RateLimitRule: config.RateLimitRule{Requests: 1, Per: time.Minute}},
+		},
+	})
+	interceptor := limiter.StreamServerInterceptor()
+
+	handler := func(any, grpc.ServerStream) error { return nil }
+	open := func(ip string) error {
+		ss := fakeStream{ctx: clientinfo.With(context.Background(), clientinfo.Info{IP: ip})}
+		return interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/events.Events/WatchUserEvents"}, handler)
+	}
+
+	if err := open("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := open("10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	if err := open("10.0.0.2"); err != nil {
+		t.Errorf("other address limited: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := New(slog.New(slog.NewTextHandler(io.Discard, nil)), NewMemoryStore(), config.RateLimit{
+		Methods: []config.MethodRateLimit{
+			{Method: "POST /saml/{connector}/acs", RateLimitRule: config.RateLimitRule{Requests: 1, Per: time.Minute}},
+		},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /saml/{connector}/acs", func(w http.ResponseWriter, _ *http.Request) {})
+	mux.HandleFunc("GET /saml/{connector}/metadata", func(w http.ResponseWriter, _ *http.Request) {})
+	handler := limiter.Middleware(mux)
+
+	serve := func(method string, path string, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(clientinfo.With(req.Context(), clientinfo.Info{IP: ip}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/saml/corp/acs", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	// the route is limited, whichever connector is in the path
+	rec := serve(http.MethodPost, "/saml/other/acs", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" || !strings.Contains(rec.Body.String(), "RATE_LIMITED") {
+		t.Errorf("unexpected response %v %s", rec.Header(), rec.Body)
+	}
+
+	if rec := serve(http.MethodPost, "/saml/corp/acs", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("other address limited: %d", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/saml/corp/metadata", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("other route limited: %d", rec.Code)
+	}
+}
+
+// fakePruner records the idle time it was asked to prune with.
+type fakePruner struct {
+	*MemoryStore
+	idle chan time.Duration
+}
+
+func (f fakePruner) Prune(_ context.Context, idle time.Duration) (int64, error) {
+	f.idle <- idle
+	return 0, nil
+}
+
+func TestRunPrune(t *testing.T) {
+	store := fakePruner{MemoryStore: NewMemoryStore(), idle: make(chan time.Duration, 1)}
+	limiter := New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, config.RateLimit{
+		Default: config.RateLimitRule{Requests: 100, Per: time.Second, Burst: 200},
+		Methods: []config.MethodRateLimit{
+			{Method: "/auth.auth/Register", RateLimitRule: config.RateLimitRule{Requests: 30, Per: time.Minute}},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go limiter.RunPrune(ctx)
+
+	// the register limit takes a minute to refill 30 tokens
+	if idle := <-store.idle; idle != time.Minute {
+		t.Fatalf("pruned after %s idle", idle)
+	}
+}
+
+func TestMemoryStoreKeepsSlowBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	// a call an hour, the bucket takes an hour to refill
+	hourly := limit(config.RateLimitRule{Requests: 1, Per: time.Hour})
+	ctx := context.Background()
+
+	if ok, _, _ := store.Take(ctx, "login", hourly); !ok {
+		t.Fatal("first call limited")
+	}
+
+	// idle for longer than the sweep interval, but not long enough to refill
+	now = now.Add(2 * sweepInterval)
+	if ok, _, _ := store.Take(ctx, "other", hourly); !ok {
+		t.Fatal("other key limited")
+	}
+	if ok, _, _ := store.Take(ctx, "login", hourly); ok {
+		t.Fatal("the sweep dropped a bucket that was not full yet")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if ok, _, _ := store.Take(ctx, "other", hourly); !ok {
+		t.Fatal("other key limited")
+	}
+	if _, ok := store.buckets["login"]; ok {
+		t.Fatal("the full bucket was kept")
+	}
+}
@@ -0,0 +1,408 @@
+// Package saml is the service provider side of SAML 2.0 web browser SSO. It
+// sends users to the identity provider with an AuthnRequest over the
+// HTTP-Redirect binding and checks the response the provider posts back to
+// the assertion consumer service.
+//
+// Only signed, unencrypted assertions answering a request of this service
+// are accepted, logins started at the identity provider are not.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// XML namespaces, bindings and formats of SAML 2.0.
+const (
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	NameIDFormatEmail      = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+
+	StatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+	ConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+var ErrInvalidResponse = errors.New("invalid saml response")
+
+const defaultClockSkew = 2 * time.Minute
+
+// ServiceProvider is this service as it is known to one identity provider.
+// EntityID and ACSURL name this service, the IdP fields the provider, its
+// assertions must be signed with one of IdPCertificates.
+type ServiceProvider struct {
+	EntityID        string
+	ACSURL          string
+	IdPEntityID     string
+	IdPSSOURL       string
+	IdPCertificates []*x509.Certificate
+	// ClockSkew is allowed between the clocks of the provider and this
+	// service, zero means two minutes.
+	ClockSkew time.Duration
+}
+
+// Assertion is what the identity provider asserted about the user. ID is
+// unique per assertion, a replayed response carries the same one until
+// NotOnOrAfter.
+type Assertion struct {
+	ID           string
+	NameID       string
+	NameIDFormat string
+	NotOnOrAfter time.Time
+	Attributes   map[string][]string
+}
+
+// Attribute returns the first value of the attribute name.
+func (a Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// ParseCertificate parses the PEM encoded certificate of a provider.
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Metadata returns the SP metadata the provider is configured with.
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", NamespaceMetadata)
+	entity.CreateAttr("entityID", sp.EntityID)
+
+	descriptor := entity.CreateElement("md:SPSSODescriptor")
+	descriptor.CreateAttr("AuthnRequestsSigned", "false")
+	descriptor.CreateAttr("WantAssertionsSigned", "true")
+	descriptor.CreateAttr("protocolSupportEnumeration", NamespaceProtocol)
+
+	for _, format := range []string{NameIDFormatPersistent, NameIDFormatEmail} {
+		descriptor.CreateElement("md:NameIDFormat").SetText(format)
+	}
+
+	acs := descriptor.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", BindingHTTPPost)
+	acs.CreateAttr("Location", sp.ACSURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+
+	return doc.WriteToBytes()
+}
+
+// AuthnRequestURL returns the URL that sends the browser to the provider
+// with an AuthnRequest. requestID comes back as InResponseTo, relayState
+// as is.
+func (sp *ServiceProvider) AuthnRequestURL(requestID string, relayState string) (string, error) {
+	doc := etree.NewDocument()
+
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	req.CreateAttr("xmlns:saml", NamespaceAssertion)
+	req.CreateAttr("ID", requestID)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", time.Now().UTC().Format(time.RFC3339))
+	req.CreateAttr("Destination", sp.IdPSSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.ACSURL)
+	req.CreateAttr("ProtocolBinding", BindingHTTPPost)
+
+	req.CreateElement("saml:Issuer").SetText(sp.EntityID)
+	req.CreateElement("samlp:NameIDPolicy").CreateAttr("AllowCreate", "true")
+
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", err
+	}
+
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(sp.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	q.Set("RelayState", relayState)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// ParseResponse checks the base64 encoded response posted to the ACS and
+// returns its assertion. requestID is the ID of the AuthnRequest the
+// response must answer. Every failure wraps ErrInvalidResponse.
+func (sp *ServiceProvider) ParseResponse(samlResponse string, requestID string) (Assertion, error) {
+	assertion, err := sp.parseResponse(samlResponse, requestID, time.Now())
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return assertion, nil
+}
+
+func (sp *ServiceProvider) parseResponse(samlResponse string, requestID string, now time.Time) (Assertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	// what does not survive a round trip through encoding/xml may be read
+	// differently by the signature check and by us
+	if err := xrv.Validate(bytes.NewReader(raw)); err != nil {
+		return Assertion{}, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return Assertion{}, err
+	}
+
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" || response.NamespaceURI() != NamespaceProtocol {
+		return Assertion{}, errors.New("not a response")
+	}
+
+	// only what a signature covers is read from here on, the elements it
+	// does not cover could have been added by anyone
+	responseSigned := child(response, dsig.Namespace, dsig.SignatureTag) != nil
+	if responseSigned {
+		if response, err = sp.verify(response); err != nil {
+			return Assertion{}, fmt.Errorf("response signature: %w", err)
+		}
+	}
+
+	if response.SelectAttrValue("InResponseTo", "") != requestID {
+		return Assertion{}, errors.New("response does not answer the request")
+	}
+	if dest := response.SelectAttrValue("Destination", ""); dest != "" && dest != sp.ACSURL {
+		return Assertion{}, fmt.Errorf("response is for %q", dest)
+	}
+	if issuer := child(response, NamespaceAssertion, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != sp.IdPEntityID {
+		return Assertion{}, fmt.Errorf("response issued by %q", issuer.Text())
+	}
+
+	code := child(child(response, NamespaceProtocol, "Status"), NamespaceProtocol, "StatusCode")
+	if code == nil || code.SelectAttrValue("Value", "") != StatusSuccess {
+		return Assertion{}, errors.New("login failed at the identity provider")
+	}
+
+	if child(response, NamespaceAssertion, "EncryptedAssertion") != nil {
+		return Assertion{}, errors.New("encrypted assertions are not supported")
+	}
+
+	assertions := children(response, NamespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return Assertion{}, fmt.Errorf("%d assertions", len(assertions))
+	}
+
+	el := assertions[0]
+	if !responseSigned || child(el, dsig.Namespace, dsig.SignatureTag) != nil {
+		if el, err = sp.verify(el); err != nil {
+			return Assertion{}, fmt.Errorf("assertion signature: %w", err)
+		}
+	}
+
+	return sp.assertion(el, requestID, now)
+}
+
+// verify checks the enveloped signature of el and returns the element as
+// signed.
+func (sp *ServiceProvider) verify(el *etree.Element) (*etree.Element, error) {
+	// the namespaces declared by the parents are part of what was signed
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = ctx.SubContext(el)
+	if err != nil {
+		return nil, err
+	}
+	el, err = etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	v := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.IdPCertificates})
+	v.IdAttribute = "ID"
+
+	return v.Validate(el)
+}
+
+func (sp *ServiceProvider) assertion(el *etree.Element, requestID string, now time.Time) (Assertion, error) {
+	skew := sp.ClockSkew
+	if skew <= 0 {
+		skew = defaultClockSkew
+	}
+
+	a := Assertion{ID: el.SelectAttrValue("ID", "")}
+	if a.ID == "" {
+		return Assertion{}, errors.New("assertion without ID")
+	}
+
+	issuer := child(el, NamespaceAssertion, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != sp.IdPEntityID {
+		return Assertion{}, errors.New("assertion of another issuer")
+	}
+
+	subject := child(el, NamespaceAssertion, "Subject")
+	nameID := child(subject, NamespaceAssertion, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return Assertion{}, errors.New("assertion without NameID")
+	}
+	a.NameID = strings.TrimSpace(nameID.Text())
+	a.NameIDFormat = nameID.SelectAttrValue("Format", "")
+
+	if err := sp.confirmed(subject, requestID, now, skew); err != nil {
+		return Assertion{}, err
+	}
+
+	conditions := child(el, NamespaceAssertion, "Conditions")
+	if conditions == nil {
+		return Assertion{}, errors.New("assertion without conditions")
+	}
+
+	if notBefore, ok, err := timeAttr(conditions, "NotBefore"); err != nil {
+		return Assertion{}, err
+	} else if ok && now.Add(skew).Before(notBefore) {
+		return Assertion{}, errors.New("assertion not valid yet")
+	}
+
+	notOnOrAfter, ok, err := timeAttr(conditions, "NotOnOrAfter")
+	if err != nil {
+		return Assertion{}, err
+	}
+	if !ok || !now.Add(-skew).Before(notOnOrAfter) {
+		return Assertion{}, errors.New("assertion expired")
+	}
+	a.NotOnOrAfter = notOnOrAfter
+
+	var audiences []string
+	for _, r := range children(conditions, NamespaceAssertion, "AudienceRestriction") {
+		for _, audience := range children(r, NamespaceAssertion, "Audience") {
+			audiences = append(audiences, strings.TrimSpace(audience.Text()))
+		}
+	}
+	if !slices.Contains(audiences, sp.EntityID) {
+		return Assertion{}, errors.New("assertion for another audience")
+	}
+
+	a.Attributes = make(map[string][]string)
+	for _, statement := range children(el, NamespaceAssertion, "AttributeStatement") {
+		for _, attr := range children(statement, NamespaceAssertion, "Attribute") {
+			name := attr.SelectAttrValue("Name", "")
+			for _, v := range children(attr, NamespaceAssertion, "AttributeValue") {
+				a.Attributes[name] = append(a.Attributes[name], strings.TrimSpace(v.Text()))
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// confirmed checks that a bearer confirmation of subject lets the response
+// be posted to the ACS of this service.
+func (sp *ServiceProvider) confirmed(subject *etree.Element, requestID string, now time.Time, skew time.Duration) error {
+	for _, c := range children(subject, NamespaceAssertion, "SubjectConfirmation") {
+		if c.SelectAttrValue("Method", "") != ConfirmationBearer {
+			continue
+		}
+
+		data := child(c, NamespaceAssertion, "SubjectConfirmationData")
+		if data == nil || data.SelectAttrValue("Recipient", "") != sp.ACSURL {
+			continue
+		}
+		if id := data.SelectAttrValue("InResponseTo", ""); id != "" && id != requestID {
+			continue
+		}
+
+		notOnOrAfter, ok, err := timeAttr(data, "NotOnOrAfter")
+		if err != nil {
+			return err
+		}
+		if ok && now.Add(-skew).Before(notOnOrAfter) {
+			return nil
+		}
+	}
+
+	return errors.New("no bearer confirmation for this service")
+}
+
+func timeAttr(el *etree.Element, name string) (time.Time, bool, error) {
+	v := el.SelectAttrValue(name, "")
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return t, true, nil
+}
+
+// child returns the first child of el named tag in namespace, nil when el is
+// nil or has none.
+func child(el *etree.Element, namespace string, tag string) *etree.Element {
+	if c := children(el, namespace, tag); len(c) > 0 {
+		return c[0]
+	}
+
+	return nil
+}
+
+func children(el *etree.Element, namespace string, tag string) []*etree.Element {
+	if el == nil {
+		return nil
+	}
+
+	var out []*etree.Element
+	for _, c := range el.ChildElements() {
+		if c.Tag == tag && c.NamespaceURI() == namespace {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,165 @@
+package saml_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/saml"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/saml/samltest"
+)
+
+const requestID = "id-request-1"
+
+func newTestSP(t *testing.T) (*saml.ServiceProvider, *samltest.IdP) {
+	t.Helper()
+
+	idp := samltest.New(t, "https://idp.example.com")
+
+	return &saml.ServiceProvider{
+		EntityID:        "https://sso.example.com/saml/okta/metadata",
+		ACSURL:          "https://sso.example.com/saml/okta/acs",
+		IdPEntityID:     idp.EntityID,
+		IdPSSOURL:       idp.SSOURL,
+		IdPCertificates: []*x509.Certificate{idp.Certificate},
+	}, idp
+}
+
+func TestLogin(t *testing.T) {
+	sp, idp := newTestSP(t)
+
+	loginURL, err := sp.AuthnRequestURL(requestID, "relay-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loginURL, idp.SSOURL+"?") {
+		t.Fatalf("login URL %q", loginURL)
+	}
+
+	for _, signResponse := range []bool{false, true} {
+		samlResponse, relayState, err := idp.Login(loginURL, samltest.Response{
+			NameID:       "u-1",
+			Attributes:   map[string][]string{"email": {"jonn@gmail.com"}, "groups": {"a", "b"}},
+			SignResponse: signResponse,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relayState != "relay-1" {
+			t.Fatalf("relay state = %q", relayState)
+		}
+
+		a, err := sp.ParseResponse(samlResponse, requestID)
+		if err != nil {
+			t.Fatalf("sign response %v: %v", signResponse, err)
+		}
+		if a.NameID != "u-1" || a.Attribute("email") != "jonn@gmail.com" || len(a.Attributes["groups"]) != 2 || a.ID == "" {
+			t.Fatalf("unexpected assertion %+v", a)
+		}
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	sp, idp := newTestSP(t)
+	other := samltest.New(t, idp.EntityID)
+
+	valid := samltest.Response{
+		InResponseTo: requestID,
+		Destination:  sp.ACSURL,
+		Recipient:    sp.ACSURL,
+		Audience:     sp.EntityID,
+		NameID:       "u-1",
+	}
+
+	tests := []struct {
+		name   string
+		idp    *samltest.IdP
+		change func(r *samltest.Response)
+	}{
+		{"unsigned", idp, func(r *samltest.Response) { r.Unsigned = true }},
+		{"signed by another key", other, func(r *samltest.Response) {}},
+		{"other request", idp, func(r *samltest.Response) { r.InResponseTo = "id-other" }},
+		{"other audience", idp, func(r *samltest.Response) { r.Audience = "https://other.example.com" }},
+		{"other recipient", idp, func(r *samltest.Response) { r.Recipient = "https://other.example.com/acs" }},
+		{"other destination", idp, func(r *samltest.Response) { r.Destination = "https://other.example.com/acs" }},
+		{"other issuer", idp, func(r *samltest.Response) { r.Issuer = "https://other.example.com" }},
+		{"expired", idp, func(r *samltest.Response) { r.NotOnOrAfter = time.Now().Add(-time.Hour) }},
+		{"failed", idp, func(r *samltest.Response) { r.Status = "urn:oasis:names:tc:SAML:2.0:status:Responder" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.change(&r)
+
+			samlResponse, err := tt.idp.Response(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := sp.ParseResponse(samlResponse, requestID); !errors.Is(err, saml.ErrInvalidResponse) {
+				t.Fatalf("expected ErrInvalidResponse, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseResponseTampered(t *testing.T) {
+	sp, idp := newTestSP(t)
+
+	samlResponse, err := idp.Response(samltest.Response{
+		InResponseTo: requestID,
+		Destination:  sp.ACSURL,
+		Recipient:    sp.ACSURL,
+		Audience:     sp.EntityID,
+		NameID:       "u-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatal(err)
+	}
+	doc.FindElement("//NameID").SetText("admin")
+	tampered, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sp.ParseResponse(base64.StdEncoding.EncodeToString(tampered), requestID); !errors.Is(err, saml.ErrInvalidResponse) {
+		t.Fatalf("expected ErrInvalidResponse, got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	sp, _ := newTestSP(t)
+
+	raw, err := sp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := doc.Root().SelectAttrValue("entityID", ""); got != sp.EntityID {
+		t.Errorf("entityID = %q", got)
+	}
+	acs := doc.FindElement("//AssertionConsumerService")
+	if acs == nil || acs.SelectAttrValue("Location", "") != sp.ACSURL || acs.SelectAttrValue("Binding", "") != saml.BindingHTTPPost {
+		t.Errorf("unexpected metadata %s", raw)
+	}
+}
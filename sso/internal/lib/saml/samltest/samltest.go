@@ -0,0 +1,280 @@
+// Package samltest is an identity provider for tests. It reads the
+// AuthnRequest of a login URL and answers it with a response signed by a
+// key generated for the test.
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// IdP is the stub provider, CertificatePEM is what a service provider
+// trusts it with.
+type IdP struct {
+	EntityID       string
+	SSOURL         string
+	Certificate    *x509.Certificate
+	CertificatePEM string
+
+	key tls.Certificate
+}
+
+// Request is an AuthnRequest read from a login URL.
+type Request struct {
+	ID         string
+	Issuer     string
+	ACSURL     string
+	RelayState string
+}
+
+// Response describes the response to build. Empty fields are filled in
+// with what a well-behaved provider would send.
+type Response struct {
+	InResponseTo string
+	Destination  string
+	Audience     string
+	Recipient    string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	Attributes   map[string][]string
+	// NotOnOrAfter defaults to five minutes from now.
+	NotOnOrAfter time.Time
+	// Status defaults to saml.StatusSuccess.
+	Status string
+	// SignResponse signs the response instead of the assertion, Unsigned
+	// signs neither.
+	SignResponse bool
+	Unsigned     bool
+}
+
+// New returns a provider with a fresh key and self-signed certificate.
+func New(t testing.TB, entityID string) *IdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &IdP{
+		EntityID:       entityID,
+		SSOURL:         "https://idp.example.com/sso",
+		Certificate:    cert,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		key:            tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// ReadRequest reads the AuthnRequest of a login URL of the HTTP-Redirect
+// binding.
+func (i *IdP) ReadRequest(loginURL string) (Request, error) {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return Request{}, err
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		return Request{}, err
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		return Request{}, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return Request{}, err
+	}
+
+	root := doc.Root()
+	if root == nil || root.Tag != "AuthnRequest" {
+		return Request{}, errors.New("not an AuthnRequest")
+	}
+
+	req := Request{
+		ID:         root.SelectAttrValue("ID", ""),
+		ACSURL:     root.SelectAttrValue("AssertionConsumerServiceURL", ""),
+		RelayState: u.Query().Get("RelayState"),
+	}
+	if issuer := root.SelectElement("Issuer"); issuer != nil {
+		req.Issuer = issuer.Text()
+	}
+
+	return req, nil
+}
+
+// Login answers the AuthnRequest of loginURL with r and returns the
+// SAMLResponse and RelayState the browser posts to the ACS.
+func (i *IdP) Login(loginURL string, r Response) (samlResponse string, relayState string, err error) {
+	req, err := i.ReadRequest(loginURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	if r.InResponseTo == "" {
+		r.InResponseTo = req.ID
+	}
+	if r.Destination == "" {
+		r.Destination = req.ACSURL
+	}
+	if r.Recipient == "" {
+		r.Recipient = req.ACSURL
+	}
+	if r.Audience == "" {
+		r.Audience = req.Issuer
+	}
+
+	samlResponse, err = i.Response(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	return samlResponse, req.RelayState, nil
+}
+
+// Response returns r base64 encoded the way the HTTP-POST binding carries
+// it.
+func (i *IdP) Response(r Response) (string, error) {
+	now := time.Now().UTC()
+	if r.Issuer == "" {
+		r.Issuer = i.EntityID
+	}
+	if r.NotOnOrAfter.IsZero() {
+		r.NotOnOrAfter = now.Add(5 * time.Minute)
+	}
+	if r.Status == "" {
+		r.Status = saml.StatusSuccess
+	}
+	if r.NameIDFormat == "" {
+		r.NameIDFormat = saml.NameIDFormatPersistent
+	}
+
+	assertion := etree.NewElement("saml:Assertion")
+	assertion.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	assertion.CreateAttr("ID", newID())
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	assertion.CreateElement("saml:Issuer").SetText(r.Issuer)
+
+	subject := assertion.CreateElement("saml:Subject")
+	nameID := subject.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", r.NameIDFormat)
+	nameID.SetText(r.NameID)
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", saml.ConfirmationBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("InResponseTo", r.InResponseTo)
+	data.CreateAttr("Recipient", r.Recipient)
+	data.CreateAttr("NotOnOrAfter", r.NotOnOrAfter.Format(time.RFC3339))
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", r.NotOnOrAfter.Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(r.Audience)
+
+	authn := assertion.CreateElement("saml:AuthnStatement")
+	authn.CreateAttr("AuthnInstant", now.Format(time.RFC3339))
+	authn.CreateElement("saml:AuthnContext").CreateElement("saml:AuthnContextClassRef").
+		SetText("urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport")
+
+	if len(r.Attributes) > 0 {
+		statement := assertion.CreateElement("saml:AttributeStatement")
+		for name, values := range r.Attributes {
+			attr := statement.CreateElement("saml:Attribute")
+			attr.CreateAttr("Name", name)
+			for _, v := range values {
+				attr.CreateElement("saml:AttributeValue").SetText(v)
+			}
+		}
+	}
+
+	var err error
+	if !r.Unsigned && !r.SignResponse {
+		if assertion, err = i.sign(assertion); err != nil {
+			return "", err
+		}
+	}
+
+	response := etree.NewElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", saml.NamespaceProtocol)
+	response.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	response.CreateAttr("ID", newID())
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	response.CreateAttr("Destination", r.Destination)
+	response.CreateAttr("InResponseTo", r.InResponseTo)
+	response.CreateElement("saml:Issuer").SetText(r.Issuer)
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", r.Status)
+	response.AddChild(assertion)
+
+	if !r.Unsigned && r.SignResponse {
+		if response, err = i.sign(response); err != nil {
+			return "", err
+		}
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(response)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// sign adds an enveloped signature to el.
+func (i *IdP) sign(el *etree.Element) (*etree.Element, error) {
+	ctx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(i.key))
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	signed, err := ctx.SignEnveloped(el)
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	return signed, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return "id-" + hex.EncodeToString(b)
+}
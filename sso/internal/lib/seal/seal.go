@@ -0,0 +1,117 @@
+// Package seal encrypts the secrets that are kept in the database, such as
+// the TOTP secrets, with AES-256-GCM so that a copy of the database does not
+// give them away.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks sealed values, values without it were stored before secrets
+// were sealed.
+const prefix = "sealed:v1:"
+
+const KeySize = 32
+
+var (
+	ErrNoKey     = errors.New("no key to open the sealed secret")
+	ErrTampered  = errors.New("sealed secret is corrupt or sealed for something else")
+	ErrKeyLength = fmt.Errorf("key must be %d bytes", KeySize)
+)
+
+// Key seals and opens secrets. A nil Key keeps secrets as they are, which is
+// only meant for development.
+type Key struct {
+	aead cipher.AEAD
+}
+
+// NewKey returns the Key of a 32 byte AES-256 key.
+func NewKey(raw []byte) (*Key, error) {
+	const op = "seal.NewKey"
+
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("%s: %w", op, ErrKeyLength)
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Key{aead: aead}, nil
+}
+
+// LoadKey reads a hex encoded 32 byte key from path.
+func LoadKey(path string) (*Key, error) {
+	const op = "seal.LoadKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrKeyLength)
+	}
+
+	return NewKey(raw)
+}
+
+// Seal encrypts secret for subject, such as the id of its user. It can only
+// be opened for the same subject, so that a sealed secret can not be copied
+// to another row.
+func (k *Key) Seal(secret string, subject string) (string, error) {
+	if k == nil {
+		return secret, nil
+	}
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := k.aead.Seal(nonce, nonce, []byte(secret), []byte(subject))
+
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed for subject. Values stored before secrets
+// were sealed are returned as they are.
+func (k *Key) Open(value string, subject string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+
+	if k == nil {
+		return "", ErrNoKey
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", ErrTampered
+	}
+
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+
+	secret, err := k.aead.Open(nil, nonce, ciphertext, []byte(subject))
+	if err != nil {
+		return "", ErrTampered
+	}
+
+	return string(secret), nil
+}
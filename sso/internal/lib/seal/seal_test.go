@@ -0,0 +1,82 @@
+package seal_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/seal"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := seal.NewKey(bytes.Repeat([]byte{7}, seal.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := key.Seal("JBSWY3DPEHPK3PXP", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("secret is readable in %q", sealed)
+	}
+
+	secret, err := key.Open(sealed, "7")
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+
+	// a secret copied to another user does not open
+	if _, err := key.Open(sealed, "8"); !errors.Is(err, seal.ErrTampered) {
+		t.Fatalf("expected ErrTampered, got %v", err)
+	}
+
+	other, _ := seal.NewKey(bytes.Repeat([]byte{8}, seal.KeySize))
+	if _, err := other.Open(sealed, "7"); !errors.Is(err, seal.ErrTampered) {
+		t.Fatalf("expected ErrTampered, got %v", err)
+	}
+
+	// secrets stored before sealing are read as they are
+	if secret, err := key.Open("JBSWY3DPEHPK3PXP", "7"); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+}
+
+func TestNilKey(t *testing.T) {
+	var key *seal.Key
+
+	sealed, err := key.Seal("JBSWY3DPEHPK3PXP", "7")
+	if err != nil || sealed != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("got %q, %v", sealed, err)
+	}
+
+	sealer, _ := seal.NewKey(bytes.Repeat([]byte{7}, seal.KeySize))
+	sealed, _ = sealer.Seal("JBSWY3DPEHPK3PXP", "7")
+	if _, err := key.Open(sealed, "7"); !errors.Is(err, seal.ErrNoKey) {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, []byte(strings.Repeat("ab", seal.KeySize)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seal.LoadKey(path); err != nil {
+		t.Fatal(err)
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte("abcd"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seal.LoadKey(short); !errors.Is(err, seal.ErrKeyLength) {
+		t.Fatalf("expected ErrKeyLength, got %v", err)
+	}
+}
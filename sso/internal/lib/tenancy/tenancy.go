@@ -0,0 +1,163 @@
+// Package tenancy resolves the tenant of every call and stores it in the
+// context, see package tenant.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/grpc/grpcerr"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Provider looks up tenants, it must not be restricted to the tenant of the
+// context.
+type Provider interface {
+	TenantBySlug(ctx context.Context, slug string) (domain.Tenant, error)
+	AppTenant(ctx context.Context, appID int64) (int64, error)
+	APIKeyTenant(ctx context.Context, prefix string) (int64, error)
+}
+
+var errUnknownTenant = grpcerr.InvalidArgument("x-tenant", "unknown tenant")
+
+// Resolver takes the tenant of a call from, in this order:
+//   - the "x-tenant" metadata, the slug of the tenant,
+//   - the owner of the API key in the "x-api-key" or "authorization"
+//     metadata,
+//   - the app of the access token in the "authorization" metadata,
+//   - the app_id field of the request,
+//
+// and falls back to tenant.DefaultID. Tokens and keys are not verified here,
+// the authorizer rejects tokens of apps outside the resolved tenant and
+// unknown keys.
+type Resolver struct {
+	log      *slog.Logger
+	provider Provider
+}
+
+func New(log *slog.Logger, provider Provider) *Resolver {
+	return &Resolver{log: log, provider: provider}
+}
+
+// UnaryServerInterceptor stores the tenant of every call in the context.
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id, err := r.resolve(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(tenant.With(ctx, id), req)
+	}
+}
+
+// StreamServerInterceptor stores the tenant in the context of the stream,
+// the request is not read yet so only the metadata counts.
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, err := r.resolve(ss.Context(), nil)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: tenant.With(ss.Context(), id)})
+	}
+}
+
+func (r *Resolver) resolve(ctx context.Context, req any) (int64, error) {
+	const op = "tenancy.resolve"
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if slug := md.Get("x-tenant"); len(slug) > 0 && slug[0] != "" {
+		t, err := r.provider.TenantBySlug(ctx, slug[0])
+		if err != nil {
+			if errors.Is(err, storage.ErrTenantNotFound) {
+				return 0, errUnknownTenant
+			}
+			r.log.Error("field to resolve tenant", slog.String("op", op), slog.Any("err", err))
+			return 0, grpcerr.Status(err)
+		}
+		return t.ID, nil
+	}
+
+	if prefix, ok := keyformat.KeyPrefix(apiKey(md)); ok {
+		id, err := r.provider.APIKeyTenant(ctx, prefix)
+		if err != nil {
+			if errors.Is(err, storage.ErrAPIKeyNotFound) {
+				return tenant.DefaultID, nil
+			}
+			r.log.Error("field to resolve tenant", slog.String("op", op), slog.Any("err", err))
+			return 0, grpcerr.Status(err)
+		}
+		return id, nil
+	}
+
+	appID := requestAppID(req)
+	if token := bearer(md); token != "" {
+		// a token that can not be read is rejected by the authorizer
+		if id, err := jwtToken.AppID(token); err == nil {
+			appID = id
+		}
+	}
+
+	if appID == 0 {
+		return tenant.DefaultID, nil
+	}
+
+	id, err := r.provider.AppTenant(ctx, appID)
+	if err != nil {
+		// unknown apps are reported by the methods themselves
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return tenant.DefaultID, nil
+		}
+		r.log.Error("field to resolve tenant", slog.String("op", op), slog.Any("err", err))
+		return 0, grpcerr.Status(err)
+	}
+
+	return id, nil
+}
+
+func requestAppID(req any) int64 {
+	switch r := req.(type) {
+	case interface{ GetAppId() int64 }:
+		return r.GetAppId()
+	case interface{ GetAppId() int32 }:
+		return int64(r.GetAppId())
+	}
+
+	return 0
+}
+
+func bearer(md metadata.MD) string {
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	return ""
+}
+
+func apiKey(md metadata.MD) string {
+	if v := md.Get("x-api-key"); len(v) > 0 && v[0] != "" {
+		return strings.TrimSpace(v[0])
+	}
+
+	return bearer(md)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
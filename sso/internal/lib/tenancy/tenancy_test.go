@@ -0,0 +1,109 @@
+package tenancy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeProvider struct {
+	tenants map[string]int64
+	apps    map[int64]int64
+	keys    map[string]int64
+}
+
+func (f fakeProvider) TenantBySlug(_ context.Context, slug string) (domain.Tenant, error) {
+	id, ok := f.tenants[slug]
+	if !ok {
+		return domain.Tenant{}, storage.ErrTenantNotFound
+	}
+
+	return domain.Tenant{ID: id, Slug: slug}, nil
+}
+
+func (f fakeProvider) AppTenant(_ context.Context, appID int64) (int64, error) {
+	id, ok := f.apps[appID]
+	if !ok {
+		return 0, storage.ErrAppNotFound
+	}
+
+	return id, nil
+}
+
+func (f fakeProvider) APIKeyTenant(_ context.Context, prefix string) (int64, error) {
+	id, ok := f.keys[prefix]
+	if !ok {
+		return 0, storage.ErrAPIKeyNotFound
+	}
+
+	return id, nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	r := New(slog.New(slog.NewTextHandler(io.Discard, nil)), fakeProvider{
+		tenants: map[string]int64{"default": 1, "acme": 2},
+		apps:    map[int64]int64{1: 1, 5: 2},
+		keys:    map[string]int64{"abc123": 2},
+	})
+
+	token, err := jwtToken.GetToken(domain.User{ID: 7}, domain.App{ID: 5, Secret: "secret", TenantID: 2}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		md     metadata.MD
+		req    any
+		tenant int64
+		code   codes.Code
+	}{
+		{name: "nothing", tenant: tenant.DefaultID},
+		{name: "slug", md: metadata.Pairs("x-tenant", "acme"), tenant: 2},
+		{name: "unknown slug", md: metadata.Pairs("x-tenant", "nope"), code: codes.InvalidArgument},
+		{name: "slug wins over the token", md: metadata.Pairs("x-tenant", "default", "authorization", "Bearer "+token), tenant: 1},
+		{name: "app of the token", md: metadata.Pairs("authorization", "Bearer "+token), tenant: 2},
+		{name: "app of the request", req: &ssov1.LoginRequest{AppId: 5}, tenant: 2},
+		{name: "token wins over the request", md: metadata.Pairs("authorization", "Bearer "+token), req: &ssov1.LoginRequest{AppId: 1}, tenant: 2},
+		{name: "unknown app", req: &ssov1.LoginRequest{AppId: 9}, tenant: tenant.DefaultID},
+		{name: "api key", md: metadata.Pairs("x-api-key", "sso_abc123_secret"), req: &ssov1.LoginRequest{AppId: 1}, tenant: 2},
+		{name: "api key as bearer", md: metadata.Pairs("authorization", "Bearer sso_abc123_secret"), tenant: 2},
+		{name: "unknown api key", md: metadata.Pairs("x-api-key", "sso_nope_secret"), tenant: tenant.DefaultID},
+		{name: "garbage token", md: metadata.Pairs("authorization", "Bearer garbage"), req: &ssov1.LoginRequest{AppId: 5}, tenant: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			var got int64
+			handler := func(ctx context.Context, _ any) (any, error) {
+				got = tenant.ID(ctx)
+				return nil, nil
+			}
+
+			_, err := r.UnaryServerInterceptor()(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: "/auth.auth/Login"}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if err == nil && got != tt.tenant {
+				t.Fatalf("tenant = %d, want %d", got, tt.tenant)
+			}
+		})
+	}
+}
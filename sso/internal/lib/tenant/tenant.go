@@ -0,0 +1,45 @@
+// Package tenant carries the tenant of a request. Users and apps belong to a
+// tenant and the storage restricts every query to the tenant of its context.
+package tenant
+
+import "context"
+
+// DefaultID is the tenant of deployments that host a single customer, data
+// created before tenants existed belongs to it.
+const DefaultID int64 = 1
+
+type ctxKey struct{}
+
+type anyKey struct{}
+
+// With scopes ctx to the tenant id, zero means DefaultID.
+func With(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the tenant stored by With, ok is false when none was.
+func FromContext(ctx context.Context) (id int64, ok bool) {
+	id, ok = ctx.Value(ctxKey{}).(int64)
+	return id, ok && id != 0
+}
+
+// ID returns the tenant of ctx, DefaultID when it has none.
+func ID(ctx context.Context) int64 {
+	if id, ok := FromContext(ctx); ok {
+		return id
+	}
+
+	return DefaultID
+}
+
+// Any lets readers of the audit log, such as the chain verification, see the
+// events of every tenant. Other queries ignore it.
+func Any(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anyKey{}, true)
+}
+
+// IsAny reports whether ctx was marked by Any.
+func IsAny(ctx context.Context) bool {
+	v, _ := ctx.Value(anyKey{}).(bool)
+	return v
+}
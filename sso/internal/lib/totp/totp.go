@@ -0,0 +1,94 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 as
+// authenticator apps generate them: HMAC-SHA1, 6 digits and 30 second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random base32 secret.
+func NewSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI that authenticator apps import, usually as
+// a QR code.
+func URI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Step returns the time step t falls into.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of secret for step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("totp: invalid secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000), nil
+}
+
+// Validate checks code against the steps around t, skew steps before and
+// after are accepted to allow for clock drift. It returns the matching step,
+// callers reject steps that were used before so that a code works once.
+func Validate(secret string, code string, t time.Time, skew int) (step int64, ok bool, err error) {
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	now := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		want, err := Code(secret, now+i)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return now + i, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
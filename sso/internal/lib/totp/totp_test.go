@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the test vectors in RFC 6238.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the RFC lists 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	code, _ := Code(rfcSecret, Step(now)-1)
+
+	step, ok, err := Validate(rfcSecret, code, now, 1)
+	if err != nil || !ok || step != Step(now)-1 {
+		t.Fatalf("previous step rejected: step %d ok %v err %v", step, ok, err)
+	}
+
+	if _, ok, _ := Validate(rfcSecret, code, now, 0); ok {
+		t.Error("previous step accepted without skew")
+	}
+
+	if _, ok, _ := Validate(rfcSecret, "12345", now, 1); ok {
+		t.Error("short code accepted")
+	}
+
+	if _, _, err := Validate("not base32!", "123456", now, 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Code(secret, 1); err != nil {
+		t.Fatalf("secret is not usable: %v", err)
+	}
+
+	uri := URI("sso", "jon@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/sso:jon@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
@@ -0,0 +1,80 @@
+// Package warning collects what a client should know about a call that
+// succeeded anyway, such as a password found in the breach corpus while the
+// corpus only warns. The interceptor sends them back in the "x-sso-warning"
+// trailer so a rollout can be watched from the client side.
+package warning
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Trailer is the metadata key the warnings of a call are sent in.
+const Trailer = "x-sso-warning"
+
+// PasswordBreached is added when the new password is in the breach corpus
+// and the corpus is in warn mode.
+const PasswordBreached = "password_breached"
+
+type collector struct {
+	mu    sync.Mutex
+	codes []string
+}
+
+type ctxKey struct{}
+
+// With returns a context that collects the warnings added to it.
+func With(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &collector{})
+}
+
+// Add records code for the call of ctx, it does nothing when ctx does not
+// collect warnings.
+func Add(ctx context.Context, code string) {
+	c, ok := ctx.Value(ctxKey{}).(*collector)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, have := range c.codes {
+		if have == code {
+			return
+		}
+	}
+	c.codes = append(c.codes, code)
+}
+
+// FromContext returns the warnings added so far.
+func FromContext(ctx context.Context) []string {
+	c, ok := ctx.Value(ctxKey{}).(*collector)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.codes...)
+}
+
+// UnaryServerInterceptor collects the warnings of every call and sets them
+// as trailer, also when the call fails.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = With(ctx)
+
+		resp, err := handler(ctx, req)
+
+		if codes := FromContext(ctx); len(codes) > 0 {
+			_ = grpc.SetTrailer(ctx, metadata.MD{Trailer: codes})
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,31 @@
+package warning_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/warning"
+)
+
+func TestAdd(t *testing.T) {
+	ctx := warning.With(context.Background())
+
+	warning.Add(ctx, warning.PasswordBreached)
+	warning.Add(ctx, warning.PasswordBreached)
+	warning.Add(ctx, "other")
+
+	if got := warning.FromContext(ctx); !reflect.DeepEqual(got, []string{warning.PasswordBreached, "other"}) {
+		t.Fatalf("warnings = %v", got)
+	}
+}
+
+func TestAddWithoutCollector(t *testing.T) {
+	ctx := context.Background()
+
+	warning.Add(ctx, warning.PasswordBreached)
+
+	if got := warning.FromContext(ctx); got != nil {
+		t.Fatalf("warnings = %v", got)
+	}
+}
@@ -0,0 +1,42 @@
+package webhooksig
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"user_id":7}`)
+
+	type test struct {
+		name   string
+		secret string
+		body   []byte
+		sentAt time.Time
+		err    error
+	}
+
+	tests := []test{
+		{name: "valid", secret: "s3cret", body: body, sentAt: time.Now()},
+		{name: "wrong secret", secret: "other", body: body, sentAt: time.Now(), err: ErrInvalidSignature},
+		{name: "changed body", secret: "s3cret", body: []byte(`{"user_id":8}`), sentAt: time.Now(), err: ErrInvalidSignature},
+		{name: "replayed", secret: "s3cret", body: body, sentAt: time.Now().Add(-time.Hour), err: ErrExpired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			SetHeaders(h, "s3cret", test.sentAt, body)
+
+			if err := Verify(h, test.secret, test.body, 5*time.Minute); !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	if err := Verify(http.Header{}, "s3cret", body, time.Minute); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("expected ErrMissingSignature, got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	SaveAPIKey(ctx context.Context, key domain.APIKey) (domain.APIKey, error)
+	APIKeyByPrefix(ctx context.Context, prefix string) (domain.APIKey, error)
+	APIKeys(ctx context.Context, userID int64) ([]domain.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int64, keyID int64) error
+	TouchAPIKeys(ctx context.Context, used map[int64]time.Time) error
+	DeleteExpiredAPIKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated  = errors.New("an access token is required")
+	ErrPermissionDenied = errors.New("api keys can not manage api keys")
+	ErrInvalidScope     = errors.New("invalid scope")
+	ErrInvalidExpiry    = errors.New("invalid expiry")
+	ErrAPIKeyNotFound   = errors.New("api key not found")
+)
+
+var reasons = access.Reasons{
+	ErrUnauthenticated:  access.ReasonUnauthenticated,
+	ErrPermissionDenied: access.ReasonPermissionDenied,
+	ErrInvalidScope:     "invalid_scope",
+	ErrInvalidExpiry:    "invalid_expiry",
+	ErrAPIKeyNotFound:   "api_key_not_found",
+}
+
+const (
+	defaultMaxTTL           = 365 * 24 * time.Hour
+	defaultFlushInterval    = time.Minute
+	defaultExpiredRetention = 30 * 24 * time.Hour
+	cleanupInterval         = time.Hour
+)
+
+type APIKeys struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+	cfg     config.APIKeys
+
+	mu   sync.Mutex
+	used map[int64]time.Time
+}
+
+// New returns new instance of the APIKeys servic
+func New(log *slog.Logger, storage Storage, auditor Auditor, cfg config.APIKeys) *APIKeys {
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = defaultMaxTTL
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.ExpiredRetention <= 0 {
+		cfg.ExpiredRetention = defaultExpiredRetention
+	}
+
+	return &APIKeys{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+		cfg:     cfg,
+		used:    make(map[int64]time.Time),
+	}
+}
+
+// CreateAPIKey mints a key of the caller. The key is returned once, only its
+// prefix and hash are stored. A zero expiresAt means the longest lifetime
+// allowed.
+func (k *APIKeys) CreateAPIKey(
+	ctx context.Context,
+	name string,
+	scopes []string,
+	expiresAt time.Time,
+) (key string, apiKey domain.APIKey, err error) {
+	const op = "apikey.CreateAPIKey"
+
+	log := k.log.With(slog.String("op", op))
+
+	defer func() { k.record(ctx, domain.AuditAPIKeyCreate, err) }()
+
+	userID, err := owner(ctx)
+	if err != nil {
+		return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	scopes, err = normalizeScopes(scopes)
+	if err != nil {
+		return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(k.cfg.MaxTTL)
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(k.cfg.MaxTTL)) {
+		return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, ErrInvalidExpiry)
+	}
+
+	key, prefix, hash, err := keyformat.New()
+	if err != nil {
+		log.Error("field to generate api key", slog.Any("err", err))
+		return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	apiKey, err = k.storage.SaveAPIKey(ctx, domain.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to save api key", slog.Any("err", err))
+		return "", domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("api key created", slog.Int64("uid", userID), slog.Int64("key_id", apiKey.ID))
+
+	return key, apiKey, nil
+}
+
+// ListAPIKeys returns the keys of the caller, keys that expired within
+// ExpiredRetention included.
+func (k *APIKeys) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	const op = "apikey.ListAPIKeys"
+
+	userID, err := owner(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys, err := k.storage.APIKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey deletes a key of the caller, it stops working at once.
+func (k *APIKeys) RevokeAPIKey(ctx context.Context, keyID int64) (err error) {
+	const op = "apikey.RevokeAPIKey"
+
+	log := k.log.With(slog.String("op", op))
+
+	defer func() { k.record(ctx, domain.AuditAPIKeyRevoke, err) }()
+
+	userID, err := owner(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := k.storage.DeleteAPIKey(ctx, userID, keyID); err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrAPIKeyNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("api key revoked", slog.Int64("uid", userID), slog.Int64("key_id", keyID))
+
+	return nil
+}
+
+// Authenticate returns the key behind the secret key. Unknown, forged and
+// expired keys are keyformat.ErrInvalidAPIKey. The use is not recorded, the
+// caller calls Touch once it accepted the key.
+func (k *APIKeys) Authenticate(ctx context.Context, key string) (domain.APIKey, error) {
+	const op = "apikey.Authenticate"
+
+	prefix, ok := keyformat.KeyPrefix(key)
+	if !ok {
+		return domain.APIKey{}, fmt.Errorf("%s: %w", op, keyformat.ErrInvalidAPIKey)
+	}
+
+	apiKey, err := k.storage.APIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return domain.APIKey{}, fmt.Errorf("%s: %w", op, keyformat.ErrInvalidAPIKey)
+		}
+		return domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if subtle.ConstantTimeCompare(keyformat.Hash(key), apiKey.Hash) != 1 || !time.Now().Before(apiKey.ExpiresAt) {
+		return domain.APIKey{}, fmt.Errorf("%s: %w", op, keyformat.ErrInvalidAPIKey)
+	}
+
+	return apiKey, nil
+}
+
+// Touch records the use of the key, Run writes it.
+func (k *APIKeys) Touch(keyID int64) {
+	k.mu.Lock()
+	k.used[keyID] = time.Now()
+	k.mu.Unlock()
+}
+
+// Run writes the last use of keys every FlushInterval until ctx is done,
+// the uses seen since the last write are written once more on the way out.
+func (k *APIKeys) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			k.flush(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			k.flush(ctx)
+		}
+	}
+}
+
+// RunCleanup deletes keys that expired more than ExpiredRetention ago until
+// ctx is done, until then they are still listed.
+func (k *APIKeys) RunCleanup(ctx context.Context) {
+	const op = "apikey.RunCleanup"
+
+	log := k.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := k.storage.DeleteExpiredAPIKeys(ctx, time.Now().Add(-k.cfg.ExpiredRetention))
+		if err != nil {
+			log.Error("field to delete expired api keys", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("expired api keys deleted", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *APIKeys) flush(ctx context.Context) {
+	const op = "apikey.flush"
+
+	k.mu.Lock()
+	used := k.used
+	k.used = make(map[int64]time.Time)
+	k.mu.Unlock()
+
+	if len(used) == 0 {
+		return
+	}
+
+	if err := k.storage.TouchAPIKeys(ctx, used); err != nil {
+		k.log.Error("field to record api key use", slog.String("op", op), slog.Any("err", err))
+
+		// keep the uses for the next try, newer uses win
+		k.mu.Lock()
+		for id, at := range used {
+			if at.After(k.used[id]) {
+				k.used[id] = at
+			}
+		}
+		k.mu.Unlock()
+	}
+}
+
+func (k *APIKeys) record(ctx context.Context, eventType string, err error) {
+	access.Record(ctx, k.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: access.UserSubject(ctx, 0),
+	}, err, reasons)
+}
+
+// owner returns the caller, keys are only managed with access tokens so a
+// leaked key can not mint more keys.
+func owner(ctx context.Context) (int64, error) {
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+
+	if caller.APIKeyID != 0 {
+		return 0, ErrPermissionDenied
+	}
+
+	return caller.UserID, nil
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if !slices.Contains(domain.Scopes, s) {
+			return nil, ErrInvalidScope
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
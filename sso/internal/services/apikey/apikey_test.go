@@ -0,0 +1,257 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/keyformat"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	mu      sync.Mutex
+	keys    map[int64]domain.APIKey
+	touched map[int64]time.Time
+	events  []domain.AuditEvent
+}
+
+func (f *fakeStorage) SaveAPIKey(_ context.Context, key domain.APIKey) (domain.APIKey, error) {
+	key.ID = int64(len(f.keys) + 1)
+	key.CreatedAt = time.Now()
+	f.keys[key.ID] = key
+
+	return key, nil
+}
+
+func (f *fakeStorage) APIKeyByPrefix(_ context.Context, prefix string) (domain.APIKey, error) {
+	for _, k := range f.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+
+	return domain.APIKey{}, storage.ErrAPIKeyNotFound
+}
+
+func (f *fakeStorage) APIKeys(_ context.Context, userID int64) ([]domain.APIKey, error) {
+	var keys []domain.APIKey
+	for _, k := range f.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+func (f *fakeStorage) DeleteAPIKey(_ context.Context, userID int64, keyID int64) error {
+	k, ok := f.keys[keyID]
+	if !ok || k.UserID != userID {
+		return storage.ErrAPIKeyNotFound
+	}
+	delete(f.keys, keyID)
+
+	return nil
+}
+
+func (f *fakeStorage) TouchAPIKeys(_ context.Context, used map[int64]time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, at := range used {
+		f.touched[id] = at
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteExpiredAPIKeys(_ context.Context, before time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int64
+	for id, k := range f.keys {
+		if k.ExpiresAt.Before(before) {
+			delete(f.keys, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestAPIKeys() (*APIKeys, *fakeStorage) {
+	st := &fakeStorage{keys: map[int64]domain.APIKey{}, touched: map[int64]time.Time{}}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, st, st, config.APIKeys{MaxTTL: 24 * time.Hour, FlushInterval: 10 * time.Millisecond}), st
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 1})
+}
+
+func TestCreateAuthenticate(t *testing.T) {
+	k, st := newTestAPIKeys()
+
+	key, apiKey, err := k.CreateAPIKey(as(1), "ci", []string{domain.ScopeProfile, domain.ScopeProfile}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apiKey.Scopes) != 1 || apiKey.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected key %+v", apiKey)
+	}
+
+	prefix, ok := keyformat.KeyPrefix(key)
+	if !ok || prefix != apiKey.Prefix {
+		t.Fatalf("key %q does not carry prefix %q", key, apiKey.Prefix)
+	}
+	if string(st.keys[apiKey.ID].Hash) == key {
+		t.Fatal("the key is stored in plain text")
+	}
+
+	got, err := k.Authenticate(context.Background(), key)
+	if err != nil || got.ID != apiKey.ID {
+		t.Fatalf("Authenticate = %+v, %v", got, err)
+	}
+
+	// a key with the right prefix but another secret is forged
+	if _, err := k.Authenticate(context.Background(), keyformat.Prefix+prefix+"_forged"); !errors.Is(err, keyformat.ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey, got %v", err)
+	}
+	if _, err := k.Authenticate(context.Background(), "garbage"); !errors.Is(err, keyformat.ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey, got %v", err)
+	}
+
+	expired := st.keys[apiKey.ID]
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	st.keys[apiKey.ID] = expired
+	if _, err := k.Authenticate(context.Background(), key); !errors.Is(err, keyformat.ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey, got %v", err)
+	}
+}
+
+func TestCreateAPIKeyRejected(t *testing.T) {
+	k, _ := newTestAPIKeys()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		scopes    []string
+		expiresAt time.Time
+		err       error
+	}{
+		{name: "anonymous", ctx: context.Background(), scopes: []string{domain.ScopeProfile}, err: ErrUnauthenticated},
+		{name: "by an api key", ctx: principal.With(context.Background(), principal.Principal{UserID: 1, APIKeyID: 3}),
+			scopes: []string{domain.ScopeProfile}, err: ErrPermissionDenied},
+		{name: "no scopes", ctx: as(1), err: ErrInvalidScope},
+		{name: "unknown scope", ctx: as(1), scopes: []string{"root"}, err: ErrInvalidScope},
+		{name: "expired", ctx: as(1), scopes: []string{domain.ScopeProfile}, expiresAt: time.Now().Add(-time.Hour), err: ErrInvalidExpiry},
+		{name: "beyond max ttl", ctx: as(1), scopes: []string{domain.ScopeProfile}, expiresAt: time.Now().Add(48 * time.Hour), err: ErrInvalidExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := k.CreateAPIKey(tt.ctx, "ci", tt.scopes, tt.expiresAt); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	k, st := newTestAPIKeys()
+
+	key, apiKey, err := k.CreateAPIKey(as(1), "ci", []string{domain.ScopeSessions}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.RevokeAPIKey(as(2), apiKey.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if err := k.RevokeAPIKey(as(1), apiKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Authenticate(context.Background(), key); !errors.Is(err, keyformat.ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey, got %v", err)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditAPIKeyRevoke || last.Subject != domain.UserSubject(1) || last.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestRunRecordsUse(t *testing.T) {
+	k, st := newTestAPIKeys()
+
+	key, apiKey, err := k.CreateAPIKey(as(1), "ci", []string{domain.ScopeProfile}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Authenticate(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	k.mu.Lock()
+	_, used := k.used[apiKey.ID]
+	k.mu.Unlock()
+	if used {
+		t.Fatal("use recorded before the key was accepted")
+	}
+	k.Touch(apiKey.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		k.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		st.mu.Lock()
+		_, ok := st.touched[apiKey.ID]
+		st.mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("last use was not recorded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunCleanup(t *testing.T) {
+	k, st := newTestAPIKeys()
+
+	now := time.Now()
+	st.keys[1] = domain.APIKey{ID: 1, UserID: 1, ExpiresAt: now.Add(-31 * 24 * time.Hour)}
+	st.keys[2] = domain.APIKey{ID: 2, UserID: 1, ExpiresAt: now.Add(-time.Hour)}
+	st.keys[3] = domain.APIKey{ID: 3, UserID: 1, ExpiresAt: now.Add(time.Hour)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	k.RunCleanup(ctx)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.keys[1]; ok || len(st.keys) != 2 {
+		t.Fatalf("unexpected keys left %v", st.keys)
+	}
+}
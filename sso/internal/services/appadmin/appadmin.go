@@ -0,0 +1,119 @@
+package appadmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	App(ctx context.Context, appID int64) (domain.App, error)
+	UpdateApp(ctx context.Context, appID int64, update domain.AppUpdate) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrAppNotFound        = errors.New("app not found")
+	ErrInvalidMinACR      = errors.New("invalid min acr")
+	ErrInvalidCallbackURL = errors.New("invalid callback url")
+)
+
+var reasons = access.Reasons{
+	ErrAppNotFound:        "app_not_found",
+	ErrInvalidMinACR:      "invalid_min_acr",
+	ErrInvalidCallbackURL: "invalid_callback_url",
+}
+
+// AppAdmin lets admins configure the apps of their tenant.
+type AppAdmin struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+}
+
+// New returns new instance of the AppAdmin servic
+func New(log *slog.Logger, storage Storage, auditor Auditor) *AppAdmin {
+	return &AppAdmin{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+	}
+}
+
+// UpdateApp changes the fields of update that are set and returns the app.
+// MinACR has to be a level sessions can reach, see domain.MethodACR.
+// CallbackURLs have to be absolute http or https urls without a fragment.
+func (a *AppAdmin) UpdateApp(ctx context.Context, appID int64, update domain.AppUpdate) (app domain.App, err error) {
+	const op = "appadmin.UpdateApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	defer a.record(ctx, domain.AuditAppUpdate, appID, &err)
+
+	if p, ok := principal.FromContext(ctx); !ok || !p.Admin {
+		return domain.App{}, fmt.Errorf("%s: %w", op, access.ErrPermissionDenied)
+	}
+
+	if update.MinACR != nil && (*update.MinACR < 0 || *update.MinACR > domain.ACRMultiFactor) {
+		return domain.App{}, fmt.Errorf("%s: %w", op, ErrInvalidMinACR)
+	}
+
+	if update.CallbackURLs != nil {
+		for _, u := range *update.CallbackURLs {
+			if !validCallbackURL(u) {
+				return domain.App{}, fmt.Errorf("%s: %w", op, ErrInvalidCallbackURL)
+			}
+		}
+	}
+
+	if err := a.storage.UpdateApp(ctx, appID, update); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.App{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to update app", slog.Any("err", err))
+		return domain.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err = a.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.App{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return domain.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app updated", slog.Int64("app_id", appID), slog.Int("min_acr", app.MinACR))
+
+	return app, nil
+}
+
+// validCallbackURL reports whether users can be sent back to u, the code is
+// added to its query so it can not carry a fragment.
+func validCallbackURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	return (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != "" && parsed.Fragment == "" && parsed.User == nil
+}
+
+func (a *AppAdmin) record(ctx context.Context, eventType string, appID int64, err *error) {
+	access.Record(ctx, a.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: domain.AppSubject(appID),
+	}, *err, reasons)
+}
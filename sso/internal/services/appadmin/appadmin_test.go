@@ -0,0 +1,126 @@
+package appadmin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	apps   map[int64]domain.App
+	events []domain.AuditEvent
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int64) (domain.App, error) {
+	app, ok := f.apps[appID]
+	if !ok {
+		return domain.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (f *fakeStorage) UpdateApp(_ context.Context, appID int64, update domain.AppUpdate) error {
+	app, ok := f.apps[appID]
+	if !ok {
+		return storage.ErrAppNotFound
+	}
+	if update.MinACR != nil {
+		app.MinACR = *update.MinACR
+	}
+	if update.CallbackURLs != nil {
+		app.CallbackURLs = *update.CallbackURLs
+	}
+	f.apps[appID] = app
+
+	return nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestAppAdmin() (*AppAdmin, *fakeStorage) {
+	st := &fakeStorage{
+		apps: map[int64]domain.App{
+			5: {ID: 5, Name: "test"},
+		},
+	}
+
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st), st
+}
+
+func asAdmin() context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: 1, AppID: 5, Admin: true})
+}
+
+func TestUpdateApp(t *testing.T) {
+	a, st := newTestAppAdmin()
+
+	acr := domain.ACRMultiFactor
+	app, err := a.UpdateApp(asAdmin(), 5, domain.AppUpdate{MinACR: &acr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.MinACR != domain.ACRMultiFactor || st.apps[5].MinACR != domain.ACRMultiFactor {
+		t.Fatalf("min acr was not updated, got %+v", app)
+	}
+
+	urls := []string{"https://app.example.com/callback", "http://localhost:8080/callback"}
+	app, err = a.UpdateApp(asAdmin(), 5, domain.AppUpdate{CallbackURLs: &urls})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.CallbackURLs) != 2 || app.MinACR != domain.ACRMultiFactor {
+		t.Fatalf("callback urls were not updated, got %+v", app)
+	}
+
+	if len(st.events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d", len(st.events))
+	}
+	if e := st.events[0]; e.Type != domain.AuditAppUpdate || e.Result != domain.AuditSuccess || e.Subject != domain.AppSubject(5) || e.ActorID != 1 {
+		t.Fatalf("unexpected audit event %+v", e)
+	}
+}
+
+func TestUpdateAppRejected(t *testing.T) {
+	a, st := newTestAppAdmin()
+
+	user := principal.With(context.Background(), principal.Principal{UserID: 2, AppID: 5})
+	acr := domain.ACRMultiFactor
+	if _, err := a.UpdateApp(user, 5, domain.AppUpdate{MinACR: &acr}); !errors.Is(err, access.ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	invalid := domain.ACRMultiFactor + 1
+	if _, err := a.UpdateApp(asAdmin(), 5, domain.AppUpdate{MinACR: &invalid}); !errors.Is(err, ErrInvalidMinACR) {
+		t.Fatalf("expected ErrInvalidMinACR, got %v", err)
+	}
+
+	if _, err := a.UpdateApp(asAdmin(), 9, domain.AppUpdate{MinACR: &acr}); !errors.Is(err, ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got %v", err)
+	}
+
+	for _, u := range []string{"/callback", "ftp://app.example.com/callback", "https://app.example.com/callback#code", "https:///callback"} {
+		urls := []string{"https://app.example.com/callback", u}
+		if _, err := a.UpdateApp(asAdmin(), 5, domain.AppUpdate{CallbackURLs: &urls}); !errors.Is(err, ErrInvalidCallbackURL) {
+			t.Fatalf("%s: expected ErrInvalidCallbackURL, got %v", u, err)
+		}
+	}
+
+	if st.apps[5].MinACR != 0 || len(st.apps[5].CallbackURLs) != 0 {
+		t.Fatalf("app changed to %+v", st.apps[5])
+	}
+	for _, e := range st.events {
+		if e.Result != domain.AuditFailure {
+			t.Fatalf("unexpected audit event %+v", e)
+		}
+	}
+}
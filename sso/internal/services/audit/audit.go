@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+)
+
+type Storage interface {
+	SaveAuditEvent(ctx context.Context, event domain.AuditEvent) (int64, error)
+	AuditEvents(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEvent, error)
+	DeleteAuditEventsBefore(ctx context.Context, before time.Time) (int64, error)
+	SaveAuditCheckpoint(ctx context.Context, checkpoint domain.AuditCheckpoint) error
+	AuditCheckpoints(ctx context.Context) ([]domain.AuditCheckpoint, error)
+	AuditChainHeads(ctx context.Context) ([]domain.AuditEvent, error)
+	LastAuditCheckpointEventID(ctx context.Context) (int64, error)
+}
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrPermissionDenied = errors.New("only admins can list audit events")
+)
+
+const (
+	defaultPageSize   = 50
+	maxPageSize       = 500
+	retentionInterval = time.Hour
+	chainBatchSize    = 1000
+	// writes must not outlive a canceled request by much, but they should
+	// still happen when the caller gave up
+	recordTimeout = 5 * time.Second
+)
+
+type Audit struct {
+	log     *slog.Logger
+	storage Storage
+	cfg     config.Audit
+	key     ed25519.PrivateKey
+}
+
+// New returns new instance of the Audit servic. key signs the checkpoints of
+// the chain, without it no checkpoints are written.
+func New(log *slog.Logger, storage Storage, cfg config.Audit, key ed25519.PrivateKey) *Audit {
+	return &Audit{
+		log:     log,
+		storage: storage,
+		cfg:     cfg,
+		key:     key,
+	}
+}
+
+// Record stores event. The client address and user agent are taken from ctx
+// when the event does not carry them. Failures are only logged, an audit
+// outage must not fail authentication.
+func (a *Audit) Record(ctx context.Context, event domain.AuditEvent) {
+	const op = "audit.Record"
+
+	info := clientinfo.FromContext(ctx)
+	if event.IP == "" {
+		event.IP = info.IP
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = info.UserAgent
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+
+	if _, err := a.storage.SaveAuditEvent(ctx, event); err != nil {
+		a.log.Error("field to save audit event",
+			slog.String("op", op),
+			slog.String("type", event.Type),
+			slog.Any("err", err),
+		)
+	}
+}
+
+// List returns one page of events matching filter and the token of the next
+// page, empty on the last page. The caller has to be an admin.
+func (a *Audit) List(ctx context.Context, filter domain.AuditFilter, pageSize int, pageToken string) ([]domain.AuditEvent, string, error) {
+	const op = "audit.List"
+
+	if p, ok := principal.FromContext(ctx); !ok || !p.Admin {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	if pageToken != "" {
+		afterID, err := strconv.ParseInt(pageToken, 10, 64)
+		if err != nil || afterID <= 0 {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+		}
+		filter.AfterID = afterID
+	}
+
+	// one more row tells whether there is a next page
+	filter.Limit = pageSize + 1
+
+	events, err := a.storage.AuditEvents(ctx, filter)
+	if err != nil {
+		a.log.Error("field to list audit events", slog.String("op", op), slog.Any("err", err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		next = strconv.FormatInt(events[pageSize-1].ID, 10)
+	}
+
+	return events, next, nil
+}
+
+// RunRetention deletes events older than the configured retention every
+// hour until ctx is done.
+func (a *Audit) RunRetention(ctx context.Context) {
+	const op = "audit.RunRetention"
+
+	if a.cfg.Retention <= 0 {
+		return
+	}
+
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := a.storage.DeleteAuditEventsBefore(ctx, time.Now().Add(-a.cfg.Retention))
+		if err != nil {
+			log.Error("field to delete expired audit events", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("expired audit events deleted", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+)
+
+type fakeStorage struct {
+	events      []domain.AuditEvent
+	checkpoints []domain.AuditCheckpoint
+}
+
+func (f *fakeStorage) SaveAuditEvent(_ context.Context, event domain.AuditEvent) (int64, error) {
+	f.events = append(f.events, event)
+	return int64(len(f.events)), nil
+}
+
+func (f *fakeStorage) AuditEvents(context.Context, domain.AuditFilter) ([]domain.AuditEvent, error) {
+	return f.events, nil
+}
+
+func (f *fakeStorage) DeleteAuditEventsBefore(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStorage) SaveAuditCheckpoint(_ context.Context, cp domain.AuditCheckpoint) error {
+	f.checkpoints = append(f.checkpoints, cp)
+	return nil
+}
+
+func (f *fakeStorage) AuditCheckpoints(context.Context) ([]domain.AuditCheckpoint, error) {
+	return f.checkpoints, nil
+}
+
+func (f *fakeStorage) AuditChainHeads(context.Context) ([]domain.AuditEvent, error) {
+	heads := map[int64]domain.AuditEvent{}
+	for _, e := range f.events {
+		heads[e.TenantID] = e
+	}
+
+	var events []domain.AuditEvent
+	for _, e := range heads {
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func (f *fakeStorage) LastAuditCheckpointEventID(context.Context) (int64, error) {
+	var last int64
+	for _, cp := range f.checkpoints {
+		last = max(last, cp.EventID)
+	}
+
+	return last, nil
+}
+
+func TestListRequiresAdmin(t *testing.T) {
+	st := &fakeStorage{events: []domain.AuditEvent{{ID: 1, Type: domain.AuditLogin}}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Audit{}, nil)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{name: "anonymous", ctx: context.Background(), err: ErrPermissionDenied},
+		{name: "user", ctx: principal.With(context.Background(), principal.Principal{UserID: 2}), err: ErrPermissionDenied},
+		{name: "admin", ctx: principal.With(context.Background(), principal.Principal{UserID: 1, Admin: true})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, _, err := a.List(tt.ctx, domain.AuditFilter{}, 0, "")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+		})
+	}
+}
+
+func TestCheckpointSignsEveryTenant(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &fakeStorage{events: []domain.AuditEvent{
+		{ID: 1, TenantID: 1, Hash: "a"},
+		{ID: 2, TenantID: 2, Hash: "b"},
+		{ID: 3, TenantID: 1, Hash: "c"},
+	}}
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Audit{}, key)
+
+	if err := a.Checkpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.checkpoints) != 2 {
+		t.Fatalf("got %d checkpoints, want one per tenant", len(st.checkpoints))
+	}
+
+	// signed heads are not signed again
+	if err := a.Checkpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.checkpoints) != 2 {
+		t.Fatalf("got %d checkpoints after a second round, want 2", len(st.checkpoints))
+	}
+}
@@ -0,0 +1,245 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+)
+
+// RunCheckpoints signs the heads of the chains at the configured interval
+// until ctx is done. The head of the log is logged with every round so that
+// the log pipeline anchors it outside the database, see Head.
+func (a *Audit) RunCheckpoints(ctx context.Context) {
+	const op = "audit.RunCheckpoints"
+
+	if a.key == nil || a.cfg.CheckpointInterval <= 0 {
+		return
+	}
+
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(a.cfg.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := a.Checkpoint(ctx); err != nil {
+			log.Error("field to write audit checkpoint", slog.Any("err", err))
+			continue
+		}
+
+		head, err := a.Head(ctx)
+		if err != nil {
+			log.Error("field to get audit head", slog.Any("err", err))
+			continue
+		}
+		if head.EventID != 0 {
+			log.Info("audit chain head", slog.String("anchor", head.String()))
+		}
+	}
+}
+
+// Checkpoint signs the head of every tenant chain unless it is signed
+// already.
+func (a *Audit) Checkpoint(ctx context.Context) error {
+	const op = "audit.Checkpoint"
+
+	heads, err := a.storage.AuditChainHeads(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	last, err := a.storage.LastAuditCheckpointEventID(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, head := range heads {
+		if head.ID <= last {
+			continue
+		}
+
+		err = a.storage.SaveAuditCheckpoint(ctx, domain.AuditCheckpoint{
+			EventID:   head.ID,
+			Hash:      head.Hash,
+			Signature: auditchain.Sign(a.key, head.ID, head.Hash),
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// Head returns the newest event of the log as an anchor, a zero anchor when
+// the log is empty. Anchors kept outside the database let VerifyAuditChain
+// notice deleted events that no checkpoint covers.
+func (a *Audit) Head(ctx context.Context) (auditchain.Anchor, error) {
+	const op = "audit.Head"
+
+	head, err := a.storage.AuditEvents(tenant.Any(ctx), domain.AuditFilter{Limit: 1})
+	if err != nil {
+		return auditchain.Anchor{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(head) == 0 || head[0].Hash == "" {
+		return auditchain.Anchor{}, nil
+	}
+
+	return auditchain.Anchor{EventID: head[0].ID, Hash: head[0].Hash, CreatedAt: head[0].CreatedAt}, nil
+}
+
+// ChainReport is the outcome of VerifyAuditChain. Broken is the first link
+// that does not verify, nil when the chain is intact.
+type ChainReport struct {
+	Events      int
+	Legacy      int
+	Checkpoints int
+	Anchors     int
+	Signed      bool
+	Broken      *auditchain.BrokenLinkError
+}
+
+// VerifyAuditChain walks the whole chain and reports the first broken link.
+// Checkpoint signatures are only checked when the service has a key. The
+// events of anchors have to be there unless retention removed them.
+func (a *Audit) VerifyAuditChain(ctx context.Context, anchors ...auditchain.Anchor) (ChainReport, error) {
+	const op = "audit.VerifyAuditChain"
+
+	report := ChainReport{}
+
+	checkpoints, err := a.storage.AuditCheckpoints(ctx)
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var pub ed25519.PublicKey
+	if a.key != nil {
+		pub = a.key.Public().(ed25519.PublicKey)
+		report.Signed = true
+	}
+
+	v, err := auditchain.NewVerifier(pub, checkpoints)
+	if err == nil {
+		var horizon time.Time
+		if a.cfg.Retention > 0 {
+			horizon = time.Now().Add(-a.cfg.Retention)
+		}
+		v.Anchor(horizon, anchors...)
+
+		err = a.walk(tenant.Any(ctx), domain.AuditFilter{}, v.Add)
+	}
+	if err == nil {
+		err = v.Finish()
+	}
+
+	if v != nil {
+		report.Events, report.Legacy, report.Checkpoints, report.Anchors = v.Events, v.Legacy, v.Checkpoints, v.Anchors
+	}
+
+	if errors.As(err, &report.Broken) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return report, nil
+}
+
+type exportRecord struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"event_type"`
+	ActorID   int64     `json:"actor_id,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	AppID     int64     `json:"app_id,omitempty"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+	Chain     bool      `json:"tenant_chain"`
+	PIIDigest string    `json:"pii_digest,omitempty"`
+	Tombstone int64     `json:"tombstone_id,omitempty"`
+	TenantID  int64     `json:"tenant_id"`
+	Hashed    bool      `json:"tenant_hashed"`
+}
+
+// Export writes the events created in [since, until) to w as JSON lines,
+// oldest first. Zero times leave the range open. The events of every tenant
+// are written so that the chain can be verified. It returns the number of
+// events written.
+func (a *Audit) Export(ctx context.Context, w io.Writer, since, until time.Time) (int, error) {
+	const op = "audit.Export"
+
+	enc := json.NewEncoder(w)
+	n := 0
+
+	err := a.walk(tenant.Any(ctx), domain.AuditFilter{Since: since, Until: until}, func(e domain.AuditEvent) error {
+		n++
+		return enc.Encode(exportRecord{
+			ID:        e.ID,
+			Type:      e.Type,
+			ActorID:   e.ActorID,
+			Subject:   e.Subject,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			AppID:     e.AppID,
+			Result:    e.Result,
+			Reason:    e.Reason,
+			CreatedAt: e.CreatedAt.UTC(),
+			PrevHash:  e.PrevHash,
+			Hash:      e.Hash,
+			Chain:     e.TenantChain,
+			PIIDigest: e.PIIDigest,
+			Tombstone: e.TombstoneID,
+			TenantID:  e.TenantID,
+			Hashed:    e.TenantHashed,
+		})
+	})
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// walk calls fn for every event matching filter in id order.
+func (a *Audit) walk(ctx context.Context, filter domain.AuditFilter, fn func(domain.AuditEvent) error) error {
+	filter.Ascending = true
+	filter.Limit = chainBatchSize
+
+	for {
+		events, err := a.storage.AuditEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < chainBatchSize {
+			return nil
+		}
+		filter.AfterID = events[len(events)-1].ID
+	}
+}
@@ -7,35 +7,102 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
 	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/breach"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
 	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/seal"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
 	"github.com/goggle-source/grpc-servic/sso/internal/storage"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// UserStorage writes event to the outbox together with the change.
 type UserStorage interface {
 	SaveUser(
 		ctx context.Context,
 		email string,
 		password []byte,
+		event domain.Event,
 	) (uid int64, err error)
+	UpdatePassword(
+		ctx context.Context,
+		userID int64,
+		oldHash []byte,
+		newHash []byte,
+		keep int,
+		event domain.Event,
+	) error
+	UpdateUser(ctx context.Context, userID int64, update domain.UserUpdate, event domain.Event) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (domain.User, error)
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	PasswordHistory(ctx context.Context, userID int64, limit int) ([][]byte, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	Profile(ctx context.Context, userID int64) (domain.Profile, error)
+	Memberships(ctx context.Context, userID int64) ([]domain.Member, error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int64) (domain.App, error)
 }
 
+type PasswordValidator interface {
+	Validate(appID int64, email string, password string) error
+}
+
+type BreachChecker interface {
+	Check(password string) (breach.Verdict, error)
+}
+
+type LoginGuard interface {
+	Check(ctx context.Context, email string, ip string) error
+	Failed(ctx context.Context, email string, ip string) (*lockout.LockedError, error)
+	Succeeded(ctx context.Context, email string) error
+	Unlock(ctx context.Context, email string) error
+}
+
+// RiskAssessor scores a login before its tokens are issued.
+type RiskAssessor interface {
+	Assess(ctx context.Context, user domain.User, appID int64) (domain.RiskDecision, error)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg notifier.Message) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
 type Auth struct {
 	log          *slog.Logger
 	userSaver    UserStorage
 	userProvider UserProvider
 	appProvider  AppProvider
-	tokenTTL     time.Duration
+	passwords    PasswordValidator
+	breaches     BreachChecker
+	rotation     config.PasswordRotation
+	guard        LoginGuard
+	risk         RiskAssessor
+	notifier     Notifier
+	auditor      Auditor
+	emailChanges EmailChangeStorage
+	sessions     SessionStorage
+	factors      FactorStorage
+	otpKey       *seal.Key
+	directory    Directory
+	hardened     bool
+	dummyHash    []byte
+	// compareHash is bcrypt.CompareHashAndPassword, tests watch it.
+	compareHash func(hash []byte, password []byte) error
+	tokenTTL    time.Duration
+	refreshTTL  time.Duration
 }
 
 var (
@@ -43,26 +110,95 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentails")
 	ErrInvalidAppID       = errors.New("invalid app id")
 	ErrAppNotFound        = errors.New("app not found")
+	ErrPasswordBreached   = errors.New("password found in a data breach")
+	ErrPasswordReused     = errors.New("password was used recently")
+	ErrPasswordExpired    = errors.New("password expired")
+	ErrInvalidChangeToken = errors.New("invalid change token")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrLoginBlocked       = errors.New("login blocked as suspicious")
 )
 
+const defaultChangeTokenTTL = 10 * time.Minute
+
+// PasswordExpiredError is returned by Login instead of an access token when
+// the password is older than allowed. ChangeToken lets the user call
+// ChangePassword without logging in again.
+type PasswordExpiredError struct {
+	ChangeToken string
+}
+
+func (e *PasswordExpiredError) Error() string { return ErrPasswordExpired.Error() }
+
+func (e *PasswordExpiredError) Unwrap() error { return ErrPasswordExpired }
+
+// Options are the dependencies and settings of Auth.
+type Options struct {
+	UserSaver    UserStorage
+	UserProvider UserProvider
+	AppProvider  AppProvider
+	Passwords    PasswordValidator
+	Breaches     BreachChecker
+	Rotation     config.PasswordRotation
+	Guard        LoginGuard
+	Risk         RiskAssessor
+	Notifier     Notifier
+	Auditor      Auditor
+	EmailChanges EmailChangeStorage
+	Sessions     SessionStorage
+	Factors      FactorStorage
+	// OTPKey seals the TOTP secrets in FactorStorage, nil stores them in
+	// the clear.
+	OTPKey     *seal.Key
+	Directory  Directory
+	Hardened   bool
+	TokenTTL   time.Duration
+	RefreshTTL time.Duration
+}
+
 // New returns new instance of the Auth servic
-func New(
-	log *slog.Logger,
-	userSaver UserStorage,
-	userProvider UserProvider,
-	appProvider AppProvider,
-	tokenTTL time.Duration,
-) *Auth {
+func New(log *slog.Logger, opts Options) *Auth {
+	if opts.Rotation.ChangeTokenTTL <= 0 {
+		opts.Rotation.ChangeTokenTTL = defaultChangeTokenTTL
+	}
+	if opts.RefreshTTL <= 0 {
+		opts.RefreshTTL = defaultRefreshTTL
+	}
+
+	// Compared against when the user does not exist so that a login takes
+	// as long as one with a wrong password. The cost must match real hashes.
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+
 	return &Auth{
 		log:          log,
-		userSaver:    userSaver,
-		userProvider: userProvider,
-		appProvider:  appProvider,
-		tokenTTL:     tokenTTL,
+		userSaver:    opts.UserSaver,
+		userProvider: opts.UserProvider,
+		appProvider:  opts.AppProvider,
+		passwords:    opts.Passwords,
+		breaches:     opts.Breaches,
+		rotation:     opts.Rotation,
+		guard:        opts.Guard,
+		risk:         opts.Risk,
+		notifier:     opts.Notifier,
+		auditor:      opts.Auditor,
+		emailChanges: opts.EmailChanges,
+		sessions:     opts.Sessions,
+		factors:      opts.Factors,
+		otpKey:       opts.OTPKey,
+		directory:    opts.Directory,
+		hardened:     opts.Hardened,
+		dummyHash:    dummyHash,
+		compareHash:  bcrypt.CompareHashAndPassword,
+		tokenTTL:     opts.TokenTTL,
+		refreshTTL:   opts.RefreshTTL,
 	}
 }
 
-func (a *Auth) Login(ctx context.Context, email string, password string, appID int64) (token string, err error) {
+// Login starts a session on the device of the caller and returns its tokens.
+func (a *Auth) Login(ctx context.Context, email string, password string, appID int64) (tokens domain.Tokens, err error) {
 	const op = "auth.Login"
 
 	log := a.log.With(
@@ -71,50 +207,145 @@ func (a *Auth) Login(ctx context.Context, email string, password string, appID i
 
 	log.Info("start is login user")
 
-	user, err := a.userProvider.User(ctx, email)
-	if err != nil {
+	var uid int64
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditLogin, ActorID: uid, Subject: email, AppID: appID}, err)
+	}()
 
-		if errors.Is(err, storage.ErrUserNotFound) {
+	ip := clientinfo.FromContext(ctx).IP
 
-			log.Error("user not found", slog.Any("err", err))
+	if err := a.guard.Check(ctx, email, ip); err != nil {
+		log.Warn("login is locked", slog.String("ip", ip), slog.Any("err", err))
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
-		}
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
 
-		log.Error("field to get user", slog.Any("err", err))
+	user, fromDirectory, err := a.authenticate(ctx, log, email, password)
+	uid = user.ID
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, a.loginFailed(ctx, log, email, ip))
+		}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
-		log.Error("invalid credentails", slog.Any("err", err))
+	if err := a.guard.Succeeded(ctx, email); err != nil {
+		log.Error("field to reset login failures", slog.Any("err", err))
+	}
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	// checked after the password so that it does not reveal the account
+	if user.Disabled() {
+		log.Warn("disabled user tried to log in", slog.Int64("uid", user.ID))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
 	}
 
 	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
 		if errors.Is(err, storage.ErrAppNotFound) {
 			log.Error("app is not found", slog.Any("err", err))
-			return "", fmt.Errorf("%s: %w", op, ErrAppNotFound)
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
 		}
 		log.Error("field to get app id", slog.Any("err", err))
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the directory owns the passwords of its users
+	if !fromDirectory && a.passwordExpired(user) {
+		changeToken, err := jwtToken.GetChangeToken(user, app, a.rotation.ChangeTokenTTL)
+		if err != nil {
+			log.Error("field get change token", slog.Any("err", err))
+
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("password expired", slog.Int64("uid", user.ID))
+
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, &PasswordExpiredError{ChangeToken: changeToken})
+	}
+
+	decision, err := a.risk.Assess(ctx, user, app.ID)
+	if err != nil {
+		// a failing assessment must not lock every user out
+		log.Error("field to assess login risk", slog.Any("err", err))
+		decision = domain.RiskDecision{Action: domain.RiskAllow}
+	}
+
+	if decision.Action == domain.RiskBlock {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrLoginBlocked)
 	}
 
-	token, err = jwtToken.GetToken(user, app, a.tokenTTL)
+	tokens, err = a.startSession(ctx, user, app, domain.AMRPassword, decision.Action == domain.RiskStepUp)
+	if err != nil {
+		log.Error("field to start session", slog.Any("err", err))
+
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session started", slog.Int64("uid", user.ID), slog.Int64("sid", tokens.SessionID))
+
+	return tokens, nil
+
+}
+
+// loginFailed counts the failure and returns the error for the caller, the
+// lock when this failure started one.
+func (a *Auth) loginFailed(ctx context.Context, log *slog.Logger, email string, ip string) error {
+	locked, err := a.guard.Failed(ctx, email, ip)
 	if err != nil {
-		log.Error("field get JWT token", slog.Any("err", err))
+		log.Error("field to record login failure", slog.Any("err", err))
+	}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+	if locked != nil {
+		return locked
 	}
 
-	return token, nil
+	return ErrInvalidCredentials
+}
 
+// UnlockUser lifts a lockout of the user started by failed logins.
+func (a *Auth) UnlockUser(ctx context.Context, userID int64) (err error) {
+	const op = "auth.UnlockUser"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	defer func() {
+		event := domain.AuditEvent{Type: domain.AuditUserUnlock, Subject: domain.UserSubject(userID)}
+		if p, ok := principal.FromContext(ctx); ok {
+			event.ActorID, event.AppID = p.UserID, p.AppID
+		}
+		a.record(ctx, event, err)
+	}()
+
+	log.Info("unlocking user", slog.Int64("uid", userID))
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user is not found", slog.Any("err", err))
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.guard.Unlock(ctx, user.Email); err != nil {
+		log.Error("field to unlock user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
 }
 
-func (a *Auth) Register(ctx context.Context, email string, password string) (userID int64, err error) {
+// Register creates a new user. appID selects the password policy and may be
+// zero to use the deployment policy.
+//
+// In hardened mode Register answers 0 and no error both for a new and for a
+// taken email, the outcome is sent to the address instead.
+func (a *Auth) Register(ctx context.Context, email string, password string, appID int64) (userID int64, err error) {
 	const op = "auth.Register"
 
 	log := a.log.With(
@@ -123,6 +354,27 @@ func (a *Auth) Register(ctx context.Context, email string, password string) (use
 
 	log.Info("register user")
 
+	// outcome and created are what gets audited, hardened mode hides them
+	// from the caller
+	var (
+		outcome error
+		created int64
+	)
+	defer func() {
+		if outcome == nil {
+			outcome = err
+		}
+		if created == 0 {
+			created = userID
+		}
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditRegister, ActorID: created, Subject: email, AppID: appID}, outcome)
+	}()
+
+	if err := a.checkPassword(ctx, log, appID, email, password); err != nil {
+		log.Warn("password rejected", slog.Any("err", err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 
 	if err != nil {
@@ -130,10 +382,25 @@ func (a *Auth) Register(ctx context.Context, email string, password string) (use
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	id, err := a.userSaver.SaveUser(ctx, email, passwordHash)
+	id, err := a.userSaver.SaveUser(ctx, email, passwordHash, domain.Event{
+		Type:  domain.EventUserRegistered,
+		AppID: appID,
+		Email: email,
+	})
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Error("user alredy exists")
+
+			if a.hardened {
+				outcome = ErrUserExists
+				a.notify(ctx, log, notifier.Message{
+					To:      email,
+					Subject: "Registration attempt",
+					Body:    "Someone tried to create an account with this email address. You already have an account, if this was you simply log in.",
+				})
+				return 0, nil
+			}
+
 			return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
 		}
 		log.Error("field to save user", slog.Any("err", err))
@@ -142,6 +409,17 @@ func (a *Auth) Register(ctx context.Context, email string, password string) (use
 
 	log.Info("good")
 
+	if a.hardened {
+		// the caller must not learn whether the account was created
+		created = id
+		a.notify(ctx, log, notifier.Message{
+			To:      email,
+			Subject: "Welcome",
+			Body:    "Your account has been created, you can now log in.",
+		})
+		return 0, nil
+	}
+
 	return id, nil
 
 }
@@ -155,11 +433,15 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err err
 
 	log.Info("checking if user is admin")
 
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditAdminCheck, ActorID: userID}, err)
+	}()
+
 	isAdmin, err = a.userProvider.IsAdmin(ctx, userID)
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
+		if errors.Is(err, storage.ErrUserNotFound) {
 			log.Warn("user is not found", slog.Any("err", err))
-			return false, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
 
 		log.Error("field checking if user is Admin", slog.Any("err", err))
@@ -170,3 +452,39 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err err
 
 	return isAdmin, nil
 }
+
+// spendDummyHash compares password against a hash of no account, so that
+// a request for an unknown user takes as long as one with a wrong password.
+func (a *Auth) spendDummyHash(password string) {
+	_ = a.compareHash(a.dummyHash, []byte(password))
+}
+
+// notify hands msg to the notifier, a notifier.Queue sends it in the
+// background so that the time it takes does not show in the response.
+func (a *Auth) notify(ctx context.Context, log *slog.Logger, msg notifier.Message) {
+	if err := a.notifier.Notify(ctx, msg); err != nil {
+		log.Error("field to send notification", slog.Any("err", err))
+	}
+}
+
+// record audits event with the outcome err.
+func (a *Auth) record(ctx context.Context, event domain.AuditEvent, err error) {
+	event.Result = domain.AuditSuccess
+	if err != nil {
+		event.Result = domain.AuditFailure
+		event.Reason = rootCause(err).Error()
+	}
+
+	a.auditor.Record(ctx, event)
+}
+
+// rootCause strips the op prefixes added while the error went up the stack.
+func rootCause(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
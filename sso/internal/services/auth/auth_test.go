@@ -0,0 +1,561 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/breach"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/warning"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeStorage keeps users in memory and implements every storage interface
+// of the service.
+type fakeStorage struct {
+	mu      sync.Mutex
+	users   map[string]domain.User
+	apps    map[int64]domain.App
+	nextID  int64
+	events  []domain.AuditEvent
+	changes []domain.EmailChange
+	// sessions are keyed by id, refresh maps a token hash to its session
+	// and whether it was used.
+	sessions map[int64]domain.Session
+	refresh  map[string]*fakeRefresh
+	otp      map[int64]domain.OTPFactor
+	admins   map[int64]bool
+}
+
+type fakeRefresh struct {
+	sessionID int64
+	used      bool
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		users:    make(map[string]domain.User),
+		apps:     map[int64]domain.App{1: {ID: 1, Name: "test", Secret: "secret_key"}},
+		sessions: make(map[int64]domain.Session),
+		refresh:  make(map[string]*fakeRefresh),
+		otp:      make(map[int64]domain.OTPFactor),
+		admins:   make(map[int64]bool),
+	}
+}
+
+func (f *fakeStorage) SaveUser(_ context.Context, email string, password []byte, _ domain.Event) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[email]; ok {
+		return 0, storage.ErrUserExists
+	}
+	f.nextID++
+	f.users[email] = domain.User{ID: f.nextID, Email: email, PasswordHash: password, PasswordChangedAt: time.Now()}
+
+	return f.nextID, nil
+}
+
+func (f *fakeStorage) UpdatePassword(_ context.Context, userID int64, _ []byte, newHash []byte, _ int, _ domain.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for email, u := range f.users {
+		if u.ID == userID {
+			u.PasswordHash = newHash
+			f.users[email] = u
+			return nil
+		}
+	}
+
+	return storage.ErrUserNotFound
+}
+
+func (f *fakeStorage) User(_ context.Context, email string) (domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[email]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID {
+			return u, nil
+		}
+	}
+
+	return domain.User{}, storage.ErrUserNotFound
+}
+
+func (f *fakeStorage) SaveEmailChange(_ context.Context, change domain.EmailChange) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.changes = append(f.changes, change)
+
+	return nil
+}
+
+func (f *fakeStorage) ConfirmEmailChange(_ context.Context, tokenHash []byte, _ domain.Event) (string, domain.EmailChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, change := range f.changes {
+		if !bytes.Equal(change.TokenHash, tokenHash) {
+			continue
+		}
+		f.changes = append(f.changes[:i], f.changes[i+1:]...)
+
+		if _, ok := f.users[change.NewEmail]; ok {
+			return "", domain.EmailChange{}, storage.ErrUserExists
+		}
+		for email, u := range f.users {
+			if u.ID == change.UserID {
+				delete(f.users, email)
+				u.Email = change.NewEmail
+				f.users[u.Email] = u
+				return email, change, nil
+			}
+		}
+		return "", domain.EmailChange{}, storage.ErrUserNotFound
+	}
+
+	return "", domain.EmailChange{}, storage.ErrEmailChangeNotFound
+}
+
+func (f *fakeStorage) CreateSession(_ context.Context, session domain.Session, refresh domain.RefreshToken) (domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session.ID = int64(len(f.sessions) + 1)
+	session.ExpiresAt = refresh.ExpiresAt
+	f.sessions[session.ID] = session
+	f.refresh[string(refresh.Hash)] = &fakeRefresh{sessionID: session.ID}
+
+	return session, nil
+}
+
+func (f *fakeStorage) RefreshTokenSession(_ context.Context, hash []byte) (domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.refresh[string(hash)]
+	if !ok {
+		return domain.Session{}, storage.ErrRefreshTokenInvalid
+	}
+	session, ok := f.sessions[token.sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrRefreshTokenInvalid
+	}
+
+	return session, nil
+}
+
+func (f *fakeStorage) RotateRefreshToken(_ context.Context, oldHash []byte, next domain.RefreshToken) (domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old, ok := f.refresh[string(oldHash)]
+	if !ok {
+		return domain.Session{}, storage.ErrRefreshTokenInvalid
+	}
+	session, ok := f.sessions[old.sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrRefreshTokenInvalid
+	}
+	if old.used {
+		delete(f.sessions, old.sessionID)
+		return domain.Session{}, storage.ErrRefreshTokenReused
+	}
+
+	old.used = true
+	f.refresh[string(next.Hash)] = &fakeRefresh{sessionID: session.ID}
+
+	return session, nil
+}
+
+func (f *fakeStorage) Session(_ context.Context, sessionID int64) (domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+func (f *fakeStorage) StepUpSession(_ context.Context, sessionID int64, acr int, method string) (domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrSessionNotFound
+	}
+	session.ACR = max(session.ACR, acr)
+	if !slices.Contains(session.AMR, method) {
+		session.AMR = append(slices.Clone(session.AMR), method)
+	}
+	session.StepUpRequired = false
+	f.sessions[sessionID] = session
+
+	return session, nil
+}
+
+func (f *fakeStorage) SaveOTPFactor(_ context.Context, factor domain.OTPFactor) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.otp[factor.UserID].Confirmed() {
+		return storage.ErrOTPFactorExists
+	}
+	f.otp[factor.UserID] = factor
+
+	return nil
+}
+
+func (f *fakeStorage) OTPFactor(_ context.Context, userID int64) (domain.OTPFactor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	factor, ok := f.otp[userID]
+	if !ok {
+		return domain.OTPFactor{}, storage.ErrOTPFactorNotFound
+	}
+
+	return factor, nil
+}
+
+func (f *fakeStorage) UseOTPStep(_ context.Context, userID int64, step int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	factor := f.otp[userID]
+	if step <= factor.LastStep {
+		return storage.ErrOTPCodeUsed
+	}
+	factor.LastStep = step
+	if !factor.Confirmed() {
+		factor.ConfirmedAt = time.Now()
+	}
+	f.otp[userID] = factor
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteOTPFactor(_ context.Context, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.otp[userID]; !ok {
+		return storage.ErrOTPFactorNotFound
+	}
+	delete(f.otp, userID)
+
+	return nil
+}
+
+func (f *fakeStorage) Profile(_ context.Context, userID int64) (domain.Profile, error) {
+	return domain.Profile{UserID: userID, DisplayName: "Jonn"}, nil
+}
+
+func (f *fakeStorage) Memberships(_ context.Context, userID int64) ([]domain.Member, error) {
+	return []domain.Member{{OrgID: 3, UserID: userID, Role: domain.OrgRoleAdmin, Teams: []string{"backend"}}}, nil
+}
+
+func (f *fakeStorage) PasswordHistory(context.Context, int64, int) ([][]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) UpdateUser(_ context.Context, userID int64, update domain.UserUpdate, _ domain.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if update.IsAdmin != nil {
+		f.admins[userID] = *update.IsAdmin
+	}
+	if update.Directory != nil {
+		for email, u := range f.users {
+			if u.ID == userID {
+				u.Directory = *update.Directory
+				f.users[email] = u
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.admins[userID], nil
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int64) (domain.App, error) {
+	app, ok := f.apps[appID]
+	if !ok {
+		return domain.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, event)
+}
+
+type allowAll struct{}
+
+func (allowAll) Validate(int64, string, string) error { return nil }
+
+func (allowAll) Check(string) (breach.Verdict, error) { return breach.VerdictNone, nil }
+
+type noGuard struct{}
+
+func (noGuard) Check(context.Context, string, string) error { return nil }
+
+func (noGuard) Failed(context.Context, string, string) (*lockout.LockedError, error) {
+	return nil, nil
+}
+
+func (noGuard) Succeeded(context.Context, string) error { return nil }
+
+func (noGuard) Unlock(context.Context, string) error { return nil }
+
+// countingGuard locks an email after limit failures.
+type countingGuard struct {
+	limit    int
+	failures map[string]int
+}
+
+func newCountingGuard(limit int) *countingGuard {
+	return &countingGuard{limit: limit, failures: make(map[string]int)}
+}
+
+func (g *countingGuard) Check(_ context.Context, email string, _ string) error {
+	if g.failures[email] >= g.limit {
+		return &lockout.LockedError{RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (g *countingGuard) Failed(_ context.Context, email string, _ string) (*lockout.LockedError, error) {
+	g.failures[email]++
+	return nil, nil
+}
+
+func (g *countingGuard) Succeeded(_ context.Context, email string) error {
+	delete(g.failures, email)
+	return nil
+}
+
+func (g *countingGuard) Unlock(_ context.Context, email string) error {
+	delete(g.failures, email)
+	return nil
+}
+
+// fakeRisk answers every assessment with decision.
+type fakeRisk struct {
+	decision domain.RiskDecision
+}
+
+func (f *fakeRisk) Assess(context.Context, domain.User, int64) (domain.RiskDecision, error) {
+	return f.decision, nil
+}
+
+type fakeNotifier struct {
+	sent chan notifier.Message
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+func newTestAuth(t *testing.T, hardened bool) (*Auth, *fakeStorage, *fakeNotifier) {
+	t.Helper()
+
+	st := newFakeStorage()
+	n := &fakeNotifier{sent: make(chan notifier.Message, 16)}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a := New(log, Options{
+		UserSaver:    st,
+		UserProvider: st,
+		AppProvider:  st,
+		Passwords:    allowAll{},
+		Breaches:     allowAll{},
+		Guard:        noGuard{},
+		Risk:         &fakeRisk{decision: domain.RiskDecision{Action: domain.RiskAllow}},
+		Notifier:     n,
+		Auditor:      st,
+		EmailChanges: st,
+		Sessions:     st,
+		Factors:      st,
+		Hardened:     hardened,
+		TokenTTL:     time.Hour,
+		RefreshTTL:   time.Hour,
+	})
+
+	return a, st, n
+}
+
+func mustHash(t *testing.T, password string) []byte {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestAuditEvents(t *testing.T) {
+	a, st, _ := newTestAuth(t, true)
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	if _, err := a.Register(ctx, "jonn@gmail.com", "Other-Password-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Wrong-Password-1", 1); err == nil {
+		t.Fatal("expected login to fail")
+	}
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []domain.AuditEvent{
+		{Type: domain.AuditRegister, Subject: "jonn@gmail.com", AppID: 1, Result: domain.AuditFailure, Reason: ErrUserExists.Error()},
+		{Type: domain.AuditLogin, ActorID: 7, Subject: "jonn@gmail.com", AppID: 1, Result: domain.AuditFailure, Reason: ErrInvalidCredentials.Error()},
+		{Type: domain.AuditLogin, ActorID: 7, Subject: "jonn@gmail.com", AppID: 1, Result: domain.AuditSuccess},
+	}
+
+	if len(st.events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), st.events)
+	}
+	for i, event := range expected {
+		if st.events[i] != event {
+			t.Errorf("event %d: expected %+v, got %+v", i, event, st.events[i])
+		}
+	}
+}
+
+// warnAll finds every password in the corpus while the corpus only warns.
+type warnAll struct{}
+
+func (warnAll) Check(string) (breach.Verdict, error) { return breach.VerdictWarn, nil }
+
+func TestRegisterBreachWarning(t *testing.T) {
+	a, _, _ := newTestAuth(t, false)
+	a.breaches = warnAll{}
+	ctx := warning.With(context.Background())
+
+	if _, err := a.Register(ctx, "jonn@gmail.com", "Correct-Password-1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := warning.FromContext(ctx); !slices.Equal(got, []string{warning.PasswordBreached}) {
+		t.Fatalf("warnings = %v", got)
+	}
+}
+
+func TestLoginDisabledUser(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now(), Status: domain.UserStatusDisabled}
+
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1); !errors.Is(err, ErrUserDisabled) {
+		t.Fatalf("expected ErrUserDisabled, got %v", err)
+	}
+
+	// a wrong password must not reveal that the account is disabled
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Wrong-Password-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestLoginProfileClaims(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	app := st.apps[1]
+	app.ClaimAttributes = []string{domain.ProfileDisplayName}
+	st.apps[1] = app
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokens.AccessToken, claims, func(*jwt.Token) (any, error) { return []byte(app.Secret), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if claims["name"] != "Jonn" {
+		t.Errorf("name = %v", claims["name"])
+	}
+	if _, ok := claims["orgs"]; ok {
+		t.Error("orgs claim in a token of an app that is not org-scoped")
+	}
+}
+
+func TestLoginOrgClaims(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	app := st.apps[1]
+	app.OrgScoped = true
+	st.apps[1] = app
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokens.AccessToken, claims, func(*jwt.Token) (any, error) { return []byte(app.Secret), nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	orgs, ok := claims["orgs"].([]any)
+	if !ok || len(orgs) != 1 {
+		t.Fatalf("orgs = %v", claims["orgs"])
+	}
+	org := orgs[0].(map[string]any)
+	if org["id"] != float64(3) || org["role"] != domain.OrgRoleAdmin || len(org["teams"].([]any)) != 1 {
+		t.Errorf("unexpected org claim %v", org)
+	}
+}
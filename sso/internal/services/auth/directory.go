@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator checks passwords against an external directory such as
+// LDAP. It returns directory.ErrUserNotFound for emails it does not know,
+// directory.ErrInvalidCredentials for a wrong password and
+// directory.ErrUnavailable when it can not be reached. Lookup returns the
+// entry without a password, from a cache when it has one.
+type Authenticator interface {
+	Authenticate(ctx context.Context, email string, password string) (domain.DirectoryUser, error)
+	Lookup(ctx context.Context, email string) (domain.DirectoryUser, error)
+}
+
+// Directory is the external directory Login consults, a nil Authenticator
+// turns it off.
+type Directory struct {
+	Authenticator Authenticator
+	Config        config.Directory
+}
+
+// serves reports whether the directory authenticates the tenant of ctx.
+func (d Directory) serves(ctx context.Context) bool {
+	if d.Authenticator == nil {
+		return false
+	}
+
+	tenantID := d.Config.TenantID
+	if tenantID == 0 {
+		tenantID = tenant.DefaultID
+	}
+
+	return tenantID == tenant.ID(ctx)
+}
+
+// managesAdmins reports whether a directory group grants domain.RoleAdmin.
+// Only then the directory decides who is an admin, otherwise admins are
+// still granted through UserAdmin.
+func (d Directory) managesAdmins() bool {
+	return slices.ContainsFunc(d.Config.GroupRoles, func(g config.GroupRole) bool {
+		return g.Role == domain.RoleAdmin
+	})
+}
+
+// authenticate checks the password of email, against the directory when it
+// serves the tenant of ctx and against the local hash otherwise. The user is
+// returned with ErrInvalidCredentials too when it is known locally.
+//
+// While the directory is unavailable users that never logged in through it
+// still log in with their local password, unless the directory is the only
+// source of passwords.
+func (a *Auth) authenticate(ctx context.Context, log *slog.Logger, email string, password string) (user domain.User, fromDirectory bool, err error) {
+	var unavailable error
+	if a.directory.serves(ctx) {
+		user, err := a.directoryLogin(ctx, log, email, password)
+		switch {
+		case err == nil:
+			return user, true, nil
+		case errors.Is(err, directory.ErrUnavailable) && a.directory.Config.Mode != domain.DirectoryOnly:
+			unavailable = err
+		case !errors.Is(err, directory.ErrUserNotFound):
+			return domain.User{}, false, err
+		case a.directory.Config.Mode == domain.DirectoryOnly:
+			log.Warn("user not found in the directory")
+			if a.hardened {
+				a.spendDummyHash(password)
+			}
+			return domain.User{}, false, ErrInvalidCredentials
+		}
+		// unknown to the directory, a local account such as a break-glass admin
+	}
+
+	user, err = a.userProvider.User(ctx, email)
+	if err != nil {
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+
+			log.Error("user not found", slog.Any("err", err))
+
+			if a.hardened {
+				a.spendDummyHash(password)
+			}
+
+			return domain.User{}, false, ErrInvalidCredentials
+		}
+
+		log.Error("field to get user", slog.Any("err", err))
+
+		return domain.User{}, false, err
+	}
+
+	// the directory checks the passwords of its users, their local one is
+	// never used
+	if user.Directory && a.directory.serves(ctx) {
+		if unavailable != nil {
+			return domain.User{}, false, unavailable
+		}
+
+		log.Warn("directory user is not in the directory", slog.Int64("uid", user.ID))
+		if a.hardened {
+			a.spendDummyHash(password)
+		}
+
+		return user, false, ErrInvalidCredentials
+	}
+	if unavailable != nil {
+		log.Warn("directory is unavailable, checking the local password", slog.Any("err", unavailable))
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		log.Error("invalid credentails", slog.Any("err", err))
+
+		return user, false, ErrInvalidCredentials
+	}
+
+	return user, false, nil
+}
+
+// directoryLogin authenticates email against the directory and returns the
+// local user of the entry. Users log in without registering, the first
+// login creates them. The admin flag follows the groups of the entry.
+func (a *Auth) directoryLogin(ctx context.Context, log *slog.Logger, email string, password string) (domain.User, error) {
+	entry, err := a.directory.Authenticator.Authenticate(ctx, email, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, directory.ErrUserNotFound):
+			return domain.User{}, err
+		case errors.Is(err, directory.ErrInvalidCredentials):
+			log.Warn("invalid directory credentails")
+			return domain.User{}, ErrInvalidCredentials
+		}
+		log.Error("field to authenticate against the directory", slog.Any("err", err))
+		return domain.User{}, err
+	}
+
+	user, err := a.userProvider.User(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		user, err = a.provisionDirectoryUser(ctx, email)
+	}
+	if err != nil {
+		log.Error("field to get directory user", slog.Any("err", err))
+		return domain.User{}, err
+	}
+
+	if !user.Directory {
+		marked := true
+		if err := a.userSaver.UpdateUser(ctx, user.ID, domain.UserUpdate{Directory: &marked}, domain.Event{}); err != nil {
+			log.Error("field to mark directory user", slog.Any("err", err))
+			return domain.User{}, err
+		}
+		user.Directory = true
+	}
+
+	if err := a.syncRoles(ctx, log, user.ID, entry); err != nil {
+		return domain.User{}, err
+	}
+
+	return user, nil
+}
+
+// directoryRefresh checks that the entry of user is still in the directory
+// before its session is refreshed and syncs the admin flag with its groups.
+// The entry is taken from the cache of the directory while it holds it.
+func (a *Auth) directoryRefresh(ctx context.Context, log *slog.Logger, user domain.User) error {
+	entry, err := a.directory.Authenticator.Lookup(ctx, user.Email)
+	if err != nil {
+		if errors.Is(err, directory.ErrUserNotFound) {
+			log.Warn("directory user left the directory", slog.Int64("uid", user.ID))
+			return ErrInvalidRefreshToken
+		}
+		log.Error("field to look up directory user", slog.Any("err", err))
+		return err
+	}
+
+	return a.syncRoles(ctx, log, user.ID, entry)
+}
+
+// syncRoles grants and revokes the admin flag by the groups of entry when a
+// directory group grants it.
+func (a *Auth) syncRoles(ctx context.Context, log *slog.Logger, userID int64, entry domain.DirectoryUser) error {
+	if !a.directory.managesAdmins() {
+		return nil
+	}
+
+	if err := a.syncAdmin(ctx, log, userID, slices.Contains(entry.Roles, domain.RoleAdmin)); err != nil {
+		log.Error("field to sync admin role", slog.Any("err", err))
+		return err
+	}
+
+	return nil
+}
+
+// provisionDirectoryUser creates the local user of a directory entry. Its
+// password is random and never told to anyone, the directory checks it.
+func (a *Auth) provisionDirectoryUser(ctx context.Context, email string) (domain.User, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return domain.User{}, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	_, err = a.userSaver.SaveUser(ctx, email, passwordHash, domain.Event{
+		Type:  domain.EventUserRegistered,
+		Email: email,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			// a concurrent login created it
+			return a.userProvider.User(ctx, email)
+		}
+		return domain.User{}, err
+	}
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	a.record(ctx, domain.AuditEvent{Type: domain.AuditRegister, ActorID: user.ID, Subject: email}, nil)
+
+	a.log.Info("directory user provisioned", slog.Int64("uid", user.ID))
+
+	return user, nil
+}
+
+func (a *Auth) syncAdmin(ctx context.Context, log *slog.Logger, userID int64, admin bool) error {
+	isAdmin, err := a.userProvider.IsAdmin(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		return err
+	}
+
+	if isAdmin == admin {
+		return nil
+	}
+
+	if err := a.userSaver.UpdateUser(ctx, userID, domain.UserUpdate{IsAdmin: &admin}, domain.Event{}); err != nil {
+		return fmt.Errorf("update admin: %w", err)
+	}
+
+	log.Info("admin role synced from the directory", slog.Int64("uid", userID), slog.Bool("is_admin", admin))
+
+	return nil
+}
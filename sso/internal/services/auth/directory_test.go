@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/directory"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+)
+
+// fakeDirectory knows the users in entries with the password "Directory-1".
+type fakeDirectory struct {
+	entries map[string]domain.DirectoryUser
+	err     error
+}
+
+func (f *fakeDirectory) Authenticate(_ context.Context, email string, password string) (domain.DirectoryUser, error) {
+	if f.err != nil {
+		return domain.DirectoryUser{}, f.err
+	}
+
+	entry, ok := f.entries[email]
+	if !ok {
+		return domain.DirectoryUser{}, directory.ErrUserNotFound
+	}
+	if password != "Directory-1" {
+		return domain.DirectoryUser{}, directory.ErrInvalidCredentials
+	}
+
+	return entry, nil
+}
+
+func (f *fakeDirectory) Lookup(_ context.Context, email string) (domain.DirectoryUser, error) {
+	if f.err != nil {
+		return domain.DirectoryUser{}, f.err
+	}
+
+	entry, ok := f.entries[email]
+	if !ok {
+		return domain.DirectoryUser{}, directory.ErrUserNotFound
+	}
+
+	return entry, nil
+}
+
+func newTestDirectoryAuth(t *testing.T, mode string) (*Auth, *fakeStorage, *fakeDirectory) {
+	t.Helper()
+
+	a, st, _ := newTestAuth(t, false)
+
+	dir := &fakeDirectory{entries: map[string]domain.DirectoryUser{
+		"jonn@gmail.com": {DN: "uid=jonn,dc=example,dc=com", Email: "jonn@gmail.com", Roles: []string{domain.RoleAdmin}},
+	}}
+	a.directory = Directory{
+		Authenticator: dir,
+		Config: config.Directory{
+			Mode:       mode,
+			GroupRoles: []config.GroupRole{{Group: "cn=admins,dc=example,dc=com", Role: domain.RoleAdmin}},
+		},
+	}
+
+	// a local account the directory does not know
+	st.users["local@gmail.com"] = domain.User{ID: 50, Email: "local@gmail.com", PasswordHash: mustHash(t, "Local-1"), PasswordChangedAt: time.Now()}
+	st.nextID = 50
+
+	return a, st, dir
+}
+
+func TestLoginDirectoryProvisions(t *testing.T) {
+	a, st, dir := newTestDirectoryAuth(t, domain.DirectoryFirst)
+	ctx := context.Background()
+
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	user, ok := st.users["jonn@gmail.com"]
+	if !ok {
+		t.Fatal("directory user was not provisioned")
+	}
+	if !user.Directory {
+		t.Error("user is not marked as a directory user")
+	}
+	if !st.admins[user.ID] {
+		t.Error("admin role of the directory was not granted")
+	}
+	if st.events[0].Type != domain.AuditRegister || st.events[0].ActorID != user.ID {
+		t.Errorf("expected the provisioning to be audited, got %+v", st.events)
+	}
+
+	// the local password is unusable
+	if _, err := a.Login(ctx, "jonn@gmail.com", "", 1); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// leaving the group revokes the role on the next login
+	dir.entries["jonn@gmail.com"] = domain.DirectoryUser{DN: "uid=jonn,dc=example,dc=com", Email: "jonn@gmail.com"}
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if st.admins[user.ID] {
+		t.Error("admin role kept after leaving the group")
+	}
+	if n := len(st.users); n != 2 {
+		t.Errorf("expected 2 users, got %d", n)
+	}
+}
+
+func TestLoginDirectoryModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		email    string
+		password string
+		want     error
+	}{
+		{"directory user", domain.DirectoryFirst, "jonn@gmail.com", "Directory-1", nil},
+		{"wrong directory password", domain.DirectoryFirst, "jonn@gmail.com", "Local-1", ErrInvalidCredentials},
+		{"local fallback", domain.DirectoryFirst, "local@gmail.com", "Local-1", nil},
+		{"unknown user", domain.DirectoryFirst, "bob@gmail.com", "Local-1", ErrInvalidCredentials},
+		{"only directory user", domain.DirectoryOnly, "jonn@gmail.com", "Directory-1", nil},
+		{"only refuses local users", domain.DirectoryOnly, "local@gmail.com", "Local-1", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _, _ := newTestDirectoryAuth(t, tt.mode)
+
+			_, err := a.Login(context.Background(), tt.email, tt.password, 1)
+			if tt.want == nil && err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoginDirectoryOtherTenant(t *testing.T) {
+	a, st, _ := newTestDirectoryAuth(t, domain.DirectoryOnly)
+	ctx := tenant.With(context.Background(), 2)
+
+	// the directory serves the default tenant only
+	if _, err := a.Login(ctx, "local@gmail.com", "Local-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.users["jonn@gmail.com"]; ok {
+		t.Fatal("directory consulted for another tenant")
+	}
+}
+
+func TestLoginDirectoryUnavailable(t *testing.T) {
+	a, _, dir := newTestDirectoryAuth(t, domain.DirectoryFirst)
+	ctx := context.Background()
+
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dir.err = fmt.Errorf("%w: connection refused", directory.ErrUnavailable)
+
+	// local users are not held up by the directory
+	if _, err := a.Login(ctx, "local@gmail.com", "Local-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Login(ctx, "local@gmail.com", "Directory-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := a.Login(ctx, "bob@gmail.com", "Local-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// directory users wait for it
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1); !errors.Is(err, directory.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	// only never falls back
+	a.directory.Config.Mode = domain.DirectoryOnly
+	if _, err := a.Login(ctx, "local@gmail.com", "Local-1", 1); !errors.Is(err, directory.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	// other errors are not an outage
+	a.directory.Config.Mode = domain.DirectoryFirst
+	dir.err = errors.New("size limit exceeded")
+	if _, err := a.Login(ctx, "local@gmail.com", "Local-1", 1); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected the directory error, got %v", err)
+	}
+}
+
+func TestLoginDirectoryUserLeft(t *testing.T) {
+	a, st, dir := newTestDirectoryAuth(t, domain.DirectoryFirst)
+	ctx := context.Background()
+
+	// a local user the directory took over keeps its old password hash
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Local-1"), PasswordChangedAt: time.Now()}
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(dir.entries, "jonn@gmail.com")
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Local-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestRefreshDirectoryUser(t *testing.T) {
+	a, st, dir := newTestDirectoryAuth(t, domain.DirectoryFirst)
+	ctx := context.Background()
+
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Directory-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := st.users["jonn@gmail.com"]
+
+	// leaving the admin group shows on the next refresh
+	dir.entries["jonn@gmail.com"] = domain.DirectoryUser{DN: "uid=jonn,dc=example,dc=com", Email: "jonn@gmail.com"}
+	tokens, err = a.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.admins[user.ID] {
+		t.Error("admin role kept after leaving the group")
+	}
+
+	dir.err = fmt.Errorf("%w: connection refused", directory.ErrUnavailable)
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, directory.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	// a user removed from the directory can not refresh
+	dir.err = nil
+	delete(dir.entries, "jonn@gmail.com")
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestLoginDirectoryExpiredLocalPassword(t *testing.T) {
+	a, st, _ := newTestDirectoryAuth(t, domain.DirectoryFirst)
+	a.rotation.MaxAgeDays = 1
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Local-1"), PasswordChangedAt: time.Now().Add(-48 * time.Hour)}
+
+	// the directory owns the password, its local age does not matter
+	if _, err := a.Login(context.Background(), "jonn@gmail.com", "Directory-1", 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// EmailChangeStorage keeps the pending email changes.
+type EmailChangeStorage interface {
+	SaveEmailChange(ctx context.Context, change domain.EmailChange) error
+	ConfirmEmailChange(ctx context.Context, tokenHash []byte, event domain.Event) (oldEmail string, change domain.EmailChange, err error)
+}
+
+var (
+	ErrUnauthenticated         = errors.New("an access token is required")
+	ErrSameEmail               = errors.New("new email equals the current one")
+	ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+// RequestEmailChange starts changing the email of the caller to newEmail. The
+// caller proves who they are with the current password, the change is applied
+// once ConfirmEmailChange gets the token sent to the new address. The old
+// address is told about the request.
+//
+// In hardened mode a taken newEmail is not reported to the caller, the new
+// address is told instead.
+func (a *Auth) RequestEmailChange(ctx context.Context, password string, newEmail string) (err error) {
+	const op = "auth.RequestEmailChange"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	caller, ok := principal.FromContext(ctx)
+
+	var outcome error
+	defer func() {
+		if outcome == nil {
+			outcome = err
+		}
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditEmailChangeReq, ActorID: caller.UserID, Subject: newEmail, AppID: caller.AppID}, outcome)
+	}()
+
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	log.Info("requesting email change", slog.Int64("uid", caller.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.confirmPassword(ctx, log, user, password); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if strings.EqualFold(user.Email, newEmail) {
+		return fmt.Errorf("%s: %w", op, ErrSameEmail)
+	}
+
+	_, err = a.userProvider.User(ctx, newEmail)
+	switch {
+	case err == nil:
+		log.Warn("new email is taken")
+
+		if a.hardened {
+			outcome = ErrUserExists
+			a.notify(ctx, log, notifier.Message{
+				To:      newEmail,
+				Subject: "Email change attempt",
+				Body:    "Someone tried to move their account to this email address, but it already belongs to an account. If this was you, use another address.",
+			})
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", op, ErrUserExists)
+	case !errors.Is(err, storage.ErrUserNotFound):
+		log.Error("field to get user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		log.Error("field to generate token", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.emailChanges.SaveEmailChange(ctx, domain.EmailChange{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		AppID:     caller.AppID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	})
+	if err != nil {
+		log.Error("field to save email change", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.notify(ctx, log, notifier.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body:    fmt.Sprintf("Use this code to confirm your new email address: %s\nThe code expires in %s.", token, emailChangeTTL),
+	})
+	a.notify(ctx, log, notifier.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body:    fmt.Sprintf("A change of your email address to %s was requested. If this was not you, change your password.", newEmail),
+	})
+
+	return nil
+}
+
+// ConfirmEmailChange applies the change the token was sent for. Access tokens
+// issued before carry the old email and stop being accepted, the user has to
+// log in again.
+func (a *Auth) ConfirmEmailChange(ctx context.Context, token string) (err error) {
+	const op = "auth.ConfirmEmailChange"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	var change domain.EmailChange
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditEmailChange, ActorID: change.UserID, Subject: change.NewEmail, AppID: change.AppID}, err)
+	}()
+
+	log.Info("confirming email change")
+
+	sum := sha256.Sum256([]byte(token))
+
+	oldEmail, change, err := a.emailChanges.ConfirmEmailChange(ctx, sum[:], domain.Event{Type: domain.EventEmailChanged})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEmailChangeNotFound), errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("invalid email change token", slog.Any("err", err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailChangeToken)
+		case errors.Is(err, storage.ErrUserExists):
+			log.Warn("new email was taken in the meantime")
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		log.Error("field to confirm email change", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email changed", slog.Int64("uid", change.UserID))
+
+	a.notify(ctx, log, notifier.Message{
+		To:      oldEmail,
+		Subject: "Email address changed",
+		Body:    fmt.Sprintf("The email address of your account was changed to %s. If this was not you, contact support.", change.NewEmail),
+	})
+
+	return nil
+}
+
+// newSecretToken returns a random token for the user and the hash to store.
+func newSecretToken() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+
+	return token, sum[:], nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+)
+
+func receive(t *testing.T, n *fakeNotifier) notifier.Message {
+	t.Helper()
+
+	select {
+	case msg := <-n.sent:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no notification sent")
+		return notifier.Message{}
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	a, st, n := newTestAuth(t, false)
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1")}
+	st.users["taken@gmail.com"] = domain.User{ID: 8, Email: "taken@gmail.com"}
+
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	if err := a.RequestEmailChange(context.Background(), "Correct-Password-1", "new@gmail.com"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("anonymous: expected ErrUnauthenticated, got %v", err)
+	}
+	if err := a.RequestEmailChange(ctx, "Wrong-Password-1", "new@gmail.com"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password: expected ErrInvalidCredentials, got %v", err)
+	}
+	if err := a.RequestEmailChange(ctx, "Correct-Password-1", "taken@gmail.com"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("taken email: expected ErrUserExists, got %v", err)
+	}
+
+	if err := a.RequestEmailChange(ctx, "Correct-Password-1", "new@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	for range 2 {
+		msg := receive(t, n)
+		switch msg.To {
+		case "new@gmail.com":
+			token = strings.Fields(strings.SplitN(msg.Body, ": ", 2)[1])[0]
+		case "jonn@gmail.com":
+		default:
+			t.Fatalf("unexpected notification to %s", msg.To)
+		}
+	}
+	if token == "" {
+		t.Fatal("no confirmation sent to the new address")
+	}
+	if strings.Contains(string(st.changes[0].TokenHash), token) {
+		t.Fatal("token stored in plain text")
+	}
+
+	if err := a.ConfirmEmailChange(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := st.users["new@gmail.com"]; !ok || u.ID != 7 {
+		t.Fatalf("email not changed: %+v", st.users)
+	}
+	if msg := receive(t, n); msg.To != "jonn@gmail.com" {
+		t.Fatalf("expected notice to the old address, got %s", msg.To)
+	}
+
+	if err := a.ConfirmEmailChange(context.Background(), token); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Fatalf("reused token: expected ErrInvalidEmailChangeToken, got %v", err)
+	}
+}
+
+func TestRequestEmailChangeLockout(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	guard := newCountingGuard(3)
+	a.guard = guard
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1")}
+
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	for range 3 {
+		if err := a.RequestEmailChange(ctx, "Wrong-Password-1", "new@gmail.com"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+
+	// the password of a locked account cannot be guessed through email changes
+	if err := a.RequestEmailChange(ctx, "Correct-Password-1", "new@gmail.com"); !errors.Is(err, lockout.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if len(st.changes) != 0 {
+		t.Fatal("email change requested on a locked account")
+	}
+}
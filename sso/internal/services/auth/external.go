@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// LoginExternal starts a session for a user that was authenticated outside
+// of this service, by an upstream identity provider for example. It takes
+// the same path as Login after the password check: disabled users are
+// refused, the login is scored for risk and the session records method.
+//
+// The caller audits the login, it knows how the user was authenticated.
+func (a *Auth) LoginExternal(ctx context.Context, user domain.User, appID int64, method string) (domain.Tokens, error) {
+	const op = "auth.LoginExternal"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if user.Disabled() {
+		log.Warn("disabled user tried to log in", slog.Int64("uid", user.ID))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	decision, err := a.risk.Assess(ctx, user, app.ID)
+	if err != nil {
+		log.Error("field to assess login risk", slog.Any("err", err))
+		decision = domain.RiskDecision{Action: domain.RiskAllow}
+	}
+
+	if decision.Action == domain.RiskBlock {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrLoginBlocked)
+	}
+
+	tokens, err := a.startSession(ctx, user, app, method, decision.Action == domain.RiskStepUp)
+	if err != nil {
+		log.Error("field to start session", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session started", slog.Int64("uid", user.ID), slog.Int64("sid", tokens.SessionID), slog.String("method", method))
+
+	return tokens, nil
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/breach"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/warning"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePassword sets a new password for the user. The caller proves who
+// they are either with the current password or with the change token
+// returned by Login for an expired password.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	email string,
+	oldPassword string,
+	newPassword string,
+	changeToken string,
+	appID int64,
+) (err error) {
+	const op = "auth.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	var uid int64
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditPasswordChange, ActorID: uid, Subject: email, AppID: appID}, err)
+	}()
+
+	log.Info("changing password")
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("app is not found", slog.Any("err", err))
+			return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.passwordOwner(ctx, log, app, email, oldPassword, changeToken)
+	if err != nil {
+		log.Warn("field to authenticate user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	uid = user.ID
+
+	if err := a.checkPassword(ctx, log, appID, user.Email, newPassword); err != nil {
+		log.Warn("password rejected", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkReuse(ctx, user, newPassword); err != nil {
+		log.Warn("password rejected", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error("invalid generate hash password", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.userSaver.UpdatePassword(ctx, user.ID, user.PasswordHash, passwordHash, a.rotation.HistoryDepth, domain.Event{
+		Type:  domain.EventPasswordChanged,
+		AppID: appID,
+		Email: user.Email,
+	})
+	if err != nil {
+		log.Error("field to update password", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed", slog.Int64("uid", user.ID))
+
+	return nil
+}
+
+func (a *Auth) passwordOwner(
+	ctx context.Context,
+	log *slog.Logger,
+	app domain.App,
+	email string,
+	oldPassword string,
+	changeToken string,
+) (domain.User, error) {
+	if changeToken != "" {
+		claims, err := jwtToken.ParseChangeToken(changeToken, app)
+		if err != nil {
+			return domain.User{}, fmt.Errorf("%w: %w", ErrInvalidChangeToken, err)
+		}
+
+		user, err := a.userProvider.UserByID(ctx, claims.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return domain.User{}, ErrInvalidChangeToken
+			}
+			return domain.User{}, err
+		}
+
+		// spent by the change it was issued for or by any other one
+		if !claims.IssuedFor(user) {
+			return domain.User{}, ErrInvalidChangeToken
+		}
+
+		if user.Disabled() {
+			return domain.User{}, ErrUserDisabled
+		}
+
+		return user, nil
+	}
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			ip := clientinfo.FromContext(ctx).IP
+			if err := a.guard.Check(ctx, email, ip); err != nil {
+				return domain.User{}, err
+			}
+			if a.hardened {
+				a.spendDummyHash(oldPassword)
+			}
+			return domain.User{}, a.loginFailed(ctx, log, email, ip)
+		}
+		return domain.User{}, err
+	}
+
+	if err := a.confirmPassword(ctx, log, user, oldPassword); err != nil {
+		return domain.User{}, err
+	}
+
+	if user.Disabled() {
+		return domain.User{}, ErrUserDisabled
+	}
+
+	return user, nil
+}
+
+// confirmPassword checks the password of a known user the way Login does:
+// a locked account or address is refused and a wrong password counts
+// towards the lockout. Every flow that asks for the current password has to
+// go through it.
+func (a *Auth) confirmPassword(ctx context.Context, log *slog.Logger, user domain.User, password string) error {
+	ip := clientinfo.FromContext(ctx).IP
+
+	if err := a.guard.Check(ctx, user.Email, ip); err != nil {
+		log.Warn("login is locked", slog.String("ip", ip), slog.Any("err", err))
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return a.loginFailed(ctx, log, user.Email, ip)
+	}
+
+	if err := a.guard.Succeeded(ctx, user.Email); err != nil {
+		log.Error("field to reset login failures", slog.Any("err", err))
+	}
+
+	return nil
+}
+
+// checkReuse rejects the current password and the ones kept in the history.
+func (a *Auth) checkReuse(ctx context.Context, user domain.User, password string) error {
+	if a.rotation.HistoryDepth <= 0 {
+		return nil
+	}
+
+	history, err := a.userProvider.PasswordHistory(ctx, user.ID, a.rotation.HistoryDepth)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range append([][]byte{user.PasswordHash}, history...) {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return ErrPasswordReused
+		}
+	}
+
+	return nil
+}
+
+func (a *Auth) passwordExpired(user domain.User) bool {
+	if a.rotation.MaxAgeDays <= 0 {
+		return false
+	}
+
+	maxAge := time.Duration(a.rotation.MaxAgeDays) * 24 * time.Hour
+
+	return time.Since(user.PasswordChangedAt) > maxAge
+}
+
+// checkPassword applies the password policy and the breach corpus. Every
+// flow that sets a new password has to go through it. A password the corpus
+// only warns about is let through with a warning for the client.
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, appID int64, email string, password string) error {
+	if err := a.passwords.Validate(appID, email, password); err != nil {
+		return err
+	}
+
+	verdict, err := a.breaches.Check(password)
+	if err != nil {
+		// a broken corpus must not stop users from signing up
+		log.Error("field to check breach corpus", slog.Any("err", err))
+		return nil
+	}
+
+	switch verdict {
+	case breach.VerdictWarn:
+		log.Warn("password found in breach corpus")
+		warning.Add(ctx, warning.PasswordBreached)
+	case breach.VerdictBlock:
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+)
+
+func TestChangeTokenSingleUse(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	a.rotation.MaxAgeDays = 30
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now().AddDate(0, 0, -31)}
+
+	_, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	var expired *PasswordExpiredError
+	if !errors.As(err, &expired) {
+		t.Fatalf("expected PasswordExpiredError, got %v", err)
+	}
+
+	if err := a.ChangePassword(ctx, "", "", "New-Password-1", expired.ChangeToken, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token was issued for the password it replaced
+	if err := a.ChangePassword(ctx, "", "", "New-Password-2", expired.ChangeToken, 1); !errors.Is(err, ErrInvalidChangeToken) {
+		t.Fatalf("expected ErrInvalidChangeToken, got %v", err)
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	guard := newCountingGuard(3)
+	a.guard = guard
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	for range 3 {
+		if err := a.ChangePassword(ctx, "jonn@gmail.com", "Wrong-Password-1", "New-Password-1", "", 1); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+
+	// the right password does not help once the account is locked
+	if err := a.ChangePassword(ctx, "jonn@gmail.com", "Correct-Password-1", "New-Password-1", "", 1); !errors.Is(err, lockout.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	// unknown accounts are counted as well
+	for range 3 {
+		_ = a.ChangePassword(ctx, "nobody@gmail.com", "Wrong-Password-1", "New-Password-1", "", 1)
+	}
+	if guard.failures["nobody@gmail.com"] != 3 {
+		t.Fatalf("failures of unknown account = %d", guard.failures["nobody@gmail.com"])
+	}
+}
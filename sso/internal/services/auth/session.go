@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// SessionStorage keeps the login sessions and their refresh tokens.
+type SessionStorage interface {
+	CreateSession(ctx context.Context, session domain.Session, refresh domain.RefreshToken) (domain.Session, error)
+	// RefreshTokenSession returns the session of an unexpired refresh
+	// token, used or not.
+	RefreshTokenSession(ctx context.Context, hash []byte) (domain.Session, error)
+	RotateRefreshToken(ctx context.Context, oldHash []byte, next domain.RefreshToken) (domain.Session, error)
+	Session(ctx context.Context, sessionID int64) (domain.Session, error)
+	StepUpSession(ctx context.Context, sessionID int64, acr int, method string) (domain.Session, error)
+}
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+const defaultRefreshTTL = 30 * 24 * time.Hour
+
+// Refresh exchanges a refresh token for new tokens of the same session. A
+// refresh token can be used once, using it again revokes the session.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (tokens domain.Tokens, err error) {
+	const op = "auth.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	var session domain.Session
+	defer func() {
+		event := domain.AuditEvent{Type: domain.AuditTokenRefresh, ActorID: session.UserID, AppID: session.AppID}
+		if session.UserID != 0 {
+			event.Subject = domain.UserSubject(session.UserID)
+		}
+		a.record(ctx, event, err)
+	}()
+
+	sum := sha256.Sum256([]byte(refreshToken))
+
+	session, err = a.sessions.RefreshTokenSession(ctx, sum[:])
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenInvalid) {
+			log.Warn("invalid refresh token")
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+		log.Error("field to get session", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a disabled user must not keep the session alive, the token is left
+	// unused
+	user, err := a.userProvider.UserByID(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Disabled() {
+		log.Warn("disabled user tried to refresh", slog.Int64("uid", user.ID))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	// a user removed from the directory must not keep the session alive
+	if user.Directory && a.directory.serves(ctx) {
+		if err := a.directoryRefresh(ctx, log, user); err != nil {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	next, refresh, err := a.newRefreshToken(ctx)
+	if err != nil {
+		log.Error("field to generate refresh token", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	session, err = a.sessions.RotateRefreshToken(ctx, sum[:], refresh)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrRefreshTokenInvalid):
+			log.Warn("invalid refresh token")
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		case errors.Is(err, storage.ErrRefreshTokenReused):
+			log.Warn("refresh token reused, session revoked")
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrRefreshTokenReused)
+		}
+		log.Error("field to rotate refresh token", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, session.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	access, err := a.accessToken(ctx, user, app, session)
+	if err != nil {
+		log.Error("field get JWT token", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionTokens(session, app, access, next), nil
+}
+
+// startSession records a session of user in app for the client of the
+// request and issues its first tokens, method is how the user logged in.
+func (a *Auth) startSession(ctx context.Context, user domain.User, app domain.App, method string, stepUp bool) (domain.Tokens, error) {
+	next, refresh, err := a.newRefreshToken(ctx)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	info := clientinfo.FromContext(ctx)
+
+	session, err := a.sessions.CreateSession(ctx, domain.Session{
+		UserID:    user.ID,
+		AppID:     app.ID,
+		Device:    info.Device,
+		UserAgent: info.UserAgent,
+		IP:        info.IP,
+		ACR:       domain.MethodACR[method],
+		AMR:       []string{method},
+
+		StepUpRequired: stepUp,
+	}, refresh)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	access, err := a.accessToken(ctx, user, app, session)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	return sessionTokens(session, app, access, next), nil
+}
+
+// sessionTokens tells the client to step up when the login looked risky or
+// the session is below the level the app requires.
+func sessionTokens(session domain.Session, app domain.App, access string, refresh string) domain.Tokens {
+	return domain.Tokens{
+		AccessToken:    access,
+		RefreshToken:   refresh,
+		SessionID:      session.ID,
+		ACR:            session.ACR,
+		StepUpRequired: session.StepUpRequired || session.ACR < app.MinACR,
+	}
+}
+
+// accessToken issues an access token of the session with the profile claims
+// the app asked for.
+func (a *Auth) accessToken(ctx context.Context, user domain.User, app domain.App, session domain.Session) (string, error) {
+	extra := map[string]any{}
+	if len(app.ClaimAttributes) > 0 {
+		profile, err := a.userProvider.Profile(ctx, user.ID)
+		if err != nil {
+			return "", err
+		}
+		extra = jwtToken.ProfileClaims(profile, app.ClaimAttributes)
+	}
+	if app.OrgScoped {
+		members, err := a.userProvider.Memberships(ctx, user.ID)
+		if err != nil {
+			return "", err
+		}
+		for k, v := range jwtToken.OrgClaims(members) {
+			extra[k] = v
+		}
+	}
+	for k, v := range jwtToken.SessionClaims(session) {
+		extra[k] = v
+	}
+
+	return jwtToken.GetTokenWithClaims(user, app, a.tokenTTL, extra)
+}
+
+// newRefreshToken returns a refresh token for the caller and what is stored
+// of it.
+func (a *Auth) newRefreshToken(ctx context.Context) (string, domain.RefreshToken, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", domain.RefreshToken{}, err
+	}
+
+	info := clientinfo.FromContext(ctx)
+
+	return token, domain.RefreshToken{
+		Hash:      hash,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		ExpiresAt: time.Now().Add(a.refreshTTL),
+	}, nil
+}
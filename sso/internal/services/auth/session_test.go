@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+)
+
+func TestLoginSession(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := clientinfo.With(context.Background(), clientinfo.Info{IP: "10.0.0.1", UserAgent: "test", Device: "laptop"})
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := st.sessions[tokens.SessionID]
+	if session.UserID != 7 || session.AppID != 1 || session.Device != "laptop" || session.IP != "10.0.0.1" || session.UserAgent != "test" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	claims, err := jwtToken.ParseAccessToken(tokens.AccessToken, st.apps[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.SessionID != tokens.SessionID {
+		t.Fatalf("sid = %d, want %d", claims.SessionID, tokens.SessionID)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	first, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := a.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("unexpected tokens %+v after %+v", second, first)
+	}
+	if _, err := jwtToken.ParseAccessToken(second.AccessToken, st.apps[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Refresh(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+
+	// the first token was used, whoever presents it again must not get in
+	// and the session is gone for the holder of the second one too
+	if _, err := a.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := a.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditTokenRefresh || last.Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestRefreshDisabledUser(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := st.users["jonn@gmail.com"]
+	user.Status = domain.UserStatusDisabled
+	st.users["jonn@gmail.com"] = user
+
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrUserDisabled) {
+		t.Fatalf("expected ErrUserDisabled, got %v", err)
+	}
+
+	// the token was not rotated, it works once the user is enabled again
+	for _, r := range st.refresh {
+		if r.used {
+			t.Fatal("refresh token of a disabled user was used up")
+		}
+	}
+
+	user.Status = domain.UserStatusActive
+	st.users["jonn@gmail.com"] = user
+
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginRisk(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+
+	a.risk = &fakeRisk{decision: domain.RiskDecision{Score: 100, Action: domain.RiskBlock}}
+	if _, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1); !errors.Is(err, ErrLoginBlocked) {
+		t.Fatalf("expected ErrLoginBlocked, got %v", err)
+	}
+	if len(st.sessions) != 0 {
+		t.Fatalf("blocked login started a session")
+	}
+
+	a.risk = &fakeRisk{decision: domain.RiskDecision{Score: 60, Action: domain.RiskStepUp}}
+	tokens, err := a.Login(ctx, "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.StepUpRequired || !st.sessions[tokens.SessionID].StepUpRequired {
+		t.Fatalf("step-up not required: %+v", tokens)
+	}
+
+	// the access token is restricted until the session is stepped up
+	claims, err := jwtToken.ParseAccessToken(tokens.AccessToken, st.apps[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.StepUpRequired {
+		t.Fatalf("access token is not restricted: %+v", claims)
+	}
+
+	// refreshing does not skip the step-up
+	refreshed, err := a.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refreshed.StepUpRequired {
+		t.Fatal("refresh dropped the step-up")
+	}
+	if claims, _ := jwtToken.ParseAccessToken(refreshed.AccessToken, st.apps[1]); !claims.StepUpRequired {
+		t.Fatal("refreshed access token is not restricted")
+	}
+}
+
+func TestLoginExternal(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	ctx := context.Background()
+
+	user := domain.User{ID: 7, Email: "jonn@gmail.com", PasswordChangedAt: time.Now()}
+
+	tokens, err := a.LoginExternal(ctx, user, 1, domain.AMRFederated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := st.sessions[tokens.SessionID]
+	if session.UserID != 7 || len(session.AMR) != 1 || session.AMR[0] != domain.AMRFederated || session.ACR != domain.ACRPassword {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	user.Status = domain.UserStatusDisabled
+	if _, err := a.LoginExternal(ctx, user, 1, domain.AMRFederated); !errors.Is(err, ErrUserDisabled) {
+		t.Fatalf("expected ErrUserDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/totp"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// FactorStorage keeps the second factors of the users.
+type FactorStorage interface {
+	SaveOTPFactor(ctx context.Context, factor domain.OTPFactor) error
+	OTPFactor(ctx context.Context, userID int64) (domain.OTPFactor, error)
+	UseOTPStep(ctx context.Context, userID int64, step int64) error
+	// DeleteOTPFactor removes the factor and revokes the tokens of the user.
+	DeleteOTPFactor(ctx context.Context, userID int64) error
+}
+
+var (
+	ErrOTPEnrolled       = errors.New("otp is already enrolled")
+	ErrFactorNotEnrolled = errors.New("factor is not enrolled")
+	ErrUnsupportedFactor = errors.New("unsupported factor")
+	ErrSessionRequired   = errors.New("access token has no session")
+	ErrStepUpRequired    = errors.New("session has to be stepped up")
+)
+
+const (
+	otpIssuer = "sso"
+	// otpSkew accepts the codes of the steps next to the current one.
+	otpSkew = 1
+)
+
+// EnrollOTP creates a TOTP secret for the caller, who proves who they are
+// with the password. The factor is pending until ConfirmOTP gets a code of
+// it, a confirmed factor is not replaced. Sessions of risky logins can not
+// enroll, the new factor would let them step themselves up.
+func (a *Auth) EnrollOTP(ctx context.Context, password string) (secret string, uri string, err error) {
+	const op = "auth.EnrollOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	caller, ok := principal.FromContext(ctx)
+
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditOTPEnroll, ActorID: caller.UserID, Subject: domain.UserSubject(caller.UserID), AppID: caller.AppID}, err)
+	}()
+
+	if !ok {
+		return "", "", fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if caller.StepUpRequired {
+		return "", "", fmt.Errorf("%s: %w", op, ErrStepUpRequired)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.confirmPassword(ctx, log, user, password); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err = totp.NewSecret()
+	if err != nil {
+		log.Error("field to generate otp secret", slog.Any("err", err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	sealed, err := a.otpKey.Seal(secret, otpSubject(user.ID))
+	if err != nil {
+		log.Error("field to seal otp secret", slog.Any("err", err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.factors.SaveOTPFactor(ctx, domain.OTPFactor{UserID: user.ID, Secret: sealed}); err != nil {
+		if errors.Is(err, storage.ErrOTPFactorExists) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrOTPEnrolled)
+		}
+		log.Error("field to save otp factor", slog.Any("err", err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("otp factor pending", slog.Int64("uid", user.ID))
+
+	return secret, totp.URI(otpIssuer, user.Email, secret), nil
+}
+
+// ConfirmOTP confirms the pending factor of the caller with a code of the
+// authenticator app, from then on it can be used by StepUp.
+func (a *Auth) ConfirmOTP(ctx context.Context, code string) (err error) {
+	const op = "auth.ConfirmOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	caller, ok := principal.FromContext(ctx)
+
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditOTPConfirm, ActorID: caller.UserID, Subject: domain.UserSubject(caller.UserID), AppID: caller.AppID}, err)
+	}()
+
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	factor, err := a.otpFactor(ctx, log, caller.UserID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if factor.Confirmed() {
+		return fmt.Errorf("%s: %w", op, ErrOTPEnrolled)
+	}
+
+	if err := a.checkOTP(ctx, log, caller.Email, factor, code); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("otp factor confirmed", slog.Int64("uid", caller.UserID))
+
+	return nil
+}
+
+// RemoveOTP removes the confirmed factor of the caller, who proves who they
+// are with the password and a code of the factor. The tokens of the user are
+// revoked, sessions stepped up with the factor end with it.
+func (a *Auth) RemoveOTP(ctx context.Context, password string, code string) (err error) {
+	const op = "auth.RemoveOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	caller, ok := principal.FromContext(ctx)
+
+	defer func() {
+		a.record(ctx, domain.AuditEvent{Type: domain.AuditOTPRemove, ActorID: caller.UserID, Subject: domain.UserSubject(caller.UserID), AppID: caller.AppID}, err)
+	}()
+
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if caller.StepUpRequired {
+		return fmt.Errorf("%s: %w", op, ErrStepUpRequired)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.confirmPassword(ctx, log, user, password); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.verifyOTP(ctx, log, caller, code); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.factors.DeleteOTPFactor(ctx, user.ID); err != nil {
+		if errors.Is(err, storage.ErrOTPFactorNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrFactorNotEnrolled)
+		}
+		log.Error("field to delete otp factor", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("otp factor removed", slog.Int64("uid", user.ID))
+
+	return nil
+}
+
+// StepUp confirms the session of the caller with a stronger factor and
+// returns an access token with the raised acr. The refresh token of the
+// session stays valid and keeps the raised level.
+func (a *Auth) StepUp(ctx context.Context, method string, code string) (tokens domain.Tokens, err error) {
+	const op = "auth.StepUp"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	caller, ok := principal.FromContext(ctx)
+
+	defer func() {
+		event := domain.AuditEvent{Type: domain.AuditStepUp, ActorID: caller.UserID, Subject: domain.UserSubject(caller.UserID), AppID: caller.AppID}
+		if err == nil {
+			event.Reason = method
+		}
+		a.record(ctx, event, err)
+	}()
+
+	if !ok {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if caller.SessionID == 0 {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrSessionRequired)
+	}
+
+	acr, known := domain.MethodACR[method]
+	if !known || acr <= domain.ACRPassword {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnsupportedFactor)
+	}
+
+	if err := a.verifyOTP(ctx, log, caller, code); err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	session, err := a.sessions.StepUpSession(ctx, caller.SessionID, acr, method)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to step up session", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, session.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	access, err := a.accessToken(ctx, user, app, session)
+	if err != nil {
+		log.Error("field get JWT token", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session stepped up", slog.Int64("sid", session.ID), slog.Int("acr", session.ACR))
+
+	return sessionTokens(session, app, access, ""), nil
+}
+
+// verifyOTP checks code against the confirmed OTP factor of the caller, the
+// only factor a session can be stepped up with so far.
+func (a *Auth) verifyOTP(ctx context.Context, log *slog.Logger, caller principal.Principal, code string) error {
+	factor, err := a.otpFactor(ctx, log, caller.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !factor.Confirmed() {
+		return ErrFactorNotEnrolled
+	}
+
+	return a.checkOTP(ctx, log, caller.Email, factor, code)
+}
+
+// otpFactor returns the factor of the user with its secret opened.
+func (a *Auth) otpFactor(ctx context.Context, log *slog.Logger, userID int64) (domain.OTPFactor, error) {
+	factor, err := a.factors.OTPFactor(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrOTPFactorNotFound) {
+			return domain.OTPFactor{}, ErrFactorNotEnrolled
+		}
+		log.Error("field to get otp factor", slog.Any("err", err))
+		return domain.OTPFactor{}, err
+	}
+
+	factor.Secret, err = a.otpKey.Open(factor.Secret, otpSubject(userID))
+	if err != nil {
+		log.Error("field to open otp secret", slog.Int64("uid", userID), slog.Any("err", err))
+		return domain.OTPFactor{}, err
+	}
+
+	return factor, nil
+}
+
+// otpSubject binds a sealed secret to its user.
+func otpSubject(userID int64) string {
+	return "otp:" + strconv.FormatInt(userID, 10)
+}
+
+// checkOTP accepts a code of factor once. Wrong codes count as failed logins
+// of the user so that codes can not be guessed.
+func (a *Auth) checkOTP(ctx context.Context, log *slog.Logger, email string, factor domain.OTPFactor, code string) error {
+	ip := clientinfo.FromContext(ctx).IP
+
+	if err := a.guard.Check(ctx, email, ip); err != nil {
+		log.Warn("otp is locked", slog.String("ip", ip), slog.Any("err", err))
+		return err
+	}
+
+	step, valid, err := totp.Validate(factor.Secret, code, time.Now(), otpSkew)
+	if err != nil {
+		log.Error("field to validate otp code", slog.Any("err", err))
+		return err
+	}
+
+	if !valid || step <= factor.LastStep {
+		log.Warn("invalid otp code", slog.Int64("uid", factor.UserID))
+		return a.loginFailed(ctx, log, email, ip)
+	}
+
+	if err := a.factors.UseOTPStep(ctx, factor.UserID, step); err != nil {
+		if errors.Is(err, storage.ErrOTPCodeUsed) {
+			log.Warn("otp code replayed", slog.Int64("uid", factor.UserID))
+			return a.loginFailed(ctx, log, email, ip)
+		}
+		log.Error("field to use otp code", slog.Any("err", err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/seal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/totp"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/lockout"
+)
+
+func TestEnrollOTP(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	if _, _, err := a.EnrollOTP(context.Background(), "Correct-Password-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+
+	// a risky login can not add the factor it has to step up with
+	risky := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1, StepUpRequired: true})
+	if _, _, err := a.EnrollOTP(risky, "Correct-Password-1"); !errors.Is(err, ErrStepUpRequired) {
+		t.Fatalf("expected ErrStepUpRequired, got %v", err)
+	}
+
+	if _, _, err := a.EnrollOTP(ctx, "Wrong-Password-1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	secret, uri, err := a.EnrollOTP(ctx, "Correct-Password-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == "" || uri == "" || st.otp[7].Confirmed() {
+		t.Fatalf("unexpected enrollment %q %q %+v", secret, uri, st.otp[7])
+	}
+
+	if err := a.ConfirmOTP(ctx, "000000"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	if err := a.ConfirmOTP(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	if !st.otp[7].Confirmed() {
+		t.Fatal("factor is not confirmed")
+	}
+
+	// a confirmed factor is not replaced by whoever knows the password
+	if _, _, err := a.EnrollOTP(ctx, "Correct-Password-1"); !errors.Is(err, ErrOTPEnrolled) {
+		t.Fatalf("expected ErrOTPEnrolled, got %v", err)
+	}
+}
+
+func TestRemoveOTP(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	if err := a.RemoveOTP(ctx, "Correct-Password-1", "000000"); !errors.Is(err, ErrFactorNotEnrolled) {
+		t.Fatalf("expected ErrFactorNotEnrolled, got %v", err)
+	}
+
+	secret, _, err := a.EnrollOTP(ctx, "Correct-Password-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, _ := totp.Code(secret, totp.Step(time.Now())-1)
+	if err := a.ConfirmOTP(ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	// a stolen password alone does not remove the factor
+	if err := a.RemoveOTP(ctx, "Correct-Password-1", "000000"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if err := a.RemoveOTP(ctx, "Wrong-Password-1", code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	risky := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1, StepUpRequired: true})
+	if err := a.RemoveOTP(risky, "Correct-Password-1", code); !errors.Is(err, ErrStepUpRequired) {
+		t.Fatalf("expected ErrStepUpRequired, got %v", err)
+	}
+
+	if err := a.RemoveOTP(ctx, "Correct-Password-1", code); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.otp[7]; ok {
+		t.Fatal("factor was not removed")
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditOTPRemove || last.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestOTPSecretSealed(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+
+	key, err := seal.NewKey(bytes.Repeat([]byte{1}, seal.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.otpKey = key
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	secret, _, err := a.EnrollOTP(ctx, "Correct-Password-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := st.otp[7].Secret; stored == secret || strings.Contains(stored, secret) {
+		t.Fatalf("otp secret stored in the clear: %q", stored)
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	if err := a.ConfirmOTP(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnrollOTPLockout(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+	a.guard = newCountingGuard(3)
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+
+	for range 3 {
+		if _, _, err := a.EnrollOTP(ctx, "Wrong-Password-1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+
+	// the password of a locked account cannot be guessed through enrollment
+	if _, _, err := a.EnrollOTP(ctx, "Correct-Password-1"); !errors.Is(err, lockout.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if _, ok := st.otp[7]; ok {
+		t.Fatal("otp enrolled on a locked account")
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	a, st, _ := newTestAuth(t, false)
+
+	st.users["jonn@gmail.com"] = domain.User{ID: 7, Email: "jonn@gmail.com", PasswordHash: mustHash(t, "Correct-Password-1"), PasswordChangedAt: time.Now()}
+	st.apps[1] = domain.App{ID: 1, Name: "test", Secret: "secret_key", MinACR: domain.ACRMultiFactor}
+
+	tokens, err := a.Login(context.Background(), "jonn@gmail.com", "Correct-Password-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.StepUpRequired || tokens.ACR != domain.ACRPassword {
+		t.Fatalf("expected a password session below the app minimum, got %+v", tokens)
+	}
+
+	claims, err := jwtToken.ParseAccessToken(tokens.AccessToken, st.apps[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ACR != domain.ACRPassword || !slices.Equal(claims.AMR, []string{domain.AMRPassword}) {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	ctx := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1, SessionID: tokens.SessionID})
+
+	if _, err := a.StepUp(ctx, domain.AMROTP, "123456"); !errors.Is(err, ErrFactorNotEnrolled) {
+		t.Fatalf("expected ErrFactorNotEnrolled, got %v", err)
+	}
+	if _, err := a.StepUp(ctx, "hwk", "assertion"); !errors.Is(err, ErrUnsupportedFactor) {
+		t.Fatalf("expected ErrUnsupportedFactor, got %v", err)
+	}
+	if _, err := a.StepUp(ctx, domain.AMRPassword, "Correct-Password-1"); !errors.Is(err, ErrUnsupportedFactor) {
+		t.Fatalf("expected ErrUnsupportedFactor, got %v", err)
+	}
+
+	secret, _ := totp.NewSecret()
+	st.otp[7] = domain.OTPFactor{UserID: 7, Secret: secret, ConfirmedAt: time.Now()}
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	noSession := principal.With(context.Background(), principal.Principal{UserID: 7, Email: "jonn@gmail.com", AppID: 1})
+	if _, err := a.StepUp(noSession, domain.AMROTP, code); !errors.Is(err, ErrSessionRequired) {
+		t.Fatalf("expected ErrSessionRequired, got %v", err)
+	}
+
+	stepped, err := a.StepUp(ctx, domain.AMROTP, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stepped.StepUpRequired || stepped.ACR != domain.ACRMultiFactor || stepped.SessionID != tokens.SessionID {
+		t.Fatalf("unexpected tokens %+v", stepped)
+	}
+
+	claims, err = jwtToken.ParseAccessToken(stepped.AccessToken, st.apps[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ACR != domain.ACRMultiFactor || !slices.Equal(claims.AMR, []string{domain.AMRPassword, domain.AMROTP}) {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// a code works once
+	if _, err := a.StepUp(ctx, domain.AMROTP, code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// the refresh token keeps the raised level
+	refreshed, err := a.Refresh(context.Background(), tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.StepUpRequired || refreshed.ACR != domain.ACRMultiFactor {
+		t.Fatalf("unexpected tokens after refresh %+v", refreshed)
+	}
+
+	replay := st.events[len(st.events)-2]
+	if replay.Type != domain.AuditStepUp || replay.Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit event %+v", replay)
+	}
+}
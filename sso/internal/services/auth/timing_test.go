@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyWatch records how often the dummy hash was compared against.
+type dummyWatch struct {
+	mu    sync.Mutex
+	dummy []byte
+	count int
+}
+
+func watchDummyHash(a *Auth) *dummyWatch {
+	w := &dummyWatch{dummy: a.dummyHash}
+	a.compareHash = func(hash []byte, password []byte) error {
+		if bytes.Equal(hash, w.dummy) {
+			w.mu.Lock()
+			w.count++
+			w.mu.Unlock()
+		}
+		return bcrypt.CompareHashAndPassword(hash, password)
+	}
+
+	return w
+}
+
+func (w *dummyWatch) compared() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.count
+}
+
+// Hardened mode spends a bcrypt comparison on unknown users, so that they
+// take as long as a wrong password for an existing user.
+func TestLoginSpendsDummyHash(t *testing.T) {
+	for _, hardened := range []bool{true, false} {
+		a, st, _ := newTestAuth(t, hardened)
+		w := watchDummyHash(a)
+		ctx := context.Background()
+
+		if _, err := st.SaveUser(ctx, "jonn@gmail.com", mustHash(t, "Correct-Password-1"), domain.Event{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := a.Login(ctx, "jonn@gmail.com", "Wrong-Password-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+		if got := w.compared(); got != 0 {
+			t.Fatalf("hardened %v: dummy hash compared for an existing user", hardened)
+		}
+
+		if _, err := a.Login(ctx, "nobody@gmail.com", "Wrong-Password-1", 1); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+		if err := a.ChangePassword(ctx, "nobody@gmail.com", "Wrong-Password-1", "New-Password-1", "", 1); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+
+		want := 0
+		if hardened {
+			want = 2
+		}
+		if got := w.compared(); got != want {
+			t.Errorf("hardened %v: dummy hash compared %d times, want %d", hardened, got, want)
+		}
+	}
+}
+
+func TestRegisterHardened(t *testing.T) {
+	a, st, n := newTestAuth(t, true)
+	ctx := context.Background()
+
+	id, err := a.Register(ctx, "jonn@gmail.com", "Correct-Password-1", 0)
+	if err != nil || id != 0 {
+		t.Fatalf("expected 0 and no error, got %d, %v", id, err)
+	}
+
+	// the audit log keeps what the caller is not told
+	if ev := st.events[len(st.events)-1]; ev.Type != domain.AuditRegister || ev.ActorID != 1 || ev.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", ev)
+	}
+
+	id, err = a.Register(ctx, "jonn@gmail.com", "Other-Password-1", 0)
+	if err != nil || id != 0 {
+		t.Fatalf("expected 0 and no error for a taken email, got %d, %v", id, err)
+	}
+
+	for _, subject := range []string{"Welcome", "Registration attempt"} {
+		select {
+		case msg := <-n.sent:
+			if msg.To != "jonn@gmail.com" || msg.Subject != subject {
+				t.Errorf("unexpected notification %+v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %q was not sent", subject)
+		}
+	}
+}
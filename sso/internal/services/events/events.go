@@ -0,0 +1,170 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+type Storage interface {
+	OutboxEvents(ctx context.Context, filter domain.EventFilter) ([]domain.OutboxEvent, error)
+	OutboxHead(ctx context.Context) (domain.EventPosition, error)
+	OutboxPruned(ctx context.Context) (domain.EventPosition, error)
+}
+
+var (
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrCursorExpired    = errors.New("cursor expired")
+	ErrUnknownEventType = errors.New("unknown event type")
+)
+
+// EventTypes are the events a watcher can select.
+var EventTypes = []string{
+	domain.EventUserRegistered,
+	domain.EventPasswordChanged,
+	domain.EventUserDeleted,
+	domain.EventEmailChanged,
+}
+
+const (
+	defaultPollInterval = 500 * time.Millisecond
+	batchSize           = 100
+)
+
+// Events streams the outbox to watchers. The cursor of an event is its
+// position in the stream, a watcher that reconnects with the cursor of the
+// last event it got continues right after it. Events can be resumed for as
+// long as the outbox keeps them, older cursors fail with ErrCursorExpired
+// and the watcher has to resync.
+type Events struct {
+	log     *slog.Logger
+	storage Storage
+	cfg     config.Watch
+}
+
+// New returns new instance of the Events servic
+func New(log *slog.Logger, storage Storage, cfg config.Watch) *Events {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+
+	return &Events{
+		log:     log,
+		storage: storage,
+		cfg:     cfg,
+	}
+}
+
+// Watch calls send for every event matching types and appID after cursor
+// until ctx is done or send fails. An empty cursor starts at the newest
+// event.
+func (e *Events) Watch(
+	ctx context.Context,
+	types []string,
+	appID int64,
+	cursor string,
+	send func(event domain.Event, cursor string) error,
+) error {
+	const op = "events.Watch"
+
+	log := e.log.With(slog.String("op", op))
+
+	for _, t := range types {
+		if !slices.Contains(EventTypes, t) {
+			return fmt.Errorf("%s: %w %q", op, ErrUnknownEventType, t)
+		}
+	}
+
+	filter := domain.EventFilter{Types: types, AppID: appID, Limit: batchSize}
+
+	if cursor == "" {
+		head, err := e.storage.OutboxHead(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		filter.After = head
+	} else {
+		after, err := parseCursor(cursor)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		pruned, err := e.storage.OutboxPruned(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if after.Before(pruned) {
+			log.Info("cursor expired", slog.String("cursor", cursor))
+			return fmt.Errorf("%s: %w", op, ErrCursorExpired)
+		}
+		filter.After = after
+	}
+
+	log.Info("watching user events", slog.String("after", formatCursor(filter.After)), slog.Int64("app_id", appID))
+
+	ticker := time.NewTicker(e.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := e.storage.OutboxEvents(ctx, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%s: %w", op, ctx.Err())
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, event := range events {
+			if err := send(event.Event, formatCursor(event.Position)); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			filter.After = event.Position
+		}
+
+		if len(events) == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatCursor encodes p as "<xid>.<seq>".
+func formatCursor(p domain.EventPosition) string {
+	return strconv.FormatUint(p.XID, 10) + "." + strconv.FormatInt(p.Seq, 10)
+}
+
+// parseCursor decodes a cursor of formatCursor. The bare sequence numbers of
+// older versions are expired, their events may be ordered differently.
+func parseCursor(cursor string) (domain.EventPosition, error) {
+	xid, seq, ok := strings.Cut(cursor, ".")
+	if !ok {
+		if n, err := strconv.ParseInt(cursor, 10, 64); err == nil && n >= 0 {
+			return domain.EventPosition{}, ErrCursorExpired
+		}
+		return domain.EventPosition{}, ErrInvalidCursor
+	}
+
+	var p domain.EventPosition
+	var err error
+	if p.XID, err = strconv.ParseUint(xid, 10, 64); err != nil {
+		return domain.EventPosition{}, ErrInvalidCursor
+	}
+	if p.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil || p.Seq < 0 {
+		return domain.EventPosition{}, ErrInvalidCursor
+	}
+
+	return p, nil
+}
@@ -0,0 +1,188 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+type memStorage struct {
+	mu     sync.Mutex
+	events []domain.OutboxEvent
+	pruned domain.EventPosition
+}
+
+// add writes an event in a transaction of its own, transactions are
+// numbered like the events.
+func (m *memStorage) add(typ string, appID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seq := int64(len(m.events) + 1)
+	m.events = append(m.events, domain.OutboxEvent{
+		Seq:      seq,
+		Position: domain.EventPosition{XID: uint64(seq), Seq: seq},
+		Event:    domain.Event{Type: typ, AppID: appID, UserID: seq},
+	})
+}
+
+func (m *memStorage) OutboxEvents(_ context.Context, filter domain.EventFilter) ([]domain.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ordered := slices.SortedFunc(slices.Values(m.events), func(a, b domain.OutboxEvent) int {
+		if a.Position.Before(b.Position) {
+			return -1
+		}
+		return 1
+	})
+
+	var events []domain.OutboxEvent
+	for _, e := range ordered {
+		if !filter.After.Before(e.Position) || len(events) == filter.Limit {
+			continue
+		}
+		if len(filter.Types) > 0 && !slices.Contains(filter.Types, e.Event.Type) {
+			continue
+		}
+		if filter.AppID != 0 && e.Event.AppID != filter.AppID {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func (m *memStorage) OutboxHead(context.Context) (domain.EventPosition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.events) == 0 {
+		return m.pruned, nil
+	}
+
+	return m.events[len(m.events)-1].Position, nil
+}
+
+func (m *memStorage) OutboxPruned(context.Context) (domain.EventPosition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pruned, nil
+}
+
+// collect watches until n events arrived and returns their user ids and
+// the last cursor.
+func collect(t *testing.T, e *Events, types []string, appID int64, cursor string, n int) ([]int64, string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ids []int64
+	var last string
+	errDone := errors.New("done")
+
+	err := e.Watch(ctx, types, appID, cursor, func(event domain.Event, cursor string) error {
+		ids = append(ids, event.UserID)
+		last = cursor
+		if len(ids) == n {
+			return errDone
+		}
+		return nil
+	})
+	if !errors.Is(err, errDone) {
+		t.Fatalf("watch ended with %v after %v", err, ids)
+	}
+
+	return ids, last
+}
+
+func TestWatch(t *testing.T) {
+	st := &memStorage{}
+	st.add(domain.EventUserRegistered, 1)
+
+	e := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Watch{PollInterval: 10 * time.Millisecond})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		st.add(domain.EventUserRegistered, 2)
+		st.add(domain.EventPasswordChanged, 1)
+		st.add(domain.EventUserRegistered, 1)
+		st.add(domain.EventUserDeleted, 1)
+	}()
+
+	// without a cursor only new events arrive
+	ids, cursor := collect(t, e, []string{domain.EventUserRegistered, domain.EventUserDeleted}, 1, "", 1)
+	if !slices.Equal(ids, []int64{4}) {
+		t.Fatalf("expected [4], got %v", ids)
+	}
+
+	// resuming continues right after the cursor
+	ids, _ = collect(t, e, nil, 1, cursor, 1)
+	if !slices.Equal(ids, []int64{5}) {
+		t.Fatalf("expected [5] after cursor %s, got %v", cursor, ids)
+	}
+
+	ids, _ = collect(t, e, nil, 0, "0.0", 5)
+	if !slices.Equal(ids, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("expected every event from cursor 0.0, got %v", ids)
+	}
+
+	for _, cursor := range []string{"abc", "1.x", "x.1", "1.-1"} {
+		err := e.Watch(context.Background(), nil, 0, cursor, nil)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: expected ErrInvalidCursor, got %v", cursor, err)
+		}
+	}
+}
+
+func TestWatchExpiredCursor(t *testing.T) {
+	st := &memStorage{}
+	for range 4 {
+		st.add(domain.EventUserRegistered, 1)
+	}
+	// retention deleted the first two events
+	st.pruned = st.events[1].Position
+	st.events = st.events[2:]
+
+	e := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Watch{PollInterval: 10 * time.Millisecond})
+
+	// the cursor of the first event missed the second one
+	for _, cursor := range []string{"1.1", "1"} {
+		err := e.Watch(context.Background(), nil, 0, cursor, nil)
+		if !errors.Is(err, ErrCursorExpired) {
+			t.Errorf("cursor %q: expected ErrCursorExpired, got %v", cursor, err)
+		}
+	}
+
+	// the cursor of the last pruned event missed nothing
+	ids, _ := collect(t, e, nil, 0, "2.2", 2)
+	if !slices.Equal(ids, []int64{3, 4}) {
+		t.Fatalf("expected [3 4], got %v", ids)
+	}
+}
+
+func TestWatchTransactionOrder(t *testing.T) {
+	st := &memStorage{}
+	st.add(domain.EventUserRegistered, 1)
+	st.add(domain.EventUserRegistered, 1)
+	// the first event took its sequence number in a later transaction
+	st.events[0].Position.XID = 3
+
+	e := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Watch{PollInterval: 10 * time.Millisecond})
+
+	ids, _ := collect(t, e, nil, 0, "0.0", 2)
+	if !slices.Equal(ids, []int64{2, 1}) {
+		t.Fatalf("expected [2 1], got %v", ids)
+	}
+}
@@ -0,0 +1,536 @@
+package federation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/oidc"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Storage interface {
+	User(ctx context.Context, email string) (domain.User, error)
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	App(ctx context.Context, appID int64) (domain.App, error)
+
+	FederatedIdentity(ctx context.Context, connector string, subject string) (domain.FederatedIdentity, error)
+	FederatedIdentities(ctx context.Context, userID int64) ([]domain.FederatedIdentity, error)
+	SaveFederatedIdentity(ctx context.Context, identity domain.FederatedIdentity) (domain.FederatedIdentity, error)
+	// SaveFederatedUser creates the user and links identity to it, event
+	// goes into the outbox together with the user.
+	SaveFederatedUser(
+		ctx context.Context,
+		email string,
+		passwordHash []byte,
+		identity domain.FederatedIdentity,
+		event domain.Event,
+	) (domain.User, error)
+	UpdateProfile(ctx context.Context, userID int64, update domain.ProfileUpdate) (domain.Profile, error)
+
+	// UseSAMLAssertion records the assertion id of connector as used until
+	// expires, storage.ErrAssertionUsed when it was used already.
+	UseSAMLAssertion(ctx context.Context, connector string, id string, expires time.Time) error
+	DeleteExpiredSAMLAssertions(ctx context.Context) (int64, error)
+
+	SaveLoginCode(ctx context.Context, code domain.LoginCode) error
+	// UseLoginCode takes the code with hash, storage.ErrLoginCodeNotFound
+	// when it is unknown, expired or was taken already.
+	UseLoginCode(ctx context.Context, hash []byte) (domain.LoginCode, error)
+	DeleteExpiredLoginCodes(ctx context.Context) (int64, error)
+}
+
+// SessionStarter issues the tokens of a user the provider authenticated,
+// see auth.Auth.LoginExternal.
+type SessionStarter interface {
+	LoginExternal(ctx context.Context, user domain.User, appID int64, method string) (domain.Tokens, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated   = errors.New("an access token is required")
+	ErrPermissionDenied  = errors.New("api keys can not link identities")
+	ErrConnectorNotFound = errors.New("identity provider not found")
+	ErrAppNotFound       = errors.New("app not found")
+	ErrInvalidState      = errors.New("invalid or expired state")
+	ErrUpstreamLogin     = errors.New("identity provider login failed")
+	ErrAccountExists     = errors.New("an account with the email exists")
+	ErrNotProvisioned    = errors.New("no account for the identity")
+	ErrIdentityLinked    = errors.New("identity is linked to another user")
+	ErrEmailNotVerified  = errors.New("the provider did not verify the email")
+	ErrInvalidRedirect   = errors.New("redirect uri is not a callback url of the app")
+	ErrInvalidLoginCode  = errors.New("invalid or expired login code")
+)
+
+const (
+	defaultStateTTL = 10 * time.Minute
+	defaultTimeout  = 10 * time.Second
+	cleanupInterval = time.Hour
+	loginCodeTTL    = time.Minute
+)
+
+// Federation logs users in with upstream OpenID Connect providers. A login
+// starts at the provider with the URL of StartLogin and ends with the code
+// and state the provider redirects back with, FinishLogin turns them into
+// tokens. SAML providers work the same way with StartSAMLLogin and
+// FinishSAMLLogin, see saml.go.
+//
+// The identity of the provider is looked up by its subject. Unknown
+// identities are linked to the user that started the login with StartLink,
+// else to the user with the same email when the connector allows it, else a
+// user is created when the connector provisions users just in time and the
+// provider verified the email.
+type Federation struct {
+	log        *slog.Logger
+	storage    Storage
+	sessions   SessionStarter
+	auditor    Auditor
+	oidc       *oidc.Client
+	connectors []config.OIDCConnector
+	saml       []config.SAMLConnector
+	stateTTL   time.Duration
+}
+
+// New returns new instance of the Federation servic. The names of the SAML
+// connectors have to be unique.
+func New(log *slog.Logger, storage Storage, sessions SessionStarter, auditor Auditor, cfg config.Federation) (*Federation, error) {
+	const op = "federation.New"
+
+	if err := validateSAML(cfg.SAML); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.StateTTL <= 0 {
+		cfg.StateTTL = defaultStateTTL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	for i, c := range cfg.Connectors {
+		if c.TenantID == 0 {
+			cfg.Connectors[i].TenantID = tenant.DefaultID
+		}
+		if c.Link == "" {
+			cfg.Connectors[i].Link = domain.LinkNone
+		}
+	}
+
+	for i, c := range cfg.SAML {
+		if c.TenantID == 0 {
+			cfg.SAML[i].TenantID = tenant.DefaultID
+		}
+		if c.Link == "" {
+			cfg.SAML[i].Link = domain.LinkNone
+		}
+	}
+
+	return &Federation{
+		log:        log,
+		storage:    storage,
+		sessions:   sessions,
+		auditor:    auditor,
+		oidc:       oidc.NewClient(&http.Client{Timeout: cfg.Timeout}),
+		connectors: cfg.Connectors,
+		saml:       cfg.SAML,
+		stateTTL:   cfg.StateTTL,
+	}, nil
+}
+
+// StartLogin returns the URL of the login page of the provider and the
+// state the provider redirects back with.
+func (f *Federation) StartLogin(ctx context.Context, connector string, appID int64) (authURL string, err error) {
+	const op = "federation.StartLogin"
+
+	authURL, err = f.start(ctx, connector, appID, 0)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return authURL, nil
+}
+
+// StartLink is StartLogin for the caller, FinishLogin links the identity to
+// the caller instead of looking up the user by email. The caller has to
+// finish the login with its access token.
+func (f *Federation) StartLink(ctx context.Context, connector string) (authURL string, err error) {
+	const op = "federation.StartLink"
+
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+	// a leaked key must not be able to take over the account
+	if caller.APIKeyID != 0 {
+		return "", fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	authURL, err = f.start(ctx, connector, caller.AppID, caller.UserID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return authURL, nil
+}
+
+func (f *Federation) start(ctx context.Context, name string, appID int64, userID int64) (string, error) {
+	app, err := f.app(ctx, appID)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := f.connector(ctx, name, app)
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := f.oidc.Provider(ctx, conn.Issuer)
+	if err != nil {
+		f.log.Error("field to discover provider", slog.String("connector", conn.Name), slog.Any("err", err))
+		return "", err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := jwtToken.GetFederationState(jwtToken.FederationState{
+		Connector: conn.Name,
+		Nonce:     nonce,
+		UserID:    userID,
+	}, app, f.stateTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.AuthURL(conn.ClientID, conn.RedirectURL, conn.Scopes, state, nonce, codeVerifier(app, nonce)), nil
+}
+
+// FinishLogin redeems the code the provider redirected back with and starts
+// a session of the user behind the identity.
+func (f *Federation) FinishLogin(ctx context.Context, appID int64, state string, code string) (tokens domain.Tokens, err error) {
+	const op = "federation.FinishLogin"
+
+	log := f.log.With(slog.String("op", op))
+
+	event := domain.AuditEvent{Type: domain.AuditFederatedLogin, AppID: appID}
+	defer func() { f.record(ctx, event, err) }()
+
+	app, err := f.app(ctx, appID)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	st, err := jwtToken.ParseFederationState(state, app)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+
+	// a link is finished by the user that started it, else a stolen state
+	// would link the identity of a victim to the account of an attacker
+	if st.UserID != 0 {
+		if caller, ok := principal.FromContext(ctx); !ok || caller.UserID != st.UserID {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidState)
+		}
+	}
+
+	conn, err := f.connector(ctx, st.Connector, app)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+	event.Subject = conn.Name
+
+	claims, err := f.exchange(ctx, conn, code, st.Nonce, codeVerifier(app, st.Nonce))
+	if err != nil {
+		log.Warn("upstream login failed", slog.String("connector", conn.Name), slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrUpstreamLogin)
+	}
+	event.Subject = conn.Name + ":" + claims.Subject
+
+	user, err := f.resolve(ctx, policy{Name: conn.Name, JIT: conn.JIT, Link: conn.Link}, st.UserID, asserted{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, appID)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+	event.ActorID = user.ID
+
+	tokens, err = f.sessions.LoginExternal(ctx, user, appID, domain.AMRFederated)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("federated login", slog.String("connector", conn.Name), slog.Int64("uid", user.ID))
+
+	return tokens, nil
+}
+
+// Identities returns the identities linked to the caller.
+func (f *Federation) Identities(ctx context.Context) ([]domain.FederatedIdentity, error) {
+	const op = "federation.Identities"
+
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	identities, err := f.storage.FederatedIdentities(ctx, caller.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identities, nil
+}
+
+func (f *Federation) exchange(ctx context.Context, conn config.OIDCConnector, code string, nonce string, verifier string) (oidc.Claims, error) {
+	provider, err := f.oidc.Provider(ctx, conn.Issuer)
+	if err != nil {
+		return oidc.Claims{}, err
+	}
+
+	idToken, err := f.oidc.Exchange(ctx, provider, conn.ClientID, conn.ClientSecret, conn.RedirectURL, code, verifier)
+	if err != nil {
+		return oidc.Claims{}, err
+	}
+
+	return f.oidc.Verify(ctx, provider, idToken, conn.ClientID, nonce)
+}
+
+// policy is how a connector, OpenID Connect or SAML, treats new identities.
+type policy struct {
+	Name string
+	JIT  bool
+	Link string
+}
+
+// asserted is what a provider says about the user. Profile fills the profile
+// of a provisioned user.
+type asserted struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Profile       domain.ProfileUpdate
+}
+
+// resolve returns the user of the identity, linking or provisioning it on
+// its first login. linkTo is the user that started the login with StartLink.
+func (f *Federation) resolve(
+	ctx context.Context,
+	conn policy,
+	linkTo int64,
+	claims asserted,
+	appID int64,
+) (domain.User, error) {
+	identity, err := f.storage.FederatedIdentity(ctx, conn.Name, claims.Subject)
+	switch {
+	case err == nil:
+		if linkTo != 0 && linkTo != identity.UserID {
+			return domain.User{}, ErrIdentityLinked
+		}
+		return f.user(ctx, identity.UserID)
+	case !errors.Is(err, storage.ErrIdentityNotFound):
+		return domain.User{}, err
+	}
+
+	identity = domain.FederatedIdentity{
+		Connector: conn.Name,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+	}
+
+	if linkTo != 0 {
+		return f.link(ctx, linkTo, identity, appID)
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" {
+		return domain.User{}, ErrNotProvisioned
+	}
+
+	user, err := f.storage.User(ctx, email)
+	switch {
+	case err == nil:
+		// an unverified email could be anyone's, linking it would hand
+		// the account over
+		if conn.Link != domain.LinkVerifiedEmail || !claims.EmailVerified {
+			return domain.User{}, ErrAccountExists
+		}
+		return f.link(ctx, user.ID, identity, appID)
+	case !errors.Is(err, storage.ErrUserNotFound):
+		return domain.User{}, err
+	}
+
+	if !conn.JIT {
+		return domain.User{}, ErrNotProvisioned
+	}
+	// the account would hold an address nobody proved to own, it could
+	// not be linked or recovered safely later
+	if !claims.EmailVerified {
+		return domain.User{}, ErrEmailNotVerified
+	}
+
+	return f.provision(ctx, email, identity, claims.Profile, appID)
+}
+
+func (f *Federation) link(ctx context.Context, userID int64, identity domain.FederatedIdentity, appID int64) (user domain.User, err error) {
+	defer func() {
+		f.record(ctx, domain.AuditEvent{
+			Type:    domain.AuditIdentityLink,
+			ActorID: userID,
+			Subject: identity.Connector + ":" + identity.Subject,
+			AppID:   appID,
+		}, err)
+	}()
+
+	identity.UserID = userID
+	if _, err := f.storage.SaveFederatedIdentity(ctx, identity); err != nil {
+		if errors.Is(err, storage.ErrIdentityLinked) {
+			return domain.User{}, ErrIdentityLinked
+		}
+		return domain.User{}, err
+	}
+
+	f.log.Info("identity linked", slog.String("connector", identity.Connector), slog.Int64("uid", userID))
+
+	return f.user(ctx, userID)
+}
+
+// provision creates the user of a new identity. The user gets a password
+// nobody knows, it logs in through the provider.
+func (f *Federation) provision(ctx context.Context, email string, identity domain.FederatedIdentity, profile domain.ProfileUpdate, appID int64) (domain.User, error) {
+	secret, err := randomString()
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	user, err := f.storage.SaveFederatedUser(ctx, email, passwordHash, identity, domain.Event{
+		Type:  domain.EventUserRegistered,
+		AppID: appID,
+		Email: email,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserExists):
+			// registered in the meantime
+			return domain.User{}, ErrAccountExists
+		case errors.Is(err, storage.ErrIdentityLinked):
+			return domain.User{}, ErrIdentityLinked
+		}
+		return domain.User{}, err
+	}
+
+	f.record(ctx, domain.AuditEvent{Type: domain.AuditRegister, ActorID: user.ID, Subject: email, AppID: appID}, nil)
+
+	// the user exists already, a profile that could not be saved is
+	// filled in by the user later
+	if profile.DisplayName != nil || profile.Locale != nil || profile.Timezone != nil || profile.AvatarURL != nil {
+		if _, err := f.storage.UpdateProfile(ctx, user.ID, profile); err != nil {
+			f.log.Error("field to save profile", slog.Int64("uid", user.ID), slog.Any("err", err))
+		}
+	}
+
+	f.log.Info("user provisioned", slog.String("connector", identity.Connector), slog.Int64("uid", user.ID))
+
+	return user, nil
+}
+
+func (f *Federation) user(ctx context.Context, userID int64) (domain.User, error) {
+	user, err := f.storage.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.User{}, ErrNotProvisioned
+		}
+		return domain.User{}, err
+	}
+
+	return user, nil
+}
+
+func (f *Federation) app(ctx context.Context, appID int64) (domain.App, error) {
+	app, err := f.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.App{}, ErrAppNotFound
+		}
+		return domain.App{}, err
+	}
+
+	return app, nil
+}
+
+// connector returns the connector name of the tenant of ctx usable by app.
+func (f *Federation) connector(ctx context.Context, name string, app domain.App) (config.OIDCConnector, error) {
+	tenantID := tenant.ID(ctx)
+	for _, c := range f.connectors {
+		if c.Name == name && c.TenantID == tenantID && (c.AppID == 0 || c.AppID == app.ID) {
+			return c, nil
+		}
+	}
+
+	return config.OIDCConnector{}, ErrConnectorNotFound
+}
+
+func (f *Federation) record(ctx context.Context, event domain.AuditEvent, err error) {
+	event.Result = domain.AuditSuccess
+	if err != nil {
+		event.Result = domain.AuditFailure
+		event.Reason = rootCause(err).Error()
+	}
+
+	f.auditor.Record(ctx, event)
+}
+
+// rootCause strips the "op: " prefixes added on the way up.
+func rootCause(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// codeVerifier is the PKCE verifier of the login with nonce. It is derived
+// with the secret of app rather than put into the state, which travels with
+// the code through the browser.
+func codeVerifier(app domain.App, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(app.Secret))
+	mac.Write([]byte("pkce:" + nonce))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,438 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/oidc/oidctest"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+const redirectURL = "https://app.example.com/callback"
+
+type fakeStorage struct {
+	users      map[int64]domain.User
+	identities []domain.FederatedIdentity
+	events     []domain.AuditEvent
+	outbox     []domain.Event
+	logins     []int64
+	clients    []clientinfo.Info
+	assertions map[string]time.Time
+	codes      map[string]domain.LoginCode
+	profiles   map[int64]domain.ProfileUpdate
+}
+
+func (f *fakeStorage) User(_ context.Context, email string) (domain.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+
+	return domain.User{}, storage.ErrUserNotFound
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	u, ok := f.users[userID]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int64) (domain.App, error) {
+	if appID != 1 && appID != 2 {
+		return domain.App{}, storage.ErrAppNotFound
+	}
+
+	return domain.App{ID: appID, Secret: "secret", CallbackURLs: []string{redirectURL}}, nil
+}
+
+func (f *fakeStorage) FederatedIdentity(_ context.Context, connector string, subject string) (domain.FederatedIdentity, error) {
+	for _, i := range f.identities {
+		if i.Connector == connector && i.Subject == subject {
+			return i, nil
+		}
+	}
+
+	return domain.FederatedIdentity{}, storage.ErrIdentityNotFound
+}
+
+func (f *fakeStorage) FederatedIdentities(_ context.Context, userID int64) ([]domain.FederatedIdentity, error) {
+	var out []domain.FederatedIdentity
+	for _, i := range f.identities {
+		if i.UserID == userID {
+			out = append(out, i)
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeStorage) SaveFederatedIdentity(ctx context.Context, identity domain.FederatedIdentity) (domain.FederatedIdentity, error) {
+	if _, err := f.FederatedIdentity(ctx, identity.Connector, identity.Subject); err == nil {
+		return domain.FederatedIdentity{}, storage.ErrIdentityLinked
+	}
+
+	identity.ID = int64(len(f.identities) + 1)
+	identity.CreatedAt = time.Now()
+	f.identities = append(f.identities, identity)
+
+	return identity, nil
+}
+
+func (f *fakeStorage) SaveFederatedUser(
+	ctx context.Context,
+	email string,
+	passwordHash []byte,
+	identity domain.FederatedIdentity,
+	event domain.Event,
+) (domain.User, error) {
+	if _, err := f.User(ctx, email); err == nil {
+		return domain.User{}, storage.ErrUserExists
+	}
+
+	user := domain.User{ID: int64(len(f.users) + 1), Email: email, PasswordHash: passwordHash, Status: domain.UserStatusActive}
+	f.users[user.ID] = user
+
+	identity.UserID = user.ID
+	if _, err := f.SaveFederatedIdentity(ctx, identity); err != nil {
+		return domain.User{}, err
+	}
+
+	event.UserID = user.ID
+	f.outbox = append(f.outbox, event)
+
+	return user, nil
+}
+
+func (f *fakeStorage) UpdateProfile(_ context.Context, userID int64, update domain.ProfileUpdate) (domain.Profile, error) {
+	if f.profiles == nil {
+		f.profiles = map[int64]domain.ProfileUpdate{}
+	}
+	f.profiles[userID] = update
+
+	return domain.Profile{UserID: userID}, nil
+}
+
+func (f *fakeStorage) LoginExternal(ctx context.Context, user domain.User, appID int64, method string) (domain.Tokens, error) {
+	if method != domain.AMRFederated {
+		return domain.Tokens{}, errors.New("unexpected method " + method)
+	}
+	if user.Status == domain.UserStatusDisabled {
+		return domain.Tokens{}, errors.New("user is disabled")
+	}
+	f.logins = append(f.logins, user.ID)
+	f.clients = append(f.clients, clientinfo.FromContext(ctx))
+
+	return domain.Tokens{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func (f *fakeStorage) UseSAMLAssertion(_ context.Context, connector string, id string, expires time.Time) error {
+	if f.assertions == nil {
+		f.assertions = make(map[string]time.Time)
+	}
+
+	key := connector + ":" + id
+	if used, ok := f.assertions[key]; ok && time.Now().Before(used) {
+		return storage.ErrAssertionUsed
+	}
+	f.assertions[key] = expires
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteExpiredSAMLAssertions(_ context.Context) (int64, error) {
+	var n int64
+	for key, expires := range f.assertions {
+		if time.Now().After(expires) {
+			delete(f.assertions, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakeStorage) SaveLoginCode(_ context.Context, code domain.LoginCode) error {
+	if f.codes == nil {
+		f.codes = make(map[string]domain.LoginCode)
+	}
+	f.codes[string(code.Hash)] = code
+
+	return nil
+}
+
+func (f *fakeStorage) UseLoginCode(_ context.Context, hash []byte) (domain.LoginCode, error) {
+	code, ok := f.codes[string(hash)]
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return domain.LoginCode{}, storage.ErrLoginCodeNotFound
+	}
+	delete(f.codes, string(hash))
+
+	return code, nil
+}
+
+func (f *fakeStorage) DeleteExpiredLoginCodes(_ context.Context) (int64, error) {
+	var n int64
+	for key, code := range f.codes {
+		if time.Now().After(code.ExpiresAt) {
+			delete(f.codes, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestFederation(t *testing.T, connectors ...config.OIDCConnector) (*Federation, *fakeStorage, *oidctest.Provider) {
+	t.Helper()
+
+	stub := oidctest.New(t, "client", "client-secret")
+	for i := range connectors {
+		connectors[i].Issuer = stub.Issuer
+		connectors[i].ClientID = "client"
+		connectors[i].ClientSecret = "client-secret"
+		connectors[i].RedirectURL = redirectURL
+	}
+
+	st := &fakeStorage{
+		users: map[int64]domain.User{
+			1: {ID: 1, Email: "jonn@gmail.com", Status: domain.UserStatusActive},
+		},
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	f, err := New(log, st, st, st, config.Federation{Connectors: connectors})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, st, stub
+}
+
+// login goes through the provider as id and returns what FinishLogin does.
+func login(t *testing.T, f *Federation, stub *oidctest.Provider, ctx context.Context, authURL string, id oidctest.Identity) (domain.Tokens, error) {
+	t.Helper()
+
+	code, state, err := stub.Login(authURL, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f.FinishLogin(ctx, 1, state, code)
+}
+
+func start(t *testing.T, f *Federation, ctx context.Context) string {
+	t.Helper()
+
+	authURL, err := f.StartLogin(ctx, "idp", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authURL
+}
+
+func TestJustInTimeProvisioning(t *testing.T) {
+	f, st, stub := newTestFederation(t, config.OIDCConnector{Name: "idp", JIT: true})
+	ctx := context.Background()
+	id := oidctest.Identity{Subject: "u-1", Email: "new@gmail.com", EmailVerified: true}
+
+	tokens, err := login(t, f, stub, ctx, start(t, f, ctx), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" {
+		t.Fatal("no access token")
+	}
+
+	if len(st.users) != 2 || len(st.identities) != 1 || st.identities[0].UserID != 2 {
+		t.Fatalf("user not provisioned: %+v %+v", st.users, st.identities)
+	}
+	if len(st.outbox) != 1 || st.outbox[0].Type != domain.EventUserRegistered {
+		t.Fatalf("unexpected outbox %+v", st.outbox)
+	}
+
+	// the second login finds the identity
+	if _, err := login(t, f, stub, ctx, start(t, f, ctx), id); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.users) != 2 || len(st.logins) != 2 || st.logins[1] != 2 {
+		t.Fatalf("unexpected logins %v", st.logins)
+	}
+}
+
+func TestWithoutProvisioning(t *testing.T) {
+	f, st, stub := newTestFederation(t, config.OIDCConnector{Name: "idp"})
+	ctx := context.Background()
+
+	_, err := login(t, f, stub, ctx, start(t, f, ctx), oidctest.Identity{Subject: "u-1", Email: "new@gmail.com", EmailVerified: true})
+	if !errors.Is(err, ErrNotProvisioned) {
+		t.Fatalf("expected ErrNotProvisioned, got %v", err)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditFederatedLogin || last.Result != domain.AuditFailure || last.Subject != "idp:u-1" {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestProvisioningNeedsVerifiedEmail(t *testing.T) {
+	f, st, stub := newTestFederation(t, config.OIDCConnector{Name: "idp", JIT: true})
+	ctx := context.Background()
+
+	_, err := login(t, f, stub, ctx, start(t, f, ctx), oidctest.Identity{Subject: "u-1", Email: "new@gmail.com"})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got %v", err)
+	}
+	if len(st.users) != 1 || len(st.identities) != 0 {
+		t.Fatalf("user provisioned: %+v %+v", st.users, st.identities)
+	}
+}
+
+func TestLinkByEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		link     string
+		verified bool
+		wantErr  error
+	}{
+		{name: "verified email", link: domain.LinkVerifiedEmail, verified: true},
+		{name: "unverified email", link: domain.LinkVerifiedEmail, verified: false, wantErr: ErrAccountExists},
+		{name: "linking off", link: domain.LinkNone, verified: true, wantErr: ErrAccountExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, st, stub := newTestFederation(t, config.OIDCConnector{Name: "idp", JIT: true, Link: tt.link})
+			ctx := context.Background()
+
+			_, err := login(t, f, stub, ctx, start(t, f, ctx),
+				oidctest.Identity{Subject: "u-1", Email: "jonn@gmail.com", EmailVerified: tt.verified})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+
+			if tt.wantErr != nil {
+				if len(st.identities) != 0 || len(st.users) != 1 {
+					t.Fatalf("identity linked or user created: %+v", st.identities)
+				}
+				return
+			}
+
+			if len(st.identities) != 1 || st.identities[0].UserID != 1 || st.logins[0] != 1 {
+				t.Fatalf("identity not linked to the user: %+v", st.identities)
+			}
+		})
+	}
+}
+
+func TestStartLink(t *testing.T) {
+	f, st, stub := newTestFederation(t, config.OIDCConnector{Name: "idp"})
+	caller := principal.With(context.Background(), principal.Principal{UserID: 1, AppID: 1})
+	id := oidctest.Identity{Subject: "u-1", Email: "other@gmail.com"}
+
+	if _, err := f.StartLink(context.Background(), "idp"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+	withKey := principal.With(context.Background(), principal.Principal{UserID: 1, AppID: 1, APIKeyID: 3})
+	if _, err := f.StartLink(withKey, "idp"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	authURL, err := f.StartLink(caller, "idp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone else can not finish the link
+	if _, err := login(t, f, stub, context.Background(), authURL, id); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState, got %v", err)
+	}
+
+	if _, err := login(t, f, stub, caller, authURL, id); err != nil {
+		t.Fatal(err)
+	}
+
+	identities, err := f.Identities(caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Subject != "u-1" {
+		t.Fatalf("unexpected identities %+v", identities)
+	}
+
+	// the identity can not be linked to a second user
+	st.users[2] = domain.User{ID: 2, Email: "other@gmail.com", Status: domain.UserStatusActive}
+	second := principal.With(context.Background(), principal.Principal{UserID: 2, AppID: 1})
+
+	authURL, err = f.StartLink(second, "idp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(t, f, stub, second, authURL, id); !errors.Is(err, ErrIdentityLinked) {
+		t.Fatalf("expected ErrIdentityLinked, got %v", err)
+	}
+}
+
+func TestConnectorScope(t *testing.T) {
+	f, _, _ := newTestFederation(t,
+		config.OIDCConnector{Name: "idp", AppID: 2},
+		config.OIDCConnector{Name: "other", TenantID: 5},
+	)
+	ctx := context.Background()
+
+	if _, err := f.StartLogin(ctx, "idp", 1); !errors.Is(err, ErrConnectorNotFound) {
+		t.Fatalf("expected ErrConnectorNotFound for another app, got %v", err)
+	}
+	if _, err := f.StartLogin(ctx, "idp", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.StartLogin(ctx, "other", 1); !errors.Is(err, ErrConnectorNotFound) {
+		t.Fatalf("expected ErrConnectorNotFound for another tenant, got %v", err)
+	}
+	if _, err := f.StartLogin(tenant.With(ctx, 5), "other", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.StartLogin(ctx, "idp", 9); !errors.Is(err, ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got %v", err)
+	}
+}
+
+func TestFinishRejectsState(t *testing.T) {
+	f, _, stub := newTestFederation(t, config.OIDCConnector{Name: "idp", JIT: true})
+	ctx := context.Background()
+
+	code, state, err := stub.Login(start(t, f, ctx), oidctest.Identity{Subject: "u-1", Email: "new@gmail.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.FinishLogin(ctx, 1, state+"x", code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState, got %v", err)
+	}
+	// the state is bound to the app it was issued for
+	if _, err := f.FinishLogin(ctx, 2, state, code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState, got %v", err)
+	}
+	if _, err := f.FinishLogin(ctx, 1, state, "wrong code"); !errors.Is(err, ErrUpstreamLogin) {
+		t.Fatalf("expected ErrUpstreamLogin, got %v", err)
+	}
+}
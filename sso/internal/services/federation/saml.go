@@ -0,0 +1,386 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/jwtToken"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/saml"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/services/profile"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// SAMLMetadata returns the service provider metadata of the SAML connector
+// name, the provider is set up with it.
+func (f *Federation) SAMLMetadata(ctx context.Context, name string) ([]byte, error) {
+	const op = "federation.SAMLMetadata"
+
+	conn, err := f.samlConnector(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sp, err := serviceProvider(conn)
+	if err != nil {
+		f.log.Error("field to build service provider", slog.String("connector", conn.Name), slog.Any("err", err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return metadata, nil
+}
+
+// StartSAMLLogin returns the URL that sends the browser to the provider
+// with an AuthnRequest and the state of the login, which travels as
+// RelayState. redirectURI has to be one of the callback urls of the app, the
+// user is sent back to it once the login is done.
+func (f *Federation) StartSAMLLogin(ctx context.Context, name string, appID int64, redirectURI string) (authURL string, state string, err error) {
+	const op = "federation.StartSAMLLogin"
+
+	conn, err := f.samlConnector(name)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	ctx = tenant.With(ctx, conn.TenantID)
+
+	app, err := f.app(ctx, appID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if conn.AppID != 0 && conn.AppID != app.ID {
+		return "", "", fmt.Errorf("%s: %w", op, ErrConnectorNotFound)
+	}
+	if !slices.Contains(app.CallbackURLs, redirectURI) {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRedirect)
+	}
+
+	sp, err := serviceProvider(conn)
+	if err != nil {
+		f.log.Error("field to build service provider", slog.String("connector", conn.Name), slog.Any("err", err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	state, err = jwtToken.GetFederationState(jwtToken.FederationState{
+		Connector:   conn.Name,
+		Nonce:       nonce,
+		RedirectURI: redirectURI,
+	}, app, f.stateTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL, err = sp.AuthnRequestURL(requestID(nonce), state)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return authURL, state, nil
+}
+
+// FinishSAMLLogin checks the response the provider posted to the ACS of the
+// connector name and returns the callback of the app with a one-time code
+// for the user it asserts, see RedeemLoginCode. relayState is the one
+// StartSAMLLogin sent along, the client of ctx is the one the session is
+// started for.
+func (f *Federation) FinishSAMLLogin(ctx context.Context, name string, samlResponse string, relayState string) (redirectURL string, err error) {
+	const op = "federation.FinishSAMLLogin"
+
+	log := f.log.With(slog.String("op", op))
+
+	event := domain.AuditEvent{Type: domain.AuditFederatedLogin, Subject: name}
+	defer func() { f.record(ctx, event, err) }()
+
+	conn, err := f.samlConnector(name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	ctx = tenant.With(ctx, conn.TenantID)
+
+	// the state is signed with the secret of its app
+	appID, err := jwtToken.AppID(relayState)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+	event.AppID = appID
+
+	app, err := f.app(ctx, appID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	st, err := jwtToken.ParseFederationState(relayState, app)
+	if err != nil || st.Connector != conn.Name || st.UserID != 0 {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+	// the callback may have been removed since the login started
+	if !slices.Contains(app.CallbackURLs, st.RedirectURI) {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidRedirect)
+	}
+	if conn.AppID != 0 && conn.AppID != app.ID {
+		return "", fmt.Errorf("%s: %w", op, ErrConnectorNotFound)
+	}
+
+	sp, err := serviceProvider(conn)
+	if err != nil {
+		log.Error("field to build service provider", slog.String("connector", conn.Name), slog.Any("err", err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	assertion, err := sp.ParseResponse(samlResponse, requestID(st.Nonce))
+	if err != nil {
+		log.Warn("upstream login failed", slog.String("connector", conn.Name), slog.Any("err", err))
+		return "", fmt.Errorf("%s: %w", op, ErrUpstreamLogin)
+	}
+	event.Subject = conn.Name + ":" + assertion.NameID
+
+	if err := f.storage.UseSAMLAssertion(ctx, conn.Name, assertion.ID, assertion.NotOnOrAfter); err != nil {
+		if errors.Is(err, storage.ErrAssertionUsed) {
+			log.Warn("saml assertion replayed", slog.String("connector", conn.Name), slog.String("id", assertion.ID))
+			return "", fmt.Errorf("%s: %w", op, ErrUpstreamLogin)
+		}
+		log.Error("field to record saml assertion", slog.Any("err", err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := f.resolve(ctx, policy{Name: conn.Name, JIT: conn.JIT, Link: conn.Link}, 0, asserted{
+		Subject:       assertion.NameID,
+		Email:         assertedEmail(conn, assertion),
+		EmailVerified: conn.TrustEmail,
+		Profile:       assertedProfile(log, conn, assertion),
+	}, app.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	event.ActorID = user.ID
+
+	// the tokens are issued to the app once it redeems the code, they never
+	// pass through the browser
+	code, err := randomString()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	sum := sha256.Sum256([]byte(code))
+	client := clientinfo.FromContext(ctx)
+
+	err = f.storage.SaveLoginCode(ctx, domain.LoginCode{
+		Hash:      sum[:],
+		UserID:    user.ID,
+		AppID:     app.ID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Device:    client.Device,
+		ExpiresAt: time.Now().Add(loginCodeTTL),
+	})
+	if err != nil {
+		log.Error("field to save login code", slog.Any("err", err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redirect, err := url.Parse(st.RedirectURI)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+	q := redirect.Query()
+	q.Set("code", code)
+	redirect.RawQuery = q.Encode()
+
+	log.Info("saml login", slog.String("connector", conn.Name), slog.Int64("uid", user.ID))
+
+	return redirect.String(), nil
+}
+
+// RedeemLoginCode starts a session of the user of a code FinishSAMLLogin
+// issued to appID. A code is redeemed once, the session is the one of the
+// client that logged in.
+func (f *Federation) RedeemLoginCode(ctx context.Context, appID int64, code string) (tokens domain.Tokens, err error) {
+	const op = "federation.RedeemLoginCode"
+
+	log := f.log.With(slog.String("op", op))
+
+	sum := sha256.Sum256([]byte(code))
+
+	loginCode, err := f.storage.UseLoginCode(ctx, sum[:])
+	if err != nil {
+		if errors.Is(err, storage.ErrLoginCodeNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+		}
+		log.Error("field to use login code", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if loginCode.AppID != appID {
+		log.Warn("login code of another app", slog.Int64("app_id", appID))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+	}
+
+	user, err := f.storage.UserByID(ctx, loginCode.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx = clientinfo.With(ctx, clientinfo.Info{
+		IP:        loginCode.IP,
+		UserAgent: loginCode.UserAgent,
+		Device:    loginCode.Device,
+	})
+
+	tokens, err = f.sessions.LoginExternal(ctx, user, appID, domain.AMRFederated)
+	if err != nil {
+		return domain.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// RunCleanup deletes the used SAML assertions and the login codes that
+// expired until ctx is done, a replay of an assertion fails the check of its
+// validity.
+func (f *Federation) RunCleanup(ctx context.Context) {
+	const op = "federation.RunCleanup"
+
+	log := f.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := f.storage.DeleteExpiredSAMLAssertions(ctx)
+		if err != nil {
+			log.Error("field to delete expired saml assertions", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("expired saml assertions deleted", slog.Int64("count", n))
+		}
+
+		n, err = f.storage.DeleteExpiredLoginCodes(ctx)
+		if err != nil {
+			log.Error("field to delete expired login codes", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("expired login codes deleted", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// samlConnector returns the SAML connector name, New checked that names are
+// unique across tenants.
+func (f *Federation) samlConnector(name string) (config.SAMLConnector, error) {
+	for _, c := range f.saml {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	return config.SAMLConnector{}, ErrConnectorNotFound
+}
+
+// validateSAML rejects connectors without a name or with the name of
+// another, the HTTP endpoints tell connectors apart by name alone.
+func validateSAML(connectors []config.SAMLConnector) error {
+	names := make(map[string]bool, len(connectors))
+	for _, c := range connectors {
+		if c.Name == "" {
+			return errors.New("saml connector without a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("saml connector %q is configured twice", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	return nil
+}
+
+func serviceProvider(conn config.SAMLConnector) (*saml.ServiceProvider, error) {
+	cert, err := saml.ParseCertificate(conn.IdPCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of %s: %w", conn.Name, err)
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:        conn.EntityID,
+		ACSURL:          conn.ACSURL,
+		IdPEntityID:     conn.IdPEntityID,
+		IdPSSOURL:       conn.IdPSSOURL,
+		IdPCertificates: []*x509.Certificate{cert},
+	}, nil
+}
+
+// assertedEmail maps the attributes of an assertion to the email of the
+// user, the NameID serves when it is an email address.
+func assertedEmail(conn config.SAMLConnector, assertion saml.Assertion) string {
+	if email := assertion.Attribute(conn.EmailAttribute); conn.EmailAttribute != "" && email != "" {
+		return email
+	}
+
+	if assertion.NameIDFormat == saml.NameIDFormatEmail {
+		return assertion.NameID
+	}
+
+	return ""
+}
+
+// assertedProfile maps the attributes of an assertion to a profile, values
+// a profile can not hold are left out.
+func assertedProfile(log *slog.Logger, conn config.SAMLConnector, assertion saml.Assertion) domain.ProfileUpdate {
+	var update domain.ProfileUpdate
+
+	set := func(field **string, attribute string) {
+		if value := assertion.Attribute(attribute); attribute != "" && value != "" {
+			*field = &value
+		}
+	}
+	set(&update.DisplayName, conn.ProfileAttributes.DisplayName)
+	set(&update.Locale, conn.ProfileAttributes.Locale)
+	set(&update.Timezone, conn.ProfileAttributes.Timezone)
+	set(&update.AvatarURL, conn.ProfileAttributes.AvatarURL)
+
+	for _, v := range profile.ValidateFields(update) {
+		log.Warn("asserted profile field dropped", slog.String("connector", conn.Name), slog.String("field", v.Field))
+		switch v.Field {
+		case "display_name":
+			update.DisplayName = nil
+		case "locale":
+			update.Locale = nil
+		case "timezone":
+			update.Timezone = nil
+		case "avatar_url":
+			update.AvatarURL = nil
+		}
+	}
+
+	return update
+}
+
+// requestID is the ID of the AuthnRequest of a login, an XML ID has to start
+// with a letter.
+func requestID(nonce string) string {
+	return "id-" + nonce
+}
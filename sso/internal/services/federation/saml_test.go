@@ -0,0 +1,416 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/saml/samltest"
+)
+
+func newTestSAMLFederation(t *testing.T, conn config.SAMLConnector) (*Federation, *fakeStorage, *samltest.IdP) {
+	t.Helper()
+
+	idp := samltest.New(t, "https://idp.example.com")
+
+	conn.Name = "corp"
+	conn.EntityID = "https://sso.example.com/saml/corp/metadata"
+	conn.ACSURL = "https://sso.example.com/saml/corp/acs"
+	conn.IdPEntityID = idp.EntityID
+	conn.IdPSSOURL = idp.SSOURL
+	conn.IdPCertificate = idp.CertificatePEM
+	if conn.EmailAttribute == "" {
+		conn.EmailAttribute = "email"
+	}
+
+	st := &fakeStorage{
+		users: map[int64]domain.User{
+			1: {ID: 1, Email: "jonn@gmail.com", Status: domain.UserStatusActive},
+		},
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	f, err := New(log, st, st, st, config.Federation{SAML: []config.SAMLConnector{conn}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, st, idp
+}
+
+// samlLogin starts a login, lets idp answer it with r, posts the answer to
+// the ACS and redeems the code it sends the user back with.
+func samlLogin(t *testing.T, f *Federation, idp *samltest.IdP, r samltest.Response) (domain.Tokens, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	authURL, _, err := f.StartSAMLLogin(ctx, "corp", 1, redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samlResponse, relayState, err := idp.Login(authURL, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirect, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, relayState)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	return f.RedeemLoginCode(ctx, 1, loginCode(t, redirect))
+}
+
+// loginCode returns the code of the callback url redirect.
+func loginCode(t *testing.T, redirect string) string {
+	t.Helper()
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme+"://"+u.Host+u.Path != redirectURL {
+		t.Fatalf("unexpected redirect %s", redirect)
+	}
+
+	code := u.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", redirect)
+	}
+
+	return code
+}
+
+func TestSAMLJustInTimeProvisioning(t *testing.T) {
+	f, st, idp := newTestSAMLFederation(t, config.SAMLConnector{JIT: true, TrustEmail: true})
+	r := samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}}
+
+	tokens, err := samlLogin(t, f, idp, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" {
+		t.Fatal("no access token")
+	}
+
+	if len(st.users) != 2 || st.users[2].Email != "new@gmail.com" {
+		t.Fatalf("user not provisioned: %+v", st.users)
+	}
+	if len(st.identities) != 1 || st.identities[0].Connector != "corp" || st.identities[0].Subject != "u-1" {
+		t.Fatalf("unexpected identities %+v", st.identities)
+	}
+
+	// the second login finds the identity
+	if _, err := samlLogin(t, f, idp, r); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.users) != 2 || len(st.logins) != 2 || st.logins[1] != 2 {
+		t.Fatalf("unexpected logins %v", st.logins)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditFederatedLogin || last.Subject != "corp:u-1" || last.ActorID != 2 || last.AppID != 1 || last.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestSAMLProvisionedProfile(t *testing.T) {
+	f, st, idp := newTestSAMLFederation(t, config.SAMLConnector{
+		JIT:        true,
+		TrustEmail: true,
+		ProfileAttributes: config.SAMLProfileAttributes{
+			DisplayName: "displayName",
+			Locale:      "preferredLanguage",
+			AvatarURL:   "photo",
+		},
+	})
+	r := samltest.Response{NameID: "u-1", Attributes: map[string][]string{
+		"email":             {"new@gmail.com"},
+		"displayName":       {"New User"},
+		"preferredLanguage": {"pt-BR"},
+		"photo":             {"http://insecure.example.com/photo.png"},
+		"timezone":          {"Europe/Berlin"},
+	}}
+
+	if _, err := samlLogin(t, f, idp, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// the avatar a profile can not hold and the unmapped timezone are left out
+	p, ok := st.profiles[2]
+	if !ok || p.DisplayName == nil || *p.DisplayName != "New User" || p.Locale == nil || *p.Locale != "pt-BR" || p.AvatarURL != nil || p.Timezone != nil {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+
+	// the profile is the user's once provisioned
+	delete(st.profiles, 2)
+	if _, err := samlLogin(t, f, idp, r); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.profiles) != 0 {
+		t.Fatalf("profile updated on a later login: %+v", st.profiles)
+	}
+}
+
+func TestSAMLReplay(t *testing.T) {
+	f, st, idp := newTestSAMLFederation(t, config.SAMLConnector{JIT: true, TrustEmail: true})
+	ctx := context.Background()
+
+	authURL, _, err := f.StartSAMLLogin(ctx, "corp", 1, redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samlResponse, relayState, err := idp.Login(authURL, samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, relayState); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, relayState); !errors.Is(err, ErrUpstreamLogin) {
+		t.Fatalf("expected ErrUpstreamLogin, got %v", err)
+	}
+
+	// another instance shares the record through the storage
+	other, err := New(f.log, st, st, st, config.Federation{SAML: f.saml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.FinishSAMLLogin(ctx, "corp", samlResponse, relayState); !errors.Is(err, ErrUpstreamLogin) {
+		t.Fatalf("expected ErrUpstreamLogin, got %v", err)
+	}
+	if len(st.codes) != 1 {
+		t.Fatalf("replayed response got a login code: %v", st.codes)
+	}
+}
+
+func TestSAMLLoginCode(t *testing.T) {
+	f, st, idp := newTestSAMLFederation(t, config.SAMLConnector{JIT: true, TrustEmail: true})
+	browser := clientinfo.Info{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"}
+	r := samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}}
+
+	finish := func() string {
+		ctx := clientinfo.With(context.Background(), browser)
+
+		authURL, _, err := f.StartSAMLLogin(ctx, "corp", 1, redirectURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samlResponse, relayState, err := idp.Login(authURL, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirect, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, relayState)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return loginCode(t, redirect)
+	}
+
+	// the code is redeemed by the app it was issued to
+	if _, err := f.RedeemLoginCode(context.Background(), 2, finish()); !errors.Is(err, ErrInvalidLoginCode) {
+		t.Fatalf("expected ErrInvalidLoginCode, got %v", err)
+	}
+	if len(st.logins) != 0 {
+		t.Fatalf("the login started a session before the code was redeemed: %v", st.logins)
+	}
+
+	code := finish()
+	tokens, err := f.RedeemLoginCode(context.Background(), 1, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" {
+		t.Fatal("no access token")
+	}
+	// the session is the one of the browser, not of the app redeeming
+	if len(st.clients) != 1 || st.clients[0] != browser {
+		t.Fatalf("unexpected clients %+v", st.clients)
+	}
+
+	if _, err := f.RedeemLoginCode(context.Background(), 1, code); !errors.Is(err, ErrInvalidLoginCode) {
+		t.Fatalf("expected ErrInvalidLoginCode, got %v", err)
+	}
+	if _, err := f.RedeemLoginCode(context.Background(), 1, "unknown"); !errors.Is(err, ErrInvalidLoginCode) {
+		t.Fatalf("expected ErrInvalidLoginCode, got %v", err)
+	}
+	if len(st.logins) != 1 {
+		t.Fatalf("unexpected logins %v", st.logins)
+	}
+}
+
+func TestSAMLRedirectNotRegistered(t *testing.T) {
+	f, _, _ := newTestSAMLFederation(t, config.SAMLConnector{JIT: true, TrustEmail: true})
+
+	for _, redirect := range []string{"https://evil.example.com/callback", redirectURL + "/other", ""} {
+		if _, _, err := f.StartSAMLLogin(context.Background(), "corp", 1, redirect); !errors.Is(err, ErrInvalidRedirect) {
+			t.Fatalf("%q: expected ErrInvalidRedirect, got %v", redirect, err)
+		}
+	}
+}
+
+func TestSAMLLinking(t *testing.T) {
+	tests := []struct {
+		name string
+		conn config.SAMLConnector
+		r    samltest.Response
+		want error
+	}{
+		{
+			name: "existing email refused",
+			conn: config.SAMLConnector{JIT: true, TrustEmail: true},
+			r:    samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"jonn@gmail.com"}}},
+			want: ErrAccountExists,
+		},
+		{
+			name: "existing email linked",
+			conn: config.SAMLConnector{Link: domain.LinkVerifiedEmail, TrustEmail: true},
+			r:    samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"jonn@gmail.com"}}},
+		},
+		{
+			name: "untrusted email not linked",
+			conn: config.SAMLConnector{Link: domain.LinkVerifiedEmail},
+			r:    samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"jonn@gmail.com"}}},
+			want: ErrAccountExists,
+		},
+		{
+			name: "untrusted email not provisioned",
+			conn: config.SAMLConnector{JIT: true},
+			r:    samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}},
+			want: ErrEmailNotVerified,
+		},
+		{
+			name: "not provisioned",
+			conn: config.SAMLConnector{},
+			r:    samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}},
+			want: ErrNotProvisioned,
+		},
+		{
+			name: "email from the NameID",
+			conn: config.SAMLConnector{EmailAttribute: "mail", JIT: true, TrustEmail: true},
+			r:    samltest.Response{NameID: "new@gmail.com", NameIDFormat: "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"},
+		},
+		{
+			name: "no email",
+			conn: config.SAMLConnector{EmailAttribute: "mail", JIT: true, TrustEmail: true},
+			r:    samltest.Response{NameID: "u-1"},
+			want: ErrNotProvisioned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, idp := newTestSAMLFederation(t, tt.conn)
+
+			_, err := samlLogin(t, f, idp, tt.r)
+			if tt.want == nil && err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSAMLRejects(t *testing.T) {
+	f, st, idp := newTestSAMLFederation(t, config.SAMLConnector{JIT: true, TrustEmail: true})
+	other := samltest.New(t, idp.EntityID)
+	ctx := context.Background()
+
+	authURL, _, err := f.StartSAMLLogin(ctx, "corp", 1, redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := samltest.Response{NameID: "u-1", Attributes: map[string][]string{"email": {"new@gmail.com"}}}
+
+	forged, relayState, err := other.Login(authURL, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FinishSAMLLogin(ctx, "corp", forged, relayState); !errors.Is(err, ErrUpstreamLogin) {
+		t.Fatalf("expected ErrUpstreamLogin, got %v", err)
+	}
+
+	samlResponse, relayState, err := idp.Login(authURL, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, relayState+"x"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState, got %v", err)
+	}
+	if _, err := f.FinishSAMLLogin(ctx, "other", samlResponse, relayState); !errors.Is(err, ErrConnectorNotFound) {
+		t.Fatalf("expected ErrConnectorNotFound, got %v", err)
+	}
+
+	// the response answers the request of another login
+	authURL, _, err = f.StartSAMLLogin(ctx, "corp", 1, redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherState, err := idp.Login(authURL, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FinishSAMLLogin(ctx, "corp", samlResponse, otherState); !errors.Is(err, ErrUpstreamLogin) {
+		t.Fatalf("expected ErrUpstreamLogin, got %v", err)
+	}
+
+	if len(st.codes) != 0 {
+		t.Fatalf("unexpected login codes %v", st.codes)
+	}
+}
+
+func TestSAMLConnectorOfAnotherApp(t *testing.T) {
+	f, _, _ := newTestSAMLFederation(t, config.SAMLConnector{AppID: 2})
+
+	if _, _, err := f.StartSAMLLogin(context.Background(), "corp", 1, redirectURL); !errors.Is(err, ErrConnectorNotFound) {
+		t.Fatalf("expected ErrConnectorNotFound, got %v", err)
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	f, _, _ := newTestSAMLFederation(t, config.SAMLConnector{})
+
+	metadata, err := f.SAMLMetadata(context.Background(), "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(metadata), `Location="https://sso.example.com/saml/corp/acs"`) {
+		t.Fatalf("unexpected metadata %s", metadata)
+	}
+}
+
+func TestSAMLConnectorNames(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name       string
+		connectors []config.SAMLConnector
+		ok         bool
+	}{
+		{name: "unique", connectors: []config.SAMLConnector{{Name: "corp"}, {Name: "partner", TenantID: 2}}, ok: true},
+		{name: "same name in another tenant", connectors: []config.SAMLConnector{{Name: "corp"}, {Name: "corp", TenantID: 2}}},
+		{name: "no name", connectors: []config.SAMLConnector{{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(log, &fakeStorage{}, nil, nil, config.Federation{SAML: tt.connectors})
+			if tt.ok != (err == nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/metrics"
+)
+
+type Storage interface {
+	// IncrementLoginFailures adds a failure to key, counting starts over when
+	// the last failure is older than window.
+	IncrementLoginFailures(ctx context.Context, key string, window time.Duration) (failures int, lockouts int, err error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	LoginLockedUntil(ctx context.Context, keys ...string) (time.Time, error)
+	ResetLoginFailures(ctx context.Context, key string) error
+	// DeleteStaleLoginFailures drops the keys of every tenant without a
+	// failure since before whose lock, if any, ran out before lockedBefore.
+	DeleteStaleLoginFailures(ctx context.Context, before time.Time, lockedBefore time.Time) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var ErrLocked = errors.New("login temporarily locked")
+
+// LockedError is returned by Check while an account or an address is locked.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string { return ErrLocked.Error() }
+
+func (e *LockedError) Unwrap() error { return ErrLocked }
+
+const (
+	defaultWindow       = 15 * time.Minute
+	defaultBaseDuration = time.Minute
+	defaultMaxDuration  = 24 * time.Hour
+	cleanupInterval     = time.Hour
+)
+
+// Lockout counts failed logins per account and per client address and locks
+// them out once a threshold is reached. Every lock of the same key lasts
+// twice as long as the previous one.
+type Lockout struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+	cfg     config.Lockout
+}
+
+// New returns new instance of the Lockout servic
+func New(log *slog.Logger, storage Storage, auditor Auditor, cfg config.Lockout) *Lockout {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultWindow
+	}
+	if cfg.BaseDuration <= 0 {
+		cfg.BaseDuration = defaultBaseDuration
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = defaultMaxDuration
+	}
+
+	return &Lockout{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+		cfg:     cfg,
+	}
+}
+
+// Check returns a *LockedError when email or ip may not log in right now.
+func (l *Lockout) Check(ctx context.Context, email string, ip string) error {
+	const op = "lockout.Check"
+
+	until, err := l.storage.LoginLockedUntil(ctx, l.keys(email, ip)...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if retryAfter := time.Until(until); retryAfter > 0 {
+		metrics.LoginBlocked.Add(1)
+		return &LockedError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// Failed records a failed login. It returns the lock that was started
+// because of it, if any.
+func (l *Lockout) Failed(ctx context.Context, email string, ip string) (*LockedError, error) {
+	const op = "lockout.Failed"
+
+	log := l.log.With(slog.String("op", op))
+
+	metrics.LoginFailures.Add(1)
+
+	var locked *LockedError
+
+	for _, key := range l.keys(email, ip) {
+		threshold := l.cfg.AccountThreshold
+		if strings.HasPrefix(key, ipPrefix) {
+			threshold = l.cfg.IPThreshold
+		}
+		if threshold <= 0 {
+			continue
+		}
+
+		failures, lockouts, err := l.storage.IncrementLoginFailures(ctx, key, l.cfg.Window)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if failures < threshold {
+			continue
+		}
+
+		duration := l.duration(lockouts)
+		if err := l.storage.LockLogin(ctx, key, time.Now().Add(duration)); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		metrics.LoginLockouts.Add(1)
+		log.Warn("login locked",
+			slog.String("key", key),
+			slog.Int("failures", failures),
+			slog.Duration("duration", duration),
+		)
+		l.auditor.Record(ctx, domain.AuditEvent{
+			Type:    domain.AuditLoginLockout,
+			Subject: key,
+			Result:  domain.AuditSuccess,
+			Reason:  fmt.Sprintf("locked for %s after %d failures", duration, failures),
+		})
+
+		if locked == nil || duration > locked.RetryAfter {
+			locked = &LockedError{RetryAfter: duration}
+		}
+	}
+
+	return locked, nil
+}
+
+// Succeeded clears the failures of the account, the address keeps its count
+// so one good password does not hide a spraying attack.
+func (l *Lockout) Succeeded(ctx context.Context, email string) error {
+	const op = "lockout.Succeeded"
+
+	if l.cfg.AccountThreshold <= 0 {
+		return nil
+	}
+
+	if err := l.storage.ResetLoginFailures(ctx, accountKey(email)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Unlock lifts the lock of the account and forgets its failures.
+func (l *Lockout) Unlock(ctx context.Context, email string) error {
+	const op = "lockout.Unlock"
+
+	if err := l.storage.ResetLoginFailures(ctx, accountKey(email)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	l.log.Info("login unlocked", slog.String("op", op), slog.String("key", accountKey(email)))
+
+	return nil
+}
+
+// RunCleanup deletes the failures of keys that stopped failing until ctx is
+// done. Failures older than the window no longer count, the lockouts of a
+// key are remembered for MaxDuration after its last lock so the next lock
+// keeps doubling.
+func (l *Lockout) RunCleanup(ctx context.Context) {
+	const op = "lockout.RunCleanup"
+
+	log := l.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		n, err := l.storage.DeleteStaleLoginFailures(ctx, now.Add(-l.cfg.Window), now.Add(-l.cfg.MaxDuration))
+		if err != nil {
+			log.Error("field to delete stale login failures", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("stale login failures deleted", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Lockout) duration(lockouts int) time.Duration {
+	d := l.cfg.BaseDuration
+	for i := 0; i < lockouts && d < l.cfg.MaxDuration; i++ {
+		d *= 2
+	}
+
+	return min(d, l.cfg.MaxDuration)
+}
+
+const (
+	ipPrefix = "ip:"
+)
+
+func (l *Lockout) keys(email string, ip string) []string {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, ipPrefix+ip)
+	}
+
+	return keys
+}
+
+func accountKey(email string) string {
+	return domain.AccountSubject(email)
+}
@@ -0,0 +1,144 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+type entry struct {
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	updated     time.Time
+}
+
+type memStorage map[string]*entry
+
+func (m memStorage) IncrementLoginFailures(_ context.Context, key string, _ time.Duration) (int, int, error) {
+	e, ok := m[key]
+	if !ok {
+		e = &entry{}
+		m[key] = e
+	}
+	e.failures++
+	e.updated = time.Now()
+	return e.failures, e.lockouts, nil
+}
+
+func (m memStorage) LockLogin(_ context.Context, key string, until time.Time) error {
+	m[key].lockedUntil = until
+	m[key].lockouts++
+	m[key].failures = 0
+	return nil
+}
+
+func (m memStorage) LoginLockedUntil(_ context.Context, keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		if e, ok := m[key]; ok && e.lockedUntil.After(until) {
+			until = e.lockedUntil
+		}
+	}
+	return until, nil
+}
+
+func (m memStorage) ResetLoginFailures(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memStorage) DeleteStaleLoginFailures(_ context.Context, before time.Time, lockedBefore time.Time) (int64, error) {
+	var n int64
+	for key, e := range m {
+		if e.updated.Before(before) && e.lockedUntil.Before(lockedBefore) {
+			delete(m, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, domain.AuditEvent) {}
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	storage := memStorage{}
+	l := New(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, nopAuditor{}, config.Lockout{
+		AccountThreshold: 3,
+		IPThreshold:      10,
+		BaseDuration:     time.Minute,
+		MaxDuration:      3 * time.Minute,
+	})
+
+	const email, ip = "Jonn@gmail.com", "10.0.0.1"
+
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	for _, duration := range expected {
+		for i := 1; i <= 3; i++ {
+			locked, err := l.Failed(ctx, email, ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i < 3 && locked != nil {
+				t.Fatalf("locked after %d failures", i)
+			}
+			if i == 3 && (locked == nil || locked.RetryAfter != duration) {
+				t.Fatalf("expected lock for %s, got %+v", duration, locked)
+			}
+		}
+
+		if err := l.Check(ctx, "jonn@gmail.com", "10.0.0.2"); !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected account to be locked, got %v", err)
+		}
+
+		// let the lock run out
+		storage[accountKey(email)].lockedUntil = time.Now().Add(-time.Second)
+	}
+
+	if err := l.Check(ctx, email, ip); err != nil {
+		t.Fatalf("expected no lock, got %v", err)
+	}
+
+	if storage[ipPrefix+ip].failures != 9 {
+		t.Errorf("expected 9 failures for the address, got %d", storage[ipPrefix+ip].failures)
+	}
+
+	if err := l.Unlock(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage[accountKey(email)]; ok {
+		t.Error("account failures are not reset")
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	storage := memStorage{
+		"account:recent":   {failures: 1, updated: time.Now()},
+		"account:stale":    {failures: 1, updated: time.Now().Add(-time.Hour)},
+		"account:locked":   {lockouts: 1, updated: time.Now().Add(-time.Hour), lockedUntil: time.Now().Add(-time.Hour)},
+		"account:released": {lockouts: 1, updated: time.Now().Add(-48 * time.Hour), lockedUntil: time.Now().Add(-25 * time.Hour)},
+	}
+	l := New(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, nopAuditor{}, config.Lockout{
+		AccountThreshold: 3,
+		Window:           15 * time.Minute,
+		MaxDuration:      24 * time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.RunCleanup(ctx)
+
+	// the lockouts of a key are kept until the longest lock is over
+	if len(storage) != 2 || storage["account:recent"] == nil || storage["account:locked"] == nil {
+		t.Fatalf("unexpected keys left %v", storage)
+	}
+}
@@ -0,0 +1,443 @@
+package org
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	CreateOrganization(ctx context.Context, org domain.Organization, owner domain.Member) (domain.Organization, error)
+	Organization(ctx context.Context, orgID int64) (domain.Organization, error)
+	OrgMember(ctx context.Context, orgID int64, userID int64) (domain.Member, error)
+	OrgMembers(ctx context.Context, orgID int64) ([]domain.Member, error)
+	UpdateOrgMember(ctx context.Context, orgID int64, userID int64, update domain.MemberUpdate) (domain.Member, error)
+	RemoveOrgMember(ctx context.Context, orgID int64, userID int64) error
+	SaveOrgInvite(ctx context.Context, invite domain.OrgInvite) (domain.OrgInvite, error)
+	OrgInvite(ctx context.Context, tokenHash []byte) (domain.OrgInvite, error)
+	AcceptOrgInvite(ctx context.Context, invite domain.OrgInvite, userID int64) (domain.Member, error)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg notifier.Message) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated  = errors.New("an access token is required")
+	ErrOrgNotFound      = errors.New("organization not found")
+	ErrPermissionDenied = errors.New("only owners and admins can manage members")
+	ErrInvalidRole      = errors.New("invalid member role")
+	ErrInvalidInvite    = errors.New("invalid or expired invite")
+	ErrAlreadyMember    = errors.New("user is already a member")
+	ErrMemberNotFound   = errors.New("member not found")
+	ErrLastOwner        = errors.New("the last owner can not be removed or demoted")
+)
+
+var reasons = access.Reasons{
+	ErrUnauthenticated:  access.ReasonUnauthenticated,
+	ErrOrgNotFound:      "org_not_found",
+	ErrPermissionDenied: access.ReasonPermissionDenied,
+	ErrInvalidRole:      "invalid_role",
+	ErrInvalidInvite:    "invalid_invite",
+	ErrAlreadyMember:    "already_member",
+	ErrMemberNotFound:   "member_not_found",
+	ErrLastOwner:        "last_owner",
+}
+
+const inviteTTL = 7 * 24 * time.Hour
+
+type Organizations struct {
+	log      *slog.Logger
+	storage  Storage
+	notifier Notifier
+	auditor  Auditor
+}
+
+// New returns new instance of the Organizations servic
+func New(log *slog.Logger, storage Storage, notifier Notifier, auditor Auditor) *Organizations {
+	return &Organizations{
+		log:      log,
+		storage:  storage,
+		notifier: notifier,
+		auditor:  auditor,
+	}
+}
+
+// CreateOrganization creates an organization in the tenant of the caller,
+// the caller becomes its owner.
+func (o *Organizations) CreateOrganization(ctx context.Context, name string) (org domain.Organization, err error) {
+	const op = "org.CreateOrganization"
+
+	log := o.log.With(slog.String("op", op))
+
+	defer func() { o.record(ctx, domain.AuditOrgCreate, org.ID, err) }()
+
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	org, err = o.storage.CreateOrganization(ctx,
+		domain.Organization{Name: name, CreatedBy: caller.UserID},
+		domain.Member{UserID: caller.UserID, Role: domain.OrgRoleOwner},
+	)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.Organization{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to create organization", slog.Any("err", err))
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("organization created", slog.Int64("org", org.ID), slog.Int64("uid", caller.UserID))
+
+	return org, nil
+}
+
+// InviteMember emails an invite to join the organization with role and teams
+// to email. Only owners and admins invite, only owners invite owners.
+// Inviting an address again replaces its pending invite.
+func (o *Organizations) InviteMember(
+	ctx context.Context,
+	orgID int64,
+	email string,
+	role string,
+	teams []string,
+) (invite domain.OrgInvite, err error) {
+	const op = "org.InviteMember"
+
+	log := o.log.With(slog.String("op", op))
+
+	defer func() { o.record(ctx, domain.AuditOrgInvite, orgID, err) }()
+
+	if !slices.Contains(domain.OrgRoles, role) {
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, ErrInvalidRole)
+	}
+
+	org, caller, err := o.manager(ctx, orgID)
+	if err != nil {
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if role == domain.OrgRoleOwner && caller.Role != domain.OrgRoleOwner {
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	token, hash, err := newInviteToken()
+	if err != nil {
+		log.Error("field to generate token", slog.Any("err", err))
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite, err = o.storage.SaveOrgInvite(ctx, domain.OrgInvite{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		Teams:     teams,
+		InvitedBy: caller.UserID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(inviteTTL),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgNotFound) {
+			return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, ErrOrgNotFound)
+		}
+		log.Error("field to save invite", slog.Any("err", err))
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("member invited", slog.Int64("org", orgID), slog.Int64("invite", invite.ID))
+
+	o.notify(ctx, log, notifier.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You are invited to join %s", org.Name),
+		Body: fmt.Sprintf("%s invited you to join %s as %s. Log in and use this code to accept: %s\nThe code expires in %s.",
+			caller.Email, org.Name, role, token, inviteTTL),
+	})
+
+	return invite, nil
+}
+
+// AcceptInvite makes the caller a member of the organization the token was
+// sent for. The invite must have been sent to the email of the caller.
+func (o *Organizations) AcceptInvite(ctx context.Context, token string) (member domain.Member, err error) {
+	const op = "org.AcceptInvite"
+
+	log := o.log.With(slog.String("op", op))
+
+	var invite domain.OrgInvite
+	defer func() { o.record(ctx, domain.AuditOrgJoin, invite.OrgID, err) }()
+
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	user, err := o.storage.UserByID(ctx, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to get user", slog.Any("err", err))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	invite, err = o.storage.OrgInvite(ctx, sum[:])
+	if err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrInvalidInvite)
+		}
+		log.Error("field to get invite", slog.Any("err", err))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a leaked token is useless to anyone but the invited address
+	if time.Now().After(invite.ExpiresAt) || !strings.EqualFold(invite.Email, user.Email) {
+		log.Warn("invite rejected", slog.Int64("invite", invite.ID), slog.Int64("uid", user.ID))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, ErrInvalidInvite)
+	}
+
+	member, err = o.storage.AcceptOrgInvite(ctx, invite, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInviteNotFound):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrInvalidInvite)
+		case errors.Is(err, storage.ErrMemberExists):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrAlreadyMember)
+		case errors.Is(err, storage.ErrUserNotFound):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+		}
+		log.Error("field to accept invite", slog.Any("err", err))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("invite accepted", slog.Int64("org", member.OrgID), slog.Int64("uid", member.UserID))
+
+	return member, nil
+}
+
+// ListMembers returns the members of the organization, any member may list
+// them.
+func (o *Organizations) ListMembers(ctx context.Context, orgID int64) ([]domain.Member, error) {
+	const op = "org.ListMembers"
+
+	if _, err := o.member(ctx, orgID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	members, err := o.storage.OrgMembers(ctx, orgID)
+	if err != nil {
+		o.log.Error("field to list members", slog.String("op", op), slog.Any("err", err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// UpdateMember changes the role and teams of the member. Owners and admins
+// update members, only owners make or change owners. The last owner stays an
+// owner. The orgs claim of tokens issued before shows the old membership
+// until they are refreshed.
+func (o *Organizations) UpdateMember(
+	ctx context.Context,
+	orgID int64,
+	userID int64,
+	update domain.MemberUpdate,
+) (member domain.Member, err error) {
+	const op = "org.UpdateMember"
+
+	log := o.log.With(slog.String("op", op))
+
+	defer func() { o.record(ctx, domain.AuditOrgUpdate, orgID, err) }()
+
+	if update.Role != nil && !slices.Contains(domain.OrgRoles, *update.Role) {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, ErrInvalidRole)
+	}
+
+	caller, err := o.member(ctx, orgID)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !caller.CanManage() {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	target, err := o.storage.OrgMember(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+		}
+		log.Error("field to get member", slog.Any("err", err))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	owner := target.Role == domain.OrgRoleOwner || (update.Role != nil && *update.Role == domain.OrgRoleOwner)
+	if owner && caller.Role != domain.OrgRoleOwner {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	member, err = o.storage.UpdateOrgMember(ctx, orgID, userID, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrMemberNotFound):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+		case errors.Is(err, storage.ErrOrgNotFound):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrOrgNotFound)
+		case errors.Is(err, storage.ErrLastOrgOwner):
+			return domain.Member{}, fmt.Errorf("%s: %w", op, ErrLastOwner)
+		}
+		log.Error("field to update member", slog.Any("err", err))
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("member updated", slog.Int64("org", orgID), slog.Int64("uid", userID), slog.String("role", member.Role))
+
+	return member, nil
+}
+
+// RemoveMember removes the user from the organization. Members may leave on
+// their own, owners and admins remove others, only owners remove owners.
+// The last owner stays.
+func (o *Organizations) RemoveMember(ctx context.Context, orgID int64, userID int64) (err error) {
+	const op = "org.RemoveMember"
+
+	log := o.log.With(slog.String("op", op))
+
+	defer func() { o.record(ctx, domain.AuditOrgRemove, orgID, err) }()
+
+	caller, err := o.member(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if userID != caller.UserID {
+		if !caller.CanManage() {
+			return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+		}
+
+		target, err := o.storage.OrgMember(ctx, orgID, userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrMemberNotFound) {
+				return fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+			}
+			log.Error("field to get member", slog.Any("err", err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if target.Role == domain.OrgRoleOwner && caller.Role != domain.OrgRoleOwner {
+			return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+		}
+	}
+
+	if err := o.storage.RemoveOrgMember(ctx, orgID, userID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrMemberNotFound):
+			return fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+		case errors.Is(err, storage.ErrOrgNotFound):
+			return fmt.Errorf("%s: %w", op, ErrOrgNotFound)
+		case errors.Is(err, storage.ErrLastOrgOwner):
+			return fmt.Errorf("%s: %w", op, ErrLastOwner)
+		}
+		log.Error("field to remove member", slog.Any("err", err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("member removed", slog.Int64("org", orgID), slog.Int64("uid", userID))
+
+	return nil
+}
+
+// member returns the membership of the caller. Organizations the caller is
+// not a member of are not found.
+func (o *Organizations) member(ctx context.Context, orgID int64) (domain.Member, error) {
+	caller, ok := principal.FromContext(ctx)
+	if !ok {
+		return domain.Member{}, ErrUnauthenticated
+	}
+
+	m, err := o.storage.OrgMember(ctx, orgID, caller.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return domain.Member{}, ErrOrgNotFound
+		}
+		return domain.Member{}, err
+	}
+
+	return m, nil
+}
+
+// manager returns the organization and the membership of the caller, who
+// must be allowed to manage members.
+func (o *Organizations) manager(ctx context.Context, orgID int64) (domain.Organization, domain.Member, error) {
+	m, err := o.member(ctx, orgID)
+	if err != nil {
+		return domain.Organization{}, domain.Member{}, err
+	}
+	if !m.CanManage() {
+		return domain.Organization{}, domain.Member{}, ErrPermissionDenied
+	}
+
+	org, err := o.storage.Organization(ctx, orgID)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgNotFound) {
+			return domain.Organization{}, domain.Member{}, ErrOrgNotFound
+		}
+		return domain.Organization{}, domain.Member{}, err
+	}
+
+	return org, m, nil
+}
+
+// notify hands msg to the notifier, see Auth.notify.
+func (o *Organizations) notify(ctx context.Context, log *slog.Logger, msg notifier.Message) {
+	if err := o.notifier.Notify(ctx, msg); err != nil {
+		log.Error("field to send notification", slog.Any("err", err))
+	}
+}
+
+// record audits a call about the organization. Calls that failed before
+// the organization was known, such as a create that was not stored, are
+// about the caller.
+func (o *Organizations) record(ctx context.Context, eventType string, orgID int64, err error) {
+	subject := domain.OrgSubject(orgID)
+	if orgID == 0 {
+		subject = access.UserSubject(ctx, 0)
+	}
+
+	access.Record(ctx, o.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: subject,
+	}, err, reasons)
+}
+
+// newInviteToken returns a random token for the invited user and the hash to
+// store.
+func newInviteToken() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+
+	return token, sum[:], nil
+}
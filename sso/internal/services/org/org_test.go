@@ -0,0 +1,396 @@
+package org
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type memberKey struct{ org, user int64 }
+
+type fakeStorage struct {
+	mu      sync.Mutex
+	users   map[int64]domain.User
+	orgs    map[int64]domain.Organization
+	members map[memberKey]domain.Member
+	invites map[int64]domain.OrgInvite
+	events  []domain.AuditEvent
+	sent    chan notifier.Message
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	u, ok := f.users[userID]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f *fakeStorage) CreateOrganization(_ context.Context, org domain.Organization, owner domain.Member) (domain.Organization, error) {
+	if _, ok := f.users[owner.UserID]; !ok {
+		return domain.Organization{}, storage.ErrUserNotFound
+	}
+	org.ID = int64(len(f.orgs) + 1)
+	f.orgs[org.ID] = org
+	owner.OrgID = org.ID
+	owner.Email = f.users[owner.UserID].Email
+	f.members[memberKey{org.ID, owner.UserID}] = owner
+
+	return org, nil
+}
+
+func (f *fakeStorage) Organization(_ context.Context, orgID int64) (domain.Organization, error) {
+	org, ok := f.orgs[orgID]
+	if !ok {
+		return domain.Organization{}, storage.ErrOrgNotFound
+	}
+
+	return org, nil
+}
+
+func (f *fakeStorage) OrgMember(_ context.Context, orgID int64, userID int64) (domain.Member, error) {
+	m, ok := f.members[memberKey{orgID, userID}]
+	if !ok {
+		return domain.Member{}, storage.ErrMemberNotFound
+	}
+
+	return m, nil
+}
+
+func (f *fakeStorage) OrgMembers(_ context.Context, orgID int64) ([]domain.Member, error) {
+	var members []domain.Member
+	for k, m := range f.members {
+		if k.org == orgID {
+			members = append(members, m)
+		}
+	}
+
+	return members, nil
+}
+
+func (f *fakeStorage) UpdateOrgMember(_ context.Context, orgID int64, userID int64, update domain.MemberUpdate) (domain.Member, error) {
+	m, ok := f.members[memberKey{orgID, userID}]
+	if !ok {
+		return domain.Member{}, storage.ErrMemberNotFound
+	}
+
+	if update.Role != nil && *update.Role != domain.OrgRoleOwner && m.Role == domain.OrgRoleOwner {
+		owners := 0
+		for k, other := range f.members {
+			if k.org == orgID && other.Role == domain.OrgRoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			return domain.Member{}, storage.ErrLastOrgOwner
+		}
+	}
+	if update.Role != nil {
+		m.Role = *update.Role
+	}
+	if update.Teams != nil {
+		m.Teams = *update.Teams
+	}
+	f.members[memberKey{orgID, userID}] = m
+
+	return m, nil
+}
+
+func (f *fakeStorage) RemoveOrgMember(_ context.Context, orgID int64, userID int64) error {
+	m, ok := f.members[memberKey{orgID, userID}]
+	if !ok {
+		return storage.ErrMemberNotFound
+	}
+
+	if m.Role == domain.OrgRoleOwner {
+		owners := 0
+		for k, other := range f.members {
+			if k.org == orgID && other.Role == domain.OrgRoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			return storage.ErrLastOrgOwner
+		}
+	}
+	delete(f.members, memberKey{orgID, userID})
+
+	return nil
+}
+
+func (f *fakeStorage) SaveOrgInvite(_ context.Context, invite domain.OrgInvite) (domain.OrgInvite, error) {
+	invite.ID = int64(len(f.invites) + 1)
+	f.invites[invite.ID] = invite
+
+	return invite, nil
+}
+
+func (f *fakeStorage) OrgInvite(_ context.Context, tokenHash []byte) (domain.OrgInvite, error) {
+	for _, i := range f.invites {
+		if bytes.Equal(i.TokenHash, tokenHash) {
+			return i, nil
+		}
+	}
+
+	return domain.OrgInvite{}, storage.ErrInviteNotFound
+}
+
+func (f *fakeStorage) AcceptOrgInvite(_ context.Context, invite domain.OrgInvite, userID int64) (domain.Member, error) {
+	if _, ok := f.invites[invite.ID]; !ok {
+		return domain.Member{}, storage.ErrInviteNotFound
+	}
+	if _, ok := f.members[memberKey{invite.OrgID, userID}]; ok {
+		return domain.Member{}, storage.ErrMemberExists
+	}
+	delete(f.invites, invite.ID)
+
+	m := domain.Member{OrgID: invite.OrgID, UserID: userID, Email: f.users[userID].Email, Role: invite.Role, Teams: invite.Teams}
+	f.members[memberKey{invite.OrgID, userID}] = m
+
+	return m, nil
+}
+
+func (f *fakeStorage) Notify(_ context.Context, msg notifier.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+}
+
+func newTestOrganizations() (*Organizations, *fakeStorage) {
+	st := &fakeStorage{
+		users: map[int64]domain.User{
+			1: {ID: 1, Email: "owner@example.com"},
+			2: {ID: 2, Email: "jonn@gmail.com"},
+			3: {ID: 3, Email: "other@example.com"},
+		},
+		orgs:    map[int64]domain.Organization{},
+		members: map[memberKey]domain.Member{},
+		invites: map[int64]domain.OrgInvite{},
+		sent:    make(chan notifier.Message, 10),
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, st, st, st), st
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 1})
+}
+
+var codeRe = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// invite invites email and returns the token that was sent.
+func invite(t *testing.T, o *Organizations, st *fakeStorage, ctx context.Context, orgID int64, email string, role string) string {
+	t.Helper()
+
+	if _, err := o.InviteMember(ctx, orgID, email, role, []string{"backend"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-st.sent:
+		if msg.To != email {
+			t.Fatalf("invite sent to %s, want %s", msg.To, email)
+		}
+		return codeRe.FindString(msg.Body)
+	case <-time.After(time.Second):
+		t.Fatal("no invite sent")
+	}
+
+	return ""
+}
+
+func TestInviteAccept(t *testing.T) {
+	o, st := newTestOrganizations()
+
+	if _, err := o.CreateOrganization(context.Background(), "acme"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+
+	org, err := o.CreateOrganization(as(1), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.InviteMember(as(1), org.ID, "jonn@gmail.com", "boss", nil); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+	// strangers do not learn that the organization exists
+	if _, err := o.InviteMember(as(3), org.ID, "jonn@gmail.com", domain.OrgRoleMember, nil); !errors.Is(err, ErrOrgNotFound) {
+		t.Fatalf("expected ErrOrgNotFound, got %v", err)
+	}
+
+	token := invite(t, o, st, as(1), org.ID, "Jonn@gmail.com", domain.OrgRoleMember)
+
+	// the token only works for the invited address
+	if _, err := o.AcceptInvite(as(3), token); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("expected ErrInvalidInvite, got %v", err)
+	}
+
+	member, err := o.AcceptInvite(as(2), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.OrgID != org.ID || member.Role != domain.OrgRoleMember || len(member.Teams) != 1 {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	if _, err := o.AcceptInvite(as(2), token); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("expected ErrInvalidInvite, got %v", err)
+	}
+
+	// members may not invite, nor may admins invite owners
+	if _, err := o.InviteMember(as(2), org.ID, "other@example.com", domain.OrgRoleMember, nil); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	members, err := o.ListMembers(as(2), org.ID)
+	if err != nil || len(members) != 2 {
+		t.Fatalf("expected 2 members, got %v, %v", members, err)
+	}
+	if _, err := o.ListMembers(as(3), org.ID); !errors.Is(err, ErrOrgNotFound) {
+		t.Fatalf("expected ErrOrgNotFound, got %v", err)
+	}
+}
+
+func TestInviteExpired(t *testing.T) {
+	o, st := newTestOrganizations()
+
+	org, err := o.CreateOrganization(as(1), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := invite(t, o, st, as(1), org.ID, "jonn@gmail.com", domain.OrgRoleAdmin)
+	for id, i := range st.invites {
+		i.ExpiresAt = time.Now().Add(-time.Minute)
+		st.invites[id] = i
+	}
+
+	if _, err := o.AcceptInvite(as(2), token); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("expected ErrInvalidInvite, got %v", err)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	o, st := newTestOrganizations()
+
+	org, err := o.CreateOrganization(as(1), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.members[memberKey{org.ID, 2}] = domain.Member{OrgID: org.ID, UserID: 2, Role: domain.OrgRoleAdmin}
+	st.members[memberKey{org.ID, 3}] = domain.Member{OrgID: org.ID, UserID: 3, Role: domain.OrgRoleMember}
+
+	if err := o.RemoveMember(as(3), org.ID, 2); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if err := o.RemoveMember(as(2), org.ID, 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if err := o.RemoveMember(as(1), org.ID, 1); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+
+	if err := o.RemoveMember(as(2), org.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	// leaving needs no role
+	if err := o.RemoveMember(as(2), org.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.RemoveMember(as(1), org.ID, 2); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("expected ErrMemberNotFound, got %v", err)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditOrgRemove || last.Subject != domain.OrgSubject(org.ID) || last.Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestUpdateMember(t *testing.T) {
+	o, st := newTestOrganizations()
+
+	org, err := o.CreateOrganization(as(1), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.members[memberKey{org.ID, 2}] = domain.Member{OrgID: org.ID, UserID: 2, Role: domain.OrgRoleAdmin}
+	st.members[memberKey{org.ID, 3}] = domain.Member{OrgID: org.ID, UserID: 3, Role: domain.OrgRoleMember}
+
+	role := func(r string) domain.MemberUpdate { return domain.MemberUpdate{Role: &r} }
+
+	if _, err := o.UpdateMember(as(3), org.ID, 2, role(domain.OrgRoleMember)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	// only owners make owners and change them
+	if _, err := o.UpdateMember(as(2), org.ID, 3, role(domain.OrgRoleOwner)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := o.UpdateMember(as(2), org.ID, 1, role(domain.OrgRoleMember)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := o.UpdateMember(as(1), org.ID, 1, role(domain.OrgRoleAdmin)); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	if _, err := o.UpdateMember(as(1), org.ID, 3, role("root")); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+	if _, err := o.UpdateMember(as(1), org.ID, 9, role(domain.OrgRoleAdmin)); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("expected ErrMemberNotFound, got %v", err)
+	}
+
+	teams := []string{"dev"}
+	member, err := o.UpdateMember(as(2), org.ID, 3, domain.MemberUpdate{Teams: &teams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != domain.OrgRoleMember || len(member.Teams) != 1 || member.Teams[0] != "dev" {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	member, err = o.UpdateMember(as(1), org.ID, 3, role(domain.OrgRoleOwner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != domain.OrgRoleOwner || len(member.Teams) != 1 {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditOrgUpdate || last.Subject != domain.OrgSubject(org.ID) || last.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestCreateOrganizationFailureAudit(t *testing.T) {
+	o, st := newTestOrganizations()
+
+	if _, err := o.CreateOrganization(as(9), "acme"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+
+	// no organization was stored, the event is about the caller
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditOrgCreate || last.Subject != domain.UserSubject(9) || last.Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
@@ -0,0 +1,158 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/publisher"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+)
+
+type Storage interface {
+	// ClaimOutboxEvents leases unpublished events so that concurrent relays
+	// do not deliver them at the same time.
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]domain.OutboxEvent, error)
+	MarkOutboxPublished(ctx context.Context, seq int64) error
+	MarkOutboxFailed(ctx context.Context, seq int64, reason string, retryAt time.Time) error
+	DeletePublishedOutboxBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultLease        = 30 * time.Second
+	defaultMaxBackoff   = 10 * time.Minute
+	baseBackoff         = time.Second
+	cleanupInterval     = time.Hour
+)
+
+// Relay moves events from the outbox to the publisher. An event is marked
+// published only after the publisher accepted it, so a crash in between
+// delivers it again: delivery is at-least-once and consumers dedup by the
+// event id. Failed events are retried with exponential backoff.
+type Relay struct {
+	log       *slog.Logger
+	storage   Storage
+	publisher publisher.Publisher
+	cfg       config.Outbox
+}
+
+// New returns new instance of the outbox Relay
+func New(log *slog.Logger, storage Storage, publisher publisher.Publisher, cfg config.Outbox) *Relay {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultLease
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+
+	return &Relay{
+		log:       log,
+		storage:   storage,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run relays events until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	const op = "outbox.Run"
+
+	log := r.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var cleaned time.Time
+
+	for {
+		// keep going while full batches come back
+		for {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				log.Error("field to relay outbox", slog.Any("err", err))
+			}
+			if err != nil || n < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		if r.cfg.Retention > 0 && time.Since(cleaned) > cleanupInterval {
+			cleaned = time.Now()
+			if _, err := r.storage.DeletePublishedOutboxBefore(ctx, cleaned.Add(-r.cfg.Retention)); err != nil {
+				log.Error("field to delete published outbox events", slog.Any("err", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes one batch of events and returns how many were claimed.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	const op = "outbox.Relay"
+
+	log := r.log.With(slog.String("op", op))
+
+	events, err := r.storage.ClaimOutboxEvents(ctx, r.cfg.BatchSize, r.cfg.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, e := range events {
+		data, err := json.Marshal(e.Event)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// subscribers such as the webhooks work in the tenant of the event
+		err = r.publisher.Publish(tenant.With(ctx, e.Event.TenantID), publisher.Message{
+			ID:      e.Event.ID,
+			Subject: e.Event.Type,
+			Data:    data,
+		})
+		if err != nil {
+			retryAt := time.Now().Add(r.backoff(e.Attempts))
+			log.Warn("field to publish event",
+				slog.String("id", e.Event.ID),
+				slog.Int("attempts", e.Attempts+1),
+				slog.Time("retry_at", retryAt),
+				slog.Any("err", err),
+			)
+			if err := r.storage.MarkOutboxFailed(ctx, e.Seq, err.Error(), retryAt); err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			continue
+		}
+
+		if err := r.storage.MarkOutboxPublished(ctx, e.Seq); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 0; i < attempts && d < r.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, r.cfg.MaxBackoff)
+}
@@ -0,0 +1,142 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/publisher"
+)
+
+type row struct {
+	event     domain.OutboxEvent
+	published bool
+	retryAt   time.Time
+}
+
+type memStorage struct {
+	mu   sync.Mutex
+	rows []*row
+}
+
+func (m *memStorage) ClaimOutboxEvents(_ context.Context, limit int, _ time.Duration) ([]domain.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []domain.OutboxEvent
+	for _, r := range m.rows {
+		if len(events) == limit {
+			break
+		}
+		if !r.published && !time.Now().Before(r.retryAt) {
+			events = append(events, r.event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *memStorage) MarkOutboxPublished(_ context.Context, seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rows[seq-1].published = true
+	return nil
+}
+
+func (m *memStorage) MarkOutboxFailed(_ context.Context, seq int64, _ string, _ time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// retry right away, the test does not want to wait for the backoff
+	m.rows[seq-1].event.Attempts++
+	return nil
+}
+
+func (m *memStorage) DeletePublishedOutboxBefore(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// flaky fails the first publish of every event.
+type flaky struct {
+	publisher.Publisher
+	seen map[string]bool
+}
+
+func (f *flaky) Publish(ctx context.Context, msg publisher.Message) error {
+	if !f.seen[msg.ID] {
+		f.seen[msg.ID] = true
+		return errors.New("broker unavailable")
+	}
+
+	return f.Publisher.Publish(ctx, msg)
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := publisher.NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	storage := &memStorage{}
+	for i, typ := range []string{domain.EventUserRegistered, domain.EventPasswordChanged} {
+		storage.rows = append(storage.rows, &row{event: domain.OutboxEvent{
+			Seq:   int64(i + 1),
+			Event: domain.Event{ID: typ + "-id", Type: typ, UserID: 7},
+		}})
+	}
+
+	relay := New(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, &flaky{Publisher: sink, seen: map[string]bool{}}, config.Outbox{})
+
+	if n, err := relay.Relay(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 claimed events, got %d, %v", n, err)
+	}
+	if n, err := relay.Relay(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 retried events, got %d, %v", n, err)
+	}
+	if n, err := relay.Relay(ctx); err != nil || n != 0 {
+		t.Fatalf("expected an empty outbox, got %d, %v", n, err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []publisher.Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg publisher.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg)
+	}
+
+	if len(got) != 2 || got[0].Subject != domain.EventUserRegistered || got[1].Subject != domain.EventPasswordChanged {
+		t.Fatalf("unexpected messages %+v", got)
+	}
+
+	var event domain.Event
+	if err := json.Unmarshal(got[0].Data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != got[0].ID || event.UserID != 7 {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
@@ -0,0 +1,214 @@
+package privacy
+
+import (
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+// Archive is the document ExportUserData returns. Password hashes and other
+// secrets are left out.
+type Archive struct {
+	ExportedAt  time.Time         `json:"exported_at"`
+	User        archiveUser       `json:"user"`
+	Profile     archiveProfile    `json:"profile"`
+	Roles       []string          `json:"roles"`
+	Consents    []archiveConsent  `json:"consents"`
+	Sessions    []archiveSession  `json:"sessions"`
+	Logins      []archiveLogin    `json:"login_history"`
+	Identities  []archiveIdentity `json:"federated_identities"`
+	Erasure     *archiveErasure   `json:"pending_erasure,omitempty"`
+	AuditEvents []archiveAudit    `json:"audit_events"`
+}
+
+type archiveUser struct {
+	ID                int64     `json:"id"`
+	Email             string    `json:"email"`
+	Status            string    `json:"status"`
+	CreatedAt         time.Time `json:"created_at"`
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+}
+
+type archiveProfile struct {
+	DisplayName string         `json:"display_name,omitempty"`
+	Locale      string         `json:"locale,omitempty"`
+	Timezone    string         `json:"timezone,omitempty"`
+	AvatarURL   string         `json:"avatar_url,omitempty"`
+	Attributes  map[string]any `json:"attributes,omitempty"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type archiveConsent struct {
+	AppID     int64     `json:"app_id"`
+	AppName   string    `json:"app_name"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+type archiveSession struct {
+	ID         int64     `json:"id"`
+	AppID      int64     `json:"app_id"`
+	Device     string    `json:"device,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type archiveLogin struct {
+	IP        string    `json:"ip,omitempty"`
+	Device    string    `json:"device,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archiveIdentity struct {
+	Connector string    `json:"connector"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archiveErasure struct {
+	RequestedAt time.Time `json:"requested_at"`
+	EraseAfter  time.Time `json:"erase_after"`
+}
+
+type archiveAudit struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"event_type"`
+	ActorID   int64     `json:"actor_id,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	AppID     int64     `json:"app_id,omitempty"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newArchive(
+	user domain.User,
+	profile domain.Profile,
+	isAdmin bool,
+	consents []domain.Consent,
+	sessions []domain.Session,
+	logins []domain.LoginRecord,
+	identities []domain.FederatedIdentity,
+	events []domain.AuditEvent,
+	erasure domain.Erasure,
+) Archive {
+	a := Archive{
+		ExportedAt: time.Now().UTC(),
+		User: archiveUser{
+			ID:                user.ID,
+			Email:             user.Email,
+			Status:            user.Status,
+			CreatedAt:         user.CreatedAt.UTC(),
+			PasswordChangedAt: user.PasswordChangedAt.UTC(),
+		},
+		Profile: archiveProfile{
+			DisplayName: profile.DisplayName,
+			Locale:      profile.Locale,
+			Timezone:    profile.Timezone,
+			AvatarURL:   profile.AvatarURL,
+			Attributes:  profile.Attributes,
+			UpdatedAt:   profile.UpdatedAt.UTC(),
+		},
+		Roles:       []string{},
+		Consents:    make([]archiveConsent, 0, len(consents)),
+		Sessions:    make([]archiveSession, 0, len(sessions)),
+		Logins:      make([]archiveLogin, 0, len(logins)),
+		Identities:  make([]archiveIdentity, 0, len(identities)),
+		AuditEvents: make([]archiveAudit, 0, len(events)),
+	}
+
+	if isAdmin {
+		a.Roles = append(a.Roles, "admin")
+	}
+
+	if erasure.ID != 0 {
+		a.Erasure = &archiveErasure{
+			RequestedAt: erasure.RequestedAt.UTC(),
+			EraseAfter:  erasure.EraseAfter.UTC(),
+		}
+	}
+
+	for _, c := range consents {
+		a.Consents = append(a.Consents, archiveConsent{
+			AppID:     c.AppID,
+			AppName:   c.AppName,
+			GrantedAt: c.GrantedAt.UTC(),
+		})
+	}
+
+	for _, s := range sessions {
+		a.Sessions = append(a.Sessions, archiveSession{
+			ID:         s.ID,
+			AppID:      s.AppID,
+			Device:     s.Device,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt.UTC(),
+			LastSeenAt: s.LastSeenAt.UTC(),
+			ExpiresAt:  s.ExpiresAt.UTC(),
+		})
+	}
+
+	for _, l := range logins {
+		a.Logins = append(a.Logins, archiveLogin{
+			IP:        l.IP,
+			Device:    l.Device,
+			Country:   l.Country,
+			CreatedAt: l.CreatedAt.UTC(),
+		})
+	}
+
+	for _, i := range identities {
+		a.Identities = append(a.Identities, archiveIdentity{
+			Connector: i.Connector,
+			Subject:   i.Subject,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt.UTC(),
+		})
+	}
+
+	for _, e := range events {
+		e = redact(user.ID, e)
+		a.AuditEvents = append(a.AuditEvents, archiveAudit{
+			ID:        e.ID,
+			Type:      e.Type,
+			ActorID:   e.ActorID,
+			Subject:   e.Subject,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			AppID:     e.AppID,
+			Result:    e.Result,
+			Reason:    e.Reason,
+			CreatedAt: e.CreatedAt.UTC(),
+		})
+	}
+
+	return a
+}
+
+// redactedSubject stands in for another user an event of the exported user
+// is about.
+const redactedSubject = "redacted"
+
+// redact keeps other users out of an audit event of userID: the user that
+// userID acted on, and the actor of an action on userID with the address and
+// agent it came from.
+func redact(userID int64, e domain.AuditEvent) domain.AuditEvent {
+	if e.ActorID != 0 && e.ActorID != userID {
+		e.ActorID = 0
+		e.IP = ""
+		e.UserAgent = ""
+	}
+
+	if id, ok := domain.ParseUserSubject(e.Subject); ok && id != userID {
+		e.Subject = redactedSubject
+	}
+
+	return e
+}
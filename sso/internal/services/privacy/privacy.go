@@ -0,0 +1,311 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	Profile(ctx context.Context, userID int64) (domain.Profile, error)
+	UserAuditEvents(ctx context.Context, userID int64, subjects []string) ([]domain.AuditEvent, error)
+	Sessions(ctx context.Context, userID int64) ([]domain.Session, error)
+	LoginRecords(ctx context.Context, userID int64) ([]domain.LoginRecord, error)
+	FederatedIdentities(ctx context.Context, userID int64) ([]domain.FederatedIdentity, error)
+	Consents(ctx context.Context, userID int64) ([]domain.Consent, error)
+
+	ScheduleErasure(ctx context.Context, erasure domain.Erasure) (domain.Erasure, error)
+	PendingErasure(ctx context.Context, userID int64) (domain.Erasure, error)
+	CancelErasure(ctx context.Context, userID int64) error
+	// ClaimDueErasures leases erasures whose grace period is over so that
+	// concurrent workers do not run the same one.
+	ClaimDueErasures(ctx context.Context, limit int, lease time.Duration) ([]domain.Erasure, error)
+	EraseUser(ctx context.Context, erasure domain.Erasure, event domain.Event) error
+}
+
+// LoginGuard forgets the failed logins kept under the email.
+type LoginGuard interface {
+	Unlock(ctx context.Context, email string) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated  = access.ErrUnauthenticated
+	ErrPermissionDenied = access.ErrPermissionDenied
+	ErrUserNotFound     = errors.New("user not found")
+	ErrErasurePending   = errors.New("erasure already requested")
+	ErrNoErasure        = errors.New("no pending erasure")
+)
+
+var reasons = access.Reasons{
+	ErrUserNotFound:   "user_not_found",
+	ErrErasurePending: "erasure_pending",
+	ErrNoErasure:      "no_erasure",
+}
+
+const (
+	defaultPollInterval = time.Minute
+	defaultBatchSize    = 10
+	defaultLease        = 5 * time.Minute
+)
+
+// Privacy implements the data export and the right to erasure.
+type Privacy struct {
+	log     *slog.Logger
+	storage Storage
+	guard   LoginGuard
+	auditor Auditor
+	cfg     config.Erasure
+}
+
+// New returns new instance of the Privacy servic
+func New(log *slog.Logger, storage Storage, guard LoginGuard, auditor Auditor, cfg config.Erasure) *Privacy {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultLease
+	}
+
+	return &Privacy{
+		log:     log,
+		storage: storage,
+		guard:   guard,
+		auditor: auditor,
+		cfg:     cfg,
+	}
+}
+
+// ExportUserData returns a JSON archive of everything stored about userID,
+// zero means the caller. Only admins may export other users.
+func (p *Privacy) ExportUserData(ctx context.Context, userID int64) (archive []byte, err error) {
+	const op = "privacy.ExportUserData"
+
+	log := p.log.With(slog.String("op", op))
+
+	defer p.record(ctx, domain.AuditDataExport, userID, &err)
+
+	userID, err = access.Owner(ctx, p.storage, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := p.storage.UserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	profile, err := p.storage.Profile(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	isAdmin, err := p.storage.IsAdmin(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	events, err := p.storage.UserAuditEvents(ctx, userID, []string{user.Email, domain.UserSubject(userID)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := p.storage.Sessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	logins, err := p.storage.LoginRecords(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	identities, err := p.storage.FederatedIdentities(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	consents, err := p.storage.Consents(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	erasure, err := p.storage.PendingErasure(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrErasureNotFound) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	archive, err = json.MarshalIndent(newArchive(user, profile, isAdmin, consents, sessions, logins, identities, events, erasure), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user data exported", slog.Int64("uid", userID))
+
+	return archive, nil
+}
+
+// RequestErasure disables userID, zero means the caller, and erases it once
+// the grace period is over.
+func (p *Privacy) RequestErasure(ctx context.Context, userID int64) (erasure domain.Erasure, err error) {
+	const op = "privacy.RequestErasure"
+
+	log := p.log.With(slog.String("op", op))
+
+	defer p.record(ctx, domain.AuditErasureRequest, userID, &err)
+
+	userID, err = access.Owner(ctx, p.storage, userID)
+	if err != nil {
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	caller, _ := principal.FromContext(ctx)
+
+	erasure, err = p.storage.ScheduleErasure(ctx, domain.Erasure{
+		UserID:      userID,
+		RequestedBy: caller.UserID,
+		EraseAfter:  time.Now().Add(p.cfg.GracePeriod),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrErasureExists) {
+			return domain.Erasure{}, fmt.Errorf("%s: %w", op, ErrErasurePending)
+		}
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	log.Info("erasure scheduled", slog.Int64("uid", userID), slog.Time("erase_after", erasure.EraseAfter))
+
+	return erasure, nil
+}
+
+// CancelErasure stops the pending erasure of userID, zero means the caller,
+// and enables the user again.
+func (p *Privacy) CancelErasure(ctx context.Context, userID int64) (err error) {
+	const op = "privacy.CancelErasure"
+
+	defer p.record(ctx, domain.AuditErasureCancel, userID, &err)
+
+	userID, err = access.Owner(ctx, p.storage, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := p.storage.CancelErasure(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrErasureNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrNoErasure)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Run applies due erasures until ctx is done.
+func (p *Privacy) Run(ctx context.Context) {
+	const op = "privacy.Run"
+
+	log := p.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.EraseDue(ctx)
+		if err != nil {
+			log.Error("field to erase users", slog.Any("err", err))
+		}
+
+		// keep going while full batches come back
+		if err == nil && n == p.cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EraseDue applies one batch of due erasures and returns how many it
+// claimed. A failed erasure is retried once its lease expires.
+func (p *Privacy) EraseDue(ctx context.Context) (int, error) {
+	const op = "privacy.EraseDue"
+
+	log := p.log.With(slog.String("op", op))
+
+	erasures, err := p.storage.ClaimDueErasures(ctx, p.cfg.BatchSize, p.cfg.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, erasure := range erasures {
+		ctx := tenant.With(ctx, erasure.TenantID)
+		err := p.erase(ctx, erasure)
+
+		event := domain.AuditEvent{
+			Type:    domain.AuditUserErase,
+			ActorID: erasure.RequestedBy,
+			Subject: domain.ErasedSubject(erasure.ID),
+			Result:  domain.AuditSuccess,
+		}
+		if err != nil {
+			log.Error("field to erase user", slog.Int64("erasure", erasure.ID), slog.Any("err", err))
+			event.Result = domain.AuditFailure
+			event.Reason = access.ReasonInternal
+		}
+		p.auditor.Record(ctx, event)
+	}
+
+	return len(erasures), nil
+}
+
+func (p *Privacy) erase(ctx context.Context, erasure domain.Erasure) error {
+	user, err := p.storage.UserByID(ctx, erasure.UserID)
+	switch {
+	case err == nil:
+		if err := p.guard.Unlock(ctx, user.Email); err != nil {
+			return err
+		}
+	case !errors.Is(err, storage.ErrUserNotFound):
+		return err
+	}
+
+	return p.storage.EraseUser(ctx, erasure, domain.Event{Type: domain.EventUserDeleted})
+}
+
+// record audits a call about userID, it is deferred with the requested id
+// so that it sees the final error.
+func (p *Privacy) record(ctx context.Context, eventType string, userID int64, err *error) {
+	access.Record(ctx, p.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: access.UserSubject(ctx, userID),
+	}, *err, reasons)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
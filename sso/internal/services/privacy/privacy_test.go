@@ -0,0 +1,275 @@
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	users    map[int64]domain.User
+	admins   map[int64]bool
+	erasures map[int64]domain.Erasure
+	erased   []int64
+	unlocked []string
+	events   []domain.AuditEvent
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		users: map[int64]domain.User{
+			1: {ID: 1, Email: "admin@gmail.com"},
+			7: {ID: 7, Email: "jonn@gmail.com", PasswordHash: []byte("secret hash")},
+		},
+		admins:   map[int64]bool{1: true},
+		erasures: map[int64]domain.Erasure{},
+	}
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	u, ok := f.users[userID]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func (f *fakeStorage) Profile(_ context.Context, userID int64) (domain.Profile, error) {
+	return domain.Profile{UserID: userID, DisplayName: "Jonn"}, nil
+}
+
+func (f *fakeStorage) UserAuditEvents(_ context.Context, userID int64, subjects []string) ([]domain.AuditEvent, error) {
+	return []domain.AuditEvent{
+		{ID: 1, Type: domain.AuditLogin, ActorID: userID, Subject: subjects[0], IP: "10.0.0.1"},
+		// an admin acted on the user
+		{ID: 2, Type: domain.AuditUserDisable, ActorID: 1, Subject: domain.UserSubject(userID), IP: "10.0.0.2", UserAgent: "admin-cli"},
+		// the user acted on another one
+		{ID: 3, Type: domain.AuditSessionRevoke, ActorID: userID, Subject: domain.UserSubject(8), IP: "10.0.0.1"},
+	}, nil
+}
+
+func (f *fakeStorage) Sessions(_ context.Context, userID int64) ([]domain.Session, error) {
+	return []domain.Session{{ID: 3, UserID: userID, AppID: 1, Device: "laptop"}}, nil
+}
+
+func (f *fakeStorage) LoginRecords(_ context.Context, userID int64) ([]domain.LoginRecord, error) {
+	return []domain.LoginRecord{{UserID: userID, IP: "10.0.0.1", Country: "DE"}}, nil
+}
+
+func (f *fakeStorage) FederatedIdentities(_ context.Context, userID int64) ([]domain.FederatedIdentity, error) {
+	return []domain.FederatedIdentity{{ID: 4, UserID: userID, Connector: "okta", Subject: "00u1"}}, nil
+}
+
+func (f *fakeStorage) Consents(_ context.Context, _ int64) ([]domain.Consent, error) {
+	return []domain.Consent{{AppID: 1, AppName: "test"}}, nil
+}
+
+func (f *fakeStorage) ScheduleErasure(_ context.Context, e domain.Erasure) (domain.Erasure, error) {
+	if _, ok := f.erasures[e.UserID]; ok {
+		return domain.Erasure{}, storage.ErrErasureExists
+	}
+	e.ID = int64(len(f.erasures) + 1)
+	f.erasures[e.UserID] = e
+
+	return e, nil
+}
+
+func (f *fakeStorage) PendingErasure(_ context.Context, userID int64) (domain.Erasure, error) {
+	e, ok := f.erasures[userID]
+	if !ok {
+		return domain.Erasure{}, storage.ErrErasureNotFound
+	}
+
+	return e, nil
+}
+
+func (f *fakeStorage) CancelErasure(_ context.Context, userID int64) error {
+	if _, ok := f.erasures[userID]; !ok {
+		return storage.ErrErasureNotFound
+	}
+	delete(f.erasures, userID)
+
+	return nil
+}
+
+func (f *fakeStorage) ClaimDueErasures(_ context.Context, limit int, _ time.Duration) ([]domain.Erasure, error) {
+	var due []domain.Erasure
+	for _, e := range f.erasures {
+		if !e.EraseAfter.After(time.Now()) && len(due) < limit {
+			due = append(due, e)
+		}
+	}
+
+	return due, nil
+}
+
+func (f *fakeStorage) EraseUser(_ context.Context, e domain.Erasure, _ domain.Event) error {
+	delete(f.erasures, e.UserID)
+	delete(f.users, e.UserID)
+	f.erased = append(f.erased, e.UserID)
+
+	return nil
+}
+
+func (f *fakeStorage) Unlock(_ context.Context, email string) error {
+	f.unlocked = append(f.unlocked, email)
+	return nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestPrivacy(grace time.Duration) (*Privacy, *fakeStorage) {
+	st := newFakeStorage()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, st, st, st, config.Erasure{GracePeriod: grace}), st
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 1})
+}
+
+func TestExportUserData(t *testing.T) {
+	p, _ := newTestPrivacy(time.Hour)
+
+	if _, err := p.ExportUserData(as(7), 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	data, err := p.ExportUserData(as(1), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.User.Email != "jonn@gmail.com" || archive.Profile.DisplayName != "Jonn" || len(archive.AuditEvents) != 3 {
+		t.Fatalf("unexpected archive %+v", archive)
+	}
+	if len(archive.Consents) != 1 || archive.Consents[0].AppName != "test" {
+		t.Fatalf("unexpected consents %+v", archive.Consents)
+	}
+	if len(archive.Logins) != 1 || archive.Logins[0].Country != "DE" {
+		t.Fatalf("unexpected login history %+v", archive.Logins)
+	}
+	if len(archive.Sessions) != 1 || archive.Sessions[0].Device != "laptop" {
+		t.Fatalf("unexpected sessions %+v", archive.Sessions)
+	}
+	if len(archive.Identities) != 1 || archive.Identities[0].Connector != "okta" {
+		t.Fatalf("unexpected identities %+v", archive.Identities)
+	}
+	if len(archive.Roles) != 0 || archive.Erasure != nil {
+		t.Fatalf("unexpected roles or erasure %+v", archive)
+	}
+	if bytes.Contains(data, []byte("secret hash")) {
+		t.Fatal("password hash exported")
+	}
+
+	// other users are left out of the audit events
+	own, byAdmin, onOther := archive.AuditEvents[0], archive.AuditEvents[1], archive.AuditEvents[2]
+	if own.ActorID != 7 || own.Subject != "jonn@gmail.com" || own.IP != "10.0.0.1" {
+		t.Fatalf("own event redacted %+v", own)
+	}
+	if byAdmin.ActorID != 0 || byAdmin.IP != "" || byAdmin.UserAgent != "" || byAdmin.Subject != domain.UserSubject(7) {
+		t.Fatalf("actor not redacted %+v", byAdmin)
+	}
+	if onOther.Subject != redactedSubject || onOther.ActorID != 7 {
+		t.Fatalf("target not redacted %+v", onOther)
+	}
+}
+
+func TestRecordRequestedUser(t *testing.T) {
+	p, st := newTestPrivacy(time.Hour)
+
+	if _, err := p.ExportUserData(as(7), 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	// the denied export is about user 1, not the caller
+	want := domain.AuditEvent{
+		Type:    domain.AuditDataExport,
+		ActorID: 7,
+		Subject: domain.UserSubject(1),
+		AppID:   1,
+		Result:  domain.AuditFailure,
+		Reason:  access.ReasonPermissionDenied,
+	}
+	if len(st.events) != 1 || st.events[0] != want {
+		t.Fatalf("audit events %+v, want %+v", st.events, want)
+	}
+}
+
+func TestErasure(t *testing.T) {
+	p, st := newTestPrivacy(time.Hour)
+	ctx := context.Background()
+
+	erasure, err := p.RequestErasure(as(7), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(erasure.EraseAfter); until < 59*time.Minute || until > time.Hour {
+		t.Fatalf("unexpected erase_after %s", erasure.EraseAfter)
+	}
+	if _, err := p.RequestErasure(as(7), 0); !errors.Is(err, ErrErasurePending) {
+		t.Fatalf("expected ErrErasurePending, got %v", err)
+	}
+
+	// the grace period is not over yet
+	if n, err := p.EraseDue(ctx); err != nil || n != 0 {
+		t.Fatalf("erased %d before the grace period, %v", n, err)
+	}
+
+	e := st.erasures[7]
+	e.EraseAfter = time.Now().Add(-time.Second)
+	st.erasures[7] = e
+
+	if n, err := p.EraseDue(ctx); err != nil || n != 1 {
+		t.Fatalf("expected one erasure, got %d, %v", n, err)
+	}
+	if len(st.erased) != 1 || st.erased[0] != 7 || st.unlocked[0] != "jonn@gmail.com" {
+		t.Fatalf("user not erased: %v %v", st.erased, st.unlocked)
+	}
+
+	last := st.events[len(st.events)-1]
+	if last.Type != domain.AuditUserErase || last.Subject != domain.ErasedSubject(erasure.ID) || last.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+}
+
+func TestCancelErasure(t *testing.T) {
+	p, _ := newTestPrivacy(time.Hour)
+
+	if err := p.CancelErasure(as(1), 7); !errors.Is(err, ErrNoErasure) {
+		t.Fatalf("expected ErrNoErasure, got %v", err)
+	}
+	if _, err := p.RequestErasure(as(1), 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CancelErasure(as(1), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CancelErasure(as(7), 0); !errors.Is(err, ErrNoErasure) {
+		t.Fatalf("expected ErrNoErasure, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"time"
+	// timezones are validated against the embedded database so that the
+	// result does not depend on the host
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/attrschema"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	Profile(ctx context.Context, userID int64) (domain.Profile, error)
+	UpdateProfile(ctx context.Context, userID int64, update domain.ProfileUpdate) (domain.Profile, error)
+	App(ctx context.Context, appID int64) (domain.App, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated  = access.ErrUnauthenticated
+	ErrPermissionDenied = access.ErrPermissionDenied
+	ErrUserNotFound     = errors.New("user not found")
+	ErrAppNotFound      = errors.New("app not found")
+	ErrInvalidProfile   = errors.New("invalid profile")
+)
+
+var reasons = access.Reasons{
+	ErrUserNotFound:   "user_not_found",
+	ErrAppNotFound:    "app_not_found",
+	ErrInvalidProfile: "invalid_profile",
+}
+
+const (
+	maxDisplayNameLen = 100
+	maxAvatarURLLen   = 2048
+)
+
+// locales are BCP 47 tags such as "en" or "pt-BR".
+var localeRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+type Profiles struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+}
+
+// New returns new instance of the Profiles servic
+func New(log *slog.Logger, storage Storage, auditor Auditor) *Profiles {
+	return &Profiles{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+	}
+}
+
+// GetProfile returns the profile of userID, zero means the caller. Only
+// admins may read the profiles of other users.
+func (p *Profiles) GetProfile(ctx context.Context, userID int64) (domain.Profile, error) {
+	const op = "profile.GetProfile"
+
+	userID, err := access.Owner(ctx, p.storage, userID)
+	if err != nil {
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	profile, err := p.storage.Profile(ctx, userID)
+	if err != nil {
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	return profile, nil
+}
+
+// UpdateProfile changes the profile of userID, zero means the caller. Custom
+// attributes are validated against the schema of the app the caller's token
+// was issued for, apps without a schema can not change them.
+func (p *Profiles) UpdateProfile(ctx context.Context, userID int64, update domain.ProfileUpdate) (profile domain.Profile, err error) {
+	const op = "profile.UpdateProfile"
+
+	log := p.log.With(slog.String("op", op))
+
+	caller, _ := principal.FromContext(ctx)
+
+	// deferred with the requested id so that it sees the final error
+	defer func(subject string) {
+		access.Record(ctx, p.auditor, domain.AuditEvent{Type: domain.AuditProfileUpdate, Subject: subject}, err, reasons)
+	}(access.UserSubject(ctx, userID))
+
+	userID, err = access.Owner(ctx, p.storage, userID)
+	if err != nil {
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := p.storage.App(ctx, caller.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.Profile{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to get app", slog.Any("err", err))
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	current, err := p.storage.Profile(ctx, userID)
+	if err != nil {
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	if err := validate(app.AttributeSchema, current, update); err != nil {
+		log.Warn("profile rejected", slog.Any("err", err))
+		return domain.Profile{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidProfile, err)
+	}
+
+	profile, err = p.storage.UpdateProfile(ctx, userID, update)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("field to update profile", slog.Any("err", err))
+		}
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	log.Info("profile updated", slog.Int64("uid", userID))
+
+	return profile, nil
+}
+
+func validate(schema domain.AttributeSchema, current domain.Profile, update domain.ProfileUpdate) error {
+	violations := ValidateFields(update)
+
+	merged := make(map[string]any, len(current.Attributes)+len(update.Attributes))
+	for k, v := range current.Attributes {
+		merged[k] = v
+	}
+	for k, v := range update.Attributes {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+
+	var verr *attrschema.ViolationError
+	if err := attrschema.Validate(schema, update.Attributes, merged); errors.As(err, &verr) {
+		violations = append(violations, verr.Violations...)
+	}
+
+	if len(violations) > 0 {
+		return &attrschema.ViolationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// ValidateFields checks the fields every profile has, custom attributes are
+// left to the schema of the app.
+func ValidateFields(update domain.ProfileUpdate) []attrschema.Violation {
+	var violations []attrschema.Violation
+
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLen {
+		violations = append(violations, attrschema.Violation{
+			Field:       "display_name",
+			Description: fmt.Sprintf("display_name must be at most %d characters", maxDisplayNameLen),
+		})
+	}
+
+	if update.Locale != nil && *update.Locale != "" && !localeRe.MatchString(*update.Locale) {
+		violations = append(violations, attrschema.Violation{
+			Field:       "locale",
+			Description: "locale must be a BCP 47 language tag",
+		})
+	}
+
+	if update.Timezone != nil && *update.Timezone != "" {
+		if _, err := time.LoadLocation(*update.Timezone); err != nil {
+			violations = append(violations, attrschema.Violation{
+				Field:       "timezone",
+				Description: "timezone must be an IANA time zone name",
+			})
+		}
+	}
+
+	if update.AvatarURL != nil && *update.AvatarURL != "" && !validAvatarURL(*update.AvatarURL) {
+		violations = append(violations, attrschema.Violation{
+			Field:       "avatar_url",
+			Description: "avatar_url must be an absolute https url",
+		})
+	}
+
+	return violations
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLen {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func notFound(err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
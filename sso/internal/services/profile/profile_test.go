@@ -0,0 +1,174 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/attrschema"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	profiles map[int64]domain.Profile
+	admins   map[int64]bool
+	app      domain.App
+	events   []domain.AuditEvent
+}
+
+func (f *fakeStorage) Profile(_ context.Context, userID int64) (domain.Profile, error) {
+	p, ok := f.profiles[userID]
+	if !ok {
+		return domain.Profile{}, storage.ErrUserNotFound
+	}
+
+	return p, nil
+}
+
+func (f *fakeStorage) UpdateProfile(_ context.Context, userID int64, update domain.ProfileUpdate) (domain.Profile, error) {
+	p := f.profiles[userID]
+	if update.DisplayName != nil {
+		p.DisplayName = *update.DisplayName
+	}
+	if update.Locale != nil {
+		p.Locale = *update.Locale
+	}
+	for k, v := range update.Attributes {
+		if v == nil {
+			delete(p.Attributes, k)
+		} else {
+			p.Attributes[k] = v
+		}
+	}
+	f.profiles[userID] = p
+
+	return p, nil
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int64) (domain.App, error) {
+	if appID != f.app.ID {
+		return domain.App{}, storage.ErrAppNotFound
+	}
+
+	return f.app, nil
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestProfiles() (*Profiles, *fakeStorage) {
+	st := &fakeStorage{
+		profiles: map[int64]domain.Profile{
+			1: {UserID: 1, Attributes: map[string]any{"plan": "free"}},
+			2: {UserID: 2, Attributes: map[string]any{"plan": "pro"}},
+		},
+		admins: map[int64]bool{1: true},
+		app: domain.App{ID: 1, AttributeSchema: domain.AttributeSchema{
+			"plan": {Type: domain.AttributeString, Required: true},
+		}},
+	}
+
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st), st
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 1})
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestAccess(t *testing.T) {
+	p, _ := newTestProfiles()
+
+	if _, err := p.GetProfile(context.Background(), 0); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("anonymous: expected ErrUnauthenticated, got %v", err)
+	}
+
+	got, err := p.GetProfile(as(2), 0)
+	if err != nil || got.UserID != 2 {
+		t.Fatalf("own profile: got %+v, %v", got, err)
+	}
+
+	if _, err := p.GetProfile(as(2), 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("other profile: expected ErrPermissionDenied, got %v", err)
+	}
+
+	if got, err := p.GetProfile(as(1), 2); err != nil || got.UserID != 2 {
+		t.Fatalf("admin: got %+v, %v", got, err)
+	}
+
+	if _, err := p.GetProfile(as(1), 3); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("missing user: expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	p, st := newTestProfiles()
+
+	got, err := p.UpdateProfile(as(2), 0, domain.ProfileUpdate{
+		DisplayName: ptr("Jonn"),
+		Locale:      ptr("pt-BR"),
+		Timezone:    ptr("Europe/Berlin"),
+		Attributes:  map[string]any{"plan": "free"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "Jonn" || got.Attributes["plan"] != "free" {
+		t.Fatalf("unexpected profile %+v", got)
+	}
+
+	_, err = p.UpdateProfile(as(2), 0, domain.ProfileUpdate{
+		Locale:     ptr("not a locale"),
+		Timezone:   ptr("Mars/Olympus"),
+		AvatarURL:  ptr("http://example.com/a.png"),
+		Attributes: map[string]any{"plan": nil},
+	})
+
+	var verr *attrschema.ViolationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected violations, got %v", err)
+	}
+	var fields []string
+	for _, v := range verr.Violations {
+		fields = append(fields, v.Field)
+	}
+	want := []string{"locale", "timezone", "avatar_url", "attributes.plan"}
+	if len(fields) != len(want) {
+		t.Fatalf("violations = %v, want %v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("violations = %v, want %v", fields, want)
+		}
+	}
+
+	if len(st.events) != 2 || st.events[0].Result != domain.AuditSuccess || st.events[1].Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit events %+v", st.events)
+	}
+}
+
+func TestUpdateProfileWithoutSchema(t *testing.T) {
+	p, st := newTestProfiles()
+	st.app.AttributeSchema = nil
+
+	if _, err := p.UpdateProfile(as(2), 0, domain.ProfileUpdate{Attributes: map[string]any{"plan": "enterprise"}}); !errors.Is(err, ErrInvalidProfile) {
+		t.Fatalf("expected ErrInvalidProfile, got %v", err)
+	}
+	if st.profiles[2].Attributes["plan"] != "pro" {
+		t.Fatalf("attribute changed to %v", st.profiles[2].Attributes["plan"])
+	}
+
+	if _, err := p.UpdateProfile(as(2), 0, domain.ProfileUpdate{DisplayName: ptr("Mary")}); err != nil {
+		t.Fatal(err)
+	}
+}
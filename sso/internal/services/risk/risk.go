@@ -0,0 +1,330 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/geoip"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+)
+
+type Storage interface {
+	LoginHistory(ctx context.Context, userID int64, device string, ip string, ipRange string, since time.Time) (domain.LoginHistory, error)
+	SaveLoginRecord(ctx context.Context, record domain.LoginRecord) error
+	DeleteLoginRecordsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// GeoIP locates client addresses, see package geoip.
+type GeoIP interface {
+	Lookup(ip netip.Addr) (geoip.Location, bool)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg notifier.Message) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+const (
+	defaultMaxSpeedKmh      = 900
+	defaultAccountsWindow   = time.Hour
+	defaultHistoryRetention = 180 * 24 * time.Hour
+	defaultBlockScore       = 100
+	retentionInterval       = time.Hour
+
+	// logins from the same /24 or /48 come from the same network
+	ipv4RangeBits = 24
+	ipv6RangeBits = 48
+)
+
+// Risk scores logins against the earlier logins of the user.
+type Risk struct {
+	log      *slog.Logger
+	storage  Storage
+	geo      GeoIP
+	notifier Notifier
+	auditor  Auditor
+	cfg      config.Risk
+}
+
+// New returns new instance of the Risk servic. geo may be nil, impossible
+// travel is not detected then. The thresholds that are set have to grow from
+// NotifyScore to BlockScore.
+func New(log *slog.Logger, storage Storage, geo GeoIP, notifier Notifier, auditor Auditor, cfg config.Risk) (*Risk, error) {
+	const op = "risk.New"
+
+	if cfg.BlockScore == 0 {
+		cfg.BlockScore = defaultBlockScore
+	}
+	if err := validateThresholds(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.MaxSpeedKmh <= 0 {
+		cfg.MaxSpeedKmh = defaultMaxSpeedKmh
+	}
+	if cfg.AccountsWindow <= 0 {
+		cfg.AccountsWindow = defaultAccountsWindow
+	}
+	if cfg.HistoryRetention <= 0 {
+		cfg.HistoryRetention = defaultHistoryRetention
+	}
+
+	return &Risk{
+		log:      log,
+		storage:  storage,
+		geo:      geo,
+		notifier: notifier,
+		auditor:  auditor,
+		cfg:      cfg,
+	}, nil
+}
+
+// validateThresholds rejects thresholds that hide each other, a step-up
+// score above the block score would never be reached.
+func validateThresholds(cfg config.Risk) error {
+	last := 0
+	for _, t := range []struct {
+		name  string
+		score int
+	}{
+		{"notify_score", cfg.NotifyScore},
+		{"step_up_score", cfg.StepUpScore},
+		{"block_score", cfg.BlockScore},
+	} {
+		if t.score <= 0 {
+			continue
+		}
+		if t.score <= last {
+			return fmt.Errorf("%s %d is not above the lower thresholds", t.name, t.score)
+		}
+		last = t.score
+	}
+
+	return nil
+}
+
+// Assess scores a login of user to appID from the client of the request. The
+// decision is audited, the user is notified of risky logins and the login is
+// remembered unless it is blocked.
+func (r *Risk) Assess(ctx context.Context, user domain.User, appID int64) (domain.RiskDecision, error) {
+	const op = "risk.Assess"
+
+	log := r.log.With(slog.String("op", op))
+
+	if !r.cfg.Enabled {
+		return domain.RiskDecision{Action: domain.RiskAllow}, nil
+	}
+
+	record := r.newRecord(ctx, user.ID)
+
+	history, err := r.storage.LoginHistory(ctx, user.ID, record.Device, record.IP, record.IPRange, time.Now().Add(-r.cfg.AccountsWindow))
+	if err != nil {
+		return domain.RiskDecision{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	decision := r.decide(r.signals(record, history))
+
+	r.record(ctx, user.ID, appID, decision)
+
+	if decision.Action == domain.RiskBlock {
+		log.Warn("login blocked", slog.Int64("uid", user.ID), slog.Int("score", decision.Score))
+		r.notify(ctx, log, user.Email, record, decision)
+		return decision, nil
+	}
+
+	if err := r.storage.SaveLoginRecord(ctx, record); err != nil {
+		return decision, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if decision.Action != domain.RiskAllow {
+		log.Info("risky login", slog.Int64("uid", user.ID), slog.Int("score", decision.Score), slog.String("action", decision.Action))
+		r.notify(ctx, log, user.Email, record, decision)
+	}
+
+	return decision, nil
+}
+
+// signals returns the signals a login raises. The first login of a user has
+// nothing to be compared with and only counts towards shared addresses.
+func (r *Risk) signals(record domain.LoginRecord, history domain.LoginHistory) []string {
+	var signals []string
+
+	if history.Logins > 0 {
+		if record.Device != "" && !history.KnownDevice {
+			signals = append(signals, domain.RiskNewDevice)
+		}
+		if record.IPRange != "" && !history.KnownIPRange {
+			signals = append(signals, domain.RiskNewIPRange)
+		}
+		if r.impossibleTravel(history.Last, record) {
+			signals = append(signals, domain.RiskImpossibleTravel)
+		}
+	}
+
+	if r.cfg.AccountsPerIP > 0 && history.AccountsFromIP+1 >= r.cfg.AccountsPerIP {
+		signals = append(signals, domain.RiskSharedIP)
+	}
+
+	return signals
+}
+
+// impossibleTravel tells whether getting from the last login to this one
+// takes faster than MaxSpeedKmh. Jumps shorter than MinTravelKm are ignored,
+// GeoIP locations are not more precise than that.
+func (r *Risk) impossibleTravel(last domain.LoginRecord, record domain.LoginRecord) bool {
+	if !last.HasLocation || !record.HasLocation {
+		return false
+	}
+
+	km := geoip.Distance(
+		geoip.Location{Latitude: last.Latitude, Longitude: last.Longitude},
+		geoip.Location{Latitude: record.Latitude, Longitude: record.Longitude},
+	)
+	if km < r.cfg.MinTravelKm {
+		return false
+	}
+
+	hours := time.Since(last.CreatedAt).Hours()
+
+	return hours <= 0 || km/hours > r.cfg.MaxSpeedKmh
+}
+
+func (r *Risk) decide(signals []string) domain.RiskDecision {
+	weights := map[string]int{
+		domain.RiskNewDevice:        r.cfg.Weights.NewDevice,
+		domain.RiskNewIPRange:       r.cfg.Weights.NewIPRange,
+		domain.RiskImpossibleTravel: r.cfg.Weights.ImpossibleTravel,
+		domain.RiskSharedIP:         r.cfg.Weights.SharedIP,
+	}
+
+	decision := domain.RiskDecision{Action: domain.RiskAllow}
+	for _, signal := range signals {
+		if weights[signal] > 0 {
+			decision.Score += weights[signal]
+			decision.Signals = append(decision.Signals, signal)
+		}
+	}
+
+	switch {
+	case reached(decision.Score, r.cfg.BlockScore):
+		decision.Action = domain.RiskBlock
+	case reached(decision.Score, r.cfg.StepUpScore):
+		decision.Action = domain.RiskStepUp
+	case reached(decision.Score, r.cfg.NotifyScore):
+		decision.Action = domain.RiskNotify
+	}
+
+	return decision
+}
+
+func reached(score int, threshold int) bool {
+	return threshold > 0 && score >= threshold
+}
+
+func (r *Risk) newRecord(ctx context.Context, userID int64) domain.LoginRecord {
+	info := clientinfo.FromContext(ctx)
+
+	record := domain.LoginRecord{UserID: userID, IP: info.IP, Device: info.Device}
+
+	ip, err := netip.ParseAddr(info.IP)
+	if err != nil {
+		return record
+	}
+	ip = ip.Unmap()
+
+	bits := ipv6RangeBits
+	if ip.Is4() {
+		bits = ipv4RangeBits
+	}
+	if prefix, err := ip.Prefix(bits); err == nil {
+		record.IPRange = prefix.String()
+	}
+
+	if r.geo != nil {
+		if loc, ok := r.geo.Lookup(ip); ok {
+			record.Country = loc.Country
+			record.Latitude, record.Longitude = loc.Latitude, loc.Longitude
+			record.HasLocation = true
+		}
+	}
+
+	return record
+}
+
+// RunRetention deletes logins older than HistoryRetention until ctx is done.
+func (r *Risk) RunRetention(ctx context.Context) {
+	const op = "risk.RunRetention"
+
+	log := r.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.storage.DeleteLoginRecordsBefore(ctx, time.Now().Add(-r.cfg.HistoryRetention))
+		if err != nil {
+			log.Error("field to delete login history", slog.Any("err", err))
+		} else if n > 0 {
+			log.Info("login history deleted", slog.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record audits the decision, the reason lists the score and the signals.
+func (r *Risk) record(ctx context.Context, userID int64, appID int64, decision domain.RiskDecision) {
+	event := domain.AuditEvent{
+		Type:    domain.AuditRiskDecision,
+		ActorID: userID,
+		Subject: domain.UserSubject(userID),
+		AppID:   appID,
+		Result:  domain.AuditSuccess,
+		Reason:  decision.Action + " score=" + strconv.Itoa(decision.Score),
+	}
+	if len(decision.Signals) > 0 {
+		event.Reason += " signals=" + strings.Join(decision.Signals, ",")
+	}
+	if decision.Action == domain.RiskBlock {
+		event.Result = domain.AuditFailure
+	}
+
+	r.auditor.Record(ctx, event)
+}
+
+// notify tells the user about a risky login, the notifier queues the
+// message.
+func (r *Risk) notify(ctx context.Context, log *slog.Logger, email string, record domain.LoginRecord, decision domain.RiskDecision) {
+	where := record.IP
+	if record.Country != "" {
+		where += " (" + record.Country + ")"
+	}
+	if record.Device != "" {
+		where += " on device " + record.Device
+	}
+
+	body := fmt.Sprintf("There was a new sign-in to your account from %s. If this was not you, change your password and revoke your sessions.", where)
+	if decision.Action == domain.RiskBlock {
+		body = fmt.Sprintf("A sign-in to your account from %s was blocked because it looked suspicious. If this was you, contact support.", where)
+	}
+
+	msg := notifier.Message{To: email, Subject: "New sign-in to your account", Body: body}
+
+	if err := r.notifier.Notify(ctx, msg); err != nil {
+		log.Error("field to send notification", slog.Any("err", err))
+	}
+}
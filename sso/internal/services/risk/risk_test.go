@@ -0,0 +1,243 @@
+package risk
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/clientinfo"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/geoip"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/notifier"
+)
+
+// fakeStorage keeps the login history in memory, the accounts window is not
+// applied.
+type fakeStorage struct {
+	records []domain.LoginRecord
+	events  []domain.AuditEvent
+}
+
+func (f *fakeStorage) LoginHistory(_ context.Context, userID int64, device string, ip string, ipRange string, _ time.Time) (domain.LoginHistory, error) {
+	var h domain.LoginHistory
+	others := map[int64]bool{}
+
+	for _, r := range f.records {
+		if r.UserID != userID {
+			if r.IP == ip {
+				others[r.UserID] = true
+			}
+			continue
+		}
+		h.Logins++
+		h.KnownDevice = h.KnownDevice || (device != "" && r.Device == device)
+		h.KnownIPRange = h.KnownIPRange || (ipRange != "" && r.IPRange == ipRange)
+		if r.CreatedAt.After(h.Last.CreatedAt) {
+			h.Last = r
+		}
+	}
+	h.AccountsFromIP = len(others)
+
+	return h, nil
+}
+
+func (f *fakeStorage) SaveLoginRecord(_ context.Context, record domain.LoginRecord) error {
+	record.CreatedAt = time.Now()
+	f.records = append(f.records, record)
+	return nil
+}
+
+func (f *fakeStorage) DeleteLoginRecordsBefore(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+type fakeNotifier struct {
+	sent chan notifier.Message
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+const testGeoIP = `
+10.0.0.0,10.0.255.255,DE,52.52,13.40
+10.1.0.0,10.1.255.255,US,40.71,-74.00
+10.2.0.0,10.2.255.255,DE,52.40,13.06
+`
+
+var testConfig = config.Risk{
+	Enabled:     true,
+	NotifyScore: 20,
+	StepUpScore: 50,
+	BlockScore:  80,
+	Weights: config.RiskWeights{
+		NewDevice:        20,
+		NewIPRange:       10,
+		ImpossibleTravel: 50,
+		SharedIP:         40,
+	},
+	MinTravelKm:   300,
+	AccountsPerIP: 3,
+}
+
+func newTestRisk(t *testing.T, cfg config.Risk) (*Risk, *fakeStorage, *fakeNotifier) {
+	t.Helper()
+
+	geo, err := geoip.Parse(strings.NewReader(testGeoIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &fakeStorage{}
+	n := &fakeNotifier{sent: make(chan notifier.Message, 16)}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	r, err := New(log, st, geo, n, st, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, st, n
+}
+
+func from(ip string, device string) context.Context {
+	return clientinfo.With(context.Background(), clientinfo.Info{IP: ip, Device: device})
+}
+
+func TestAssess(t *testing.T) {
+	r, st, n := newTestRisk(t, testConfig)
+	user := domain.User{ID: 7, Email: "jonn@gmail.com"}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		action  string
+		signals []string
+	}{
+		{name: "first login", ctx: from("10.0.0.1", "laptop"), action: domain.RiskAllow},
+		{name: "same device and network", ctx: from("10.0.0.2", "laptop"), action: domain.RiskAllow},
+		{name: "new device", ctx: from("10.0.0.3", "phone"), action: domain.RiskNotify, signals: []string{domain.RiskNewDevice}},
+		{name: "close new network", ctx: from("10.2.0.1", "phone"), action: domain.RiskAllow, signals: []string{domain.RiskNewIPRange}},
+		{
+			name:    "other continent a moment later",
+			ctx:     from("10.1.0.1", "tablet"),
+			action:  domain.RiskBlock,
+			signals: []string{domain.RiskNewDevice, domain.RiskNewIPRange, domain.RiskImpossibleTravel},
+		},
+	}
+
+	for _, tt := range tests {
+		decision, err := r.Assess(tt.ctx, user, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Action != tt.action || !slices.Equal(decision.Signals, tt.signals) {
+			t.Errorf("%s: got %+v, want %s %v", tt.name, decision, tt.action, tt.signals)
+		}
+	}
+
+	// the blocked login is not remembered
+	if len(st.records) != 4 {
+		t.Fatalf("expected 4 remembered logins, got %d", len(st.records))
+	}
+
+	if len(st.events) != len(tests) || st.events[2].Reason != "notify score=20 signals=new_device" {
+		t.Fatalf("unexpected audit events %+v", st.events)
+	}
+	if last := st.events[len(st.events)-1]; last.Type != domain.AuditRiskDecision || last.Result != domain.AuditFailure {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+
+	for range 2 {
+		select {
+		case msg := <-n.sent:
+			if msg.To != user.Email {
+				t.Fatalf("notified %s", msg.To)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("user not notified")
+		}
+	}
+}
+
+func TestAssessSharedIP(t *testing.T) {
+	r, _, _ := newTestRisk(t, testConfig)
+
+	var decision domain.RiskDecision
+	for uid := int64(1); uid <= 3; uid++ {
+		var err error
+		decision, err = r.Assess(from("10.0.0.1", ""), domain.User{ID: uid}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if decision.Action != domain.RiskNotify || !slices.Equal(decision.Signals, []string{domain.RiskSharedIP}) {
+		t.Fatalf("third account from the address: %+v", decision)
+	}
+}
+
+func TestAssessDisabled(t *testing.T) {
+	r, st, _ := newTestRisk(t, config.Risk{})
+
+	decision, err := r.Assess(from("10.0.0.1", "laptop"), domain.User{ID: 7}, 1)
+	if err != nil || decision.Action != domain.RiskAllow {
+		t.Fatalf("got %+v, %v", decision, err)
+	}
+	if len(st.records) != 0 || len(st.events) != 0 {
+		t.Fatal("disabled assessment left traces")
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	r, _, _ := newTestRisk(t, config.Risk{Enabled: true, StepUpScore: 50})
+	if r.cfg.BlockScore != defaultBlockScore {
+		t.Fatalf("block score = %d, want the default", r.cfg.BlockScore)
+	}
+
+	never := config.Risk{Enabled: true, StepUpScore: 50, BlockScore: -1, Weights: config.RiskWeights{NewDevice: 500}}
+	r, _, _ = newTestRisk(t, never)
+	if decision := r.decide([]string{domain.RiskNewDevice}); decision.Action != domain.RiskStepUp {
+		t.Fatalf("unexpected decision %+v", decision)
+	}
+
+	invalid := []config.Risk{
+		{NotifyScore: 50, StepUpScore: 50},
+		{StepUpScore: 150},
+		{NotifyScore: 60, BlockScore: 40},
+	}
+	for _, cfg := range invalid {
+		if _, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), &fakeStorage{}, nil, nil, nil, cfg); err == nil {
+			t.Errorf("thresholds %+v accepted", cfg)
+		}
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	r, _, _ := newTestRisk(t, testConfig)
+
+	record := r.newRecord(from("::ffff:10.0.3.4", "laptop"), 7)
+	if record.IPRange != "10.0.3.0/24" || record.Country != "DE" || !record.HasLocation {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	record = r.newRecord(from("2001:db8:1:2::1", ""), 7)
+	if record.IPRange != "2001:db8:1::/48" || record.HasLocation {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	if _, ok := r.geo.Lookup(netip.MustParseAddr("10.1.0.1")); !ok {
+		t.Fatal("test database not loaded")
+	}
+}
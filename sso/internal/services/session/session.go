@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	Session(ctx context.Context, sessionID int64) (domain.Session, error)
+	Sessions(ctx context.Context, userID int64) ([]domain.Session, error)
+	DeleteSession(ctx context.Context, sessionID int64) error
+	RevokeSessions(ctx context.Context, userID int64) (int64, error)
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUnauthenticated  = access.ErrUnauthenticated
+	ErrPermissionDenied = access.ErrPermissionDenied
+	ErrUserNotFound     = errors.New("user not found")
+	ErrSessionNotFound  = errors.New("session not found")
+)
+
+var reasons = access.Reasons{
+	ErrUserNotFound:    "user_not_found",
+	ErrSessionNotFound: "session_not_found",
+}
+
+const defaultCleanupInterval = time.Hour
+
+type Sessions struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+	cfg     config.Sessions
+}
+
+// New returns new instance of the Sessions servic
+func New(log *slog.Logger, storage Storage, auditor Auditor, cfg config.Sessions) *Sessions {
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = defaultCleanupInterval
+	}
+
+	return &Sessions{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+		cfg:     cfg,
+	}
+}
+
+// ListSessions returns the active sessions of userID, zero means the caller.
+// Only admins may list the sessions of other users.
+func (s *Sessions) ListSessions(ctx context.Context, userID int64) ([]domain.Session, error) {
+	const op = "session.ListSessions"
+
+	userID, err := access.Owner(ctx, s.storage, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := s.storage.Sessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session, its refresh token and access tokens stop
+// working. Sessions of other users are not found unless the caller is an
+// admin.
+func (s *Sessions) RevokeSession(ctx context.Context, sessionID int64) (err error) {
+	const op = "session.RevokeSession"
+
+	log := s.log.With(slog.String("op", op))
+
+	var session domain.Session
+	defer func() { s.record(ctx, domain.AuditSessionRevoke, session.UserID, &err) }()
+
+	session, err = s.storage.Session(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, sessionNotFound(err))
+	}
+
+	if _, err := access.Owner(ctx, s.storage, session.UserID); err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			session = domain.Session{}
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.storage.DeleteSession(ctx, sessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, sessionNotFound(err))
+	}
+
+	log.Info("session revoked", slog.Int64("uid", session.UserID), slog.Int64("sid", sessionID))
+
+	return nil
+}
+
+// RevokeAllSessions ends every session of userID, zero means the caller, and
+// revokes all access tokens issued to the user so far.
+func (s *Sessions) RevokeAllSessions(ctx context.Context, userID int64) (revoked int64, err error) {
+	const op = "session.RevokeAllSessions"
+
+	log := s.log.With(slog.String("op", op))
+
+	defer s.record(ctx, domain.AuditTokenRevoke, userID, &err)
+
+	userID, err = access.Owner(ctx, s.storage, userID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	revoked, err = s.storage.RevokeSessions(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return 0, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("sessions revoked", slog.Int64("uid", userID), slog.Int64("sessions", revoked))
+
+	return revoked, nil
+}
+
+// Run deletes expired sessions until ctx is done.
+func (s *Sessions) Run(ctx context.Context) {
+	const op = "session.Run"
+
+	log := s.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(s.cfg.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := s.storage.DeleteExpiredSessions(ctx)
+		if err != nil {
+			log.Error("field to delete expired sessions", slog.Any("err", err))
+			continue
+		}
+		if n > 0 {
+			log.Info("expired sessions deleted", slog.Int64("count", n))
+		}
+	}
+}
+
+// record audits a call about userID, it is deferred with the requested id
+// so that it sees the final error.
+func (s *Sessions) record(ctx context.Context, eventType string, userID int64, err *error) {
+	access.Record(ctx, s.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: access.UserSubject(ctx, userID),
+	}, *err, reasons)
+}
+
+func sessionNotFound(err error) error {
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		return ErrSessionNotFound
+	}
+
+	return err
+}
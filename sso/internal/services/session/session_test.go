@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	admins   map[int64]bool
+	sessions map[int64]domain.Session
+	events   []domain.AuditEvent
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func (f *fakeStorage) Session(_ context.Context, sessionID int64) (domain.Session, error) {
+	s, ok := f.sessions[sessionID]
+	if !ok {
+		return domain.Session{}, storage.ErrSessionNotFound
+	}
+
+	return s, nil
+}
+
+func (f *fakeStorage) Sessions(_ context.Context, userID int64) ([]domain.Session, error) {
+	var sessions []domain.Session
+	for _, s := range f.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (f *fakeStorage) DeleteSession(_ context.Context, sessionID int64) error {
+	if _, ok := f.sessions[sessionID]; !ok {
+		return storage.ErrSessionNotFound
+	}
+	delete(f.sessions, sessionID)
+
+	return nil
+}
+
+func (f *fakeStorage) RevokeSessions(_ context.Context, userID int64) (int64, error) {
+	var n int64
+	for id, s := range f.sessions {
+		if s.UserID == userID {
+			delete(f.sessions, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakeStorage) DeleteExpiredSessions(context.Context) (int64, error) { return 0, nil }
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestSessions() (*Sessions, *fakeStorage) {
+	st := &fakeStorage{
+		admins: map[int64]bool{1: true},
+		sessions: map[int64]domain.Session{
+			10: {ID: 10, UserID: 7, Device: "phone"},
+			11: {ID: 11, UserID: 7, Device: "laptop"},
+			12: {ID: 12, UserID: 8},
+		},
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, st, st, config.Sessions{}), st
+}
+
+func as(userID int64) context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: userID, AppID: 1})
+}
+
+func TestListSessions(t *testing.T) {
+	s, _ := newTestSessions()
+
+	if _, err := s.ListSessions(context.Background(), 0); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+	if _, err := s.ListSessions(as(7), 8); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	own, err := s.ListSessions(as(7), 0)
+	if err != nil || len(own) != 2 {
+		t.Fatalf("expected 2 sessions, got %v, %v", own, err)
+	}
+
+	other, err := s.ListSessions(as(1), 8)
+	if err != nil || len(other) != 1 {
+		t.Fatalf("expected 1 session, got %v, %v", other, err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	s, st := newTestSessions()
+
+	// sessions of other users are not revealed
+	if err := s.RevokeSession(as(8), 10); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+	if err := s.RevokeSession(as(7), 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.sessions[10]; ok {
+		t.Fatal("session not revoked")
+	}
+	if err := s.RevokeSession(as(1), 12); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []domain.AuditEvent{
+		{Type: domain.AuditSessionRevoke, ActorID: 8, Subject: domain.UserSubject(8), AppID: 1, Result: domain.AuditFailure, Reason: "session_not_found"},
+		{Type: domain.AuditSessionRevoke, ActorID: 7, Subject: domain.UserSubject(7), AppID: 1, Result: domain.AuditSuccess},
+		{Type: domain.AuditSessionRevoke, ActorID: 1, Subject: domain.UserSubject(8), AppID: 1, Result: domain.AuditSuccess},
+	}
+	for i, event := range expected {
+		if st.events[i] != event {
+			t.Errorf("event %d: expected %+v, got %+v", i, event, st.events[i])
+		}
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	s, st := newTestSessions()
+
+	n, err := s.RevokeAllSessions(as(7), 0)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 revoked sessions, got %d, %v", n, err)
+	}
+	if len(st.sessions) != 1 {
+		t.Fatalf("unexpected sessions left %v", st.sessions)
+	}
+
+	if _, err := s.RevokeAllSessions(as(7), 8); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+
+	// the denied call is recorded against the user it was about
+	denied := st.events[len(st.events)-1]
+	if denied.Subject != domain.UserSubject(8) || denied.Result != domain.AuditFailure || denied.Reason != "permission_denied" {
+		t.Fatalf("unexpected audit event %+v", denied)
+	}
+}
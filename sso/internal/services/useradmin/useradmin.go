@@ -0,0 +1,224 @@
+package useradmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/access"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	UserByID(ctx context.Context, userID int64) (domain.User, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	Users(ctx context.Context, filter domain.UserFilter) ([]domain.User, error)
+	// UpdateUser puts event into the outbox when the email changes.
+	UpdateUser(ctx context.Context, userID int64, update domain.UserUpdate, event domain.Event) error
+	SetUserStatus(ctx context.Context, userID int64, status string) error
+	DeleteUser(ctx context.Context, userID int64, event domain.Event) error
+	// DeleteOTPFactor removes the factor and revokes the tokens of the user.
+	DeleteOTPFactor(ctx context.Context, userID int64) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("email is taken")
+	ErrSelfAction       = errors.New("admins can not disable or delete themselves")
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrOTPNotEnrolled   = errors.New("otp is not enrolled")
+)
+
+var reasons = access.Reasons{
+	ErrUserNotFound:   "user_not_found",
+	ErrUserExists:     "user_exists",
+	ErrSelfAction:     "self_action",
+	ErrOTPNotEnrolled: "otp_not_enrolled",
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// UserWithRole is a user as support staff sees it.
+type UserWithRole struct {
+	domain.User
+	IsAdmin bool
+}
+
+type UserAdmin struct {
+	log     *slog.Logger
+	storage Storage
+	auditor Auditor
+}
+
+// New returns new instance of the UserAdmin servic
+func New(log *slog.Logger, storage Storage, auditor Auditor) *UserAdmin {
+	return &UserAdmin{
+		log:     log,
+		storage: storage,
+		auditor: auditor,
+	}
+}
+
+func (u *UserAdmin) GetUser(ctx context.Context, userID int64) (UserWithRole, error) {
+	const op = "useradmin.GetUser"
+
+	user, err := u.storage.UserByID(ctx, userID)
+	if err != nil {
+		return UserWithRole{}, fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	isAdmin, err := u.storage.IsAdmin(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		return UserWithRole{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.PasswordHash = nil
+
+	return UserWithRole{User: user, IsAdmin: isAdmin}, nil
+}
+
+// ListUsers returns one page of users in id order and the token of the next
+// page. email matches any part of the email address.
+func (u *UserAdmin) ListUsers(ctx context.Context, email string, status string, pageSize int, pageToken string) ([]domain.User, string, error) {
+	const op = "useradmin.ListUsers"
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	filter := domain.UserFilter{Email: email, Status: status, Limit: pageSize + 1}
+	if pageToken != "" {
+		afterID, err := strconv.ParseInt(pageToken, 10, 64)
+		if err != nil || afterID <= 0 {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+		}
+		filter.AfterID = afterID
+	}
+
+	users, err := u.storage.Users(ctx, filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		next = strconv.FormatInt(users[pageSize-1].ID, 10)
+	}
+
+	return users, next, nil
+}
+
+func (u *UserAdmin) UpdateUser(ctx context.Context, userID int64, update domain.UserUpdate) (err error) {
+	const op = "useradmin.UpdateUser"
+
+	defer u.record(ctx, domain.AuditUserUpdate, userID, &err)
+
+	if err := u.storage.UpdateUser(ctx, userID, update, domain.Event{Type: domain.EventEmailChanged}); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	return nil
+}
+
+func (u *UserAdmin) DisableUser(ctx context.Context, userID int64) (err error) {
+	const op = "useradmin.DisableUser"
+
+	defer u.record(ctx, domain.AuditUserDisable, userID, &err)
+
+	if isSelf(ctx, userID) {
+		return fmt.Errorf("%s: %w", op, ErrSelfAction)
+	}
+
+	if err := u.storage.SetUserStatus(ctx, userID, domain.UserStatusDisabled); err != nil {
+		return fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	return nil
+}
+
+func (u *UserAdmin) EnableUser(ctx context.Context, userID int64) (err error) {
+	const op = "useradmin.EnableUser"
+
+	defer u.record(ctx, domain.AuditUserEnable, userID, &err)
+
+	if err := u.storage.SetUserStatus(ctx, userID, domain.UserStatusActive); err != nil {
+		return fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	return nil
+}
+
+func (u *UserAdmin) DeleteUser(ctx context.Context, userID int64) (err error) {
+	const op = "useradmin.DeleteUser"
+
+	defer u.record(ctx, domain.AuditUserDelete, userID, &err)
+
+	if isSelf(ctx, userID) {
+		return fmt.Errorf("%s: %w", op, ErrSelfAction)
+	}
+
+	if err := u.storage.DeleteUser(ctx, userID, domain.Event{Type: domain.EventUserDeleted}); err != nil {
+		return fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	u.log.Info("user deleted", slog.String("op", op), slog.Int64("uid", userID))
+
+	return nil
+}
+
+// ResetOTP removes the OTP factor of a user who lost the authenticator, the
+// tokens of the user are revoked. The user enrolls a new factor afterwards.
+func (u *UserAdmin) ResetOTP(ctx context.Context, userID int64) (err error) {
+	const op = "useradmin.ResetOTP"
+
+	defer u.record(ctx, domain.AuditOTPReset, userID, &err)
+
+	if err := u.storage.DeleteOTPFactor(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrOTPFactorNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrOTPNotEnrolled)
+		}
+		return fmt.Errorf("%s: %w", op, notFound(err))
+	}
+
+	u.log.Info("otp factor reset", slog.String("op", op), slog.Int64("uid", userID))
+
+	return nil
+}
+
+// record audits an admin action on userID, it is deferred so that it sees
+// the final error.
+func (u *UserAdmin) record(ctx context.Context, eventType string, userID int64, err *error) {
+	access.Record(ctx, u.auditor, domain.AuditEvent{
+		Type:    eventType,
+		Subject: domain.UserSubject(userID),
+	}, *err, reasons)
+}
+
+func isSelf(ctx context.Context, userID int64) bool {
+	p, ok := principal.FromContext(ctx)
+	return ok && p.UserID == userID
+}
+
+func notFound(err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,295 @@
+package useradmin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/principal"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type fakeStorage struct {
+	users  map[int64]domain.User
+	admins map[int64]bool
+	events []domain.AuditEvent
+	outbox []domain.Event
+	otp    map[int64]bool
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (domain.User, error) {
+	u, ok := f.users[userID]
+	if !ok {
+		return domain.User{}, storage.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func (f *fakeStorage) Users(_ context.Context, filter domain.UserFilter) ([]domain.User, error) {
+	var users []domain.User
+	for _, u := range f.users {
+		if u.ID <= filter.AfterID || !strings.Contains(u.Email, filter.Email) {
+			continue
+		}
+		if filter.Status != "" && u.Status != filter.Status {
+			continue
+		}
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b domain.User) int { return int(a.ID - b.ID) })
+
+	return users[:min(len(users), filter.Limit)], nil
+}
+
+func (f *fakeStorage) UpdateUser(_ context.Context, userID int64, update domain.UserUpdate, event domain.Event) error {
+	u, ok := f.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+
+	if update.Email != nil {
+		for _, other := range f.users {
+			if other.ID != userID && other.Email == *update.Email {
+				return storage.ErrUserExists
+			}
+		}
+		u.Email = *update.Email
+		event.UserID = userID
+		event.Email = u.Email
+		f.outbox = append(f.outbox, event)
+	}
+	if update.IsAdmin != nil {
+		f.admins[userID] = *update.IsAdmin
+	}
+	f.users[userID] = u
+
+	return nil
+}
+
+func (f *fakeStorage) SetUserStatus(_ context.Context, userID int64, status string) error {
+	u, ok := f.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	u.Status = status
+	f.users[userID] = u
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteUser(_ context.Context, userID int64, _ domain.Event) error {
+	if _, ok := f.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	delete(f.users, userID)
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteOTPFactor(_ context.Context, userID int64) error {
+	if _, ok := f.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	if !f.otp[userID] {
+		return storage.ErrOTPFactorNotFound
+	}
+	delete(f.otp, userID)
+
+	return nil
+}
+
+func (f *fakeStorage) Record(_ context.Context, event domain.AuditEvent) {
+	f.events = append(f.events, event)
+}
+
+func newTestUserAdmin() (*UserAdmin, *fakeStorage) {
+	st := &fakeStorage{
+		users: map[int64]domain.User{
+			1: {ID: 1, Email: "admin@example.com", PasswordHash: []byte("hash"), Status: domain.UserStatusActive},
+			2: {ID: 2, Email: "jonn@gmail.com", PasswordHash: []byte("hash"), Status: domain.UserStatusActive},
+			3: {ID: 3, Email: "mary@gmail.com", PasswordHash: []byte("hash"), Status: domain.UserStatusDisabled},
+		},
+		admins: map[int64]bool{1: true},
+		otp:    map[int64]bool{2: true},
+	}
+
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, st), st
+}
+
+// asAdmin is the context of a call by admin 1.
+func asAdmin() context.Context {
+	return principal.With(context.Background(), principal.Principal{UserID: 1, AppID: 5, Admin: true})
+}
+
+func TestGetUser(t *testing.T) {
+	u, _ := newTestUserAdmin()
+
+	user, err := u.GetUser(asAdmin(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsAdmin || user.PasswordHash != nil {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	if _, err := u.GetUser(asAdmin(), 9); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	u, _ := newTestUserAdmin()
+
+	users, next, err := u.ListUsers(asAdmin(), "gmail", "", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != 2 || next != "2" {
+		t.Fatalf("unexpected first page %v, next %q", users, next)
+	}
+
+	users, next, err = u.ListUsers(asAdmin(), "gmail", "", 1, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != 3 || next != "" {
+		t.Fatalf("unexpected last page %v, next %q", users, next)
+	}
+
+	users, _, err = u.ListUsers(asAdmin(), "", domain.UserStatusDisabled, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != 3 {
+		t.Fatalf("unexpected disabled users %v", users)
+	}
+
+	if _, _, err := u.ListUsers(asAdmin(), "", "", 0, "abc"); !errors.Is(err, ErrInvalidPageToken) {
+		t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	u, st := newTestUserAdmin()
+
+	taken := "mary@gmail.com"
+	if err := u.UpdateUser(asAdmin(), 2, domain.UserUpdate{Email: &taken}); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+
+	admin := true
+	if err := u.UpdateUser(asAdmin(), 2, domain.UserUpdate{IsAdmin: &admin}); err != nil {
+		t.Fatal(err)
+	}
+	if !st.admins[2] {
+		t.Fatal("user 2 was not made an admin")
+	}
+	if len(st.outbox) != 0 {
+		t.Fatalf("unexpected outbox events %+v", st.outbox)
+	}
+
+	email := "jonn.new@gmail.com"
+	if err := u.UpdateUser(asAdmin(), 2, domain.UserUpdate{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.outbox) != 1 || st.outbox[0].Type != domain.EventEmailChanged || st.outbox[0].UserID != 2 || st.outbox[0].Email != email {
+		t.Fatalf("unexpected outbox events %+v", st.outbox)
+	}
+
+	if len(st.events) != 3 {
+		t.Fatalf("expected 3 audit events, got %d", len(st.events))
+	}
+	failed, updated := st.events[0], st.events[1]
+	if failed.Result != domain.AuditFailure || updated.Result != domain.AuditSuccess {
+		t.Fatalf("unexpected results %q, %q", failed.Result, updated.Result)
+	}
+	if updated.Type != domain.AuditUserUpdate || updated.ActorID != 1 || updated.AppID != 5 || updated.Subject != domain.UserSubject(2) {
+		t.Fatalf("unexpected audit event %+v", updated)
+	}
+}
+
+func TestDisableAndEnableUser(t *testing.T) {
+	u, st := newTestUserAdmin()
+
+	if err := u.DisableUser(asAdmin(), 1); !errors.Is(err, ErrSelfAction) {
+		t.Fatalf("expected ErrSelfAction, got %v", err)
+	}
+
+	if err := u.DisableUser(asAdmin(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if st.users[2].Status != domain.UserStatusDisabled {
+		t.Fatal("user 2 is not disabled")
+	}
+
+	if err := u.EnableUser(asAdmin(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if st.users[2].Status != domain.UserStatusActive {
+		t.Fatal("user 2 is not enabled")
+	}
+
+	if err := u.EnableUser(asAdmin(), 9); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	types := make([]string, 0, len(st.events))
+	for _, e := range st.events {
+		types = append(types, e.Type)
+	}
+	want := []string{domain.AuditUserDisable, domain.AuditUserDisable, domain.AuditUserEnable, domain.AuditUserEnable}
+	if !slices.Equal(types, want) {
+		t.Fatalf("audit events %v, want %v", types, want)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	u, st := newTestUserAdmin()
+
+	if err := u.DeleteUser(asAdmin(), 1); !errors.Is(err, ErrSelfAction) {
+		t.Fatalf("expected ErrSelfAction, got %v", err)
+	}
+
+	if err := u.DeleteUser(asAdmin(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.users[2]; ok {
+		t.Fatal("user 2 was not deleted")
+	}
+
+	if err := u.DeleteUser(asAdmin(), 2); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestResetOTP(t *testing.T) {
+	u, st := newTestUserAdmin()
+
+	if err := u.ResetOTP(asAdmin(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if st.otp[2] {
+		t.Fatal("otp factor of user 2 was not removed")
+	}
+
+	if err := u.ResetOTP(asAdmin(), 2); !errors.Is(err, ErrOTPNotEnrolled) {
+		t.Fatalf("expected ErrOTPNotEnrolled, got %v", err)
+	}
+	if err := u.ResetOTP(asAdmin(), 42); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if len(st.events) != 3 || st.events[0].Type != domain.AuditOTPReset || st.events[0].Result != domain.AuditSuccess {
+		t.Fatalf("unexpected audit events %+v", st.events)
+	}
+}
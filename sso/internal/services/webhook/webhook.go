@@ -0,0 +1,399 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/netguard"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/publisher"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/webhooksig"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type Storage interface {
+	SaveWebhook(ctx context.Context, webhook domain.Webhook) (int64, error)
+	DeleteWebhook(ctx context.Context, webhookID int64) error
+	Webhook(ctx context.Context, webhookID int64) (domain.Webhook, error)
+	AppWebhooks(ctx context.Context, appID int64) ([]domain.Webhook, error)
+	SaveWebhookDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error
+	WebhookDeliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error)
+}
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrAppNotFound      = errors.New("app not found")
+	ErrInvalidURL       = errors.New("invalid webhook url")
+	ErrPrivateURL       = errors.New("webhook url is not public")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// EventTypes are the events a webhook can select.
+var EventTypes = []string{
+	domain.EventUserRegistered,
+	domain.EventPasswordChanged,
+	domain.EventUserDeleted,
+	domain.EventEmailChanged,
+}
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 50
+	defaultLease        = time.Minute
+	defaultTimeout      = 10 * time.Second
+	defaultMaxAttempts  = 10
+	defaultMaxBackoff   = time.Hour
+	baseBackoff         = 5 * time.Second
+
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Webhooks delivers events to the URLs registered by apps. Every request
+// is signed with the secret of the webhook, see package webhooksig. Failed
+// deliveries are retried with exponential backoff and dead-lettered after
+// the last attempt.
+type Webhooks struct {
+	log     *slog.Logger
+	storage Storage
+	client  *http.Client
+	cfg     config.Webhooks
+}
+
+// New returns new instance of the Webhooks servic
+func New(log *slog.Logger, storage Storage, cfg config.Webhooks) *Webhooks {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultLease
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+
+	client := netguard.Client(cfg.Timeout)
+	if cfg.AllowPrivateNetworks {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &Webhooks{
+		log:     log,
+		storage: storage,
+		client:  client,
+		cfg:     cfg,
+	}
+}
+
+// Create registers a webhook for app. The returned webhook carries the
+// secret its requests are signed with. The host of the url has to resolve to
+// public addresses only, the deliveries check that again.
+func (w *Webhooks) Create(ctx context.Context, appID int64, rawURL string, eventTypes []string) (domain.Webhook, error) {
+	const op = "webhook.Create"
+
+	log := w.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return domain.Webhook{}, fmt.Errorf("%s: %w", op, ErrInvalidURL)
+	}
+
+	if !w.cfg.AllowPrivateNetworks {
+		if err := netguard.CheckHost(ctx, u.Hostname()); err != nil {
+			if errors.Is(err, netguard.ErrForbiddenAddress) {
+				log.Warn("webhook url is not public", slog.String("host", u.Hostname()))
+				return domain.Webhook{}, fmt.Errorf("%s: %w", op, ErrPrivateURL)
+			}
+			return domain.Webhook{}, fmt.Errorf("%s: %w", op, ErrInvalidURL)
+		}
+	}
+
+	// no types selects every event
+	eventTypes = append([]string{}, eventTypes...)
+
+	for _, t := range eventTypes {
+		if !slices.Contains(EventTypes, t) {
+			return domain.Webhook{}, fmt.Errorf("%s: %w %q", op, ErrUnknownEventType, t)
+		}
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	webhook := domain.Webhook{
+		AppID:      appID,
+		URL:        u.String(),
+		Secret:     "whsec_" + hex.EncodeToString(secret),
+		EventTypes: eventTypes,
+		CreatedAt:  time.Now(),
+	}
+
+	webhook.ID, err = w.storage.SaveWebhook(ctx, webhook)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return domain.Webhook{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		log.Error("field to save webhook", slog.Any("err", err))
+		return domain.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("webhook created", slog.Int64("webhook_id", webhook.ID))
+
+	return webhook, nil
+}
+
+func (w *Webhooks) Delete(ctx context.Context, webhookID int64) error {
+	const op = "webhook.Delete"
+
+	if err := w.storage.DeleteWebhook(ctx, webhookID); err != nil {
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrWebhookNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListDeliveries returns one page of the deliveries of a webhook, newest
+// first, and the token of the next page.
+func (w *Webhooks) ListDeliveries(ctx context.Context, webhookID int64, status string, pageSize int, pageToken string) ([]domain.WebhookDelivery, string, error) {
+	const op = "webhook.ListDeliveries"
+
+	if _, err := w.storage.Webhook(ctx, webhookID); err != nil {
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrWebhookNotFound)
+		}
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	filter := domain.DeliveryFilter{WebhookID: webhookID, Status: status, Limit: pageSize + 1}
+	if pageToken != "" {
+		afterID, err := strconv.ParseInt(pageToken, 10, 64)
+		if err != nil || afterID <= 0 {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+		}
+		filter.AfterID = afterID
+	}
+
+	deliveries, err := w.storage.WebhookDeliveries(ctx, filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(deliveries) > pageSize {
+		deliveries = deliveries[:pageSize]
+		next = strconv.FormatInt(deliveries[pageSize-1].ID, 10)
+	}
+
+	return deliveries, next, nil
+}
+
+// Publish queues msg for every webhook of the app the event belongs to, it
+// lets the outbox relay feed the webhooks.
+func (w *Webhooks) Publish(ctx context.Context, msg publisher.Message) error {
+	const op = "webhook.Publish"
+
+	var event domain.Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if event.AppID == 0 {
+		return nil
+	}
+
+	webhooks, err := w.storage.AppWebhooks(ctx, event.AppID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var deliveries []domain.WebhookDelivery
+	for _, webhook := range webhooks {
+		if !webhook.Wants(msg.Subject) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			WebhookID: webhook.ID,
+			EventID:   msg.ID,
+			EventType: msg.Subject,
+			Payload:   msg.Data,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := w.storage.SaveWebhookDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Run delivers due deliveries until ctx is done.
+func (w *Webhooks) Run(ctx context.Context) {
+	const op = "webhook.Run"
+
+	log := w.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := w.Deliver(ctx)
+			if err != nil {
+				log.Error("field to deliver webhooks", slog.Any("err", err))
+			}
+			if err != nil || n < w.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver attempts one batch of due deliveries and returns its size.
+func (w *Webhooks) Deliver(ctx context.Context) (int, error) {
+	const op = "webhook.Deliver"
+
+	log := w.log.With(slog.String("op", op))
+
+	deliveries, err := w.storage.ClaimWebhookDeliveries(ctx, w.cfg.BatchSize, w.cfg.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	webhooks := make(map[int64]domain.Webhook)
+
+	for _, d := range deliveries {
+		webhook, ok := webhooks[d.WebhookID]
+		if !ok {
+			webhook, err = w.storage.Webhook(tenant.With(ctx, d.TenantID), d.WebhookID)
+			if errors.Is(err, storage.ErrWebhookNotFound) {
+				// deleted meanwhile, its deliveries went with it
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			webhooks[d.WebhookID] = webhook
+		}
+
+		d = w.attempt(ctx, webhook, d)
+
+		if d.Status == domain.DeliveryDead {
+			log.Warn("webhook delivery dead-lettered",
+				slog.Int64("webhook_id", d.WebhookID),
+				slog.String("event_id", d.EventID),
+				slog.String("err", d.LastError),
+			)
+		}
+
+		if err := w.storage.UpdateWebhookDelivery(ctx, d); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// attempt sends d once and returns it updated with the outcome.
+func (w *Webhooks) attempt(ctx context.Context, webhook domain.Webhook, d domain.WebhookDelivery) domain.WebhookDelivery {
+	d.Attempts++
+	d.LastStatusCode = 0
+	d.LastError = ""
+
+	code, err := w.send(ctx, webhook, d)
+	d.LastStatusCode = code
+
+	if err == nil {
+		d.Status = domain.DeliveryDelivered
+		d.DeliveredAt = time.Now()
+		return d
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= w.cfg.MaxAttempts {
+		d.Status = domain.DeliveryDead
+		return d
+	}
+
+	d.Status = domain.DeliveryPending
+	d.NextAttemptAt = time.Now().Add(w.backoff(d.Attempts))
+
+	return d
+}
+
+func (w *Webhooks) send(ctx context.Context, webhook domain.Webhook, d domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooksig.HeaderEventID, d.EventID)
+	req.Header.Set(webhooksig.HeaderEventType, d.EventType)
+	webhooksig.SetHeaders(req.Header, webhook.Secret, time.Now(), d.Payload)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < w.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, w.cfg.MaxBackoff)
+}
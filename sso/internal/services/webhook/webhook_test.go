@@ -0,0 +1,264 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/config"
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/publisher"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/webhooksig"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+type memStorage struct {
+	mu         sync.Mutex
+	webhooks   map[int64]domain.Webhook
+	deliveries []domain.WebhookDelivery
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{webhooks: make(map[int64]domain.Webhook)}
+}
+
+func (m *memStorage) SaveWebhook(_ context.Context, w domain.Webhook) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.ID = int64(len(m.webhooks) + 1)
+	m.webhooks[w.ID] = w
+	return w.ID, nil
+}
+
+func (m *memStorage) DeleteWebhook(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return storage.ErrWebhookNotFound
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *memStorage) Webhook(_ context.Context, id int64) (domain.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[id]
+	if !ok {
+		return domain.Webhook{}, storage.ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+func (m *memStorage) AppWebhooks(_ context.Context, appID int64) ([]domain.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var webhooks []domain.Webhook
+	for _, w := range m.webhooks {
+		if w.AppID == appID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *memStorage) SaveWebhookDeliveries(_ context.Context, deliveries []domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range deliveries {
+		d.ID = int64(len(m.deliveries) + 1)
+		d.Status = domain.DeliveryPending
+		m.deliveries = append(m.deliveries, d)
+	}
+	return nil
+}
+
+func (m *memStorage) ClaimWebhookDeliveries(_ context.Context, limit int, _ time.Duration) ([]domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []domain.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.Status == domain.DeliveryPending && len(due) < limit {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (m *memStorage) UpdateWebhookDelivery(_ context.Context, d domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[d.ID-1] = d
+	return nil
+}
+
+func (m *memStorage) WebhookDeliveries(_ context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []domain.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if d := m.deliveries[i]; d.WebhookID == filter.WebhookID && (filter.Status == "" || d.Status == filter.Status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func TestDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var secret string
+	var received []string
+	failures := 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		if err := webhooksig.Verify(r.Header, secret, body, time.Minute); err != nil {
+			t.Errorf("signature: %v", err)
+		}
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received = append(received, r.Header.Get(webhooksig.HeaderEventType))
+	}))
+	defer srv.Close()
+
+	st := newMemStorage()
+	w := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Webhooks{MaxAttempts: 3, AllowPrivateNetworks: true})
+
+	webhook, err := w.Create(ctx, 1, srv.URL, []string{domain.EventUserRegistered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = webhook.Secret
+
+	for _, typ := range []string{domain.EventUserRegistered, domain.EventPasswordChanged} {
+		data, _ := json.Marshal(domain.Event{ID: typ, Type: typ, UserID: 7, AppID: 1})
+		if err := w.Publish(ctx, publisher.Message{ID: typ, Subject: typ, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 2 {
+		if _, err := w.Deliver(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, _, err := w.ListDeliveries(ctx, webhook.ID, "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected only the selected event to be queued, got %+v", deliveries)
+	}
+
+	d := deliveries[0]
+	if d.Status != domain.DeliveryDelivered || d.Attempts != 2 || d.LastStatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+	if len(received) != 1 || received[0] != domain.EventUserRegistered {
+		t.Errorf("unexpected requests %v", received)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	st := newMemStorage()
+	w := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Webhooks{MaxAttempts: 2, AllowPrivateNetworks: true})
+
+	webhook, err := w.Create(ctx, 1, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(domain.Event{ID: "1", Type: domain.EventUserDeleted, UserID: 7, AppID: 1})
+	if err := w.Publish(ctx, publisher.Message{ID: "1", Subject: domain.EventUserDeleted, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := w.Deliver(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dead, _, err := w.ListDeliveries(ctx, webhook.ID, domain.DeliveryDead, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastStatusCode != http.StatusGone {
+		t.Fatalf("expected one dead delivery after 2 attempts, got %+v", dead)
+	}
+}
+
+func TestCreateRejectsPrivateURL(t *testing.T) {
+	w := New(slog.New(slog.NewTextHandler(io.Discard, nil)), newMemStorage(), config.Webhooks{})
+
+	for _, u := range []string{"http://127.0.0.1/hook", "http://169.254.169.254/latest", "https://10.0.0.1/", "http://[::1]:8080/"} {
+		if _, err := w.Create(context.Background(), 1, u, nil); !errors.Is(err, ErrPrivateURL) {
+			t.Errorf("Create(%s) = %v, want ErrPrivateURL", u, err)
+		}
+	}
+}
+
+func TestDeliveryRefusesPrivateAddress(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the delivery reached a loopback server")
+	}))
+	defer srv.Close()
+
+	st := newMemStorage()
+	// the url was public when it was saved
+	id, err := st.SaveWebhook(ctx, domain.Webhook{AppID: 1, URL: srv.URL, Secret: "whsec_test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(slog.New(slog.NewTextHandler(io.Discard, nil)), st, config.Webhooks{MaxAttempts: 3})
+
+	if err := w.Publish(ctx, publisher.Message{ID: "1", Subject: domain.EventUserRegistered, Data: []byte(`{"app_id":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Deliver(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := st.WebhookDeliveries(ctx, domain.DeliveryFilter{WebhookID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status == domain.DeliveryDelivered {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+}
@@ -6,4 +6,27 @@ var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrAppNotFound  = errors.New("app not found")
+
+	ErrTenantNotFound = errors.New("tenant not found")
+
+	ErrWebhookNotFound     = errors.New("webhook not found")
+	ErrEmailChangeNotFound = errors.New("email change not found")
+	ErrErasureExists       = errors.New("erasure already requested")
+	ErrErasureNotFound     = errors.New("erasure not found")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrRefreshTokenInvalid = errors.New("refresh token is unknown or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+	ErrOTPFactorExists     = errors.New("otp factor already confirmed")
+	ErrOTPFactorNotFound   = errors.New("otp factor not found")
+	ErrOTPCodeUsed         = errors.New("otp code was already used")
+	ErrOrgNotFound         = errors.New("organization not found")
+	ErrMemberExists        = errors.New("user is already a member")
+	ErrMemberNotFound      = errors.New("member not found")
+	ErrLastOrgOwner        = errors.New("organization would have no owner")
+	ErrInviteNotFound      = errors.New("invite not found")
+	ErrAPIKeyNotFound      = errors.New("api key not found")
+	ErrIdentityNotFound    = errors.New("federated identity not found")
+	ErrIdentityLinked      = errors.New("federated identity is linked already")
+	ErrAssertionUsed       = errors.New("saml assertion was already used")
+	ErrLoginCodeNotFound   = errors.New("login code is unknown or expired")
 )
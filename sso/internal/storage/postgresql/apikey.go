@@ -0,0 +1,175 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = "k.id, k.user_id, k.name, k.prefix, k.token_hash, k.scopes, k.expires_at, k.last_used_at, k.created_at"
+
+// tenantAPIKeys joins the API keys of users of the tenant $1.
+const tenantAPIKeys = "api_keys k JOIN users u ON u.id = k.user_id WHERE u.tenant_id = $1"
+
+// SaveAPIKey stores the key of a user of the tenant of ctx.
+func (s *Storage) SaveAPIKey(ctx context.Context, key domain.APIKey) (domain.APIKey, error) {
+	const op = "postgresql.SaveAPIKey"
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, token_hash, scopes, expires_at)
+		SELECT id, $2, $3, $4, $5, $6 FROM users WHERE id = $1 AND tenant_id = $7
+		RETURNING id, created_at`,
+		key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(nonNil(key.Scopes)), key.ExpiresAt, tenant.ID(ctx),
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// APIKeyByPrefix returns the key with prefix, expired keys are returned too.
+func (s *Storage) APIKeyByPrefix(ctx context.Context, prefix string) (domain.APIKey, error) {
+	const op = "postgresql.APIKeyByPrefix"
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM "+tenantAPIKeys+" AND k.prefix = $2", tenant.ID(ctx), prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+		return domain.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// APIKeys returns the keys of the user, the newest first.
+func (s *Storage) APIKeys(ctx context.Context, userID int64) ([]domain.APIKey, error) {
+	const op = "postgresql.APIKeys"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM "+tenantAPIKeys+" AND k.user_id = $2 ORDER BY k.created_at DESC, k.id DESC", tenant.ID(ctx), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the key if it belongs to the user.
+func (s *Storage) DeleteAPIKey(ctx context.Context, userID int64, keyID int64) error {
+	const op = "postgresql.DeleteAPIKey"
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM api_keys k USING users u
+		WHERE k.id = $1 AND k.user_id = $2 AND u.id = k.user_id AND u.tenant_id = $3`, keyID, userID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+// TouchAPIKeys records the last use of keys of any tenant, older times do
+// not overwrite newer ones.
+func (s *Storage) TouchAPIKeys(ctx context.Context, used map[int64]time.Time) error {
+	const op = "postgresql.TouchAPIKeys"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for id, at := range used {
+		_, err := tx.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)", id, at)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredAPIKeys removes the keys of every tenant that expired before
+// before.
+func (s *Storage) DeleteExpiredAPIKeys(ctx context.Context, before time.Time) (int64, error) {
+	const op = "postgresql.DeleteExpiredAPIKeys"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// APIKeyTenant returns the tenant of the owner of the key, it is not
+// restricted to the tenant of ctx.
+func (s *Storage) APIKeyTenant(ctx context.Context, prefix string) (int64, error) {
+	const op = "postgresql.APIKeyTenant"
+
+	var tenantID int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT u.tenant_id FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.prefix = $1", prefix,
+	).Scan(&tenantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tenantID, nil
+}
+
+func scanAPIKey(row rowScanner) (domain.APIKey, error) {
+	var (
+		key      domain.APIKey
+		lastUsed sql.NullTime
+	)
+
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes),
+		&key.ExpiresAt, &lastUsed, &key.CreatedAt)
+	key.LastUsedAt = lastUsed.Time
+
+	return key, err
+}
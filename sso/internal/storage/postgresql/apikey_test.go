@@ -0,0 +1,103 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+	otherID := seedUser(t, s, "mary@gmail.com")
+
+	key, err := s.SaveAPIKey(ctx, domain.APIKey{
+		UserID:    userID,
+		Name:      "ci",
+		Prefix:    "abc123",
+		Hash:      []byte("key hash"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.APIKeyByPrefix(ctx, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != key.ID || found.UserID != userID || len(found.Scopes) != 0 || !found.LastUsedAt.IsZero() {
+		t.Fatalf("unexpected key %+v", found)
+	}
+
+	tenantID, err := s.APIKeyTenant(ctx, "abc123")
+	if err != nil || tenantID != 1 {
+		t.Fatalf("tenant = %d, %v", tenantID, err)
+	}
+
+	// an older use does not overwrite a newer one
+	used := time.Now().Truncate(time.Microsecond)
+	if err := s.TouchAPIKeys(ctx, map[int64]time.Time{key.ID: used}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TouchAPIKeys(ctx, map[int64]time.Time{key.ID: used.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := s.APIKeys(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].LastUsedAt.Equal(used) {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	// keys are only deleted by their owner
+	if err := s.DeleteAPIKey(ctx, otherID, key.ID); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if err := s.DeleteAPIKey(ctx, userID, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.APIKeyByPrefix(ctx, "abc123"); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+}
+
+func TestDeleteExpiredAPIKeys(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	for prefix, expiresAt := range map[string]time.Time{
+		"expired": time.Now().Add(-2 * time.Hour),
+		"recent":  time.Now().Add(-time.Minute),
+		"valid":   time.Now().Add(time.Hour),
+	} {
+		if _, err := s.SaveAPIKey(ctx, domain.APIKey{UserID: userID, Name: prefix, Prefix: prefix, Hash: []byte(prefix), ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.DeleteExpiredAPIKeys(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d keys, want 1", n)
+	}
+
+	if _, err := s.APIKeyByPrefix(ctx, "expired"); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if _, err := s.APIKeyByPrefix(ctx, "recent"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,279 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/lib/pq"
+)
+
+// auditChainLock serialises the inserts of a tenant so that every event
+// links to the one stored right before it, the tenant is the second key.
+const auditChainLock = 0x5a0a_0d17
+
+// SaveAuditEvent appends event to the hash chain of its tenant.
+func (s *Storage) SaveAuditEvent(ctx context.Context, event domain.AuditEvent) (int64, error) {
+	const op = "postgresql.SaveAuditEvent"
+
+	tenantID := tenant.ID(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", auditChainLock, int32(tenantID)); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"SELECT hash FROM audit_events WHERE tenant_id = $1 ORDER BY id DESC LIMIT 1", tenantID,
+	).Scan(&event.PrevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT nextval('audit_events_id_seq')").Scan(&event.ID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the hash covers the time, keep only what postgres stores
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.TenantID = tenantID
+	event.TenantHashed = true
+	event.Hash = auditchain.Hash(event)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_events (id, event_type, actor_id, subject, ip, user_agent, app_id, result, reason, created_at, prev_hash, hash, tenant_id, tenant_chain, tenant_hashed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, true, true)`,
+		event.ID, event.Type, nullInt(event.ActorID), event.Subject, event.IP, event.UserAgent,
+		nullInt(event.AppID), event.Result, event.Reason, event.CreatedAt, event.PrevHash, event.Hash, event.TenantID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return event.ID, nil
+}
+
+// AuditEvents returns the events of the tenant matching filter, the events of
+// every tenant when ctx is marked by tenant.Any.
+func (s *Storage) AuditEvents(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEvent, error) {
+	const op = "postgresql.AuditEvents"
+
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if !tenant.IsAny(ctx) {
+		add("tenant_id = $%d", tenant.ID(ctx))
+	}
+	if filter.ActorID != 0 {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Type != "" {
+		add("event_type = $%d", filter.Type)
+	}
+	if filter.AppID != 0 {
+		add("app_id = $%d", filter.AppID)
+	}
+	if filter.Result != "" {
+		add("result = $%d", filter.Result)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+	order := "DESC"
+	if filter.AfterID != 0 {
+		if filter.Ascending {
+			add("id > $%d", filter.AfterID)
+		} else {
+			add("id < $%d", filter.AfterID)
+		}
+	}
+	if filter.Ascending {
+		order = "ASC"
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id %s LIMIT $%d", order, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// UserAuditEvents returns the events the user did or that are about one of
+// subjects, oldest first.
+func (s *Storage) UserAuditEvents(ctx context.Context, userID int64, subjects []string) ([]domain.AuditEvent, error) {
+	const op = "postgresql.UserAuditEvents"
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+auditColumns+` FROM audit_events WHERE tenant_id = $3 AND (actor_id = $1 OR subject = ANY($2)) ORDER BY id`,
+		userID, pq.Array(subjects), tenant.ID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+const auditColumns = `id, event_type, COALESCE(actor_id, 0), subject, ip, user_agent, COALESCE(app_id, 0), result, reason,
+	created_at, prev_hash, hash, tenant_chain, COALESCE(pii_digest, ''), COALESCE(tombstone_id, 0), tenant_id, tenant_hashed`
+
+func scanAuditEvents(rows *sql.Rows) ([]domain.AuditEvent, error) {
+	var events []domain.AuditEvent
+	for rows.Next() {
+		var e domain.AuditEvent
+		err := rows.Scan(&e.ID, &e.Type, &e.ActorID, &e.Subject, &e.IP, &e.UserAgent, &e.AppID, &e.Result, &e.Reason,
+			&e.CreatedAt, &e.PrevHash, &e.Hash, &e.TenantChain, &e.PIIDigest, &e.TombstoneID, &e.TenantID, &e.TenantHashed)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Storage) DeleteAuditEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	const op = "postgresql.DeleteAuditEventsBefore"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM audit_events WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// checkpoints of deleted events can never be verified again
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM audit_checkpoints
+		WHERE event_id < COALESCE((SELECT MIN(id) FROM audit_events), 9223372036854775807)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SaveAuditCheckpoint(ctx context.Context, checkpoint domain.AuditCheckpoint) error {
+	const op = "postgresql.SaveAuditCheckpoint"
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO audit_checkpoints (event_id, hash, signature) VALUES ($1, $2, $3)",
+		checkpoint.EventID, checkpoint.Hash, checkpoint.Signature,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditCheckpoints returns every checkpoint, oldest first.
+func (s *Storage) AuditCheckpoints(ctx context.Context) ([]domain.AuditCheckpoint, error) {
+	const op = "postgresql.AuditCheckpoints"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, event_id, hash, signature, created_at FROM audit_checkpoints ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var checkpoints []domain.AuditCheckpoint
+	for rows.Next() {
+		var cp domain.AuditCheckpoint
+		if err := rows.Scan(&cp.ID, &cp.EventID, &cp.Hash, &cp.Signature, &cp.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return checkpoints, nil
+}
+
+// AuditChainHeads returns the newest chained event of every tenant.
+func (s *Storage) AuditChainHeads(ctx context.Context) ([]domain.AuditEvent, error) {
+	const op = "postgresql.AuditChainHeads"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (tenant_id) `+auditColumns+`
+		FROM audit_events
+		WHERE hash <> ''
+		ORDER BY tenant_id, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// LastAuditCheckpointEventID returns the newest event signed by a
+// checkpoint, zero when there is none.
+func (s *Storage) LastAuditCheckpointEventID(ctx context.Context) (int64, error) {
+	const op = "postgresql.LastAuditCheckpointEventID"
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(event_id), 0) FROM audit_checkpoints").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// nullInt stores zero ids as NULL.
+func nullInt(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
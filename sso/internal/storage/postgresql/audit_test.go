@@ -0,0 +1,58 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+)
+
+func TestAuditTenantChains(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.db.Exec("INSERT INTO tenants (id, slug, name) VALUES (2, 'other', 'Other')"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenantID := range []int64{1, 2, 1, 2, 2} {
+		event := domain.AuditEvent{Type: domain.AuditLogin, Subject: "jonn@gmail.com", Result: domain.AuditSuccess}
+		if _, err := s.SaveAuditEvent(tenant.With(ctx, tenantID), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := s.AuditEvents(tenant.Any(ctx), domain.AuditFilter{Ascending: true, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := auditchain.NewVerifier(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := map[int64]string{}
+	for _, e := range events {
+		if !e.TenantChain || !e.TenantHashed || e.PrevHash != prev[e.TenantID] {
+			t.Fatalf("event %d is not chained to its tenant", e.ID)
+		}
+		prev[e.TenantID] = e.Hash
+
+		if err := v.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	heads, err := s.AuditChainHeads(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heads) != 2 || heads[0].Hash != prev[1] || heads[1].Hash != prev[2] {
+		t.Fatalf("unexpected heads %+v", heads)
+	}
+}
@@ -0,0 +1,112 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+// SaveEmailChange replaces the pending email change of the user.
+func (s *Storage) SaveEmailChange(ctx context.Context, change domain.EmailChange) error {
+	const op = "postgresql.SaveEmailChange"
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO email_changes (user_id, new_email, app_id, token_hash, expires_at)
+		SELECT id, $2, $3, $4, $5 FROM users WHERE id = $1 AND tenant_id = $6
+		ON CONFLICT (user_id) DO UPDATE SET
+			new_email = EXCLUDED.new_email,
+			app_id = EXCLUDED.app_id,
+			token_hash = EXCLUDED.token_hash,
+			expires_at = EXCLUDED.expires_at,
+			created_at = now()`,
+		change.UserID, change.NewEmail, change.AppID, change.TokenHash, change.ExpiresAt, tenant.ID(ctx),
+	)
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// no row is inserted for users of another tenant
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange applies the pending change with tokenHash and revokes
+// the access tokens issued before it. The unique email per tenant
+// constraint decides if the address was taken in the meantime. It returns the replaced
+// address together with the applied change.
+func (s *Storage) ConfirmEmailChange(ctx context.Context, tokenHash []byte, event domain.Event) (oldEmail string, change domain.EmailChange, err error) {
+	const op = "postgresql.ConfirmEmailChange"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// the token is single use, deleting the row locks it against a
+	// concurrent confirmation
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM email_changes c USING users u
+		WHERE c.token_hash = $1 AND u.id = c.user_id AND u.tenant_id = $2
+		RETURNING c.user_id, c.new_email, c.app_id, c.token_hash, c.expires_at`,
+		tokenHash, tenant.ID(ctx),
+	).Scan(&change.UserID, &change.NewEmail, &change.AppID, &change.TokenHash, &change.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, storage.ErrEmailChangeNotFound)
+		}
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if time.Now().After(change.ExpiresAt) {
+		if err := tx.Commit(); err != nil {
+			return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+		}
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, storage.ErrEmailChangeNotFound)
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 FOR UPDATE", change.UserID).Scan(&oldEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET email = $2, tokens_invalid_before = date_trunc('second', now()) WHERE id = $1",
+		change.UserID, change.NewEmail)
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+			return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = change.UserID
+	event.AppID = change.AppID
+	event.Email = change.NewEmail
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", domain.EmailChange{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return oldEmail, change, nil
+}
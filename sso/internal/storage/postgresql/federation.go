@@ -0,0 +1,251 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+const identityColumns = "id, user_id, connector, subject, email, created_at"
+
+// FederatedIdentity returns the identity of subject at the connector of the
+// tenant of ctx.
+func (s *Storage) FederatedIdentity(ctx context.Context, connector string, subject string) (domain.FederatedIdentity, error) {
+	const op = "postgresql.FederatedIdentity"
+
+	identity, err := scanIdentity(s.db.QueryRowContext(ctx,
+		"SELECT "+identityColumns+" FROM federated_identities WHERE tenant_id = $1 AND connector = $2 AND subject = $3",
+		tenant.ID(ctx), connector, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.FederatedIdentity{}, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+		}
+		return domain.FederatedIdentity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identity, nil
+}
+
+// FederatedIdentities returns the identities linked to the user, the oldest
+// first.
+func (s *Storage) FederatedIdentities(ctx context.Context, userID int64) ([]domain.FederatedIdentity, error) {
+	const op = "postgresql.FederatedIdentities"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+identityColumns+" FROM federated_identities WHERE tenant_id = $1 AND user_id = $2 ORDER BY id",
+		tenant.ID(ctx), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var identities []domain.FederatedIdentity
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identities, nil
+}
+
+// SaveFederatedIdentity links the identity to a user of the tenant of ctx.
+func (s *Storage) SaveFederatedIdentity(ctx context.Context, identity domain.FederatedIdentity) (domain.FederatedIdentity, error) {
+	const op = "postgresql.SaveFederatedIdentity"
+
+	identity, err := saveIdentity(ctx, s.db, identity)
+	if err != nil {
+		return domain.FederatedIdentity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identity, nil
+}
+
+// SaveFederatedUser creates a user without a usable password, links the
+// identity to it and puts event, completed with the new id, into the outbox
+// in one transaction.
+func (s *Storage) SaveFederatedUser(
+	ctx context.Context,
+	email string,
+	passwordHash []byte,
+	identity domain.FederatedIdentity,
+	event domain.Event,
+) (domain.User, error) {
+	const op = "postgresql.SaveFederatedUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRowContext(ctx,
+		"INSERT INTO users(email, pass_hash, tenant_id) VALUES($1, $2, $3) RETURNING "+userColumns,
+		email, passwordHash, tenant.ID(ctx)))
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+			return domain.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	identity.UserID = user.ID
+	if _, err := saveIdentity(ctx, tx, identity); err != nil {
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = user.ID
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// queryRower is satisfied by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func saveIdentity(ctx context.Context, db queryRower, identity domain.FederatedIdentity) (domain.FederatedIdentity, error) {
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO federated_identities (tenant_id, user_id, connector, subject, email)
+		SELECT tenant_id, id, $3, $4, $5 FROM users WHERE id = $2 AND tenant_id = $1
+		RETURNING id, created_at`,
+		tenant.ID(ctx), identity.UserID, identity.Connector, identity.Subject, identity.Email,
+	).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+			return domain.FederatedIdentity{}, storage.ErrIdentityLinked
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.FederatedIdentity{}, storage.ErrUserNotFound
+		}
+		return domain.FederatedIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+func scanIdentity(row rowScanner) (domain.FederatedIdentity, error) {
+	var identity domain.FederatedIdentity
+
+	err := row.Scan(&identity.ID, &identity.UserID, &identity.Connector, &identity.Subject, &identity.Email, &identity.CreatedAt)
+
+	return identity, err
+}
+
+// UseSAMLAssertion records the assertion id of connector as used until
+// expires. An id that is recorded and not expired is ErrAssertionUsed, also
+// for concurrent calls.
+func (s *Storage) UseSAMLAssertion(ctx context.Context, connector string, id string, expires time.Time) error {
+	const op = "postgresql.UseSAMLAssertion"
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO saml_assertions (connector, id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (connector, id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE saml_assertions.expires_at < now()`,
+		connector, id, expires,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAssertionUsed)
+	}
+
+	return nil
+}
+
+// DeleteExpiredSAMLAssertions removes the assertions that expired, they can
+// not be replayed anymore.
+func (s *Storage) DeleteExpiredSAMLAssertions(ctx context.Context) (int64, error) {
+	const op = "postgresql.DeleteExpiredSAMLAssertions"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM saml_assertions WHERE expires_at < now()")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// SaveLoginCode stores code for the tenant of ctx.
+func (s *Storage) SaveLoginCode(ctx context.Context, code domain.LoginCode) error {
+	const op = "postgresql.SaveLoginCode"
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_codes (hash, tenant_id, user_id, app_id, ip, user_agent, device, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		code.Hash, tenant.ID(ctx), code.UserID, code.AppID, code.IP, code.UserAgent, code.Device, code.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseLoginCode deletes the code with hash and returns it. A code is taken
+// once, an unknown or expired one is ErrLoginCodeNotFound.
+func (s *Storage) UseLoginCode(ctx context.Context, hash []byte) (domain.LoginCode, error) {
+	const op = "postgresql.UseLoginCode"
+
+	code := domain.LoginCode{Hash: hash}
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM login_codes WHERE hash = $1 AND tenant_id = $2 AND expires_at > now()
+		RETURNING user_id, app_id, ip, user_agent, device, expires_at`,
+		hash, tenant.ID(ctx),
+	).Scan(&code.UserID, &code.AppID, &code.IP, &code.UserAgent, &code.Device, &code.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.LoginCode{}, fmt.Errorf("%s: %w", op, storage.ErrLoginCodeNotFound)
+		}
+		return domain.LoginCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// DeleteExpiredLoginCodes removes the codes of every tenant that expired
+// without being redeemed.
+func (s *Storage) DeleteExpiredLoginCodes(ctx context.Context) (int64, error) {
+	const op = "postgresql.DeleteExpiredLoginCodes"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM login_codes WHERE expires_at < now()")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
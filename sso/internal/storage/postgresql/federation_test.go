@@ -0,0 +1,137 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+func TestFederatedIdentities(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	identity := domain.FederatedIdentity{Connector: "okta", Subject: "00u1", Email: "jonn@gmail.com"}
+
+	user, err := s.SaveFederatedUser(ctx, "jonn@gmail.com", []byte("hash"), identity, domain.Event{Type: domain.EventUserRegistered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.FederatedIdentity(ctx, "okta", "00u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.UserID != user.ID || found.Email != "jonn@gmail.com" {
+		t.Fatalf("unexpected identity %+v", found)
+	}
+
+	// a subject links to one user
+	otherID := seedUser(t, s, "mary@gmail.com")
+	identity.UserID = otherID
+	if _, err := s.SaveFederatedIdentity(ctx, identity); !errors.Is(err, storage.ErrIdentityLinked) {
+		t.Fatalf("expected ErrIdentityLinked, got %v", err)
+	}
+
+	if _, err := s.SaveFederatedIdentity(ctx, domain.FederatedIdentity{UserID: user.ID, Connector: "google", Subject: "1042"}); err != nil {
+		t.Fatal(err)
+	}
+
+	identities, err := s.FederatedIdentities(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 || identities[0].Connector != "okta" || identities[1].Connector != "google" {
+		t.Fatalf("unexpected identities %+v", identities)
+	}
+
+	// the account is not created twice, nor is its identity linked
+	_, err = s.SaveFederatedUser(ctx, "jonn@gmail.com", []byte("hash"), domain.FederatedIdentity{Connector: "okta", Subject: "00u2"}, domain.Event{Type: domain.EventUserRegistered})
+	if !errors.Is(err, storage.ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+	if _, err := s.FederatedIdentity(ctx, "okta", "00u2"); !errors.Is(err, storage.ErrIdentityNotFound) {
+		t.Fatalf("expected ErrIdentityNotFound, got %v", err)
+	}
+}
+
+func TestUseSAMLAssertion(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if err := s.UseSAMLAssertion(ctx, "corp", "id-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseSAMLAssertion(ctx, "corp", "id-1", time.Now().Add(time.Minute)); !errors.Is(err, storage.ErrAssertionUsed) {
+		t.Fatalf("expected ErrAssertionUsed, got %v", err)
+	}
+	// ids are the ones of a provider
+	if err := s.UseSAMLAssertion(ctx, "partner", "id-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseSAMLAssertion(ctx, "corp", "id-2", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.DeleteExpiredSAMLAssertions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d assertions, want 1", n)
+	}
+}
+
+func TestUseLoginCode(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+	appID := seedApp(t, s, "app")
+
+	code := domain.LoginCode{
+		Hash:      []byte("hash-1"),
+		UserID:    userID,
+		AppID:     appID,
+		IP:        "203.0.113.7",
+		UserAgent: "Mozilla/5.0",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	if err := s.SaveLoginCode(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.UseLoginCode(ctx, code.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != userID || got.AppID != appID || got.IP != code.IP || got.UserAgent != code.UserAgent {
+		t.Fatalf("unexpected code %+v", got)
+	}
+
+	// a code is redeemed once
+	if _, err := s.UseLoginCode(ctx, code.Hash); !errors.Is(err, storage.ErrLoginCodeNotFound) {
+		t.Fatalf("expected ErrLoginCodeNotFound, got %v", err)
+	}
+
+	expired := code
+	expired.Hash = []byte("hash-2")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := s.SaveLoginCode(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UseLoginCode(ctx, expired.Hash); !errors.Is(err, storage.ErrLoginCodeNotFound) {
+		t.Fatalf("expected ErrLoginCodeNotFound, got %v", err)
+	}
+
+	n, err := s.DeleteExpiredLoginCodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d codes, want 1", n)
+	}
+}
@@ -0,0 +1,373 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+// CreateOrganization stores the organization in the tenant of ctx with
+// owner as its first member.
+func (s *Storage) CreateOrganization(ctx context.Context, org domain.Organization, owner domain.Member) (domain.Organization, error) {
+	const op = "postgresql.CreateOrganization"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	org.TenantID = tenant.ID(ctx)
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO organizations (tenant_id, name, created_by) VALUES ($1, $2, $3) RETURNING id, created_at",
+		org.TenantID, org.Name, org.CreatedBy,
+	).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO org_members (org_id, user_id, role, teams)
+		SELECT $1, id, $3, $4 FROM users WHERE id = $2 AND tenant_id = $5`,
+		org.ID, owner.UserID, owner.Role, pq.Array(nonNil(owner.Teams)), org.TenantID,
+	)
+	if err != nil {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+func (s *Storage) Organization(ctx context.Context, orgID int64) (domain.Organization, error) {
+	const op = "postgresql.Organization"
+
+	var org domain.Organization
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, tenant_id, name, created_by, created_at FROM organizations WHERE id = $1 AND tenant_id = $2",
+		orgID, tenant.ID(ctx),
+	).Scan(&org.ID, &org.TenantID, &org.Name, &org.CreatedBy, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+		return domain.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+const memberColumns = "m.org_id, m.user_id, u.email, m.role, m.teams, m.joined_at"
+
+// orgMembers joins the members of organizations of the tenant $1.
+const orgMembers = `
+	org_members m JOIN organizations o ON o.id = m.org_id JOIN users u ON u.id = m.user_id
+	WHERE o.tenant_id = $1`
+
+func (s *Storage) OrgMember(ctx context.Context, orgID int64, userID int64) (domain.Member, error) {
+	const op = "postgresql.OrgMember"
+
+	member, err := scanMember(s.db.QueryRowContext(ctx,
+		"SELECT "+memberColumns+" FROM "+orgMembers+" AND m.org_id = $2 AND m.user_id = $3",
+		tenant.ID(ctx), orgID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+		}
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return member, nil
+}
+
+// OrgMembers returns the members of the organization, the oldest first.
+func (s *Storage) OrgMembers(ctx context.Context, orgID int64) ([]domain.Member, error) {
+	const op = "postgresql.OrgMembers"
+
+	members, err := s.members(ctx,
+		"SELECT "+memberColumns+" FROM "+orgMembers+" AND m.org_id = $2 ORDER BY m.joined_at, m.user_id",
+		tenant.ID(ctx), orgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// Memberships returns the memberships of the user in the organizations of
+// the tenant.
+func (s *Storage) Memberships(ctx context.Context, userID int64) ([]domain.Member, error) {
+	const op = "postgresql.Memberships"
+
+	members, err := s.members(ctx,
+		"SELECT "+memberColumns+" FROM "+orgMembers+" AND m.user_id = $2 ORDER BY m.org_id",
+		tenant.ID(ctx), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+func (s *Storage) members(ctx context.Context, query string, args ...any) ([]domain.Member, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []domain.Member
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// UpdateOrgMember changes the membership, the last owner of an organization
+// stays an owner.
+func (s *Storage) UpdateOrgMember(ctx context.Context, orgID int64, userID int64, update domain.MemberUpdate) (domain.Member, error) {
+	const op = "postgresql.UpdateOrgMember"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// locked like in RemoveOrgMember, two owners can not demote each other
+	// at once
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM organizations WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orgID, tenant.ID(ctx),
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var teams any
+	if update.Teams != nil {
+		teams = pq.Array(nonNil(*update.Teams))
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE org_members SET role = COALESCE($3, role), teams = COALESCE($4::text[], teams)
+		WHERE org_id = $1 AND user_id = $2`,
+		orgID, userID, update.Role, teams,
+	)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+	}
+
+	var owners int
+	err = tx.QueryRowContext(ctx,
+		"SELECT count(*) FROM org_members WHERE org_id = $1 AND role = $2", orgID, domain.OrgRoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if owners == 0 {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrLastOrgOwner)
+	}
+
+	member, err := scanMember(tx.QueryRowContext(ctx,
+		"SELECT "+memberColumns+" FROM "+orgMembers+" AND m.org_id = $2 AND m.user_id = $3",
+		tenant.ID(ctx), orgID, userID))
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return member, nil
+}
+
+// RemoveOrgMember removes the member, the last owner of an organization can
+// not be removed.
+func (s *Storage) RemoveOrgMember(ctx context.Context, orgID int64, userID int64) error {
+	const op = "postgresql.RemoveOrgMember"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// locking the organization serialises removals, two owners can not
+	// remove each other at once
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM organizations WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orgID, tenant.ID(ctx),
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var role string
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM org_members WHERE org_id = $1 AND user_id = $2 RETURNING role", orgID, userID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if role == domain.OrgRoleOwner {
+		var owners int
+		err := tx.QueryRowContext(ctx,
+			"SELECT count(*) FROM org_members WHERE org_id = $1 AND role = $2", orgID, domain.OrgRoleOwner,
+		).Scan(&owners)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if owners == 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrLastOrgOwner)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveOrgInvite stores the invite, it replaces a pending invite of the same
+// address to the organization.
+func (s *Storage) SaveOrgInvite(ctx context.Context, invite domain.OrgInvite) (domain.OrgInvite, error) {
+	const op = "postgresql.SaveOrgInvite"
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO org_invites (org_id, email, role, teams, invited_by, token_hash, expires_at)
+		SELECT id, $2, $3, $4, $5, $6, $7 FROM organizations WHERE id = $1 AND tenant_id = $8
+		ON CONFLICT (org_id, email) DO UPDATE SET
+			role = EXCLUDED.role,
+			teams = EXCLUDED.teams,
+			invited_by = EXCLUDED.invited_by,
+			token_hash = EXCLUDED.token_hash,
+			expires_at = EXCLUDED.expires_at,
+			created_at = now()
+		RETURNING id, created_at`,
+		invite.OrgID, invite.Email, invite.Role, pq.Array(nonNil(invite.Teams)), invite.InvitedBy,
+		invite.TokenHash, invite.ExpiresAt, tenant.ID(ctx),
+	).Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+// OrgInvite returns the invite with tokenHash, expired invites are returned
+// too.
+func (s *Storage) OrgInvite(ctx context.Context, tokenHash []byte) (domain.OrgInvite, error) {
+	const op = "postgresql.OrgInvite"
+
+	var invite domain.OrgInvite
+	err := s.db.QueryRowContext(ctx, `
+		SELECT i.id, i.org_id, i.email, i.role, i.teams, i.invited_by, i.token_hash, i.expires_at, i.created_at
+		FROM org_invites i JOIN organizations o ON o.id = i.org_id
+		WHERE i.token_hash = $1 AND o.tenant_id = $2`, tokenHash, tenant.ID(ctx),
+	).Scan(&invite.ID, &invite.OrgID, &invite.Email, &invite.Role, pq.Array(&invite.Teams), &invite.InvitedBy,
+		&invite.TokenHash, &invite.ExpiresAt, &invite.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+		}
+		return domain.OrgInvite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+// AcceptOrgInvite turns the invite into a membership of the user. The invite
+// is single use, a concurrent accept finds it gone.
+func (s *Storage) AcceptOrgInvite(ctx context.Context, invite domain.OrgInvite, userID int64) (domain.Member, error) {
+	const op = "postgresql.AcceptOrgInvite"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM org_invites WHERE id = $1", invite.ID)
+	if err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+	}
+
+	member := domain.Member{OrgID: invite.OrgID, UserID: userID, Role: invite.Role, Teams: invite.Teams}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO org_members (org_id, user_id, role, teams)
+		SELECT $1, id, $3, $4 FROM users WHERE id = $2 AND tenant_id = $5
+		RETURNING joined_at, (SELECT email FROM users WHERE id = $2)`,
+		member.OrgID, member.UserID, member.Role, pq.Array(nonNil(member.Teams)), tenant.ID(ctx),
+	).Scan(&member.JoinedAt, &member.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+			return domain.Member{}, fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+		}
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return member, nil
+}
+
+func scanMember(row rowScanner) (domain.Member, error) {
+	var m domain.Member
+	err := row.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, pq.Array(&m.Teams), &m.JoinedAt)
+
+	return m, err
+}
+
+// nonNil stores a nil slice as an empty array, the columns are NOT NULL.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
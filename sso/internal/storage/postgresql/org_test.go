@@ -0,0 +1,147 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+func TestOrgMembers(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	ownerID := seedUser(t, s, "owner@gmail.com")
+	memberID := seedUser(t, s, "member@gmail.com")
+
+	org, err := s.CreateOrganization(ctx, domain.Organization{Name: "acme", CreatedBy: ownerID}, domain.Member{UserID: ownerID, Role: domain.OrgRoleOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, err := s.SaveOrgInvite(ctx, domain.OrgInvite{
+		OrgID:     org.ID,
+		Email:     "member@gmail.com",
+		Role:      domain.OrgRoleMember,
+		Teams:     []string{"dev"},
+		InvitedBy: ownerID,
+		TokenHash: []byte("invite hash"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, err = s.OrgInvite(ctx, invite.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := s.AcceptOrgInvite(ctx, invite, memberID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Email != "member@gmail.com" || member.Role != domain.OrgRoleMember || len(member.Teams) != 1 {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	// invites are single use
+	if _, err := s.AcceptOrgInvite(ctx, invite, memberID); !errors.Is(err, storage.ErrInviteNotFound) {
+		t.Fatalf("expected ErrInviteNotFound, got %v", err)
+	}
+
+	members, err := s.OrgMembers(ctx, org.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].UserID != ownerID || len(members[0].Teams) != 0 {
+		t.Fatalf("unexpected members %+v", members)
+	}
+
+	// the last owner stays
+	if err := s.RemoveOrgMember(ctx, org.ID, ownerID); !errors.Is(err, storage.ErrLastOrgOwner) {
+		t.Fatalf("expected ErrLastOrgOwner, got %v", err)
+	}
+	if _, err := s.OrgMember(ctx, org.ID, ownerID); err != nil {
+		t.Fatalf("owner removed: %v", err)
+	}
+
+	if err := s.RemoveOrgMember(ctx, org.ID, memberID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OrgMember(ctx, org.ID, memberID); !errors.Is(err, storage.ErrMemberNotFound) {
+		t.Fatalf("expected ErrMemberNotFound, got %v", err)
+	}
+}
+
+func TestUpdateOrgMember(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	ownerID := seedUser(t, s, "owner@gmail.com")
+
+	org, err := s.CreateOrganization(ctx, domain.Organization{Name: "acme", CreatedBy: ownerID}, domain.Member{UserID: ownerID, Role: domain.OrgRoleOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teams := []string{"dev", "ops"}
+	member, err := s.UpdateOrgMember(ctx, org.ID, ownerID, domain.MemberUpdate{Teams: &teams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != domain.OrgRoleOwner || len(member.Teams) != 2 {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	// the last owner stays an owner
+	admin := domain.OrgRoleAdmin
+	if _, err := s.UpdateOrgMember(ctx, org.ID, ownerID, domain.MemberUpdate{Role: &admin}); !errors.Is(err, storage.ErrLastOrgOwner) {
+		t.Fatalf("expected ErrLastOrgOwner, got %v", err)
+	}
+
+	member, err = s.OrgMember(ctx, org.ID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != domain.OrgRoleOwner || len(member.Teams) != 2 {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	if _, err := s.UpdateOrgMember(ctx, org.ID, ownerID+1, domain.MemberUpdate{Role: &admin}); !errors.Is(err, storage.ErrMemberNotFound) {
+		t.Fatalf("expected ErrMemberNotFound, got %v", err)
+	}
+}
+
+func TestOrgOfOtherTenant(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.db.Exec("INSERT INTO tenants (id, slug, name) VALUES (2, 'other', 'Other')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ownerID := seedUser(t, s, "owner@gmail.com")
+
+	org, err := s.CreateOrganization(ctx, domain.Organization{Name: "acme", CreatedBy: ownerID}, domain.Member{UserID: ownerID, Role: domain.OrgRoleOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := tenant.With(ctx, 2)
+	if _, err := s.Organization(other, org.ID); !errors.Is(err, storage.ErrOrgNotFound) {
+		t.Fatalf("expected ErrOrgNotFound, got %v", err)
+	}
+	if err := s.RemoveOrgMember(other, org.ID, ownerID); !errors.Is(err, storage.ErrOrgNotFound) {
+		t.Fatalf("expected ErrOrgNotFound, got %v", err)
+	}
+
+	// users of the default tenant can not own organizations of another one
+	if _, err := s.CreateOrganization(other, domain.Organization{Name: "other"}, domain.Member{UserID: ownerID, Role: domain.OrgRoleOwner}); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+// SaveOTPFactor stores a pending factor of the user, it replaces a pending
+// one but not a confirmed one.
+func (s *Storage) SaveOTPFactor(ctx context.Context, factor domain.OTPFactor) error {
+	const op = "postgresql.SaveOTPFactor"
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO otp_factors (user_id, secret)
+		SELECT id, $2 FROM users WHERE id = $1 AND tenant_id = $3
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			last_step = 0,
+			created_at = now()
+		WHERE otp_factors.confirmed_at IS NULL`,
+		factor.UserID, factor.Secret, tenant.ID(ctx),
+	)
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23503" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// nothing is inserted for users of another tenant either
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)", factor.UserID, tenant.ID(ctx),
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if !exists {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, storage.ErrOTPFactorExists)
+	}
+
+	return nil
+}
+
+// OTPFactor returns the factor of the user, pending or confirmed.
+func (s *Storage) OTPFactor(ctx context.Context, userID int64) (domain.OTPFactor, error) {
+	const op = "postgresql.OTPFactor"
+
+	factor := domain.OTPFactor{UserID: userID}
+	var confirmed sql.NullTime
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT f.secret, f.confirmed_at, f.last_step FROM otp_factors f
+		JOIN users u ON u.id = f.user_id WHERE f.user_id = $1 AND u.tenant_id = $2`, userID, tenant.ID(ctx),
+	).Scan(&factor.Secret, &confirmed, &factor.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.OTPFactor{}, fmt.Errorf("%s: %w", op, storage.ErrOTPFactorNotFound)
+		}
+		return domain.OTPFactor{}, fmt.Errorf("%s: %w", op, err)
+	}
+	factor.ConfirmedAt = confirmed.Time
+
+	return factor, nil
+}
+
+// UseOTPStep records that the code of step was accepted and confirms a
+// pending factor. Steps up to the last accepted one are rejected, so that a
+// code can not be replayed even by concurrent calls.
+func (s *Storage) UseOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "postgresql.UseOTPStep"
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE otp_factors f SET last_step = $2, confirmed_at = coalesce(f.confirmed_at, now())
+		FROM users u
+		WHERE f.user_id = $1 AND f.last_step < $2 AND u.id = f.user_id AND u.tenant_id = $3`,
+		userID, step, tenant.ID(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrOTPCodeUsed)
+	}
+
+	return nil
+}
+
+// DeleteOTPFactor removes the factor of the user, pending or confirmed, and
+// revokes the tokens of the user in the same transaction.
+func (s *Storage) DeleteOTPFactor(ctx context.Context, userID int64) error {
+	const op = "postgresql.DeleteOTPFactor"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)", userID, tenant.ID(ctx),
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM otp_factors WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrOTPFactorNotFound)
+	}
+
+	// sessions stepped up with the factor must not outlive it
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET tokens_invalid_before = date_trunc('second', now()) WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package postgresql
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/lib/pq"
+)
+
+// saveOutboxEvent writes event within tx, it fills in the id, the time and
+// the tenant of ctx.
+func saveOutboxEvent(ctx context.Context, tx execer, event domain.Event) error {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+	if event.TenantID == 0 {
+		event.TenantID = tenant.ID(ctx)
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox (event_id, event_type, user_id, app_id, payload, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.ID, event.Type, event.UserID, nullInt(event.AppID), payload, event.TenantID,
+	)
+
+	return err
+}
+
+// ClaimOutboxEvents leases up to limit unpublished events for lease, other
+// relays skip them until the lease runs out. The events of every tenant are
+// claimed, each carries its tenant.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]domain.OutboxEvent, error) {
+	const op = "postgresql.ClaimOutboxEvents"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE outbox SET locked_until = now() + $2 * interval '1 millisecond'
+		WHERE seq IN (
+			SELECT seq FROM outbox
+			WHERE published_at IS NULL AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY seq
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING seq, payload, attempts`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []domain.OutboxEvent
+	for rows.Next() {
+		var e domain.OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&e.Seq, &payload, &e.Attempts); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := json.Unmarshal(payload, &e.Event); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// UPDATE ... RETURNING does not keep the order of the subquery
+	slices.SortFunc(events, func(a, b domain.OutboxEvent) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	return events, nil
+}
+
+func (s *Storage) MarkOutboxPublished(ctx context.Context, seq int64) error {
+	const op = "postgresql.MarkOutboxPublished"
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET published_at = now(), locked_until = NULL WHERE seq = $1", seq)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkOutboxFailed records a failed delivery, the event is retried once
+// retryAt has passed.
+func (s *Storage) MarkOutboxFailed(ctx context.Context, seq int64, reason string, retryAt time.Time) error {
+	const op = "postgresql.MarkOutboxFailed"
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET attempts = attempts + 1, last_error = $2, locked_until = $3 WHERE seq = $1",
+		seq, reason, retryAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeletePublishedOutboxBefore deletes the events published before before.
+// The newest deleted event of every tenant is remembered, see
+// OutboxPruned.
+func (s *Storage) DeletePublishedOutboxBefore(ctx context.Context, before time.Time) (int64, error) {
+	const op = "postgresql.DeletePublishedOutboxBefore"
+
+	var n int64
+	err := s.db.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < $1
+			RETURNING tenant_id, xid, seq
+		), marked AS (
+			INSERT INTO outbox_pruned (tenant_id, xid, seq)
+			SELECT DISTINCT ON (tenant_id) tenant_id, xid, seq FROM deleted
+			ORDER BY tenant_id, xid DESC, seq DESC
+			ON CONFLICT (tenant_id) DO UPDATE SET xid = EXCLUDED.xid, seq = EXCLUDED.seq
+			WHERE (outbox_pruned.xid, outbox_pruned.seq) < (EXCLUDED.xid, EXCLUDED.seq)
+		)
+		SELECT count(*) FROM deleted`, before).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// OutboxEvents returns events of the tenant matching filter, published or
+// not, in stream order. Only events of transactions older than every
+// running one are returned, no event can show up before them later.
+func (s *Storage) OutboxEvents(ctx context.Context, filter domain.EventFilter) ([]domain.OutboxEvent, error) {
+	const op = "postgresql.OutboxEvents"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT seq, xid::text, payload, attempts FROM outbox
+		WHERE tenant_id = $6
+			AND (xid, seq) > ($1::text::xid8, $2)
+			AND xid < pg_snapshot_xmin(pg_current_snapshot())
+			AND (cardinality($3::text[]) = 0 OR event_type = ANY($3))
+			AND ($4 = 0 OR app_id = $4)
+		ORDER BY xid, seq
+		LIMIT $5`,
+		// a nil slice would be sent as NULL and match nothing
+		strconv.FormatUint(filter.After.XID, 10), filter.After.Seq, pq.Array(append([]string{}, filter.Types...)),
+		filter.AppID, filter.Limit, tenant.ID(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []domain.OutboxEvent
+	for rows.Next() {
+		var e domain.OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&e.Seq, &e.Position.XID, &payload, &e.Attempts); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := json.Unmarshal(payload, &e.Event); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		e.Position.Seq = e.Seq
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// OutboxHead returns the position of the newest event of the tenant that
+// OutboxEvents could return, or of the newest pruned one when it is newer.
+func (s *Storage) OutboxHead(ctx context.Context) (domain.EventPosition, error) {
+	const op = "postgresql.OutboxHead"
+
+	var head domain.EventPosition
+	err := s.db.QueryRowContext(ctx, `
+		SELECT xid::text, seq FROM (
+			SELECT xid, seq FROM outbox
+			WHERE tenant_id = $1 AND xid < pg_snapshot_xmin(pg_current_snapshot())
+			UNION ALL
+			SELECT xid, seq FROM outbox_pruned WHERE tenant_id = $1
+		) p
+		ORDER BY xid DESC, seq DESC
+		LIMIT 1`, tenant.ID(ctx)).Scan(&head.XID, &head.Seq)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return domain.EventPosition{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return head, nil
+}
+
+// OutboxPruned returns the position of the newest event of the tenant that
+// retention deleted, zero when none was.
+func (s *Storage) OutboxPruned(ctx context.Context) (domain.EventPosition, error) {
+	const op = "postgresql.OutboxPruned"
+
+	var pruned domain.EventPosition
+	err := s.db.QueryRowContext(ctx,
+		"SELECT xid::text, seq FROM outbox_pruned WHERE tenant_id = $1", tenant.ID(ctx),
+	).Scan(&pruned.XID, &pruned.Seq)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return domain.EventPosition{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pruned, nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func newEventID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	// UUID version 4
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b[:])
+
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
@@ -0,0 +1,82 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+func TestOutboxEventsStream(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	seedUser(t, s, "first@example.com")
+	seedUser(t, s, "second@example.com")
+
+	// no types selects every event
+	events, err := s.OutboxEvents(ctx, domain.EventFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].Position.Before(events[1].Position) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	head, err := s.OutboxHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != events[1].Position {
+		t.Fatalf("head = %+v, want %+v", head, events[1].Position)
+	}
+
+	rest, err := s.OutboxEvents(ctx, domain.EventFilter{After: events[0].Position, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].Seq != events[1].Seq {
+		t.Fatalf("unexpected events after the first %+v", rest)
+	}
+
+	filtered, err := s.OutboxEvents(ctx, domain.EventFilter{Types: []string{domain.EventUserDeleted}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 0 {
+		t.Fatalf("unexpected filtered events %+v", filtered)
+	}
+}
+
+func TestOutboxPruned(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	seedUser(t, s, "first@example.com")
+	seedUser(t, s, "second@example.com")
+
+	events, err := s.OutboxEvents(ctx, domain.EventFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkOutboxPublished(ctx, events[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.DeletePublishedOutboxBefore(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d events", n)
+	}
+
+	pruned, err := s.OutboxPruned(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != events[0].Position {
+		t.Fatalf("pruned = %+v, want %+v", pruned, events[0].Position)
+	}
+}
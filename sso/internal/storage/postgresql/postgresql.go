@@ -3,15 +3,23 @@ package postgresql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/goggle-source/grpc-servic/sso/internal/config"
 	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
 	"github.com/goggle-source/grpc-servic/sso/internal/storage"
 	"github.com/lib/pq"
 )
 
+// Storage restricts the queries made for a request to the tenant of its
+// context, see package tenant. Users, apps and what belongs to them are not
+// visible from another tenant. Claims of background work and the cleanup of
+// expired rows span every tenant, they say so in their comment.
 type Storage struct {
 	db *sql.DB
 }
@@ -31,16 +39,21 @@ func New(cfg config.Config) (*Storage, error) {
 
 }
 
-func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byte) (int64, error) {
+// SaveUser creates the user and puts event, completed with the new id, into
+// the outbox in the same transaction.
+func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byte, event domain.Event) (int64, error) {
 	const op = "postgresql.SaveUser"
 
-	stmt, err := s.db.Prepare("INSERT INTO users(email, pass_hash) VALUES($1, $2) RETURNING id")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	defer tx.Rollback()
 
 	var id int64
-	err = stmt.QueryRowContext(ctx, email, passwordHash).Scan(&id)
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO users(email, pass_hash, tenant_id) VALUES($1, $2, $3) RETURNING id",
+		email, passwordHash, tenant.ID(ctx)).Scan(&id)
 	if err != nil {
 		var psqErr *pq.Error
 		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
@@ -48,7 +61,15 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byt
 		}
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	defer stmt.Close()
+
+	event.UserID = id
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
 
 	return id, nil
 }
@@ -56,16 +77,33 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byt
 func (s *Storage) User(ctx context.Context, email string) (domain.User, error) {
 	const op = "postgresql.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = $1")
+	stmt, err := s.db.Prepare("SELECT " + userColumns + " FROM users WHERE email = $1 AND tenant_id = $2")
 	if err != nil {
 		return domain.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 	defer stmt.Close()
 
-	res := stmt.QueryRowContext(ctx, email)
+	user, err := scanUser(stmt.QueryRowContext(ctx, email, tenant.ID(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
 
-	var user domain.User
-	err = res.Scan(&user.ID, &user.Email, &user.PasswordHash)
+func (s *Storage) UserByID(ctx context.Context, userID int64) (domain.User, error) {
+	const op = "postgresql.UserByID"
+
+	stmt, err := s.db.Prepare("SELECT " + userColumns + " FROM users WHERE id = $1 AND tenant_id = $2")
+	if err != nil {
+		return domain.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	user, err := scanUser(stmt.QueryRowContext(ctx, userID, tenant.ID(ctx)))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return domain.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -76,22 +114,116 @@ func (s *Storage) User(ctx context.Context, email string) (domain.User, error) {
 	return user, nil
 }
 
+const userColumns = "id, tenant_id, email, pass_hash, password_changed_at, status, created_at, tokens_invalid_before, directory"
+
+func scanUser(row rowScanner) (domain.User, error) {
+	var user domain.User
+
+	err := row.Scan(&user.ID, &user.TenantID, &user.Email, &user.PasswordHash, &user.PasswordChangedAt,
+		&user.Status, &user.CreatedAt, &user.TokensInvalidBefore, &user.Directory)
+
+	return user, err
+}
+
+// PasswordHistory returns up to limit previous password hashes of the user,
+// newest first.
+func (s *Storage) PasswordHistory(ctx context.Context, userID int64, limit int) ([][]byte, error) {
+	const op = "postgresql.PasswordHistory"
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT h.pass_hash FROM password_history h JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $3 ORDER BY h.created_at DESC, h.id DESC LIMIT $2`,
+		userID, limit, tenant.ID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hashes, nil
+}
+
+// UpdatePassword replaces the password hash and moves the old one to the
+// history, keeping at most keep entries there. event goes into the outbox in
+// the same transaction.
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, oldHash []byte, newHash []byte, keep int, event domain.Event) error {
+	const op = "postgresql.UpdatePassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET pass_hash = $1, password_changed_at = now() WHERE id = $2 AND tenant_id = $3",
+		newHash, userID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if keep > 0 {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO password_history (user_id, pass_hash) VALUES ($1, $2)",
+			userID, oldHash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+		)`, userID, keep)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = userID
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
 func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "postgresql.IsAdmin"
 
-	stmt, err := s.db.Prepare("SELECT admin FROM is_admin WHERE id = $1")
+	stmt, err := s.db.Prepare("SELECT a.admin FROM is_admin a JOIN users u ON u.id = a.user_id WHERE a.user_id = $1 AND u.tenant_id = $2")
 	if err != nil {
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 	defer stmt.Close()
 
-	res := stmt.QueryRowContext(ctx, userID)
+	res := stmt.QueryRowContext(ctx, userID, tenant.ID(ctx))
 
 	var isAdmin bool
 	err = res.Scan(&isAdmin)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
@@ -102,15 +234,19 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 func (s *Storage) App(ctx context.Context, appID int64) (domain.App, error) {
 	const op = "postgresql.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = $1")
+	stmt, err := s.db.Prepare(`
+		SELECT id, tenant_id, name, secret, attribute_schema, claim_attributes, min_acr, org_scoped, callback_urls FROM apps
+		WHERE id = $1 AND tenant_id = $2`)
 	if err != nil {
 		return domain.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 	defer stmt.Close()
 
 	var result domain.App
-	res := stmt.QueryRowContext(ctx, appID)
-	err = res.Scan(&result.ID, &result.Name, &result.Secret)
+	var schema []byte
+	res := stmt.QueryRowContext(ctx, appID, tenant.ID(ctx))
+	err = res.Scan(&result.ID, &result.TenantID, &result.Name, &result.Secret, &schema, pq.Array(&result.ClaimAttributes), &result.MinACR, &result.OrgScoped,
+		pq.Array(&result.CallbackURLs))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return domain.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -118,5 +254,175 @@ func (s *Storage) App(ctx context.Context, appID int64) (domain.App, error) {
 		return domain.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	if schema != nil {
+		if err := json.Unmarshal(schema, &result.AttributeSchema); err != nil {
+			return domain.App{}, fmt.Errorf("%s: attribute schema: %w", op, err)
+		}
+	}
+
 	return result, nil
 }
+
+// UpdateApp changes the fields of update that are set.
+func (s *Storage) UpdateApp(ctx context.Context, appID int64, update domain.AppUpdate) error {
+	const op = "postgresql.UpdateApp"
+
+	var callbackURLs any
+	if update.CallbackURLs != nil {
+		// an empty list clears the urls, it must not read as NULL
+		callbackURLs = pq.Array(append([]string{}, *update.CallbackURLs...))
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE apps SET min_acr = COALESCE($3, min_acr), callback_urls = COALESCE($4, callback_urls)
+		WHERE id = $1 AND tenant_id = $2`,
+		appID, tenant.ID(ctx), update.MinACR, callbackURLs,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) IncrementLoginFailures(ctx context.Context, key string, window time.Duration) (int, int, error) {
+	const op = "postgresql.IncrementLoginFailures"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO login_failures (key, failures, updated_at) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_failures.updated_at < now() - make_interval(secs => $2) THEN 1
+				ELSE login_failures.failures + 1
+			END,
+			updated_at = now()
+		RETURNING failures, lockouts`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var failures, lockouts int
+	err = stmt.QueryRowContext(ctx, tenantKey(ctx, key), window.Seconds()).Scan(&failures, &lockouts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, lockouts, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "postgresql.LockLogin"
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE login_failures SET locked_until = $2, lockouts = lockouts + 1, failures = 0 WHERE key = $1",
+		tenantKey(ctx, key), until)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginLockedUntil returns the latest lock of the keys, the zero time when
+// none of them is locked.
+func (s *Storage) LoginLockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	const op = "postgresql.LoginLockedUntil"
+
+	scoped := make([]string, len(keys))
+	for i, key := range keys {
+		scoped[i] = tenantKey(ctx, key)
+	}
+
+	var until sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT max(locked_until) FROM login_failures WHERE key = ANY($1)",
+		pq.Array(scoped)).Scan(&until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return until.Time, nil
+}
+
+func (s *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	const op = "postgresql.ResetLoginFailures"
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", tenantKey(ctx, key))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteStaleLoginFailures removes the keys of every tenant that last failed
+// before before and are not locked since lockedBefore.
+func (s *Storage) DeleteStaleLoginFailures(ctx context.Context, before time.Time, lockedBefore time.Time) (int64, error) {
+	const op = "postgresql.DeleteStaleLoginFailures"
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_failures
+		WHERE updated_at < $1 AND (locked_until IS NULL OR locked_until < $2)`,
+		before, lockedBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// tenantKey keeps the failures of the same email in different tenants
+// apart.
+func tenantKey(ctx context.Context, key string) string {
+	return strconv.FormatInt(tenant.ID(ctx), 10) + ":" + key
+}
+
+// TenantBySlug returns the tenant with the slug.
+func (s *Storage) TenantBySlug(ctx context.Context, slug string) (domain.Tenant, error) {
+	const op = "postgresql.TenantBySlug"
+
+	var t domain.Tenant
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, slug, name, created_at FROM tenants WHERE slug = $1", slug,
+	).Scan(&t.ID, &t.Slug, &t.Name, &t.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Tenant{}, fmt.Errorf("%s: %w", op, storage.ErrTenantNotFound)
+		}
+		return domain.Tenant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return t, nil
+}
+
+// AppTenant returns the tenant of the app. It resolves the tenant of a
+// request and is therefore not restricted to one.
+func (s *Storage) AppTenant(ctx context.Context, appID int64) (int64, error) {
+	const op = "postgresql.AppTenant"
+
+	var tenantID int64
+	err := s.db.QueryRowContext(ctx, "SELECT tenant_id FROM apps WHERE id = $1", appID).Scan(&tenantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tenantID, nil
+}
@@ -0,0 +1,322 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// testDatabaseEnv names the database the storage tests run against, they
+// are skipped when it is not set. Every test migrates a schema of its own.
+const testDatabaseEnv = "SSO_TEST_DATABASE_URL"
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skip(testDatabaseEnv + " is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://../../../migrations", "postgres", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Storage{db: db}
+}
+
+// withSearchPath points the connections of dsn to schema.
+func withSearchPath(t *testing.T, dsn string, schema string) string {
+	t.Helper()
+
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// seedUser creates a user of the default tenant.
+func seedUser(t *testing.T, s *Storage, email string) int64 {
+	t.Helper()
+
+	id, err := s.SaveUser(context.Background(), email, []byte("hash"), domain.Event{Type: domain.EventUserRegistered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// seedApp creates an app of the default tenant.
+func seedApp(t *testing.T, s *Storage, name string) int64 {
+	t.Helper()
+
+	var id int64
+	err := s.db.QueryRow("INSERT INTO apps (name, secret) VALUES ($1, $2) RETURNING id", name, name+"_secret").Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func TestUpdateApp(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	appID := seedApp(t, s, "app")
+
+	minACR := domain.ACRMultiFactor
+	if err := s.UpdateApp(ctx, appID, domain.AppUpdate{MinACR: &minACR}); err != nil {
+		t.Fatal(err)
+	}
+
+	// unset fields are kept
+	if err := s.UpdateApp(ctx, appID, domain.AppUpdate{}); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := s.App(ctx, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.MinACR != domain.ACRMultiFactor {
+		t.Fatalf("min acr = %d, want %d", app.MinACR, domain.ACRMultiFactor)
+	}
+
+	urls := []string{"https://app.example.com/callback"}
+	if err := s.UpdateApp(ctx, appID, domain.AppUpdate{CallbackURLs: &urls}); err != nil {
+		t.Fatal(err)
+	}
+	app, err = s.App(ctx, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.CallbackURLs) != 1 || app.CallbackURLs[0] != urls[0] {
+		t.Fatalf("callback urls = %v, want %v", app.CallbackURLs, urls)
+	}
+	if app.MinACR != domain.ACRMultiFactor {
+		t.Fatalf("min acr = %d, want %d", app.MinACR, domain.ACRMultiFactor)
+	}
+
+	// an empty list clears them
+	if err := s.UpdateApp(ctx, appID, domain.AppUpdate{CallbackURLs: &[]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	app, err = s.App(ctx, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.CallbackURLs) != 0 {
+		t.Fatalf("callback urls = %v, want none", app.CallbackURLs)
+	}
+
+	if _, err := s.db.Exec("INSERT INTO tenants (id, slug, name) VALUES (2, 'other', 'Other')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateApp(tenant.With(ctx, 2), appID, domain.AppUpdate{MinACR: &minACR}); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got %v", err)
+	}
+}
+
+func TestUpdateUserDirectory(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	user, err := s.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Directory {
+		t.Fatal("new user is a directory user")
+	}
+
+	directory := true
+	if err := s.UpdateUser(ctx, userID, domain.UserUpdate{Directory: &directory}, domain.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = s.User(ctx, "jonn@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Directory {
+		t.Fatal("user is not marked as a directory user")
+	}
+}
+
+func TestUpdateUserAdmin(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	for _, admin := range []bool{true, true, false} {
+		if err := s.UpdateUser(ctx, userID, domain.UserUpdate{IsAdmin: &admin}, domain.Event{}); err != nil {
+			t.Fatal(err)
+		}
+
+		isAdmin, err := s.IsAdmin(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAdmin != admin {
+			t.Fatalf("is admin = %v, want %v", isAdmin, admin)
+		}
+	}
+
+	var rows int
+	if err := s.db.QueryRow("SELECT count(*) FROM is_admin WHERE user_id = $1", userID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("expected 1 is_admin row, got %d", rows)
+	}
+
+	if err := s.DeleteUser(ctx, userID, domain.Event{Type: domain.EventUserDeleted}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.IsAdmin(ctx, userID); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestDeleteStaleLoginFailures(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"account:recent", "account:stale", "account:locked"} {
+		if _, _, err := s.IncrementLoginFailures(ctx, key, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.LockLogin(ctx, "account:locked", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("UPDATE login_failures SET updated_at = now() - interval '1 hour' WHERE key <> $1", tenantKey(ctx, "account:recent")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.DeleteStaleLoginFailures(ctx, time.Now().Add(-15*time.Minute), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d keys, want 1", n)
+	}
+
+	// the lockouts of the locked key are still counted
+	_, lockouts, err := s.IncrementLoginFailures(ctx, "account:locked", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockouts != 1 {
+		t.Fatalf("lockouts = %d, want 1", lockouts)
+	}
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	email := "jonn.new@gmail.com"
+	if err := s.UpdateUser(ctx, userID, domain.UserUpdate{Email: &email}, domain.Event{Type: domain.EventEmailChanged}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.OutboxEvents(ctx, domain.EventFilter{Types: []string{domain.EventEmailChanged}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event.UserID != userID || events[0].Event.Email != email {
+		t.Fatalf("unexpected outbox events %+v", events)
+	}
+}
+
+func TestDeleteOTPFactor(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	if err := s.DeleteOTPFactor(ctx, userID); !errors.Is(err, storage.ErrOTPFactorNotFound) {
+		t.Fatalf("expected ErrOTPFactorNotFound, got %v", err)
+	}
+	if err := s.DeleteOTPFactor(ctx, userID+1); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := s.SaveOTPFactor(ctx, domain.OTPFactor{UserID: userID, Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteOTPFactor(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.OTPFactor(ctx, userID); !errors.Is(err, storage.ErrOTPFactorNotFound) {
+		t.Fatalf("expected ErrOTPFactorNotFound, got %v", err)
+	}
+	user, err := s.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TokensInvalidBefore.IsZero() {
+		t.Fatal("tokens of the user were not revoked")
+	}
+}
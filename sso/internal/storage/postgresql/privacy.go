@@ -0,0 +1,315 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+// ScheduleErasure stores the erasure and marks an active user pending
+// erasure right away, the access tokens of the user are revoked. A disabled
+// user stays disabled.
+func (s *Storage) ScheduleErasure(ctx context.Context, erasure domain.Erasure) (domain.Erasure, error) {
+	const op = "postgresql.ScheduleErasure"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET status = CASE WHEN status = $4 THEN $2 ELSE status END, tokens_invalid_before = date_trunc('second', now())
+		WHERE id = $1 AND tenant_id = $3`,
+		erasure.UserID, domain.UserStatusPendingErasure, tenant.ID(ctx), domain.UserStatusActive)
+	if err != nil {
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	erasure.TenantID = tenant.ID(ctx)
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO erasures (user_id, requested_by, erase_after, tenant_id) VALUES ($1, $2, $3, $4)
+		RETURNING id, requested_at`,
+		erasure.UserID, erasure.RequestedBy, erasure.EraseAfter, erasure.TenantID,
+	).Scan(&erasure.ID, &erasure.RequestedAt)
+	if err != nil {
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+			return domain.Erasure{}, fmt.Errorf("%s: %w", op, storage.ErrErasureExists)
+		}
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return erasure, nil
+}
+
+// PendingErasure returns the erasure of the user that was not applied yet.
+func (s *Storage) PendingErasure(ctx context.Context, userID int64) (domain.Erasure, error) {
+	const op = "postgresql.PendingErasure"
+
+	var e domain.Erasure
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, requested_by, requested_at, erase_after, tenant_id FROM erasures
+		WHERE user_id = $1 AND tenant_id = $2 AND erased_at IS NULL`, userID, tenant.ID(ctx),
+	).Scan(&e.ID, &e.UserID, &e.RequestedBy, &e.RequestedAt, &e.EraseAfter, &e.TenantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Erasure{}, fmt.Errorf("%s: %w", op, storage.ErrErasureNotFound)
+		}
+		return domain.Erasure{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return e, nil
+}
+
+// Consents returns the apps the user granted access to, the first one first.
+func (s *Storage) Consents(ctx context.Context, userID int64) ([]domain.Consent, error) {
+	const op = "postgresql.Consents"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.app_id, a.name, c.granted_at
+		FROM user_consents c JOIN users u ON u.id = c.user_id JOIN apps a ON a.id = c.app_id
+		WHERE c.user_id = $1 AND u.tenant_id = $2 ORDER BY c.granted_at, c.app_id`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var consents []domain.Consent
+	for rows.Next() {
+		var c domain.Consent
+		if err := rows.Scan(&c.AppID, &c.AppName, &c.GrantedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		consents = append(consents, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return consents, nil
+}
+
+// CancelErasure drops the pending erasure and enables the user again unless
+// it was disabled in the meantime or before.
+func (s *Storage) CancelErasure(ctx context.Context, userID int64) error {
+	const op = "postgresql.CancelErasure"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// a running erasure holds the lease, it must not be cancelled halfway
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM erasures
+		WHERE user_id = $1 AND tenant_id = $2 AND erased_at IS NULL
+			AND (locked_until IS NULL OR locked_until < now())`, userID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrErasureNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET status = $2 WHERE id = $1 AND tenant_id = $3 AND status = $4",
+		userID, domain.UserStatusActive, tenant.ID(ctx), domain.UserStatusPendingErasure)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimDueErasures leases up to limit erasures whose grace period is over,
+// the erasures of every tenant are claimed.
+func (s *Storage) ClaimDueErasures(ctx context.Context, limit int, lease time.Duration) ([]domain.Erasure, error) {
+	const op = "postgresql.ClaimDueErasures"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE erasures SET locked_until = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM erasures
+			WHERE erased_at IS NULL AND erase_after <= now() AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY erase_after
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, requested_by, requested_at, erase_after, tenant_id`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var erasures []domain.Erasure
+	for rows.Next() {
+		var e domain.Erasure
+		if err := rows.Scan(&e.ID, &e.UserID, &e.RequestedBy, &e.RequestedAt, &e.EraseAfter, &e.TenantID); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		erasures = append(erasures, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return erasures, nil
+}
+
+// EraseUser removes the personal data of the user in one transaction:
+//   - rows that only exist for the user are deleted together with the user,
+//   - audit events keep their digest, their subject is replaced by the
+//     tombstone when it named the user or their email, the address and user
+//     agent are dropped,
+//   - the failed logins of the email are forgotten,
+//   - outbox events and webhook deliveries lose the email.
+//
+// event goes into the outbox, the erasure is marked as done. Only the data of
+// the tenant of the erasure is touched.
+func (s *Storage) EraseUser(ctx context.Context, erasure domain.Erasure, event domain.Event) error {
+	const op = "postgresql.EraseUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	tenantID := erasure.TenantID
+	if tenantID == 0 {
+		tenantID = tenant.ID(ctx)
+	}
+	ctx = tenant.With(ctx, tenantID)
+
+	subjects := []string{domain.UserSubject(erasure.UserID)}
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE", erasure.UserID, tenantID).Scan(&email)
+	switch {
+	case err == nil:
+		subjects = append(subjects, strings.ToLower(email), domain.AccountSubject(email))
+	case errors.Is(err, sql.ErrNoRows):
+		// deleted in the meantime, the audit log may still name the user
+	default:
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := erasePII(ctx, tx, tenantID, erasure, subjects); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmts := []string{
+		"UPDATE outbox SET payload = payload - 'email' WHERE user_id = $1 AND tenant_id = $2",
+		`UPDATE webhook_deliveries d SET payload = d.payload - 'email'
+		FROM webhooks w JOIN apps a ON a.id = w.app_id
+		WHERE w.id = d.webhook_id AND a.tenant_id = $2 AND d.payload->>'user_id' = $1::text`,
+		"DELETE FROM is_admin WHERE user_id = $1 AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)",
+		// profiles, password history, email changes, sessions, consents,
+		// the login history, org memberships and federated identities
+		// cascade
+		"DELETE FROM users WHERE id = $1 AND tenant_id = $2",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, erasure.UserID, tenantID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// pending invites and failed logins name the address, not the user
+	if email != "" {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM org_invites i USING organizations o
+			WHERE o.id = i.org_id AND o.tenant_id = $2 AND lower(i.email) = lower($1)`, email, tenantID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", tenantKey(ctx, domain.AccountSubject(email)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	event.UserID = erasure.UserID
+	event.Email = ""
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE erasures SET erased_at = now(), locked_until = NULL WHERE id = $1", erasure.ID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func erasePII(ctx context.Context, tx *sql.Tx, tenantID int64, erasure domain.Erasure, subjects []string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, subject, ip, user_agent FROM audit_events
+		WHERE tombstone_id IS NULL AND tenant_id = $3 AND (actor_id = $1 OR lower(subject) = ANY($2))
+		FOR UPDATE`, erasure.UserID, pq.Array(subjects), tenantID)
+	if err != nil {
+		return err
+	}
+
+	var events []domain.AuditEvent
+	for rows.Next() {
+		var e domain.AuditEvent
+		if err := rows.Scan(&e.ID, &e.Subject, &e.IP, &e.UserAgent); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		subject := e.Subject
+		for _, s := range subjects {
+			if strings.EqualFold(subject, s) {
+				subject = domain.ErasedSubject(erasure.ID)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE audit_events SET pii_digest = $2, subject = $3, ip = '', user_agent = '', tombstone_id = $4
+			WHERE id = $1`, e.ID, auditchain.PIIDigest(e), subject, erasure.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/auditchain"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+func TestCancelErasure(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	activeID := seedUser(t, s, "jonn@gmail.com")
+	disabledID := seedUser(t, s, "mary@gmail.com")
+	if err := s.SetUserStatus(ctx, disabledID, domain.UserStatusDisabled); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, userID := range []int64{activeID, disabledID} {
+		if _, err := s.ScheduleErasure(ctx, domain.Erasure{UserID: userID, EraseAfter: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.ScheduleErasure(ctx, domain.Erasure{UserID: activeID, EraseAfter: time.Now().Add(time.Hour)}); !errors.Is(err, storage.ErrErasureExists) {
+		t.Fatalf("expected ErrErasureExists, got %v", err)
+	}
+
+	statuses := map[int64]string{activeID: domain.UserStatusPendingErasure, disabledID: domain.UserStatusDisabled}
+	for userID, status := range statuses {
+		user, err := s.UserByID(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Status != status {
+			t.Fatalf("user %d is %s, want %s", userID, user.Status, status)
+		}
+	}
+
+	// cancelling gives back the status the erasure took, not more
+	statuses = map[int64]string{activeID: domain.UserStatusActive, disabledID: domain.UserStatusDisabled}
+	for userID, status := range statuses {
+		if err := s.CancelErasure(ctx, userID); err != nil {
+			t.Fatal(err)
+		}
+		user, err := s.UserByID(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Status != status {
+			t.Fatalf("user %d is %s after cancel, want %s", userID, user.Status, status)
+		}
+	}
+
+	if err := s.CancelErasure(ctx, activeID); !errors.Is(err, storage.ErrErasureNotFound) {
+		t.Fatalf("expected ErrErasureNotFound, got %v", err)
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+	appID := seedApp(t, s, "test")
+	seedSession(t, s, userID, appID, "refresh")
+
+	events := []domain.AuditEvent{
+		{Type: domain.AuditLogin, ActorID: userID, Subject: "jonn@gmail.com", IP: "10.0.0.1", Result: domain.AuditSuccess},
+		{Type: domain.AuditLogin, Subject: "mary@gmail.com", IP: "10.0.0.2", Result: domain.AuditFailure},
+	}
+	for _, e := range events {
+		if _, err := s.SaveAuditEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	erasure, err := s.ScheduleErasure(ctx, domain.Erasure{UserID: userID, RequestedBy: userID, EraseAfter: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := s.ClaimDueErasures(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != erasure.ID {
+		t.Fatalf("unexpected due erasures %+v", due)
+	}
+
+	// a claimed erasure is not cancelled halfway
+	if err := s.CancelErasure(ctx, userID); !errors.Is(err, storage.ErrErasureNotFound) {
+		t.Fatalf("expected ErrErasureNotFound, got %v", err)
+	}
+
+	if err := s.EraseUser(ctx, due[0], domain.Event{Type: domain.EventUserDeleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.UserByID(ctx, userID); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if consents, err := s.Consents(ctx, userID); err != nil || len(consents) != 0 {
+		t.Fatalf("consents left %+v, %v", consents, err)
+	}
+
+	stored, err := s.AuditEvents(ctx, domain.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range stored {
+		switch e.Subject {
+		case "mary@gmail.com":
+			if e.TombstoneID != 0 || e.IP != "10.0.0.2" {
+				t.Fatalf("event of another user erased %+v", e)
+			}
+		case domain.ErasedSubject(erasure.ID):
+			if e.TombstoneID != erasure.ID || e.IP != "" || e.PIIDigest == "" {
+				t.Fatalf("unexpected erased event %+v", e)
+			}
+		}
+		// the chain still verifies
+		if e.Hash != auditchain.Hash(e) {
+			t.Fatalf("event %d does not match its hash", e.ID)
+		}
+	}
+}
+
+func TestEraseLockedOutUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "Jonn@Gmail.com")
+
+	// the lockout keys the account by the lowercased email
+	key := domain.AccountSubject("jonn@gmail.com")
+	if _, _, err := s.IncrementLoginFailures(ctx, key, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LockLogin(ctx, key, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SaveAuditEvent(ctx, domain.AuditEvent{Type: domain.AuditLoginLockout, Subject: key, Result: domain.AuditSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	erasure, err := s.ScheduleErasure(ctx, domain.Erasure{UserID: userID, RequestedBy: userID, EraseAfter: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EraseUser(ctx, erasure, domain.Event{Type: domain.EventUserDeleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	var failures int
+	if err := s.db.QueryRow("SELECT count(*) FROM login_failures WHERE key ILIKE '%jonn@gmail.com%'").Scan(&failures); err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 {
+		t.Fatalf("%d login failures still name the email", failures)
+	}
+
+	stored, err := s.AuditEvents(ctx, domain.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range stored {
+		if strings.Contains(strings.ToLower(e.Subject), "jonn@gmail.com") {
+			t.Fatalf("audit event still names the email %+v", e)
+		}
+	}
+}
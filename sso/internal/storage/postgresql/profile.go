@@ -0,0 +1,103 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+// Profile returns an empty profile for users that never saved one.
+func (s *Storage) Profile(ctx context.Context, userID int64) (domain.Profile, error) {
+	const op = "postgresql.Profile"
+
+	row := s.db.QueryRowContext(ctx, `
+		SELECT u.id, COALESCE(p.display_name, ''), COALESCE(p.locale, ''), COALESCE(p.timezone, ''),
+			COALESCE(p.avatar_url, ''), COALESCE(p.attributes, '{}'), COALESCE(p.updated_at, u.created_at)
+		FROM users u LEFT JOIN profiles p ON p.user_id = u.id
+		WHERE u.id = $1 AND u.tenant_id = $2`, userID, tenant.ID(ctx))
+
+	profile, err := scanProfile(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return profile, nil
+}
+
+// UpdateProfile merges the attributes in the database so that concurrent
+// updates of different attributes do not overwrite each other.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, update domain.ProfileUpdate) (domain.Profile, error) {
+	const op = "postgresql.UpdateProfile"
+
+	set := map[string]any{}
+	// a nil slice would be sent as NULL and turn the attributes into NULL
+	remove := []string{}
+	for k, v := range update.Attributes {
+		if v == nil {
+			remove = append(remove, k)
+		} else {
+			set[k] = v
+		}
+	}
+
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO profiles (user_id, display_name, locale, timezone, avatar_url, attributes)
+		SELECT id, COALESCE($2, ''), COALESCE($3, ''), COALESCE($4, ''), COALESCE($5, ''), $6::jsonb - $7::text[]
+		FROM users WHERE id = $1 AND tenant_id = $8
+		ON CONFLICT (user_id) DO UPDATE SET
+			display_name = COALESCE($2, profiles.display_name),
+			locale = COALESCE($3, profiles.locale),
+			timezone = COALESCE($4, profiles.timezone),
+			avatar_url = COALESCE($5, profiles.avatar_url),
+			attributes = (profiles.attributes || $6::jsonb) - $7::text[],
+			updated_at = now()
+		RETURNING user_id, display_name, locale, timezone, avatar_url, attributes, updated_at`,
+		userID, update.DisplayName, update.Locale, update.Timezone, update.AvatarURL, setJSON, pq.Array(remove), tenant.ID(ctx),
+	)
+
+	profile, err := scanProfile(row)
+	if err != nil {
+		// no row is inserted for users of another tenant
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23503" {
+			return domain.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return domain.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return profile, nil
+}
+
+func scanProfile(row *sql.Row) (domain.Profile, error) {
+	var p domain.Profile
+	var attributes []byte
+
+	err := row.Scan(&p.UserID, &p.DisplayName, &p.Locale, &p.Timezone, &p.AvatarURL, &attributes, &p.UpdatedAt)
+	if err != nil {
+		return domain.Profile{}, err
+	}
+
+	if err := json.Unmarshal(attributes, &p.Attributes); err != nil {
+		return domain.Profile{}, err
+	}
+
+	return p, nil
+}
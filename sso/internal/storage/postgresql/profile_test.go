@@ -0,0 +1,70 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	name := "Jonn"
+	profile, err := s.UpdateProfile(ctx, userID, domain.ProfileUpdate{
+		DisplayName: &name,
+		Attributes:  map[string]any{"plan": "pro", "team": "core"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.DisplayName != name || len(profile.Attributes) != 2 {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+
+	// an update that removes nothing keeps the attributes
+	locale := "en"
+	profile, err = s.UpdateProfile(ctx, userID, domain.ProfileUpdate{Locale: &locale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Locale != locale || profile.DisplayName != name || profile.Attributes["plan"] != "pro" || profile.Attributes["team"] != "core" {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+
+	profile, err = s.UpdateProfile(ctx, userID, domain.ProfileUpdate{Attributes: map[string]any{"team": nil, "seats": float64(3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := profile.Attributes["team"]; ok || profile.Attributes["plan"] != "pro" || profile.Attributes["seats"] != float64(3) {
+		t.Fatalf("unexpected attributes %v", profile.Attributes)
+	}
+
+	stored, err := s.Profile(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Attributes) != 2 {
+		t.Fatalf("unexpected stored attributes %v", stored.Attributes)
+	}
+}
+
+func TestUpdateProfileOfNewUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+
+	// the first update inserts the profile, removing nothing
+	name := "Jonn"
+	profile, err := s.UpdateProfile(ctx, userID, domain.ProfileUpdate{DisplayName: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Attributes == nil || len(profile.Attributes) != 0 {
+		t.Fatalf("unexpected attributes %v", profile.Attributes)
+	}
+}
@@ -0,0 +1,79 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/ratelimit"
+)
+
+// RateLimitStore shares the token buckets between all instances.
+type RateLimitStore struct {
+	s *Storage
+}
+
+func (s *Storage) RateLimits() *RateLimitStore {
+	return &RateLimitStore{s: s}
+}
+
+func (r *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	const op = "postgresql.RateLimitStore.Take"
+
+	tx, err := r.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// make sure there is a row to lock, a new bucket starts full
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, now()) ON CONFLICT (key) DO NOTHING",
+		key, limit.Burst)
+	if err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var bucket ratelimit.Bucket
+	var now time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT tokens, updated_at, now() FROM rate_limits WHERE key = $1 FOR UPDATE",
+		key).Scan(&bucket.Tokens, &bucket.Updated, &now)
+	if err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	bucket, ok, retryAfter := limit.Take(bucket, now)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limits SET tokens = $2, updated_at = $3 WHERE key = $1",
+		key, bucket.Tokens, bucket.Updated)
+	if err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, retryAfter, nil
+}
+
+// Prune deletes the buckets not taken from for idle, across every tenant.
+func (r *RateLimitStore) Prune(ctx context.Context, idle time.Duration) (int64, error) {
+	const op = "postgresql.RateLimitStore.Prune"
+
+	res, err := r.s.db.ExecContext(ctx,
+		"DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)",
+		idle.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,42 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/ratelimit"
+)
+
+func TestRateLimitPrune(t *testing.T) {
+	s := newTestStorage(t)
+	store := s.RateLimits()
+	ctx := context.Background()
+
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+	for _, key := range []string{"idle", "busy"} {
+		if _, _, err := store.Take(ctx, key, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.db.Exec("UPDATE rate_limits SET updated_at = now() - interval '1 hour' WHERE key = 'idle'"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.Prune(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("pruned %d buckets", n)
+	}
+
+	// the busy bucket keeps its state, its token is gone
+	ok, _, err := store.Take(ctx, "busy", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("busy bucket was reset")
+	}
+}
@@ -0,0 +1,122 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+)
+
+// LoginHistory compares a login of the user from device, ip and ipRange with
+// the earlier ones. Other users count towards AccountsFromIP when they logged
+// in from ip after since, only users of the same tenant are counted.
+func (s *Storage) LoginHistory(ctx context.Context, userID int64, device string, ip string, ipRange string, since time.Time) (domain.LoginHistory, error) {
+	const op = "postgresql.LoginHistory"
+
+	var h domain.LoginHistory
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			count(*),
+			$2 <> '' AND coalesce(bool_or(h.device = $2), false),
+			$4 <> '' AND coalesce(bool_or(h.ip_range = $4), false),
+			(SELECT count(DISTINCT o.user_id) FROM login_history o JOIN users ou ON ou.id = o.user_id
+				WHERE o.ip = $3 AND o.ip <> '' AND o.user_id <> $1 AND o.created_at > $5 AND ou.tenant_id = $6)
+		FROM login_history h JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $6`,
+		userID, device, ip, ipRange, since, tenant.ID(ctx),
+	).Scan(&h.Logins, &h.KnownDevice, &h.KnownIPRange, &h.AccountsFromIP)
+	if err != nil {
+		return domain.LoginHistory{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if h.Logins == 0 {
+		return h, nil
+	}
+
+	var lat, lon sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT h.user_id, h.ip, h.ip_range, h.device, h.country, h.latitude, h.longitude, h.created_at
+		FROM login_history h JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $2 ORDER BY h.created_at DESC LIMIT 1`, userID, tenant.ID(ctx),
+	).Scan(&h.Last.UserID, &h.Last.IP, &h.Last.IPRange, &h.Last.Device, &h.Last.Country, &lat, &lon, &h.Last.CreatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return domain.LoginHistory{}, fmt.Errorf("%s: %w", op, err)
+	}
+	h.Last.Latitude, h.Last.Longitude, h.Last.HasLocation = lat.Float64, lon.Float64, lat.Valid && lon.Valid
+
+	return h, nil
+}
+
+func (s *Storage) SaveLoginRecord(ctx context.Context, record domain.LoginRecord) error {
+	const op = "postgresql.SaveLoginRecord"
+
+	var lat, lon sql.NullFloat64
+	if record.HasLocation {
+		lat = sql.NullFloat64{Float64: record.Latitude, Valid: true}
+		lon = sql.NullFloat64{Float64: record.Longitude, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_history (user_id, ip, ip_range, device, country, latitude, longitude)
+		SELECT id, $2, $3, $4, $5, $6, $7 FROM users WHERE id = $1 AND tenant_id = $8`,
+		record.UserID, record.IP, record.IPRange, record.Device, record.Country, lat, lon, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginRecords returns the kept logins of the user, the latest first.
+func (s *Storage) LoginRecords(ctx context.Context, userID int64) ([]domain.LoginRecord, error) {
+	const op = "postgresql.LoginRecords"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT h.user_id, h.ip, h.ip_range, h.device, h.country, h.latitude, h.longitude, h.created_at
+		FROM login_history h JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $2 ORDER BY h.created_at DESC`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var records []domain.LoginRecord
+	for rows.Next() {
+		var (
+			r        domain.LoginRecord
+			lat, lon sql.NullFloat64
+		)
+		if err := rows.Scan(&r.UserID, &r.IP, &r.IPRange, &r.Device, &r.Country, &lat, &lon, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		r.Latitude, r.Longitude, r.HasLocation = lat.Float64, lon.Float64, lat.Valid && lon.Valid
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return records, nil
+}
+
+// DeleteLoginRecordsBefore applies the retention to every tenant.
+func (s *Storage) DeleteLoginRecordsBefore(ctx context.Context, before time.Time) (int64, error) {
+	const op = "postgresql.DeleteLoginRecordsBefore"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM login_history WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,328 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+const sessionColumns = "s.id, s.user_id, s.app_id, s.device, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.expires_at, s.acr, s.amr, s.step_up_required"
+
+// activeSessions joins the sessions of the tenant $1 that are neither expired
+// nor revoked by users.tokens_invalid_before.
+const activeSessions = `
+	sessions s JOIN users u ON u.id = s.user_id
+	WHERE u.tenant_id = $1 AND s.expires_at > now() AND date_trunc('second', s.created_at) >= u.tokens_invalid_before`
+
+// CreateSession stores the session together with its first refresh token,
+// the first session of the user in the app records the consent to it.
+func (s *Storage) CreateSession(ctx context.Context, session domain.Session, refresh domain.RefreshToken) (domain.Session, error) {
+	const op = "postgresql.CreateSession"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO sessions (user_id, app_id, device, user_agent, ip, expires_at, acr, amr, step_up_required)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8, $9 FROM users WHERE id = $1 AND tenant_id = $10
+		RETURNING id, created_at, last_seen_at`,
+		session.UserID, session.AppID, session.Device, session.UserAgent, session.IP, refresh.ExpiresAt,
+		session.ACR, pq.Array(session.AMR), session.StepUpRequired, tenant.ID(ctx),
+	).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	session.ExpiresAt = refresh.ExpiresAt
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)",
+		refresh.Hash, session.ID, refresh.ExpiresAt); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO user_consents (user_id, app_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		session.UserID, session.AppID); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// RefreshTokenSession returns the session of the unexpired refresh token
+// with hash, used tokens included so that reuse is still detected by
+// RotateRefreshToken.
+func (s *Storage) RefreshTokenSession(ctx context.Context, hash []byte) (domain.Session, error) {
+	const op = "postgresql.RefreshTokenSession"
+
+	session, err := scanSession(s.db.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+` FROM refresh_tokens r
+		JOIN sessions s ON s.id = r.session_id JOIN users u ON u.id = s.user_id
+		WHERE r.token_hash = $1 AND r.expires_at > now() AND u.tenant_id = $2`, hash, tenant.ID(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenInvalid)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// RotateRefreshToken uses up the refresh token with oldHash and stores next
+// for the same session, the session is extended and seen from the client of
+// next. A token that was already used revokes its session, whoever presents
+// it has a copy of a token that was refreshed before.
+func (s *Storage) RotateRefreshToken(ctx context.Context, oldHash []byte, next domain.RefreshToken) (domain.Session, error) {
+	const op = "postgresql.RotateRefreshToken"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		sessionID int64
+		used      sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT r.session_id, r.used_at FROM refresh_tokens r
+		JOIN sessions s ON s.id = r.session_id JOIN users u ON u.id = s.user_id
+		WHERE r.token_hash = $1 AND r.expires_at > now() AND u.tenant_id = $2
+		FOR UPDATE OF r`, oldHash, tenant.ID(ctx),
+	).Scan(&sessionID, &used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenInvalid)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if used.Valid {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", sessionID); err != nil {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenReused)
+	}
+
+	session, err := scanSession(tx.QueryRowContext(ctx, `
+		UPDATE sessions s SET last_seen_at = now(), ip = $2, user_agent = $3, expires_at = $4
+		FROM users u
+		WHERE s.id = $1 AND u.id = s.user_id AND date_trunc('second', s.created_at) >= u.tokens_invalid_before
+		RETURNING `+sessionColumns,
+		sessionID, next.IP, next.UserAgent, next.ExpiresAt))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenInvalid)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1", oldHash); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)",
+		next.Hash, sessionID, next.ExpiresAt); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// Session returns an active session.
+func (s *Storage) Session(ctx context.Context, sessionID int64) (domain.Session, error) {
+	const op = "postgresql.Session"
+
+	session, err := scanSession(s.db.QueryRowContext(ctx,
+		"SELECT "+sessionColumns+" FROM "+activeSessions+" AND s.id = $2", tenant.ID(ctx), sessionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// Sessions returns the active sessions of the user, the last seen first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]domain.Session, error) {
+	const op = "postgresql.Sessions"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+sessionColumns+" FROM "+activeSessions+" AND s.user_id = $2 ORDER BY s.last_seen_at DESC", tenant.ID(ctx), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []domain.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// StepUpSession records that the session was confirmed with method. The
+// level is raised to acr unless it is higher already, the step-up flag is
+// cleared.
+func (s *Storage) StepUpSession(ctx context.Context, sessionID int64, acr int, method string) (domain.Session, error) {
+	const op = "postgresql.StepUpSession"
+
+	session, err := scanSession(s.db.QueryRowContext(ctx, `
+		UPDATE sessions s SET
+			acr = greatest(s.acr, $2),
+			amr = CASE WHEN $3 = ANY(s.amr) THEN s.amr ELSE array_append(s.amr, $3) END,
+			step_up_required = false,
+			last_seen_at = now()
+		FROM users u
+		WHERE s.id = $1 AND u.id = s.user_id AND u.tenant_id = $4
+			AND s.expires_at > now() AND date_trunc('second', s.created_at) >= u.tokens_invalid_before
+		RETURNING `+sessionColumns,
+		sessionID, acr, method, tenant.ID(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return domain.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// TouchSession records that the session was used now.
+func (s *Storage) TouchSession(ctx context.Context, sessionID int64) error {
+	const op = "postgresql.TouchSession"
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sessions s SET last_seen_at = now() FROM users u
+		WHERE s.id = $1 AND u.id = s.user_id AND u.tenant_id = $2`, sessionID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteSession revokes the session, its refresh tokens cascade.
+func (s *Storage) DeleteSession(ctx context.Context, sessionID int64) error {
+	const op = "postgresql.DeleteSession"
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM sessions s USING users u
+		WHERE s.id = $1 AND u.id = s.user_id AND u.tenant_id = $2`, sessionID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+// RevokeSessions deletes every session of the user and revokes the access
+// tokens issued so far, also those that carry no session.
+func (s *Storage) RevokeSessions(ctx context.Context, userID int64) (int64, error) {
+	const op = "postgresql.RevokeSessions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET tokens_invalid_before = date_trunc('second', now()) WHERE id = $1 AND tenant_id = $2", userID, tenant.ID(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	res, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// DeleteExpiredSessions removes expired sessions and the expired refresh
+// tokens of the remaining ones, of every tenant.
+func (s *Storage) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	const op = "postgresql.DeleteExpiredSessions"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= now()")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at <= now()"); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (domain.Session, error) {
+	var session domain.Session
+
+	err := row.Scan(&session.ID, &session.UserID, &session.AppID, &session.Device, &session.UserAgent,
+		&session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.ACR, pq.Array(&session.AMR),
+		&session.StepUpRequired)
+
+	return session, err
+}
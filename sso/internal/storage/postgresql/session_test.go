@@ -0,0 +1,119 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+)
+
+// seedSession starts a session of the user in the app with the refresh token
+// hash.
+func seedSession(t *testing.T, s *Storage, userID int64, appID int64, hash string) domain.Session {
+	t.Helper()
+
+	session, err := s.CreateSession(context.Background(),
+		domain.Session{UserID: userID, AppID: appID, Device: "laptop", ACR: 1, AMR: []string{"pwd"}},
+		domain.RefreshToken{Hash: []byte(hash), ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+	appID := seedApp(t, s, "test")
+
+	first := seedSession(t, s, userID, appID, "first")
+	seedSession(t, s, userID, appID, "second")
+
+	sessions, err := s.Sessions(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	// the app is consented to once
+	consents, err := s.Consents(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consents) != 1 || consents[0].AppID != appID || consents[0].AppName != "test" || consents[0].GrantedAt.After(first.CreatedAt) {
+		t.Fatalf("unexpected consents %+v", consents)
+	}
+
+	if err := s.DeleteSession(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteSession(ctx, first.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	n, err := s.RevokeSessions(ctx, userID)
+	if err != nil || n != 1 {
+		t.Fatalf("revoked %d sessions, %v", n, err)
+	}
+
+	user, err := s.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TokensInvalidBefore.IsZero() || time.Since(user.TokensInvalidBefore) > time.Minute {
+		t.Fatalf("tokens not revoked: %s", user.TokensInvalidBefore)
+	}
+
+	// consents outlive the sessions
+	if consents, err := s.Consents(ctx, userID); err != nil || len(consents) != 1 {
+		t.Fatalf("unexpected consents %+v, %v", consents, err)
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID := seedUser(t, s, "jonn@gmail.com")
+	appID := seedApp(t, s, "test")
+	session := seedSession(t, s, userID, appID, "first")
+
+	found, err := s.RefreshTokenSession(ctx, []byte("first"))
+	if err != nil || found.ID != session.ID {
+		t.Fatalf("unexpected session %+v, %v", found, err)
+	}
+
+	next := domain.RefreshToken{Hash: []byte("second"), IP: "10.0.0.2", UserAgent: "phone", ExpiresAt: time.Now().Add(2 * time.Hour)}
+	rotated, err := s.RotateRefreshToken(ctx, []byte("first"), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID != session.ID || rotated.IP != "10.0.0.2" || !rotated.ExpiresAt.After(session.ExpiresAt) || rotated.ACR != 1 {
+		t.Fatalf("unexpected rotated session %+v", rotated)
+	}
+
+	// a used token still finds its session, presenting it again revokes it
+	if _, err := s.RefreshTokenSession(ctx, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, []byte("first"), domain.RefreshToken{Hash: []byte("third"), ExpiresAt: time.Now().Add(time.Hour)}); !errors.Is(err, storage.ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := s.Session(ctx, session.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Fatalf("expected the session to be revoked, got %v", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, []byte("second"), domain.RefreshToken{Hash: []byte("fourth"), ExpiresAt: time.Now().Add(time.Hour)}); !errors.Is(err, storage.ErrRefreshTokenInvalid) {
+		t.Fatalf("expected ErrRefreshTokenInvalid, got %v", err)
+	}
+	if _, err := s.RefreshTokenSession(ctx, []byte("unknown")); !errors.Is(err, storage.ErrRefreshTokenInvalid) {
+		t.Fatalf("expected ErrRefreshTokenInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+func (s *Storage) Users(ctx context.Context, filter domain.UserFilter) ([]domain.User, error) {
+	const op = "postgresql.Users"
+
+	where := []string{"tenant_id = $1", "id > $2"}
+	args := []any{tenant.ID(ctx), filter.AfterID}
+
+	if filter.Email != "" {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Email))+"%")
+		where = append(where, fmt.Sprintf("lower(email) LIKE $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, email, password_changed_at, status, created_at FROM users WHERE %s ORDER BY id LIMIT $%d",
+		strings.Join(where, " AND "), len(args),
+	), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		var u domain.User
+		if err := rows.Scan(&u.ID, &u.Email, &u.PasswordChangedAt, &u.Status, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UpdateUser changes the fields of update that are set. event goes into the
+// outbox in the same transaction when the email changes.
+func (s *Storage) UpdateUser(ctx context.Context, userID int64, update domain.UserUpdate, event domain.Event) error {
+	const op = "postgresql.UpdateUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)", userID, tenant.ID(ctx)).Scan(&exists); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if update.Email != nil {
+		// access tokens carry the email, the ones with the old address are revoked
+		_, err := tx.ExecContext(ctx, "UPDATE users SET email = $2, tokens_invalid_before = date_trunc('second', now()) WHERE id = $1", userID, *update.Email)
+		if err != nil {
+			var psqErr *pq.Error
+			if errors.As(err, &psqErr) && psqErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		event.UserID = userID
+		event.Email = *update.Email
+		if err := saveOutboxEvent(ctx, tx, event); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if update.IsAdmin != nil {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO is_admin (user_id, admin) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET admin = EXCLUDED.admin`,
+			userID, *update.IsAdmin)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if update.Directory != nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET directory = $2 WHERE id = $1", userID, *update.Directory); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetUserStatus(ctx context.Context, userID int64, status string) error {
+	const op = "postgresql.SetUserStatus"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET status = $2 WHERE id = $1 AND tenant_id = $3", userID, status, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user with everything that references it and puts
+// event into the outbox in the same transaction.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64, event domain.Event) error {
+	const op = "postgresql.DeleteUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE", userID, tenant.ID(ctx)).Scan(&email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM is_admin WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = userID
+	event.Email = email
+	if err := saveOutboxEvent(ctx, tx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+	"github.com/goggle-source/grpc-servic/sso/internal/lib/tenant"
+	"github.com/goggle-source/grpc-servic/sso/internal/storage"
+	"github.com/lib/pq"
+)
+
+func (s *Storage) SaveWebhook(ctx context.Context, webhook domain.Webhook) (int64, error) {
+	const op = "postgresql.SaveWebhook"
+
+	// a nil slice would be stored as NULL, no types selects every event
+	eventTypes := append([]string{}, webhook.EventTypes...)
+
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO webhooks (app_id, url, secret, event_types)
+		SELECT id, $2, $3, $4 FROM apps WHERE id = $1 AND tenant_id = $5 RETURNING id`,
+		webhook.AppID, webhook.URL, webhook.Secret, pq.Array(eventTypes), tenant.ID(ctx),
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		var psqErr *pq.Error
+		if errors.As(err, &psqErr) && psqErr.Code == "23503" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, webhookID int64) error {
+	const op = "postgresql.DeleteWebhook"
+
+	res, err := s.db.ExecContext(ctx,
+		"DELETE FROM webhooks w USING apps a WHERE w.id = $1 AND a.id = w.app_id AND a.tenant_id = $2",
+		webhookID, tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) Webhook(ctx context.Context, webhookID int64) (domain.Webhook, error) {
+	const op = "postgresql.Webhook"
+
+	var w domain.Webhook
+	err := s.db.QueryRowContext(ctx,
+		`SELECT w.id, w.app_id, w.url, w.secret, w.event_types, w.created_at FROM webhooks w
+		JOIN apps a ON a.id = w.app_id WHERE w.id = $1 AND a.tenant_id = $2`, webhookID, tenant.ID(ctx),
+	).Scan(&w.ID, &w.AppID, &w.URL, &w.Secret, pq.Array(&w.EventTypes), &w.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Webhook{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+		}
+		return domain.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return w, nil
+}
+
+func (s *Storage) AppWebhooks(ctx context.Context, appID int64) ([]domain.Webhook, error) {
+	const op = "postgresql.AppWebhooks"
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT w.id, w.app_id, w.url, w.secret, w.event_types, w.created_at FROM webhooks w
+		JOIN apps a ON a.id = w.app_id WHERE w.app_id = $1 AND a.tenant_id = $2 ORDER BY w.id`, appID, tenant.ID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		var w domain.Webhook
+		if err := rows.Scan(&w.ID, &w.AppID, &w.URL, &w.Secret, pq.Array(&w.EventTypes), &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return webhooks, nil
+}
+
+// SaveWebhookDeliveries queues deliveries, a delivery of the same event to
+// the same webhook is only queued once. Deliveries to webhooks of another
+// tenant are dropped.
+func (s *Storage) SaveWebhookDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	const op = "postgresql.SaveWebhookDeliveries"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+			SELECT w.id, $2, $3, $4 FROM webhooks w JOIN apps a ON a.id = w.app_id
+			WHERE w.id = $1 AND a.tenant_id = $5
+			ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+			d.WebhookID, d.EventID, d.EventType, d.Payload, tenant.ID(ctx),
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, COALESCE(delivered_at, 'epoch'),
+	(SELECT a.tenant_id FROM webhooks w JOIN apps a ON a.id = w.app_id WHERE w.id = webhook_deliveries.webhook_id)`
+
+// tenantWebhooks selects the webhooks of the tenant $%d.
+const tenantWebhooks = "SELECT w.id FROM webhooks w JOIN apps a ON a.id = w.app_id WHERE a.tenant_id = $%d"
+
+// ClaimWebhookDeliveries leases up to limit due deliveries for lease, the
+// deliveries of every tenant are claimed.
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	const op = "postgresql.ClaimWebhookDeliveries"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery stores the outcome of a delivery attempt, the
+// delivery was claimed before so it is not restricted to the tenant.
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, d domain.WebhookDelivery) error {
+	const op = "postgresql.UpdateWebhookDelivery"
+
+	var deliveredAt sql.NullTime
+	if !d.DeliveredAt.IsZero() {
+		deliveredAt = sql.NullTime{Time: d.DeliveredAt, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = $5, last_error = $6, delivered_at = $7
+		WHERE id = $1`,
+		d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, deliveredAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) WebhookDeliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	const op = "postgresql.WebhookDeliveries"
+
+	where := []string{"webhook_id = $1", fmt.Sprintf("webhook_id IN ("+tenantWebhooks+")", 2)}
+	args := []any{filter.WebhookID, tenant.ID(ctx)}
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if filter.AfterID != 0 {
+		args = append(args, filter.AfterID)
+		where = append(where, fmt.Sprintf("id < $%d", len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM webhook_deliveries WHERE %s ORDER BY id DESC LIMIT $%d",
+		deliveryColumns, strings.Join(where, " AND "), len(args),
+	), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+func scanDeliveries(rows *sql.Rows) ([]domain.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt, &d.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		if d.DeliveredAt.Equal(time.Unix(0, 0)) {
+			d.DeliveredAt = time.Time{}
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
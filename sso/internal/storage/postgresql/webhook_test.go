@@ -0,0 +1,28 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goggle-source/grpc-servic/sso/internal/domain"
+)
+
+func TestSaveWebhookWithoutEventTypes(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	appID := seedApp(t, s, "test")
+
+	id, err := s.SaveWebhook(ctx, domain.Webhook{AppID: appID, URL: "https://example.com/hook", Secret: "whsec_test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook, err := s.Webhook(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.EventTypes) != 0 || !webhook.Wants(domain.EventUserRegistered) {
+		t.Fatalf("unexpected event types %v", webhook.EventTypes)
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE apps
+    DROP COLUMN IF EXISTS attribute_schema,
+    DROP COLUMN IF EXISTS claim_attributes;
+
+DROP TABLE IF EXISTS profiles;
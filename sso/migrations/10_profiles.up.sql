@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS profiles
+(
+    user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    display_name TEXT NOT NULL DEFAULT '',
+    locale TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT '',
+    avatar_url TEXT NOT NULL DEFAULT '',
+    attributes JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS attribute_schema JSONB,
+    ADD COLUMN IF NOT EXISTS claim_attributes TEXT[] NOT NULL DEFAULT '{}';
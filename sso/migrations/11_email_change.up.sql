@@ -0,0 +1,12 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tokens_invalid_before TIMESTAMPTZ NOT NULL DEFAULT to_timestamp(0);
+
+CREATE TABLE IF NOT EXISTS email_changes
+(
+    user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    new_email TEXT NOT NULL,
+    app_id BIGINT NOT NULL DEFAULT 0,
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS erasures;
+
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS pii_digest,
+    DROP COLUMN IF EXISTS tombstone_id;
@@ -0,0 +1,19 @@
+-- erased audit events keep the digest of the removed personal data so that
+-- their hash still verifies, tombstone_id points at the erasure
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS pii_digest TEXT,
+    ADD COLUMN IF NOT EXISTS tombstone_id BIGINT;
+
+CREATE TABLE IF NOT EXISTS erasures
+(
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    requested_by BIGINT NOT NULL DEFAULT 0,
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    erase_after TIMESTAMPTZ NOT NULL,
+    erased_at TIMESTAMPTZ,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_erasures_pending ON erasures (user_id) WHERE erased_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_erasures_due ON erasures (erase_after) WHERE erased_at IS NULL;
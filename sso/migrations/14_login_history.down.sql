@@ -0,0 +1,4 @@
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS step_up_required;
+
+DROP TABLE IF EXISTS login_history;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS login_history
+(
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip TEXT NOT NULL DEFAULT '',
+    ip_range TEXT NOT NULL DEFAULT '',
+    device TEXT NOT NULL DEFAULT '',
+    country TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_login_history_user ON login_history (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_login_history_ip ON login_history (ip, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_history_created ON login_history (created_at);
+
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS step_up_required BOOLEAN NOT NULL DEFAULT false;
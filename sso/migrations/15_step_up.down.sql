@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS otp_factors;
+
+ALTER TABLE apps
+    DROP COLUMN IF EXISTS min_acr;
+
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS amr,
+    DROP COLUMN IF EXISTS acr;
@@ -0,0 +1,16 @@
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS acr SMALLINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS amr TEXT[] NOT NULL DEFAULT '{pwd}';
+
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS min_acr SMALLINT NOT NULL DEFAULT 0;
+
+-- One TOTP factor per user, confirmed_at is NULL until the first code.
+CREATE TABLE IF NOT EXISTS otp_factors
+(
+    user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
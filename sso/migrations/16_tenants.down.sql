@@ -0,0 +1,21 @@
+DELETE FROM login_failures WHERE key NOT LIKE '1:%';
+UPDATE login_failures SET key = substr(key, 3);
+
+ALTER TABLE erasures DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_outbox_tenant;
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_audit_events_tenant;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_apps_tenant_name;
+ALTER TABLE apps DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE apps ADD CONSTRAINT apps_name_key UNIQUE (name);
+
+DROP INDEX IF EXISTS idx_users_tenant_email;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
+
+DROP TABLE IF EXISTS tenants;
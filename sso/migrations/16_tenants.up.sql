@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id BIGSERIAL PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- everything created before tenants existed belongs to the default tenant
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('tenants', 'id'), (SELECT max(id) FROM tenants));
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+DROP INDEX IF EXISTS idx_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email);
+
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE apps DROP CONSTRAINT IF EXISTS apps_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_apps_tenant_name ON apps (tenant_id, name);
+
+-- the tenant is not part of the hash chain, the chain spans the deployment
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_audit_events_tenant ON audit_events (tenant_id, id);
+
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_outbox_tenant ON outbox (tenant_id, seq);
+
+-- the erasure outlives the user, it keeps the tenant for the worker
+ALTER TABLE erasures
+    ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 1;
+
+-- lockout keys are scoped by the tenant id
+UPDATE login_failures SET key = '1:' || key;
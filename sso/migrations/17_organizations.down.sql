@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS org_invites;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
+
+ALTER TABLE apps DROP COLUMN IF EXISTS org_scoped;
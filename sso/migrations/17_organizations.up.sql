@@ -0,0 +1,41 @@
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS org_scoped BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS organizations
+(
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+    name TEXT NOT NULL,
+    created_by BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_organizations_tenant ON organizations (tenant_id);
+
+CREATE TABLE IF NOT EXISTS org_members
+(
+    org_id BIGINT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    teams TEXT[] NOT NULL DEFAULT '{}',
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members (user_id);
+
+-- one pending invitation per address and organization, inviting again
+-- replaces it
+CREATE TABLE IF NOT EXISTS org_invites
+(
+    id BIGSERIAL PRIMARY KEY,
+    org_id BIGINT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL,
+    teams TEXT[] NOT NULL DEFAULT '{}',
+    invited_by BIGINT NOT NULL DEFAULT 0,
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (org_id, email)
+);
@@ -0,0 +1,15 @@
+-- subject is the sub claim of the upstream provider named by connector,
+-- connector names are unique within a tenant
+CREATE TABLE IF NOT EXISTS federated_identities
+(
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    connector TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, connector, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federated_identities_user ON federated_identities (user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_audit_checkpoints_event_id;
+
+ALTER TABLE audit_events DROP COLUMN IF EXISTS tenant_chain;
@@ -0,0 +1,6 @@
+-- events written from now on link to the previous event of their tenant,
+-- older ones to the previous event of any tenant
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS tenant_chain BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_event_id ON audit_checkpoints (event_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS outbox_pruned;
+
+DROP INDEX IF EXISTS idx_outbox_stream;
+ALTER TABLE outbox DROP COLUMN IF EXISTS xid;
@@ -0,0 +1,15 @@
+-- the stream pages by the transaction that wrote an event, events of
+-- transactions older than every running one can no longer be joined by
+-- earlier ones
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS xid xid8 NOT NULL DEFAULT pg_current_xact_id();
+CREATE INDEX IF NOT EXISTS idx_outbox_stream ON outbox (tenant_id, xid, seq);
+
+-- the newest event retention deleted, streams resuming before it missed
+-- events
+CREATE TABLE IF NOT EXISTS outbox_pruned
+(
+    tenant_id BIGINT PRIMARY KEY,
+    xid xid8 NOT NULL,
+    seq BIGINT NOT NULL
+);
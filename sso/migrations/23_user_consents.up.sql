@@ -0,0 +1,14 @@
+-- the apps a user granted access to, the first session of the user in an app
+-- grants it
+CREATE TABLE IF NOT EXISTS user_consents
+(
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, app_id)
+);
+
+INSERT INTO user_consents (user_id, app_id, granted_at)
+SELECT s.user_id, s.app_id, min(s.created_at) FROM sessions s JOIN apps a ON a.id = s.app_id
+GROUP BY s.user_id, s.app_id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE is_admin
+    DROP CONSTRAINT IF EXISTS is_admin_user_id_key,
+    ALTER COLUMN user_id DROP NOT NULL;
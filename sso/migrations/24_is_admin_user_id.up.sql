@@ -0,0 +1,14 @@
+-- is_admin rows were keyed by their id, which older rows set to the user id
+-- and left user_id empty, key them by user_id from now on
+UPDATE is_admin a SET user_id = a.id
+WHERE a.user_id IS NULL AND EXISTS (SELECT 1 FROM users u WHERE u.id = a.id);
+
+DELETE FROM is_admin WHERE user_id IS NULL;
+
+DELETE FROM is_admin a USING is_admin b
+WHERE a.user_id = b.user_id AND a.id < b.id;
+
+ALTER TABLE is_admin
+    ALTER COLUMN user_id TYPE BIGINT,
+    ALTER COLUMN user_id SET NOT NULL,
+    ADD CONSTRAINT is_admin_user_id_key UNIQUE (user_id);
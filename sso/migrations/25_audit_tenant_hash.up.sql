@@ -0,0 +1,3 @@
+-- the hash of events written from now on covers their tenant
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS tenant_hashed BOOLEAN NOT NULL DEFAULT false;
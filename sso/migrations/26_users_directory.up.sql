@@ -0,0 +1,3 @@
+-- users that logged in through the directory, their local password is unused
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS directory BOOLEAN NOT NULL DEFAULT false;
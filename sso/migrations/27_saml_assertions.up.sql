@@ -0,0 +1,10 @@
+-- the SAML assertions accepted, kept until they expire so that every
+-- instance rejects a replayed response
+CREATE TABLE IF NOT EXISTS saml_assertions (
+    connector TEXT NOT NULL,
+    id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (connector, id)
+);
+
+CREATE INDEX IF NOT EXISTS saml_assertions_expires_at_idx ON saml_assertions (expires_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS login_codes;
+
+ALTER TABLE apps DROP COLUMN IF EXISTS callback_urls;
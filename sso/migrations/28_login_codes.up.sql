@@ -0,0 +1,19 @@
+-- the URLs the HTTP server may send users of an app back to after a login
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS callback_urls TEXT[] NOT NULL DEFAULT '{}';
+
+-- one-time codes of finished SAML logins, the app redeems them for tokens.
+-- The client that logged in is kept for the session started then.
+CREATE TABLE IF NOT EXISTS login_codes
+(
+    hash BYTEA PRIMARY KEY,
+    tenant_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    device TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_codes_expires_at_idx ON login_codes (expires_at);
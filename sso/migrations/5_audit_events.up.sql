@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    actor_id BIGINT,
+    subject TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    app_id BIGINT,
+    result TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor_id, id DESC);
@@ -0,0 +1,12 @@
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS prev_hash TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL,
+    hash TEXT NOT NULL,
+    signature BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
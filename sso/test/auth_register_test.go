@@ -17,7 +17,7 @@ const (
 	appID      = 1
 	appSecret  = "secret_key"
 
-	passDefaulten = 7
+	passDefaulten = 12
 )
 
 func TestRegisterLogin_Login_HappyPath(t *testing.T) {
@@ -83,8 +83,10 @@ func TestRegisterDuplication(t *testing.T) {
 	require.ErrorContains(t, err, "user alredy exists")
 }
 
+// generatePassword always contains every character class the password
+// policy in config/local.yaml asks for.
 func generatePassword() string {
-	return gofakeit.Password(true, true, true, true, false, passDefaulten)
+	return "Aa1" + gofakeit.Password(true, true, true, true, false, passDefaulten)
 }
 
 // Тесты для Login хендлера
@@ -190,9 +192,9 @@ func TestLogin_ValidationErrors(t *testing.T) {
 		{
 			name:        "Password too short",
 			email:       gofakeit.Email(),
-			password:    "123", // 9 символов, нужно минимум 10
+			password:    "123", // 3 символа, нужно минимум 7
 			appId:       appID,
-			expectedErr: "password must be at least 10 characters",
+			expectedErr: "password must be at least 7 characters",
 		},
 		{
 			name:        "Empty app_id",
@@ -325,7 +327,7 @@ func TestIsAdmin_UserNotFound(t *testing.T) {
 	})
 	require.Error(t, err)
 	assert.False(t, respIsAdmin.GetIsAdmin())
-	require.ErrorContains(t, err, "app is not found")
+	require.ErrorContains(t, err, "user is not found")
 }
 
 func TestIsAdmin_ValidationErrors(t *testing.T) {
@@ -380,3 +382,43 @@ func TestFullAuthFlow(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorContains(t, err, "user alredy exists")
 }
+
+func TestChangePassword_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generatePassword()
+	newPassword := generatePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+		Email:       email,
+		OldPassword: password,
+		NewPassword: newPassword,
+		AppId:       appID,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: newPassword,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respLogin.GetToken())
+
+	// Старый пароль нельзя использовать повторно
+	_, err = st.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+		Email:       email,
+		OldPassword: newPassword,
+		NewPassword: password,
+		AppId:       appID,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "password was used recently")
+}
@@ -2,5 +2,5 @@ INSERT INTO apps (id, name, secret)
 VALUES (1, 'test', 'secret_key');
 INSERT INTO users (id, email, pass_hash)
 VALUES (12, 'jonn@gmail.com', 'xDEADBEEF');
-INSERT INTO is_admin (id, admin)
-VALUES (12, '1');
\ No newline at end of file
+INSERT INTO is_admin (id, user_id, admin)
+VALUES (12, 12, '1');
\ No newline at end of file
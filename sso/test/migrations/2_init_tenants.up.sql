@@ -0,0 +1,4 @@
+INSERT INTO tenants (id, slug, name)
+VALUES (2, 'acme', 'Acme');
+INSERT INTO apps (id, tenant_id, name, secret)
+VALUES (2, 2, 'test', 'acme_secret_key');
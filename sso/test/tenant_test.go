@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/goggle-source/grpc-servic/protos/gen/go/sso"
+	"github.com/goggle-source/grpc-servic/sso/test/suite"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	acmeTenantID  = 2
+	acmeAppID     = 2
+	acmeAppSecret = "acme_secret_key"
+)
+
+func inTenant(ctx context.Context, slug string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-tenant", slug)
+}
+
+func TestTenants_SameEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generatePassword()
+	otherPassword := generatePassword()
+
+	respDefault, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respAcme, err := st.AuthClient.Register(inTenant(ctx, "acme"), &ssov1.RegisterRequest{Email: email, Password: otherPassword})
+	require.NoError(t, err)
+	assert.NotEqual(t, respDefault.GetUserId(), respAcme.GetUserId())
+
+	// the password of one tenant does not open the account of the other
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: otherPassword, AppId: appID})
+	require.Error(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: otherPassword, AppId: acmeAppID})
+	require.NoError(t, err)
+
+	tokenParsed, err := jwt.Parse(respLogin.GetToken(), func(t *jwt.Token) (any, error) {
+		return []byte(acmeAppSecret), nil
+	})
+	require.NoError(t, err)
+
+	claims, ok := tokenParsed.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+	assert.Equal(t, respAcme.GetUserId(), int64(claims["uid"].(float64)))
+	assert.Equal(t, acmeTenantID, int(claims["tid"].(float64)))
+}
+
+func TestTenants_TokenOfOtherTenant(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generatePassword()
+
+	_, err := st.AuthClient.Register(inTenant(ctx, "acme"), &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(inTenant(ctx, "acme"), &ssov1.LoginRequest{Email: email, Password: password, AppId: acmeAppID})
+	require.NoError(t, err)
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+
+	_, err = st.AuthClient.EnrollOTP(inTenant(authCtx, "default"), &ssov1.EnrollOTPRequest{Password: password})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.EnrollOTP(inTenant(authCtx, "acme"), &ssov1.EnrollOTPRequest{Password: password})
+	require.NoError(t, err)
+}
+
+func TestTenants_UnknownTenant(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Register(inTenant(ctx, "nope"), &ssov1.RegisterRequest{Email: gofakeit.Email(), Password: generatePassword()})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}